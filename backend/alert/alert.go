@@ -0,0 +1,172 @@
+// Package alert notifies the operator through a webhook (generic JSON, Slack
+// or ntfy) when the server panics, answers with bursts of server errors or
+// fails its health checks.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nextbrowse-backend/config"
+)
+
+// Alert kinds
+const (
+	KindPanic        = "panic"
+	KindServerErrors = "server_errors"
+	KindHealth       = "health"
+	KindRecovered    = "recovered"
+)
+
+// Webhook payload formats
+const (
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+	FormatNtfy    = "ntfy"
+)
+
+// Alert is one notification. Key identifies what it is about, so that
+// repeats of the same alert are held back for ALERT_COOLDOWN.
+type Alert struct {
+	Kind    string            `json:"kind"`
+	Key     string            `json:"-"`
+	Title   string            `json:"title"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// genericPayload is the JSON posted to generic webhooks
+type genericPayload struct {
+	Alert
+	Server string `json:"server"`
+	Time   int64  `json:"time"`
+}
+
+var (
+	lastSent   = make(map[string]time.Time)
+	lastSentMu sync.Mutex
+
+	client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Enabled reports whether an alert webhook is configured
+func Enabled() bool {
+	return config.AlertWebhookURL != ""
+}
+
+// Send delivers an alert in the background unless alerts are off or the same
+// alert was sent within ALERT_COOLDOWN
+func Send(a Alert) {
+	if !Enabled() {
+		return
+	}
+
+	key := a.Kind + "|" + a.Key
+	lastSentMu.Lock()
+	if last, ok := lastSent[key]; ok && time.Since(last) < config.AlertCooldown {
+		lastSentMu.Unlock()
+		return
+	}
+	lastSent[key] = time.Now()
+	lastSentMu.Unlock()
+
+	go func() {
+		if err := deliver(a); err != nil {
+			slog.Warn("Failed to send alert", "kind", a.Kind, "error", err)
+		}
+	}()
+}
+
+// Resolved clears the cooldown of an alert once its problem is gone, so that
+// a recurrence is reported straight away
+func Resolved(kind, key string) {
+	lastSentMu.Lock()
+	defer lastSentMu.Unlock()
+	delete(lastSent, kind+"|"+key)
+}
+
+func deliver(a Alert) error {
+	host, _ := os.Hostname()
+	title := "NextBrowse: " + a.Title
+	if host != "" {
+		title += " on " + host
+	}
+
+	var body []byte
+	var err error
+	header := http.Header{}
+	switch webhookFormat() {
+	case FormatSlack:
+		body, err = json.Marshal(map[string]string{"text": "*" + title + "*\n" + a.Message + detailLines(a.Details)})
+		header.Set("Content-Type", "application/json")
+	case FormatNtfy:
+		body = []byte(a.Message + detailLines(a.Details))
+		header.Set("Title", title)
+		header.Set("Tags", "warning")
+		if a.Kind != KindRecovered {
+			header.Set("Priority", "high")
+		}
+	default:
+		body, err = json.Marshal(genericPayload{Alert: a, Server: host, Time: time.Now().UnixMilli()})
+		header.Set("Content-Type", "application/json")
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.AlertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookFormat returns ALERT_WEBHOOK_FORMAT, or guesses it from well-known
+// webhook hosts
+func webhookFormat() string {
+	if config.AlertWebhookFormat != "" {
+		return config.AlertWebhookFormat
+	}
+	switch {
+	case strings.Contains(config.AlertWebhookURL, "hooks.slack.com"):
+		return FormatSlack
+	case strings.Contains(config.AlertWebhookURL, "ntfy."):
+		return FormatNtfy
+	}
+	return FormatGeneric
+}
+
+func detailLines(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "\n%s: %s", key, details[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,48 @@
+// Package buffers lends out the buffers that uploads, downloads and copies
+// stream through, so concurrent transfers reuse them instead of each
+// allocating its own.
+package buffers
+
+import (
+	"io"
+	"sync"
+)
+
+// Size is the length of the buffers handed out
+const Size = 1 << 20
+
+var pool = sync.Pool{
+	New: func() any {
+		b := make([]byte, Size)
+		return &b
+	},
+}
+
+// Get borrows a buffer of Size bytes, which must be given back with Put
+func Get() *[]byte {
+	return pool.Get().(*[]byte)
+}
+
+// Put gives back a buffer from Get. The buffer must not be used afterwards.
+func Put(b *[]byte) {
+	if b == nil || len(*b) != Size {
+		return
+	}
+	pool.Put(b)
+}
+
+// Copy copies src to dst through a pooled buffer. Unlike io.Copy it never
+// hands the copy to dst's ReadFrom or src's WriteTo, which for files fall
+// back to allocating a buffer of their own; a copy between two local files
+// that should use the kernel's fast path is better left to io.Copy.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := Get()
+	defer Put(buf)
+	return io.CopyBuffer(onlyWriter{dst}, onlyReader{src}, *buf)
+}
+
+// onlyWriter and onlyReader hide the io.ReaderFrom and io.WriterTo of what
+// they wrap
+type onlyWriter struct{ io.Writer }
+
+type onlyReader struct{ io.Reader }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cobra"
+
+	"nextbrowse-backend/handlers"
+	"nextbrowse-backend/openapi"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// newRootCommand builds the nextbrowse CLI. Running it without a subcommand
+// serves the API, so existing deployments keep working.
+func newRootCommand() *cobra.Command {
+	serve := newServeCommand()
+	root := &cobra.Command{
+		Use:          "nextbrowse",
+		Short:        "Self-hosted file browser backend",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         serve.RunE,
+	}
+	root.Flags().AddFlagSet(serve.Flags())
+	root.AddCommand(serve, newConfigCommand(), newOpenAPICommand(), newVersionCommand())
+	return root
+}
+
+func newServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the API and WebDAV",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, _ := cmd.Flags().GetString("port")
+			if check, _ := cmd.Flags().GetBool("check"); check {
+				return runChecks(cmd, port)
+			}
+			runServer(port)
+			return nil
+		},
+	}
+	addPortFlag(cmd)
+	cmd.Flags().Bool("check", false, "check the setup like 'config check' and exit instead of serving")
+	return cmd
+}
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Check the configuration and environment without starting the server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			port, _ := cmd.Flags().GetString("port")
+			return runChecks(cmd, port)
+		},
+	}
+	addPortFlag(check)
+	cmd.AddCommand(check)
+	return cmd
+}
+
+// addPortFlag adds --port, defaulting to PORT or 9932
+func addPortFlag(cmd *cobra.Command) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "9932"
+	}
+	cmd.Flags().String("port", port, "port to listen on (env PORT)")
+}
+
+// runChecks prints the findings of the setup checks, failing if any of them
+// is a problem
+func runChecks(cmd *cobra.Command, port string) error {
+	problems := 0
+	for _, f := range checkSetup(port) {
+		out := cmd.OutOrStdout()
+		if f.level == findingProblem {
+			out = cmd.ErrOrStderr()
+			problems++
+		}
+		fmt.Fprintln(out, f.level.symbol(), f.text)
+	}
+	if problems > 0 {
+		return fmt.Errorf("setup has %d problem(s)", problems)
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "✓ no problems found")
+	return nil
+}
+
+// newOpenAPICommand prints the API specification of the routes the current
+// configuration serves, for generating clients without running the server
+func newOpenAPICommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "openapi",
+		Short: "Print the OpenAPI specification, or a TypeScript client for it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gin.SetMode(gin.ReleaseMode)
+			doc, _ := handlers.APISpec(newRouter().Routes(), version)
+			if ts, _ := cmd.Flags().GetBool("typescript"); ts {
+				_, err := fmt.Fprint(cmd.OutOrStdout(), openapi.TypeScript(doc))
+				return err
+			}
+			out := json.NewEncoder(cmd.OutOrStdout())
+			out.SetIndent("", "  ")
+			return out.Encode(doc)
+		},
+	}
+	cmd.Flags().Bool("typescript", false, "print a TypeScript client instead of the JSON specification")
+	return cmd
+}
+
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Fprintln(cmd.OutOrStdout(), "nextbrowse", version)
+		},
+	}
+}
@@ -0,0 +1,146 @@
+// Package client is a Go client for the NextBrowse API, for scripts and
+// services that list, transfer and share files on a NextBrowse server. The
+// request and response types mirror the server's JSON; /api/openapi.json on
+// a running server describes every endpoint.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to one NextBrowse server
+type Client struct {
+	// BaseURL is where the server is reached, e.g. "https://files.example.com"
+	BaseURL string
+
+	// AdminToken is sent as a bearer token; only admin endpoints need it
+	AdminToken string
+
+	// HTTPClient makes the requests, http.DefaultClient when nil
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Error is a failed request, decoded from the server's RFC 7807 problem
+// details when it sent them
+type Error struct {
+	Status    int    `json:"status"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"requestId"`
+	JobID     string `json:"jobId,omitempty"` // set when a failed operation ran as a job
+}
+
+func (e *Error) Error() string {
+	msg := e.Title
+	if e.Detail != "" {
+		msg = e.Detail
+	}
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	return fmt.Sprintf("nextbrowse: %d %s", e.Status, msg)
+}
+
+// request describes one API call
+type request struct {
+	method  string
+	path    string
+	query   url.Values
+	header  http.Header
+	body    io.Reader // sent as is
+	json    any       // encoded as the body when body is nil
+	success int       // status of a successful response, any 2xx when 0
+}
+
+// do sends req and returns the response to a successful request. The caller
+// closes its body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	target := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	body := req.body
+	if body == nil && req.json != nil {
+		data, err := json.Marshal(req.json)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.header {
+		httpReq.Header[key] = values
+	}
+	if req.body == nil && req.json != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.AdminToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if req.success != 0 {
+		ok = resp.StatusCode == req.success
+	}
+	if !ok {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+	return resp, nil
+}
+
+// doJSON sends req and decodes the response body into out
+func (c *Client) doJSON(ctx context.Context, req request, out any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeError reads the problem details of a failed response
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{Status: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" || mediaType == "application/json" {
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(apiErr)
+		apiErr.Status = resp.StatusCode
+	}
+	if apiErr.RequestID == "" {
+		apiErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return apiErr
+}
@@ -0,0 +1,287 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FileItem is an entry of a directory listing
+type FileItem struct {
+	Name            string   `json:"name"`
+	Type            string   `json:"type"` // "file" or "dir"
+	Size            *int64   `json:"size,omitempty"`
+	MTime           int64    `json:"mtime"`
+	URL             *string  `json:"url,omitempty"`
+	Target          *string  `json:"target,omitempty"` // symlink target, if the entry is a symlink
+	ItemCount       *int     `json:"itemCount,omitempty"`
+	ItemCountCapped bool     `json:"itemCountCapped,omitempty"`
+	Tags            []string `json:"tags,omitempty"`
+	Note            *Note    `json:"note,omitempty"` // only with notes requested
+}
+
+// Note is free text attached to a file or directory
+type Note struct {
+	Text      string `json:"text"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+// Page asks for part of a list
+type Page struct {
+	Offset int
+	Limit  int // at most 1000; the server's default when 0
+}
+
+func (p *Page) query(q url.Values) {
+	if p == nil {
+		return
+	}
+	q.Set("offset", strconv.Itoa(p.Offset))
+	if p.Limit > 0 {
+		q.Set("limit", strconv.Itoa(p.Limit))
+	}
+}
+
+// Pagination describes the part of a list a response holds
+type Pagination struct {
+	Offset     int  `json:"offset"`
+	Limit      int  `json:"limit"`
+	Page       int  `json:"page"`
+	PageSize   int  `json:"pageSize"`
+	TotalItems int  `json:"totalItems"`
+	TotalPages int  `json:"totalPages"`
+	HasMore    bool `json:"hasMore"`
+	HasPrev    bool `json:"hasPrev"`
+	NextOffset *int `json:"nextOffset,omitempty"`
+}
+
+// Listing is the content of a directory
+type Listing struct {
+	Path       string      `json:"path"`
+	Items      []FileItem  `json:"items"`
+	Pagination *Pagination `json:"pagination,omitempty"` // set when a page was asked for
+}
+
+// List lists the directory at path; a nil page lists every entry
+func (c *Client) List(ctx context.Context, path string, page *Page) (*Listing, error) {
+	q := url.Values{"path": {path}}
+	page.query(q)
+	var out Listing
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/fs/list", query: q}, &out)
+	return &out, err
+}
+
+// FileContent is a file, or part of one, as returned by Read
+type FileContent struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"` // "text" or "base64"
+	Charset  string `json:"charset,omitempty"`
+	Binary   bool   `json:"binary"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	Size     int64  `json:"size"`
+	Mtime    int64  `json:"mtime"`
+	ETag     string `json:"etag"` // pass to Write to keep from overwriting later changes
+}
+
+// Bytes returns the content, decoding it if it came base64 encoded
+func (f *FileContent) Bytes() ([]byte, error) {
+	if f.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(f.Content)
+	}
+	return []byte(f.Content), nil
+}
+
+// Read reads the file at path. Byte ranges of larger files are read with
+// ReadRange.
+func (c *Client) Read(ctx context.Context, path string) (*FileContent, error) {
+	return c.read(ctx, url.Values{"path": {path}})
+}
+
+// ReadRange reads length bytes of the file at path from offset; the server
+// may return fewer
+func (c *Client) ReadRange(ctx context.Context, path string, offset, length int64) (*FileContent, error) {
+	q := url.Values{"path": {path}, "range": {fmt.Sprintf("%d-%d", offset, offset+length-1)}}
+	return c.read(ctx, q)
+}
+
+func (c *Client) read(ctx context.Context, q url.Values) (*FileContent, error) {
+	var out FileContent
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/fs/read", query: q}, &out)
+	return &out, err
+}
+
+// WriteResult describes a file after a write
+type WriteResult struct {
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+	ETag  string `json:"etag"`
+}
+
+// Write replaces the file at path with body, creating it if needed. With an
+// etag from Read or an earlier Write, it fails with status 412 if the file
+// has changed since.
+func (c *Client) Write(ctx context.Context, path string, body io.Reader, etag string) (*WriteResult, error) {
+	req := request{
+		method: http.MethodPut,
+		path:   "/api/fs/write",
+		query:  url.Values{"path": {path}},
+		header: http.Header{"Content-Type": {"application/octet-stream"}},
+		body:   body,
+	}
+	if etag != "" {
+		req.header.Set("If-Match", etag)
+	}
+	var out WriteResult
+	err := c.doJSON(ctx, req, &out)
+	return &out, err
+}
+
+// Download streams the file at path. The caller closes the reader.
+func (c *Client) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	return c.download(ctx, request{method: http.MethodGet, path: "/api/fs/download", query: url.Values{"path": {path}}})
+}
+
+// DownloadZip streams a ZIP archive of the given files and directories. The
+// caller closes the reader.
+func (c *Client) DownloadZip(ctx context.Context, paths ...string) (io.ReadCloser, error) {
+	return c.download(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/fs/download-multiple",
+		json:   map[string][]string{"files": paths},
+	})
+}
+
+func (c *Client) download(ctx context.Context, req request) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// OperationResult reports a file operation. Asynchronous operations return
+// at once with the ID of the job doing the work.
+type OperationResult struct {
+	Message string     `json:"message"`
+	JobID   string     `json:"jobId,omitempty"`
+	Errors  []JobError `json:"errors,omitempty"`
+}
+
+// CopyOptions tune a copy or move
+type CopyOptions struct {
+	Async    bool   // return at once; follow the job with Job or WaitJob
+	Conflict string // skip, overwrite, keep-newer or rename to merge into an existing destination
+}
+
+// Copy copies a file or directory
+func (c *Client) Copy(ctx context.Context, source, destination string, opts *CopyOptions) (*OperationResult, error) {
+	return c.copyMove(ctx, "/api/fs/copy", source, destination, opts)
+}
+
+// Move moves or renames a file or directory
+func (c *Client) Move(ctx context.Context, source, destination string, opts *CopyOptions) (*OperationResult, error) {
+	return c.copyMove(ctx, "/api/fs/move", source, destination, opts)
+}
+
+func (c *Client) copyMove(ctx context.Context, path, source, destination string, opts *CopyOptions) (*OperationResult, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	body := map[string]any{"source": source, "destination": destination, "async": opts.Async}
+	if opts.Conflict != "" {
+		body["conflict"] = opts.Conflict
+	}
+	return c.operation(ctx, request{method: http.MethodPost, path: path, json: body})
+}
+
+// Delete deletes a file or directory; secure overwrites file contents first
+func (c *Client) Delete(ctx context.Context, path string, secure bool) (*OperationResult, error) {
+	q := url.Values{"path": {path}}
+	if secure {
+		q.Set("secure", "true")
+	}
+	return c.operation(ctx, request{method: http.MethodDelete, path: "/api/fs/delete", query: q})
+}
+
+// Mkdir creates the directory name in dir
+func (c *Client) Mkdir(ctx context.Context, dir, name string) (*OperationResult, error) {
+	return c.operation(ctx, request{method: http.MethodPost, path: "/api/fs/mkdir", json: map[string]string{"path": dir, "name": name}})
+}
+
+// Touch creates the empty file name in dir
+func (c *Client) Touch(ctx context.Context, dir, name string) (*OperationResult, error) {
+	return c.operation(ctx, request{method: http.MethodPost, path: "/api/fs/touch", json: map[string]string{"path": dir, "name": name}})
+}
+
+func (c *Client) operation(ctx context.Context, req request) (*OperationResult, error) {
+	var out OperationResult
+	err := c.doJSON(ctx, req, &out)
+	return &out, err
+}
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// JobError is a path a job failed on
+type JobError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Job is the progress of a long-running operation
+type Job struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	Status      string     `json:"status"`
+	Source      string     `json:"source,omitempty"`
+	Destination string     `json:"destination,omitempty"`
+	TotalItems  int64      `json:"totalItems"`
+	DoneItems   int64      `json:"doneItems"`
+	TotalBytes  int64      `json:"totalBytes"`
+	DoneBytes   int64      `json:"doneBytes"`
+	CurrentFile string     `json:"currentFile,omitempty"`
+	Errors      []JobError `json:"errors,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	CreatedAt   int64      `json:"createdAt"`
+	FinishedAt  *int64     `json:"finishedAt,omitempty"`
+}
+
+// Job returns the progress of the job with the given ID
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	var out struct {
+		Job *Job `json:"job"`
+	}
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/jobs/" + url.PathEscape(id)}, &out)
+	return out.Job, err
+}
+
+// WaitJob polls the job every interval until it is no longer running
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (*Job, error) {
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil || job.Status != JobRunning {
+			return job, err
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CancelJob asks the job with the given ID to stop
+func (c *Client) CancelJob(ctx context.Context, id string) error {
+	return c.doJSON(ctx, request{method: http.MethodDelete, path: "/api/jobs/" + url.PathEscape(id)}, nil)
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ShareOptions are the settings of a new share
+type ShareOptions struct {
+	Password      string `json:"password,omitempty"`
+	ExpiresIn     *int64 `json:"expiresIn,omitempty"` // seconds
+	AllowUploads  bool   `json:"allowUploads,omitempty"`
+	DisableViewer bool   `json:"disableViewer,omitempty"`
+	QuickDownload bool   `json:"quickDownload,omitempty"`
+	MaxBandwidth  *int64 `json:"maxBandwidth,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Theme         string `json:"theme,omitempty"`
+	ViewMode      string `json:"viewMode,omitempty"`
+	Torrent       bool   `json:"torrent,omitempty"` // make a torrent of the share
+}
+
+// Share is a link to a file or directory
+type Share struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"` // "file" or "dir"
+	CreatedAt     int64  `json:"createdAt"`
+	ExpiresAt     *int64 `json:"expiresAt,omitempty"`
+	HasPassword   bool   `json:"hasPassword"`
+	AllowUploads  bool   `json:"allowUploads,omitempty"`
+	DisableViewer bool   `json:"disableViewer,omitempty"`
+	QuickDownload bool   `json:"quickDownload,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Description   string `json:"description,omitempty"`
+	InfoHash      string `json:"infoHash,omitempty"` // set once the share has a torrent
+}
+
+// CreatedShare is a new share and the URL it is reached at
+type CreatedShare struct {
+	ShareID      string `json:"shareId"`
+	ShareURL     string `json:"shareUrl"`
+	Share        *Share `json:"share"`
+	TorrentJobID string `json:"torrentJobId,omitempty"` // of the job making the torrent
+}
+
+// CreateShare shares the file or directory at path
+func (c *Client) CreateShare(ctx context.Context, path string, opts *ShareOptions) (*CreatedShare, error) {
+	if opts == nil {
+		opts = &ShareOptions{}
+	}
+	body := struct {
+		Path string `json:"path"`
+		*ShareOptions
+	}{path, opts}
+	var out CreatedShare
+	err := c.doJSON(ctx, request{method: http.MethodPost, path: "/api/fs/share/create", json: body}, &out)
+	return &out, err
+}
+
+// ShareList is a list of shares, newest first
+type ShareList struct {
+	Shares     []*Share    `json:"shares"`
+	Pagination *Pagination `json:"pagination,omitempty"` // set when a page was asked for
+}
+
+// Shares lists the shares; a nil page lists all of them
+func (c *Client) Shares(ctx context.Context, page *Page) (*ShareList, error) {
+	q := url.Values{}
+	page.query(q)
+	var out ShareList
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/fs/shares", query: q}, &out)
+	return &out, err
+}
+
+// Share returns the share with the given ID
+func (c *Client) Share(ctx context.Context, id string) (*Share, error) {
+	var out struct {
+		Share *Share `json:"share"`
+	}
+	err := c.doJSON(ctx, request{method: http.MethodGet, path: "/api/fs/share/" + url.PathEscape(id)}, &out)
+	return out.Share, err
+}
+
+// DownloadShare streams a shared file, or a shared directory as a ZIP
+// archive; a non-empty path picks an entry inside a shared directory. The
+// caller closes the reader.
+func (c *Client) DownloadShare(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	q := url.Values{}
+	if path != "" {
+		q.Set("path", path)
+	}
+	return c.download(ctx, request{
+		method: http.MethodGet,
+		path:   "/api/fs/share/" + url.PathEscape(id) + "/download",
+		query:  q,
+	})
+}
+
+// CreateShareTorrent starts making a torrent of a share and returns the ID
+// of the job doing it; WaitJob tells when it is ready
+func (c *Client) CreateShareTorrent(ctx context.Context, id string) (string, error) {
+	var out struct {
+		JobID string `json:"jobId"`
+	}
+	err := c.doJSON(ctx, request{method: http.MethodPost, path: "/api/fs/share/" + url.PathEscape(id) + "/torrent"}, &out)
+	return out.JobID, err
+}
+
+// ShareTorrent streams the .torrent of a share. The caller closes the reader.
+func (c *Client) ShareTorrent(ctx context.Context, id string) (io.ReadCloser, error) {
+	return c.download(ctx, request{method: http.MethodGet, path: "/api/fs/share/" + url.PathEscape(id) + "/torrent"})
+}
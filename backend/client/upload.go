@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// tusVersion is the TUS protocol version the server speaks
+const tusVersion = "1.0.0"
+
+// DefaultChunkSize is the size of the parts Upload sends, the same as the
+// web interface's
+const DefaultChunkSize = 8 << 20
+
+// UploadOptions tune an upload
+type UploadOptions struct {
+	ChunkSize int64 // DefaultChunkSize when 0
+
+	// Progress, if set, is called with the bytes sent after each part
+	Progress func(sent, total int64)
+}
+
+// Upload stores size bytes read from r as the file name in dir, sending them
+// in parts over the resumable upload (TUS) endpoints. The file appears once
+// the last part arrives; a failed upload is cancelled.
+func (c *Client) Upload(ctx context.Context, dir, name string, r io.Reader, size int64, opts *UploadOptions) error {
+	if size == 0 {
+		// The upload protocol needs at least one byte
+		_, err := c.Touch(ctx, dir, name)
+		return err
+	}
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte(name)) +
+		",path " + base64.StdEncoding.EncodeToString([]byte(dir))
+	resp, err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/api/tus/files",
+		header: http.Header{
+			"Tus-Resumable":   {tusVersion},
+			"Upload-Length":   {strconv.FormatInt(size, 10)},
+			"Upload-Metadata": {metadata},
+		},
+		success: http.StatusCreated,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Path == "" {
+		return errors.New("nextbrowse: upload created without a location")
+	}
+	uploadPath := location.Path
+
+	for offset := int64(0); offset < size; {
+		part := min(chunkSize, size-offset)
+		resp, err := c.do(ctx, request{
+			method: http.MethodPatch,
+			path:   uploadPath,
+			header: http.Header{
+				"Tus-Resumable": {tusVersion},
+				"Content-Type":  {"application/offset+octet-stream"},
+				"Upload-Offset": {strconv.FormatInt(offset, 10)},
+			},
+			body:    io.LimitReader(r, part),
+			success: http.StatusNoContent,
+		})
+		if err != nil {
+			c.cancelUpload(uploadPath)
+			return err
+		}
+		resp.Body.Close()
+
+		next, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || next != offset+part {
+			c.cancelUpload(uploadPath)
+			return errors.New("nextbrowse: upload offset out of step with the server")
+		}
+		offset = next
+		if opts.Progress != nil {
+			opts.Progress(offset, size)
+		}
+	}
+	return nil
+}
+
+// cancelUpload discards a failed upload's partial file on the server
+func (c *Client) cancelUpload(uploadPath string) {
+	resp, err := c.do(context.Background(), request{
+		method: http.MethodDelete,
+		path:   uploadPath,
+		header: http.Header{"Tus-Resumable": {tusVersion}},
+	})
+	if err == nil {
+		resp.Body.Close()
+	}
+}
@@ -1,14 +1,405 @@
 package config
 
 import (
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	RootDir         string
 	PublicFilesBase string
 	BaseURL         string
+
+	// Directory listing cache
+	ListCacheTTL  time.Duration
+	ListCacheSize int
+
+	// How symlinks inside the root are treated (see Symlink* constants)
+	SymlinkPolicy string
+
+	// Globs of names hidden like dotfiles, and left out of walks and ZIP
+	// downloads
+	HiddenPatterns []string
+
+	// Whether clients may create symlinks and hardlinks
+	LinkCreationEnabled bool
+
+	// Metadata preserved by copies, beyond permissions
+	CopyPreserveTimestamps bool
+	CopyPreserveOwnership  bool
+	CopyPreserveXattrs     bool
+
+	// Largest total size (bytes) a single secure delete may overwrite
+	SecureDeleteMaxSize int64
+
+	// Largest request body accepted by the write, append and patch endpoints
+	WriteMaxSize int64
+
+	// Largest file, or range of one, returned by the read endpoint
+	ReadMaxSize int64
+
+	// Largest file the diff endpoint compares line by line
+	DiffMaxSize int64
+
+	// Largest Excel workbook the table endpoint opens; delimited text of any
+	// size is streamed
+	SpreadsheetMaxSize int64
+
+	// Largest image, in pixels, that thumbnails are made of, as decoding
+	// takes four bytes a pixel
+	ThumbnailMaxPixels int64
+
+	// Size the cache of thumbnails and other previews in DATA_DIR/thumbnails
+	// is kept under by evicting the least recently used (0 for no limit)
+	PreviewCacheMaxSize int64
+
+	// How long git may take to report the status of a working copy
+	GitTimeout time.Duration
+
+	// Unicode normalization applied to incoming paths (see Normalize* constants)
+	PathNormalization string
+
+	// Storage backends mounted into the tree, keyed by user path
+	Mounts map[string]string
+
+	// Copies and moves between storage backends
+	TransferRetries int
+	TransferVerify  bool
+
+	// Parts of the API that are served at all
+	Features FeatureFlags
+
+	// User path served by the built-in WebDAV server
+	WebDAVRoot string
+
+	// Editing office documents in place through an OnlyOffice or Collabora
+	// server at OfficeURL, which reaches the WOPI endpoints at OfficeWOPIURL
+	// (by default the address the browser used). Access tokens handed to
+	// the editor are signed with OfficeSecret and last OfficeTokenTTL.
+	OfficeURL      string
+	OfficeWOPIURL  string
+	OfficeSecret   string
+	OfficeTokenTTL time.Duration
+
+	// Trackers announced in the torrents made of shares; without any,
+	// clients find peers through the DHT and fetch from the share itself
+	TorrentTrackers []string
+
+	// Scheduled backups of subtrees
+	Backups []BackupSchedule
+
+	// Storage probes behind /health
+	HealthCheckTTL     time.Duration
+	HealthCheckTimeout time.Duration
+
+	// Log output format (see LogFormat* constants) and minimum level
+	LogFormat string
+	LogLevel  slog.Level
+
+	// Access log format (see AccessLog* constants), and the file it is written
+	// to with the size in megabytes at which it rotates and the rotated files
+	// kept
+	AccessLogFormat     string
+	AccessLogFile       string
+	AccessLogMaxSize    int
+	AccessLogMaxBackups int
+
+	// Connection timeouts: for request headers, for idle keep-alive
+	// connections, for the body and response of ordinary requests, and for
+	// uploads and downloads to stall
+	ReadHeaderTimeout   time.Duration
+	IdleTimeout         time.Duration
+	RequestReadTimeout  time.Duration
+	RequestWriteTimeout time.Duration
+	StreamIdleTimeout   time.Duration
+
+	// HTTP/2 over TLS, and over plain HTTP (h2c) for reverse proxies that
+	// speak it, with the flow-control windows uploads get per stream and
+	// per connection
+	HTTP2Enabled      bool
+	H2CEnabled        bool
+	HTTP2StreamWindow int
+	HTTP2ConnWindow   int
+
+	// Origins allowed to make cross-origin requests, and whether those may
+	// carry credentials
+	AllowedOrigins       []string
+	CORSAllowCredentials bool
+
+	// HTTPS with a certificate from files, or from Let's Encrypt for the
+	// given domains
+	TLSCert      string
+	TLSKey       string
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string
+	ACMEHTTPAddr string
+
+	// Per-client request rate limits
+	RateLimitEnabled bool
+	RateLimits       []RateLimitRule
+
+	// Headers added to responses serving files, by path pattern
+	HeaderRules []HeaderRule
+
+	// Budgets of whole operations, past which they are stopped with an error
+	// saying whether the client or the server was too slow (0 for none):
+	// the body of one upload request, a copy or move job, and an archive job
+	UploadTimeout  time.Duration
+	CopyTimeout    time.Duration
+	ArchiveTimeout time.Duration
+
+	// How many downloads one client IP may stream at once (0 for no limit)
+	DownloadStreamsPerClient int
+
+	// How many files of a ZIP download are compressed at once
+	ZipWorkers int
+
+	// How long an archive built by an archive job can be downloaded
+	ArchiveTTL time.Duration
+
+	// Most memory the form fields of multipart requests may take up at once,
+	// across all requests; uploaded files are streamed to disk instead
+	MultipartMemory int64
+
+	// Largest body the /api/bench endpoints send or take, in bytes (0 turns
+	// them off)
+	BenchMaxSize int64
+
+	// Redis shared by replicas for rate limits and the TUS upload registry
+	// (in-process when empty), and the local directory where uploads to
+	// non-local backends are staged
+	RedisURL      string
+	TusStagingDir string
+
+	// How often leftovers of crashed uploads, saves and backups are swept
+	// (0 disables the sweep), and how old they must be to go
+	TempCleanupInterval time.Duration
+	TempCleanupAge      time.Duration
+
+	// How often expired share links are removed (0 leaves them until the
+	// share list is next read)
+	ShareSweepInterval time.Duration
+
+	// How often the search index is reconciled with the disk (0 turns the
+	// index off, and searches walk the tree)
+	SearchIndexInterval time.Duration
+
+	// Limits on the work the server does on its own (reconciling the search
+	// index, thumbnails tasks and integrity checks), so that it leaves the
+	// disk to people browsing: how many files or directories it works on at
+	// once, how many bytes a second it reads (0 for no limit), and the hours
+	// of the day it may run in (any time when empty)
+	BackgroundWorkers int
+	BackgroundIORate  int64
+	BackgroundHours   []HourWindow
+
+	// User-defined maintenance tasks run on cron schedules
+	Tasks []TaskSchedule
+
+	// Start in read-only maintenance mode, and the message shown meanwhile
+	ReadOnly           bool
+	MaintenanceMessage string
+
+	// Bearer token for the admin API (disabled when empty)
+	AdminToken string
+
+	// Prometheus metrics at /metrics
+	MetricsEnabled bool
+
+	// Webhook alerting on panics, bursts of server errors and failed health
+	// checks, with how many 5xx responses within the window count as a burst
+	// and how long repeats of an alert are held back
+	AlertWebhookURL        string
+	AlertWebhookFormat     string
+	AlertServerErrors      int
+	AlertServerErrorWindow time.Duration
+	AlertCooldown          time.Duration
+
+	// Webhooks posting changes to the tree to other services, signed with
+	// WebhookSecret unless they have their own secret, with how often a
+	// failed delivery is retried and how long each attempt may take
+	Webhooks       []Webhook
+	WebhookSecret  string
+	WebhookRetries int
+	WebhookTimeout time.Duration
+
+	// Directory for the server's own state, such as the metadata database
+	DataDir string
+
+	// How long the activity log of changes to the tree is kept (0 keeps none)
+	ActivityRetention time.Duration
+
+	// How long the measured usage of a directory with a quota is trusted
+	// before the directory is walked again
+	QuotaUsageTTL time.Duration
+
+	// Deletes on local storage move entries to a .trash directory at the top
+	// of the root, where they are kept for TrashRetention (0 keeps them
+	// until removed) while the trash holds at most TrashMaxSize bytes (0 for
+	// no cap), the oldest going first
+	TrashEnabled   bool
+	TrashRetention time.Duration
+	TrashMaxSize   int64
+	// Files replaced by uploads, and entries replaced by copies, moves and
+	// extracts that overwrite, go to the trash as well. Saves from editors
+	// don't, as they would fill it with every autosave.
+	TrashReplaced bool
+
+	// Read-only filesystem snapshots of the root directory
+	SnapshotZFS  bool
+	SnapshotDirs []SnapshotDir
+)
+
+// FeatureFlags switch optional parts of the API on or off. The routes of a
+// feature that is off aren't registered at all.
+type FeatureFlags struct {
+	// Public share links under /api/fs/share
+	Shares bool
+	// Resumable TUS uploads under /api/tus
+	Uploads bool
+	// The WebDAV server at /dav
+	WebDAV bool
+}
+
+// RateLimitRule limits how many requests per minute one client may make to
+// paths at or below Path with one of Methods (any method when empty). A limit
+// of 0 means unlimited. The rule with the longest matching path applies, and
+// a rule naming the method wins over one for any method; among equal rules
+// the later one (e.g. a RATE_LIMITS override) applies.
+type RateLimitRule struct {
+	Methods   []string
+	Path      string
+	PerMinute int
+}
+
+// HeaderRule sets Headers on GET and HEAD responses serving a file whose
+// path matches Pattern, a glob where * stands for any part of a name and **
+// for any number of directories. Patterns starting with share: match files
+// served through shares as share:/<share ID>/<path within the share>. Every
+// matching rule applies, in order, replacing the handler's own headers.
+type HeaderRule struct {
+	Pattern string
+	Headers [][2]string // name and value
+}
+
+// defaultRateLimits are the built-in tiers, which RATE_LIMITS entries for the
+// same methods and path replace
+var defaultRateLimits = []RateLimitRule{
+	{Path: "/", PerMinute: 1000},
+	{Methods: []string{"POST", "PUT", "PATCH", "DELETE"}, Path: "/api/fs", PerMinute: 60},
+	{Methods: []string{"POST"}, Path: "/api/fs/download-multiple", PerMinute: 1000},
+	{Methods: []string{"GET", "HEAD"}, Path: "/api/fs/download", PerMinute: 5000},
+	{Methods: []string{"GET", "HEAD"}, Path: "/api/fs/read", PerMinute: 5000},
+	{Path: "/files", PerMinute: 5000},
+	{Path: "/api/tus", PerMinute: 5000},
+	{Path: "/dav", PerMinute: 5000},
+	{Path: "/health", PerMinute: 0},
+	{Path: "/metrics", PerMinute: 0},
+}
+
+// SnapshotDir is a directory whose entries are snapshots of a volume (e.g.
+// btrfs subvolume snapshots), with the root directory at Subpath inside each
+type SnapshotDir struct {
+	Dir     string
+	Subpath string
+}
+
+// BackupSchedule archives a subtree to a destination directory on a cron
+// schedule, keeping the newest Keep archives (0 keeps all of them)
+type BackupSchedule struct {
+	Name        string
+	Schedule    string
+	Source      string
+	Destination string
+	Keep        int
+}
+
+// Webhook posts the events whose type is in Events (all but job progress
+// when empty, everything with "*") on, below or above one of Paths (anywhere when empty) to URL
+type Webhook struct {
+	Name   string
+	URL    string
+	Events []string
+	Paths  []string
+	Secret string
+}
+
+// TaskSchedule runs a maintenance action on the tree at Path on a cron
+// schedule. Arg tunes the action, e.g. the age of files it touches.
+type TaskSchedule struct {
+	Name     string
+	Schedule string
+	Action   string
+	Path     string
+	Arg      string
+}
+
+// HourWindow is a daily span of local time, in minutes past midnight. A
+// window that ends before it starts runs past midnight.
+type HourWindow struct {
+	Start int
+	End   int
+}
+
+// Task actions
+const (
+	// TaskCompress gzips the files directly in a directory that are older
+	// than Arg (default 24h), replacing each with a .gz file
+	TaskCompress = "compress"
+	// TaskPurge deletes the files below a directory older than Arg
+	TaskPurge = "purge"
+	// TaskIntegrity re-hashes the files below a directory and reports those
+	// that changed since the last run, or were corrupted without changing
+	TaskIntegrity = "integrity"
+	// TaskThumbnails makes the missing thumbnails of the images below a
+	// directory, at most Arg a second (default 2), optionally stopping after
+	// a while given as ",<duration>"
+	TaskThumbnails = "thumbnails"
+)
+
+// Symlink policies
+const (
+	// SymlinkFollow follows symlinks whose real target stays inside the root
+	SymlinkFollow = "follow"
+	// SymlinkShow lists symlinks with their targets but never traverses them
+	SymlinkShow = "show"
+	// SymlinkHide omits symlinks from listings and never traverses them
+	SymlinkHide = "hide"
+)
+
+// Log formats
+const (
+	// LogFormatConsole writes human-readable key=value lines
+	LogFormatConsole = "console"
+	// LogFormatJSON writes one JSON object per line for log collectors
+	LogFormatJSON = "json"
+)
+
+// Access log formats
+const (
+	// AccessLogApp writes requests through the application log (LOG_FORMAT)
+	AccessLogApp = "log"
+	// AccessLogCombined writes Apache Combined Log Format lines
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes one JSON object per request
+	AccessLogJSON = "json"
+)
+
+// Path normalization forms
+const (
+	// NormalizeNone uses paths exactly as sent by the client
+	NormalizeNone = "none"
+	// NormalizeNFC matches existing names in any form and creates new ones as NFC
+	NormalizeNFC = "nfc"
+	// NormalizeNFD matches existing names in any form and creates new ones as NFD
+	NormalizeNFD = "nfd"
 )
 
 func init() {
@@ -32,4 +423,485 @@ func init() {
 	if BaseURL == "" {
 		BaseURL = "http://localhost:3000"
 	}
-}
\ No newline at end of file
+
+	// Listing cache (a TTL of 0 disables caching)
+	ListCacheTTL = getEnvDuration("LIST_CACHE_TTL", 30*time.Second)
+	ListCacheSize = getEnvInt("LIST_CACHE_SIZE", 1000)
+
+	// Symlink policy
+	SymlinkPolicy = os.Getenv("SYMLINK_POLICY")
+	switch SymlinkPolicy {
+	case SymlinkFollow, SymlinkShow, SymlinkHide:
+	default:
+		SymlinkPolicy = SymlinkFollow
+	}
+
+	// Names hidden beyond dotfiles, e.g. HIDDEN_PATTERNS="node_modules,@eaDir,#recycle"
+	for _, pattern := range splitList(os.Getenv("HIDDEN_PATTERNS")) {
+		if _, err := filepath.Match(pattern, ""); err != nil || strings.Contains(pattern, "/") {
+			slog.Warn("Ignoring invalid hidden pattern", "pattern", pattern)
+			continue
+		}
+		HiddenPatterns = append(HiddenPatterns, pattern)
+	}
+
+	LinkCreationEnabled = getEnvBool("LINK_CREATION_ENABLED", true)
+
+	// Copy metadata preservation, e.g. COPY_PRESERVE="timestamps,ownership,xattrs"
+	for _, item := range strings.Split(os.Getenv("COPY_PRESERVE"), ",") {
+		switch strings.TrimSpace(strings.ToLower(item)) {
+		case "timestamps":
+			CopyPreserveTimestamps = true
+		case "ownership":
+			CopyPreserveOwnership = true
+		case "xattrs":
+			CopyPreserveXattrs = true
+		case "all":
+			CopyPreserveTimestamps = true
+			CopyPreserveOwnership = true
+			CopyPreserveXattrs = true
+		}
+	}
+
+	SecureDeleteMaxSize = getEnvInt64("SECURE_DELETE_MAX_SIZE", 1024*1024*1024)
+
+	WriteMaxSize = getEnvInt64("WRITE_MAX_SIZE", 64*1024*1024)
+	ReadMaxSize = getEnvInt64("READ_MAX_SIZE", 10*1024*1024)
+	DiffMaxSize = getEnvInt64("DIFF_MAX_SIZE", 2*1024*1024)
+	SpreadsheetMaxSize = getEnvInt64("SPREADSHEET_MAX_SIZE", 100*1024*1024)
+	ThumbnailMaxPixels = getEnvInt64("THUMBNAIL_MAX_PIXELS", 64*1000*1000)
+	PreviewCacheMaxSize = getEnvInt64("PREVIEW_CACHE_MAX_SIZE", 1024*1024*1024)
+	GitTimeout = getEnvDuration("GIT_TIMEOUT", 5*time.Second)
+
+	PathNormalization = strings.ToLower(os.Getenv("PATH_NORMALIZATION"))
+	switch PathNormalization {
+	case NormalizeNFC, NormalizeNFD:
+	default:
+		PathNormalization = NormalizeNone
+	}
+
+	// Mounted backends, e.g. MOUNTS="/archive=s3://bucket/prefix?region=eu-west-1;/media=s3://media"
+	Mounts = make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("MOUNTS"), ";") {
+		mountPath, rawURL, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && mountPath != "" && rawURL != "" {
+			Mounts[filepath.ToSlash(filepath.Clean("/"+strings.TrimPrefix(mountPath, "/")))] = rawURL
+		}
+	}
+
+	// Per-file retries and read-back verification for cross-backend transfers
+	TransferRetries = getEnvInt("TRANSFER_RETRIES", 3)
+	TransferVerify = getEnvBool("TRANSFER_VERIFY", true)
+
+	// Features can be turned off to shrink the attack surface, e.g.
+	// FEATURE_SHARES="false" FEATURE_UPLOADS="false" FEATURE_WEBDAV="false".
+	// WEBDAV_ENABLED is the older name of FEATURE_WEBDAV.
+	Features = FeatureFlags{
+		Shares:  getEnvBool("FEATURE_SHARES", true),
+		Uploads: getEnvBool("FEATURE_UPLOADS", true),
+		WebDAV:  getEnvBool("FEATURE_WEBDAV", getEnvBool("WEBDAV_ENABLED", true)),
+	}
+
+	// WebDAV server at /dav, e.g. WEBDAV_ROOT="/archive" to only serve one mount
+	WebDAVRoot = filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(os.Getenv("WEBDAV_ROOT"), "/")))
+
+	// Office editing, e.g. OFFICE_URL="http://collabora:9980"; without
+	// OFFICE_SECRET tokens are signed with a key that changes on restart
+	OfficeURL = strings.TrimSuffix(os.Getenv("OFFICE_URL"), "/")
+	OfficeWOPIURL = strings.TrimSuffix(os.Getenv("OFFICE_WOPI_URL"), "/")
+	OfficeSecret = os.Getenv("OFFICE_SECRET")
+	OfficeTokenTTL = getEnvDuration("OFFICE_TOKEN_TTL", 10*time.Hour)
+
+	// Torrent trackers, e.g. TORRENT_TRACKERS="udp://tracker.example.com:1337/announce"
+	for _, tracker := range strings.Split(os.Getenv("TORRENT_TRACKERS"), ",") {
+		if tracker = strings.TrimSpace(tracker); tracker != "" {
+			TorrentTrackers = append(TorrentTrackers, tracker)
+		}
+	}
+
+	// Scheduled backups as name=schedule|source|destination[|keep], e.g.
+	// BACKUPS="projects=0 2 * * *|/projects|/archive/backups|7;photos=@weekly|/photos|/archive/photos"
+	for _, entry := range strings.Split(os.Getenv("BACKUPS"), ";") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		fields := strings.Split(spec, "|")
+		if !ok || name == "" || len(fields) < 3 {
+			continue
+		}
+		backup := BackupSchedule{
+			Name:        strings.TrimSpace(name),
+			Schedule:    strings.TrimSpace(fields[0]),
+			Source:      strings.TrimSpace(fields[1]),
+			Destination: strings.TrimSpace(fields[2]),
+			Keep:        7,
+		}
+		if len(fields) > 3 {
+			if keep, err := strconv.Atoi(strings.TrimSpace(fields[3])); err == nil && keep >= 0 {
+				backup.Keep = keep
+			}
+		}
+		Backups = append(Backups, backup)
+	}
+
+	// How long /health reuses its backend probes, and how long each may take
+	HealthCheckTTL = getEnvDuration("HEALTH_CHECK_TTL", 30*time.Second)
+	HealthCheckTimeout = getEnvDuration("HEALTH_CHECK_TIMEOUT", 5*time.Second)
+
+	// Logging, e.g. LOG_FORMAT="json" LOG_LEVEL="debug"
+	LogFormat = strings.ToLower(os.Getenv("LOG_FORMAT"))
+	if LogFormat != LogFormatJSON {
+		LogFormat = LogFormatConsole
+	}
+	if err := LogLevel.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		LogLevel = slog.LevelInfo
+	}
+
+	// Access logs for tools like GoAccess or Loki, e.g. ACCESS_LOG_FORMAT="combined"
+	// ACCESS_LOG_FILE="/var/log/nextbrowse/access.log". Without a file they
+	// go to stdout.
+	AccessLogFormat = strings.ToLower(os.Getenv("ACCESS_LOG_FORMAT"))
+	if AccessLogFormat != AccessLogCombined && AccessLogFormat != AccessLogJSON {
+		AccessLogFormat = AccessLogApp
+	}
+	AccessLogFile = os.Getenv("ACCESS_LOG_FILE")
+	AccessLogMaxSize = getEnvInt("ACCESS_LOG_MAX_SIZE", 100)
+	AccessLogMaxBackups = getEnvInt("ACCESS_LOG_MAX_BACKUPS", 5)
+
+	// Cross-origin access, e.g. ALLOWED_ORIGINS="https://files.example.com,https://*.example.com".
+	// Defaults to the frontend's own origin.
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin != "" {
+			AllowedOrigins = append(AllowedOrigins, origin)
+		}
+	}
+	if len(AllowedOrigins) == 0 {
+		AllowedOrigins = []string{strings.TrimSuffix(BaseURL, "/")}
+	}
+	CORSAllowCredentials = getEnvBool("CORS_ALLOW_CREDENTIALS", true)
+
+	// Ordinary requests must send their body, and take their response,
+	// within REQUEST_READ_TIMEOUT and REQUEST_WRITE_TIMEOUT; transfers only
+	// have to keep moving
+	ReadHeaderTimeout = getEnvDuration("READ_HEADER_TIMEOUT", 10*time.Second)
+	IdleTimeout = getEnvDuration("IDLE_TIMEOUT", 2*time.Minute)
+	RequestReadTimeout = getEnvDuration("REQUEST_READ_TIMEOUT", 30*time.Second)
+	RequestWriteTimeout = getEnvDuration("REQUEST_WRITE_TIMEOUT", 30*time.Second)
+	StreamIdleTimeout = getEnvDuration("STREAM_IDLE_TIMEOUT", time.Minute)
+	UploadTimeout = getEnvDuration("UPLOAD_TIMEOUT", 24*time.Hour)
+	CopyTimeout = getEnvDuration("COPY_TIMEOUT", 0)
+	ArchiveTimeout = getEnvDuration("ARCHIVE_TIMEOUT", 0)
+
+	// HTTP/2's default 1 MiB windows hold a single upload to 1 MiB per round
+	// trip; a window is also the most one client can make the server buffer
+	HTTP2Enabled = getEnvBool("HTTP2", true)
+	H2CEnabled = getEnvBool("H2C", false)
+	HTTP2StreamWindow = getEnvInt("HTTP2_STREAM_WINDOW", 16<<20)
+	HTTP2ConnWindow = getEnvInt("HTTP2_CONN_WINDOW", 64<<20)
+
+	// Native TLS, e.g. TLS_CERT="/certs/fullchain.pem" TLS_KEY="/certs/privkey.pem",
+	// or ACME_DOMAINS="files.example.com" for an automatic certificate
+	TLSCert = os.Getenv("TLS_CERT")
+	TLSKey = os.Getenv("TLS_KEY")
+	for _, domain := range strings.Split(os.Getenv("ACME_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			ACMEDomains = append(ACMEDomains, domain)
+		}
+	}
+	ACMEEmail = os.Getenv("ACME_EMAIL")
+	ACMECacheDir = getEnvString("ACME_CACHE_DIR", "acme-cache")
+	ACMEHTTPAddr = ":80"
+	if addr, ok := os.LookupEnv("ACME_HTTP_ADDR"); ok {
+		ACMEHTTPAddr = addr // empty disables the challenge listener
+	}
+
+	// Rate limit tiers, overridden or extended as "METHODS PATH=N" entries, e.g.
+	// RATE_LIMITS="DELETE /api/fs=30;GET,HEAD /api/thumbnail=5000;* /dav=0"
+	RateLimitEnabled = getEnvBool("RATE_LIMIT_ENABLED", true)
+	RateLimits = append([]RateLimitRule(nil), defaultRateLimits...)
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMITS"), ";") {
+		if rule, ok := parseRateLimitRule(entry); ok {
+			RateLimits = setRateLimitRule(RateLimits, rule)
+		}
+	}
+
+	// Headers by file path as "PATTERN|Name: value|..." entries, e.g.
+	// HEADER_RULES="/static/**|Cache-Control: public, max-age=31536000, immutable;share:**|X-Robots-Tag: noindex"
+	for _, entry := range strings.Split(os.Getenv("HEADER_RULES"), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		if rule, ok := parseHeaderRule(entry); ok {
+			HeaderRules = append(HeaderRules, rule)
+		} else {
+			slog.Warn("Ignoring invalid header rule", "rule", entry)
+		}
+	}
+
+	DownloadStreamsPerClient = getEnvInt("DOWNLOAD_STREAMS_PER_CLIENT", 0)
+	ZipWorkers = getEnvInt("ZIP_WORKERS", runtime.NumCPU())
+	ArchiveTTL = getEnvDuration("ARCHIVE_TTL", time.Hour)
+
+	MultipartMemory = getEnvInt64("MULTIPART_MEMORY", 8<<20)
+
+	BenchMaxSize = getEnvInt64("BENCH_MAX_SIZE", 1<<30)
+
+	// Behind a load balancer, REDIS_URL lets any replica continue an upload or
+	// count a client's requests; TUS_STAGING_DIR must then be shared as well
+	RedisURL = os.Getenv("REDIS_URL")
+	TusStagingDir = getEnvString("TUS_STAGING_DIR", filepath.Join(os.TempDir(), "nextbrowse-tus"))
+
+	TempCleanupInterval = getEnvDuration("TEMP_CLEANUP_INTERVAL", time.Hour)
+	TempCleanupAge = getEnvDuration("TEMP_CLEANUP_AGE", 24*time.Hour)
+	ShareSweepInterval = getEnvDuration("SHARE_SWEEP_INTERVAL", time.Hour)
+	SearchIndexInterval = getEnvDuration("SEARCH_INDEX_INTERVAL", time.Hour)
+
+	// Background work, e.g. BACKGROUND_HOURS="01:00-07:00,22:30-23:59" on a
+	// NAS that is busy during the day
+	BackgroundWorkers = getEnvInt("BACKGROUND_WORKERS", 2)
+	BackgroundIORate = getEnvInt64("BACKGROUND_IO_RATE", 0)
+	for _, entry := range splitList(os.Getenv("BACKGROUND_HOURS")) {
+		if window, ok := parseHourWindow(entry); ok {
+			BackgroundHours = append(BackgroundHours, window)
+		} else {
+			slog.Warn("Ignoring invalid background hours", "window", entry)
+		}
+	}
+
+	// Maintenance tasks as name=schedule|action|path[|arg], e.g.
+	// TASKS="logs=@weekly|compress|/logs|168h;downloads=0 4 * * *|purge|/downloads|720h;photos=@weekly|integrity|/photos;thumbs=0 1 * * *|thumbnails|/photos|5,6h"
+	for _, entry := range strings.Split(os.Getenv("TASKS"), ";") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		fields := strings.Split(spec, "|")
+		if !ok || name == "" || len(fields) < 3 {
+			continue
+		}
+		task := TaskSchedule{
+			Name:     strings.TrimSpace(name),
+			Schedule: strings.TrimSpace(fields[0]),
+			Action:   strings.ToLower(strings.TrimSpace(fields[1])),
+			Path:     strings.TrimSpace(fields[2]),
+		}
+		if len(fields) > 3 {
+			task.Arg = strings.TrimSpace(fields[3])
+		}
+		Tasks = append(Tasks, task)
+	}
+
+	// Maintenance mode can also be toggled at runtime through the admin API
+	ReadOnly = getEnvBool("READ_ONLY", false)
+	MaintenanceMessage = os.Getenv("MAINTENANCE_MESSAGE")
+	AdminToken = os.Getenv("ADMIN_TOKEN")
+
+	MetricsEnabled = getEnvBool("METRICS_ENABLED", true)
+
+	// Alerts, e.g. ALERT_WEBHOOK_URL="https://ntfy.sh/my-nextbrowse". The format
+	// (generic, slack or ntfy) is guessed from the URL unless given.
+	AlertWebhookURL = os.Getenv("ALERT_WEBHOOK_URL")
+	AlertWebhookFormat = strings.ToLower(os.Getenv("ALERT_WEBHOOK_FORMAT"))
+	AlertServerErrors = getEnvInt("ALERT_5XX_THRESHOLD", 10)
+	AlertServerErrorWindow = getEnvDuration("ALERT_5XX_WINDOW", time.Minute)
+	AlertCooldown = getEnvDuration("ALERT_COOLDOWN", 15*time.Minute)
+
+	// Webhooks as name=url[|events[|paths[|secret]]] with comma-separated
+	// events (a prefix such as "file" selecting all of its kind, "*" all) and
+	// paths, e.g.
+	// WEBHOOKS="plex=http://plex:32400/hook|upload.completed,file.moved,file.deleted|/media;ci=https://ci.example.com/hook|*"
+	for _, entry := range strings.Split(os.Getenv("WEBHOOKS"), ";") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		fields := strings.Split(spec, "|")
+		if !ok || name == "" || strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		hook := Webhook{Name: strings.TrimSpace(name), URL: strings.TrimSpace(fields[0])}
+		if len(fields) > 1 {
+			hook.Events = splitList(fields[1])
+		}
+		if len(fields) > 2 {
+			hook.Paths = splitList(fields[2])
+		}
+		if len(fields) > 3 {
+			hook.Secret = strings.TrimSpace(strings.Join(fields[3:], "|"))
+		}
+		Webhooks = append(Webhooks, hook)
+	}
+	WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	WebhookRetries = getEnvInt("WEBHOOK_RETRIES", 5)
+	WebhookTimeout = getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+
+	// Tags and other metadata live in DATA_DIR/metadata.db; keep it on a
+	// volume so they survive upgrades
+	DataDir = getEnvString("DATA_DIR", "data")
+	ActivityRetention = getEnvDuration("ACTIVITY_RETENTION", 30*24*time.Hour)
+	QuotaUsageTTL = getEnvDuration("QUOTA_USAGE_TTL", 10*time.Minute)
+
+	// Trash, e.g. TRASH_ENABLED="true" TRASH_MAX_SIZE="53687091200" to keep
+	// a month of deletes, up to 50 GiB of them
+	TrashEnabled = getEnvBool("TRASH_ENABLED", false)
+	TrashRetention = getEnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+	TrashMaxSize = getEnvInt64("TRASH_MAX_SIZE", 0)
+	TrashReplaced = getEnvBool("TRASH_REPLACED", false)
+
+	// Snapshots: ZFS .zfs/snapshot directories are found automatically; other
+	// snapshot directories are listed as dir[|subpath], e.g.
+	// SNAPSHOT_DIRS="/mnt/pool/.snapshots|data/files"
+	SnapshotZFS = getEnvBool("SNAPSHOT_ZFS", true)
+	for _, entry := range strings.Split(os.Getenv("SNAPSHOT_DIRS"), ";") {
+		dir, subpath, _ := strings.Cut(strings.TrimSpace(entry), "|")
+		if dir != "" {
+			SnapshotDirs = append(SnapshotDirs, SnapshotDir{Dir: filepath.Clean(dir), Subpath: strings.Trim(subpath, "/")})
+		}
+	}
+}
+
+// parseRateLimitRule parses a "METHODS PATH=N" entry, where METHODS is a
+// comma-separated list or "*" for any method
+func parseRateLimitRule(entry string) (RateLimitRule, bool) {
+	spec, limit, ok := strings.Cut(strings.TrimSpace(entry), "=")
+	fields := strings.Fields(spec)
+	if !ok || len(fields) != 2 {
+		return RateLimitRule{}, false
+	}
+	perMinute, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || perMinute < 0 {
+		return RateLimitRule{}, false
+	}
+
+	rule := RateLimitRule{
+		Path:      filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(fields[1], "/"))),
+		PerMinute: perMinute,
+	}
+	if fields[0] != "*" {
+		for _, method := range strings.Split(fields[0], ",") {
+			if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+				rule.Methods = append(rule.Methods, method)
+			}
+		}
+	}
+	return rule, true
+}
+
+// parseHeaderRule parses a "PATTERN|Name: value|..." entry
+func parseHeaderRule(entry string) (HeaderRule, bool) {
+	fields := strings.Split(strings.TrimSpace(entry), "|")
+	rule := HeaderRule{Pattern: strings.TrimSpace(fields[0])}
+	if rule.Pattern == "" || len(fields) < 2 {
+		return HeaderRule{}, false
+	}
+	for _, field := range fields[1:] {
+		name, value, ok := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return HeaderRule{}, false
+		}
+		rule.Headers = append(rule.Headers, [2]string{name, strings.TrimSpace(value)})
+	}
+	return rule, true
+}
+
+// parseHourWindow parses a "HH:MM-HH:MM" window, where minutes may be left
+// out and 24:00 is the end of the day
+func parseHourWindow(entry string) (HourWindow, bool) {
+	from, to, ok := strings.Cut(entry, "-")
+	if !ok {
+		return HourWindow{}, false
+	}
+	start, ok := parseClock(from)
+	end, ok2 := parseClock(to)
+	if !ok || !ok2 || start == end {
+		return HourWindow{}, false
+	}
+	return HourWindow{Start: start % (24 * 60), End: end}, true
+}
+
+// parseClock parses "HH:MM" or "HH" into minutes past midnight
+func parseClock(clock string) (int, bool) {
+	hours, minutes, hasMinutes := strings.Cut(strings.TrimSpace(clock), ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || h < 0 || h > 24 {
+		return 0, false
+	}
+	m := 0
+	if hasMinutes {
+		if m, err = strconv.Atoi(minutes); err != nil || m < 0 || m > 59 {
+			return 0, false
+		}
+	}
+	if h == 24 && m != 0 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// setRateLimitRule replaces the rule for the same methods and path, or adds it
+func setRateLimitRule(rules []RateLimitRule, rule RateLimitRule) []RateLimitRule {
+	key := func(r RateLimitRule) string {
+		methods := append([]string(nil), r.Methods...)
+		sort.Strings(methods)
+		return strings.Join(methods, ",") + " " + r.Path
+	}
+	for i := range rules {
+		if key(rules[i]) == key(rule) {
+			rules[i] = rule
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+// getEnvString reads a string environment variable, falling back to def when unset or empty
+// splitList splits a comma-separated list, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvString(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+// getEnvBool reads a boolean environment variable ("true", "1", "false", ...), falling back to def
+func getEnvBool(key string, def bool) bool {
+	if val := os.Getenv(key); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// getEnvInt reads an integer environment variable, falling back to def
+func getEnvInt(key string, def int) int {
+	if val := os.Getenv(key); val != "" {
+		if n, err := strconv.Atoi(val); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// getEnvInt64 reads a 64-bit integer environment variable, falling back to def
+func getEnvInt64(key string, def int64) int64 {
+	if val := os.Getenv(key); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+// getEnvDuration reads a duration environment variable (e.g. "30s", "5m"), falling back to def
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+	return def
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/handlers"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/vfs"
+	"nextbrowse-backend/webhook"
+)
+
+// lowTempSpace is the free space below which staging large uploads and
+// backups in the temp directory is likely to fail
+const lowTempSpace = 1 << 30
+
+// findingLevel is how serious a setup check finding is
+type findingLevel int
+
+const (
+	findingOK findingLevel = iota
+	findingWarning
+	findingProblem
+)
+
+func (l findingLevel) symbol() string {
+	switch l {
+	case findingProblem:
+		return "✗"
+	case findingWarning:
+		return "!"
+	}
+	return "✓"
+}
+
+type finding struct {
+	level findingLevel
+	text  string
+}
+
+// checkSetup verifies what the server relies on at startup or at its first
+// request: directories, backends, schedules, certificates and the port. Each
+// finding says what is wrong and which setting to change. Mounts are
+// connected so backup paths on them can be resolved.
+func checkSetup(port string) []finding {
+	var findings []finding
+	add := func(level findingLevel, format string, args ...any) {
+		findings = append(findings, finding{level, fmt.Sprintf(format, args...)})
+	}
+
+	if info, err := os.Stat(config.RootDir); err != nil {
+		add(findingProblem, "ROOT_PATH: %v", err)
+	} else if !info.IsDir() {
+		add(findingProblem, "ROOT_PATH: %s is not a directory", config.RootDir)
+	} else if check := handlers.ProbeBackend(vfs.OS, config.RootDir); check.Status != "ok" {
+		add(findingProblem, "ROOT_PATH: %s: %s; the server needs write access to it", config.RootDir, check.Error)
+	} else {
+		add(findingOK, "ROOT_PATH %s is writable", config.RootDir)
+	}
+
+	if config.RedisURL != "" {
+		if err := models.ConnectRedis(config.RedisURL); err != nil {
+			add(findingProblem, "REDIS_URL: %v", err)
+		} else {
+			add(findingOK, "Redis is reachable")
+		}
+	}
+
+	mountsOK := true
+	for mountPath, rawURL := range config.Mounts {
+		fsys, err := vfs.New(mountPath, rawURL)
+		if err != nil {
+			add(findingProblem, "MOUNTS: %s: %v", mountPath, err)
+			mountsOK = false
+			continue
+		}
+		vfs.Mount(mountPath, fsys)
+
+		if check := handlers.ProbeBackend(fsys, mountPath); check.Status != "ok" {
+			add(findingProblem, "MOUNTS: %s: %s", mountPath, check.Error)
+			mountsOK = false
+		} else {
+			add(findingOK, "Mount %s is reachable and writable", mountPath)
+		}
+	}
+
+	// Backups and tasks on a broken mount would only repeat the mount's problem
+	if mountsOK {
+		for _, backup := range config.Backups {
+			if err := handlers.CheckBackup(backup); err != nil {
+				add(findingProblem, "BACKUPS: %v", err)
+			}
+		}
+		for _, task := range config.Tasks {
+			if err := handlers.CheckTask(task); err != nil {
+				add(findingProblem, "TASKS: %v", err)
+			}
+		}
+	}
+
+	for _, hook := range config.Webhooks {
+		if err := webhook.Check(hook); err != nil {
+			add(findingProblem, "WEBHOOKS: %v", err)
+		}
+	}
+
+	for _, dir := range config.SnapshotDirs {
+		if info, err := os.Stat(dir.Dir); err != nil {
+			add(findingProblem, "SNAPSHOT_DIRS: %v", err)
+		} else if !info.IsDir() {
+			add(findingProblem, "SNAPSHOT_DIRS: %s is not a directory", dir.Dir)
+		}
+	}
+
+	findings = append(findings, checkTempDirs()...)
+
+	if err := checkTLS(); err != nil {
+		add(findingProblem, "%v", err)
+	}
+
+	addrs := []string{":" + port}
+	if len(config.ACMEDomains) > 0 && config.ACMEHTTPAddr != "" {
+		addrs = append(addrs, config.ACMEHTTPAddr)
+	}
+	for _, addr := range addrs {
+		if err := checkListen(addr); err != nil {
+			add(findingProblem, "Cannot listen on %s: %v; choose another port or stop what is using it", addr, err)
+		} else {
+			add(findingOK, "Port %s is free", addr)
+		}
+	}
+	return findings
+}
+
+// checkTempDirs checks the directories where uploads to mounts and backup
+// archives are staged
+func checkTempDirs() []finding {
+	var findings []finding
+	add := func(level findingLevel, format string, args ...any) {
+		findings = append(findings, finding{level, fmt.Sprintf(format, args...)})
+	}
+
+	tmp := os.TempDir()
+	if err := checkWritableDir(tmp); err != nil {
+		add(findingProblem, "Temp directory %s: %v; set TMPDIR to a writable directory", tmp, err)
+		return findings
+	}
+
+	if same, err := sameFilesystem(tmp, config.RootDir); err == nil {
+		if same {
+			add(findingOK, "Temp directory %s is on the same filesystem as ROOT_PATH", tmp)
+		} else {
+			add(findingOK, "Temp directory %s is on a different filesystem from ROOT_PATH", tmp)
+		}
+	}
+	if free, err := freeSpace(tmp); err == nil && free < lowTempSpace {
+		add(findingWarning, "Temp directory %s has only %d MB free; uploads to mounts and backups are staged there, set TMPDIR to a larger disk",
+			tmp, free>>20)
+	}
+
+	if len(config.Mounts) > 0 {
+		if err := os.MkdirAll(config.TusStagingDir, 0755); err != nil {
+			add(findingProblem, "TUS_STAGING_DIR: %v", err)
+		} else if err := checkWritableDir(config.TusStagingDir); err != nil {
+			add(findingProblem, "TUS_STAGING_DIR: %v", err)
+		}
+		if config.RedisURL != "" && filepath.Clean(config.TusStagingDir) == filepath.Join(tmp, "nextbrowse-tus") {
+			add(findingWarning, "TUS_STAGING_DIR is the local temp directory; with several replicas set it to a directory they share")
+		}
+	}
+	return findings
+}
+
+// checkWritableDir creates and removes a file in dir
+func checkWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".nextbrowse-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkTLS makes sure at most one way of serving HTTPS is configured and
+// that a certificate pair loads
+func checkTLS() error {
+	if len(config.ACMEDomains) > 0 && (config.TLSCert != "" || config.TLSKey != "") {
+		return errors.New("TLS: set either ACME_DOMAINS or TLS_CERT/TLS_KEY, not both")
+	}
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return errors.New("TLS: TLS_CERT and TLS_KEY must be set together")
+	}
+	if config.TLSCert != "" {
+		if _, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey); err != nil {
+			return fmt.Errorf("TLS: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkListen binds addr and releases it again
+func checkListen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
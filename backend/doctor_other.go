@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func sameFilesystem(a, b string) (bool, error) {
+	return false, errUnsupported
+}
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,183 @@
+// Package events broadcasts what happens to the tree — uploads, writes,
+// deletes, moves, new shares, job progress and what integrity checks find —
+// to subscribers such as the /api/events stream, so clients can update live
+// instead of polling.
+package events
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	UploadCompleted = "upload.completed"
+	FileCreated     = "file.created"
+	FileWritten     = "file.written"
+	FileDeleted     = "file.deleted"
+	FileMoved       = "file.moved"
+	FileRestored    = "file.restored"
+	DirCreated      = "dir.created"
+	LinkCreated     = "link.created"
+	ShareCreated    = "share.created"
+	JobStarted      = "job.started"
+	JobProgress     = "job.progress"
+	JobFinished     = "job.finished"
+
+	// Found by integrity tasks: files that appeared, were modified or
+	// disappeared since the last check, and files whose contents changed
+	// while their size and modification time did not
+	IntegrityNew       = "integrity.new"
+	IntegrityChanged   = "integrity.changed"
+	IntegrityMissing   = "integrity.missing"
+	IntegrityCorrupted = "integrity.corrupted"
+)
+
+// Event is one change. Path and Destination are user paths; Actor is the
+// address of the client that made the change, when known. Data holds details
+// that depend on the type, such as the job for job events.
+type Event struct {
+	ID          int64  `json:"id"`
+	Type        string `json:"type"`
+	Path        string `json:"path,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+	Time        int64  `json:"time"`
+	Data        any    `json:"data,omitempty"`
+}
+
+// Filter selects events for a subscriber. Empty lists select everything.
+type Filter struct {
+	// Paths selects events on, below or above any of these paths, so that
+	// watching a directory also reports the removal of its parent
+	Paths []string
+
+	// Types selects events by type; "job" selects every "job.*" type
+	Types []string
+}
+
+// Match reports whether the filter selects e
+func (f Filter) Match(e Event) bool {
+	if len(f.Types) > 0 && !matchType(f.Types, e.Type) {
+		return false
+	}
+	if len(f.Paths) == 0 {
+		return true
+	}
+	for _, p := range f.Paths {
+		if related(p, e.Path) || related(p, e.Destination) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchType(types []string, typ string) bool {
+	for _, t := range types {
+		if t == typ || strings.HasPrefix(typ, t+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// related reports whether one of two user paths contains the other
+func related(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	a, b = path.Clean("/"+a), path.Clean("/"+b)
+	return within(a, b) || within(b, a)
+}
+
+func within(dir, p string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+// historySize is how many past events are kept for subscribers that
+// reconnect and ask for what they missed
+const historySize = 512
+
+// subscriberBuffer is how far a subscriber may fall behind before it is
+// dropped; it can reconnect and catch up from the history
+const subscriberBuffer = 256
+
+// Subscription receives the events its filter selects on C. C is closed when
+// the subscriber fell too far behind or Close was called.
+type Subscription struct {
+	C <-chan Event
+
+	c      chan Event
+	filter Filter
+}
+
+var (
+	mu          sync.Mutex
+	lastID      int64
+	history     []Event
+	subscribers = make(map[*Subscription]struct{})
+)
+
+// Publish sends an event to every subscriber whose filter selects it. It
+// never blocks.
+func Publish(e Event) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastID++
+	e.ID = lastID
+	e.Time = time.Now().UnixMilli()
+	if len(history) == historySize {
+		history = history[1:]
+	}
+	history = append(history, e)
+
+	for sub := range subscribers {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			delete(subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe starts receiving the events filter selects. With a non-zero
+// after, it also returns the remembered events since the one with that ID;
+// complete is false when some of them have already been forgotten.
+func Subscribe(filter Filter, after int64) (sub *Subscription, missed []Event, complete bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: c, c: c, filter: filter}
+	subscribers[sub] = struct{}{}
+
+	complete = true
+	if after > 0 {
+		// IDs past the last one come from before a restart
+		complete = after == lastID || after < lastID && history[0].ID <= after+1
+		for _, e := range history {
+			if e.ID > after && filter.Match(e) {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return sub, missed, complete
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := subscribers[s]; ok {
+		delete(subscribers, s)
+		close(s.c)
+	}
+}
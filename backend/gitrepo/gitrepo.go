@@ -0,0 +1,207 @@
+// Package gitrepo reads the state of git working copies in the tree — the
+// branch checked out and which files differ from it — with the git command,
+// so NextBrowse can mark changes in listings of a repository.
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotInstalled is returned when there is no git command to run
+var ErrNotInstalled = errors.New("git is not installed")
+
+// File statuses
+const (
+	Modified   = "modified"
+	Added      = "added"
+	Deleted    = "deleted"
+	Renamed    = "renamed"
+	Copied     = "copied"
+	Untracked  = "untracked"
+	Conflicted = "conflicted"
+)
+
+// Status is the state of a work tree, or of the part of it asked about
+type Status struct {
+	Branch   string // "" when HEAD is detached
+	Commit   string // "" before the first commit
+	Upstream string
+	Ahead    int
+	Behind   int
+	Files    []File
+}
+
+// File is a path that differs from the commit checked out
+type File struct {
+	Path     string // relative to the work tree, slash-separated
+	OrigPath string // what a renamed or copied file was
+	Status   string
+	Staged   bool // the index holds changes to it
+}
+
+// Find returns the work tree containing dir, the nearest directory with a
+// .git entry, looking no higher than top
+func Find(dir, top string) (string, bool) {
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if dir == top || parent == dir || !strings.HasPrefix(parent+string(filepath.Separator), top+string(filepath.Separator)) {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Read returns the status of the work tree at root, listing only the files
+// at or below rel, a slash-separated path relative to root ("" for all).
+// Untracked directories are listed as a whole, with a trailing slash.
+func Read(ctx context.Context, root, rel string) (*Status, error) {
+	pathspec := "."
+	if rel != "" {
+		pathspec = ":(literal)" + rel
+	}
+	// Working copies often belong to other users, and reading one must never
+	// take its index lock from under a git command running there
+	cmd := exec.CommandContext(ctx, "git",
+		"-c", "safe.directory=*", "--no-optional-locks", "-C", root,
+		"status", "--porcelain=v2", "--branch", "-z", "--untracked-files=normal", "--", pathspec)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, ErrNotInstalled
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git status: %s", msg)
+		}
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+	return parse(out), nil
+}
+
+// parse reads the output of git status --porcelain=v2 --branch -z
+func parse(out []byte) *Status {
+	s := &Status{}
+	records := strings.Split(string(out), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		switch {
+		case strings.HasPrefix(record, "# "):
+			s.header(record[2:])
+		case strings.HasPrefix(record, "1 "):
+			if fields := strings.SplitN(record, " ", 9); len(fields) == 9 {
+				s.Files = append(s.Files, changed(fields[1], fields[8], ""))
+			}
+		case strings.HasPrefix(record, "2 "):
+			// The original path follows as a record of its own
+			if fields := strings.SplitN(record, " ", 10); len(fields) == 10 && i+1 < len(records) {
+				i++
+				s.Files = append(s.Files, changed(fields[1], fields[9], records[i]))
+			}
+		case strings.HasPrefix(record, "u "):
+			if fields := strings.SplitN(record, " ", 11); len(fields) == 11 {
+				s.Files = append(s.Files, File{Path: fields[10], Status: Conflicted})
+			}
+		case strings.HasPrefix(record, "? "):
+			s.Files = append(s.Files, File{Path: record[2:], Status: Untracked})
+		}
+	}
+	return s
+}
+
+func (s *Status) header(line string) {
+	key, value, _ := strings.Cut(line, " ")
+	switch key {
+	case "branch.oid":
+		if value != "(initial)" {
+			s.Commit = value
+		}
+	case "branch.head":
+		if value != "(detached)" {
+			s.Branch = value
+		}
+	case "branch.upstream":
+		s.Upstream = value
+	case "branch.ab":
+		ahead, behind, _ := strings.Cut(value, " ")
+		s.Ahead, _ = strconv.Atoi(strings.TrimPrefix(ahead, "+"))
+		s.Behind, _ = strconv.Atoi(strings.TrimPrefix(behind, "-"))
+	}
+}
+
+// changed makes a File of an ordinary or renamed entry, whose XY holds the
+// change in the index and in the work tree, "." meaning none
+func changed(xy, p, orig string) File {
+	f := File{Path: p, OrigPath: orig, Staged: xy[0] != '.'}
+	change := xy[1]
+	if change == '.' {
+		change = xy[0]
+	}
+	switch change {
+	case 'A':
+		f.Status = Added
+	case 'D':
+		f.Status = Deleted
+	case 'R':
+		f.Status = Renamed
+	case 'C':
+		f.Status = Copied
+	default:
+		f.Status = Modified
+	}
+	return f
+}
+
+// Markers returns a function giving the status to show for a path relative
+// to the work tree: the file's own, untracked for anything in an untracked
+// directory, and modified for a directory with changes below it
+func (s *Status) Markers() func(rel string) string {
+	exact := make(map[string]string)
+	untrackedDirs := make(map[string]bool)
+	changedDirs := make(map[string]bool)
+	for _, f := range s.Files {
+		p := strings.TrimSuffix(f.Path, "/")
+		exact[p] = f.Status
+		if f.Status == Untracked && strings.HasSuffix(f.Path, "/") {
+			untrackedDirs[p] = true
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if changedDirs[dir] {
+				break
+			}
+			changedDirs[dir] = true
+		}
+	}
+
+	return func(rel string) string {
+		if status, ok := exact[rel]; ok {
+			return status
+		}
+		if changedDirs[rel] {
+			return Modified
+		}
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			if untrackedDirs[dir] {
+				return Untracked
+			}
+		}
+		return ""
+	}
+}
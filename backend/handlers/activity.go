@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+)
+
+// GetActivity returns the changes made through the server on or below ?path=
+// (the whole tree by default), newest first: uploads, writes, deletes,
+// moves into and out of it, new directories, links and shares, with the
+// address of the client that made each. ?type= (comma-separated, "file" for
+// all file events) keeps some types only. The path need not exist any more,
+// so the history of a deleted directory can still be read.
+func GetActivity(c *gin.Context) {
+	if config.ActivityRetention <= 0 {
+		problem.Respond(c, http.StatusServiceUnavailable, "Activity log is disabled")
+		return
+	}
+	if !metadata.Available() {
+		problem.Respond(c, http.StatusServiceUnavailable, metadata.ErrUnavailable.Error())
+		return
+	}
+	fsys, name, err := utils.ResolveFS(c.DefaultQuery("path", "/"))
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	dir := displayPath(fsys, name)
+
+	var filter events.Filter
+	for _, list := range c.QueryArray("type") {
+		for _, t := range strings.Split(list, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types = append(filter.Types, t)
+			}
+		}
+	}
+
+	activity := []metadata.Activity{}
+	err = metadata.ActivityBelow(dir, func(a metadata.Activity) bool {
+		if filter.Match(events.Event{Type: a.Type}) {
+			activity = append(activity, a)
+		}
+		return true
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read activity: "+err.Error())
+		return
+	}
+
+	response := gin.H{
+		"ok":       true,
+		"path":     dir,
+		"activity": activity,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["activity"], response["pagination"] = paginate(activity, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+)
+
+type MaintenanceRequest struct {
+	Enabled *bool  `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// GetMaintenance reports whether the server is in read-only maintenance mode
+func GetMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"ok":          true,
+		"maintenance": models.GetMaintenance(),
+	})
+}
+
+// SetMaintenance turns read-only maintenance mode on or off. Requests that
+// would change files get 503 with the message while it is on.
+func SetMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		problem.Respond(c, http.StatusBadRequest, "Missing enabled flag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":          true,
+		"maintenance": models.SetMaintenance(*req.Enabled, req.Message),
+	})
+}
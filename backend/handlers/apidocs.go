@@ -0,0 +1,821 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/openapi"
+	"nextbrowse-backend/scheduler"
+	"nextbrowse-backend/webhook"
+)
+
+// Parameters shared by several routes
+var (
+	pathParam = openapi.Param{Name: "path", Description: "Path relative to the root", Required: true}
+	// hiddenParam reveals what listings and ZIP downloads hide, to admins
+	hiddenParam = openapi.Param{Name: "hidden", Type: "boolean", Description: "Include dotfiles and HIDDEN_PATTERNS matches; needs the admin token"}
+	pageParams  = []openapi.Param{
+		{Name: "offset", Type: "integer", Description: "First item to return; enables pagination"},
+		{Name: "limit", Type: "integer", Description: "Items per page, at most 1000 (default 50)"},
+		{Name: "page", Type: "integer", Description: "Page number from 1, used with pageSize instead of offset/limit"},
+		{Name: "pageSize", Type: "integer", Description: "Items per page when paging by page number"},
+	}
+	ifMatchHeaders = []openapi.Param{
+		{Name: "If-Match", Description: "ETag the file must still have, as returned by read or a previous write"},
+		{Name: "If-None-Match", Description: "\"*\" to only create the file if it does not exist"},
+	}
+	octetStream = openapi.Raw("application/octet-stream")
+	zipArchive  = openapi.Raw("application/zip")
+)
+
+// apiDocs documents the API routes, keyed by method and gin path. Routes under
+// /api/ missing here are still listed in the specification, and logged at
+// startup, so the list is kept in step with the routes in main.go.
+var apiDocs = map[string]openapi.Op{
+	"GET /api/fs/list": {
+		Summary:     "List a directory",
+		Description: "Lists the entries of a directory, folders first. Without offset/limit or page/pageSize every entry is returned.",
+		Query: append([]openapi.Param{
+			{Name: "path", Description: "Directory to list (default /)"},
+			{Name: "itemCounts", Type: "boolean", Description: "Count the visible children of each directory"},
+			{Name: "tag", Description: "Only entries with this tag"},
+			{Name: "notes", Type: "boolean", Description: "Include the notes attached to entries"},
+			{Name: "git", Type: "boolean", Description: "Mark entries that differ in the git working copy the directory is in"},
+			{Name: "fields", Description: "Comma-separated entry members to include besides name and type: size, mtime, url, target (default all). Without size and mtime entries aren't stat'ed and mtime is 0."},
+			hiddenParam,
+		}, pageParams...),
+		Response: ListResponse{},
+	},
+	"GET /api/fs/recent": {
+		Summary: "List recently modified files",
+		Query: []openapi.Param{
+			{Name: "path", Description: "Directory to search (default /)"},
+			{Name: "limit", Type: "integer", Description: "Number of files, at most 500 (default 50)"},
+		},
+		Response: RecentResponse{},
+	},
+	"GET /api/fs/search": {
+		Summary:     "Search a subtree for names",
+		Description: "Streams SearchEvent lines as NDJSON, or as server-sent events for clients accepting text/event-stream: a match as soon as each is found, progress every half second or so while the walk goes on, and done at the end.",
+		Query: []openapi.Param{
+			{Name: "path", Description: "Directory to search (default /)"},
+			{Name: "q", Description: "Case-insensitive substring of names, or a glob when it has *, ? or ["},
+			{Name: "type", Description: "Only \"file\" or \"dir\" entries"},
+			{Name: "limit", Type: "integer", Description: "Matches to return, at most 10000 (default 1000)"},
+			hiddenParam,
+		},
+		Response: openapi.Raw("application/x-ndjson"),
+	},
+	"GET /api/fs/read": {
+		Summary:     "Read a file",
+		Description: "Returns UTF-8 text as is and anything else base64 encoded. Files over READ_MAX_SIZE are read in parts with range.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "encoding", Description: "\"text\" (default) or \"base64\""},
+			{Name: "range", Description: "Bytes to read: start-end (inclusive), start- or -n for the last n bytes"},
+		},
+		Response: ReadFileResponse{},
+	},
+	"POST /api/fs/copy": {
+		Summary:     "Copy a file or directory",
+		Description: "Runs as a job; with async the job ID is returned at once with status 202.",
+		Request:     CopyMoveRequest{},
+		Response:    OperationResponse{},
+	},
+	"POST /api/fs/move": {
+		Summary:     "Move or rename a file or directory",
+		Description: "Runs as a job; with async the job ID is returned at once with status 202.",
+		Request:     CopyMoveRequest{},
+		Response:    OperationResponse{},
+	},
+	"POST /api/fs/sync": {
+		Summary:     "Make a destination directory match a source",
+		Description: "Copies new and changed files, optionally deleting extra ones. With async the job ID is returned at once with status 202.",
+		Request:     SyncRequest{},
+		Response:    SyncResponse{},
+	},
+	"GET /api/fs/checksums": {
+		Summary:     "Download a checksum manifest of a directory tree",
+		Description: "SHA256SUMS lines as sha256sum writes them, or an .sfv file of CRC32s. Computed as a job while the request waits.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "format", Description: "sha256 (default) or sfv"},
+		},
+		Response: openapi.Raw("text/plain"),
+	},
+	"POST /api/fs/checksums": {
+		Summary:     "Write a checksum manifest into a directory",
+		Description: "Writes SHA256SUMS, or <directory>.sfv for the sfv format, replacing the previous one. With async the job ID is returned at once with status 202.",
+		Request:     ChecksumRequest{},
+		Response:    ChecksumResponse{},
+	},
+	"POST /api/fs/checksums/verify": {
+		Summary:     "Check a directory against its checksum manifest",
+		Description: "Reports files that don't match, are missing or aren't listed; report.intact is true when nothing differs. With async the job ID is returned at once with status 202.",
+		Request:     VerifyRequest{},
+		Response:    VerifyResponse{},
+	},
+	"POST /api/fs/mkdir": {
+		Summary:  "Create a directory",
+		Request:  MkdirRequest{},
+		Response: OperationResponse{},
+	},
+	"POST /api/fs/touch": {
+		Summary:  "Create an empty file",
+		Request:  TouchRequest{},
+		Response: OperationResponse{},
+	},
+	"POST /api/fs/paste": {
+		Summary:     "Save pasted text as a file",
+		Description: "Creates a new file in path from content. Without a name it is called paste-<date>-<time> with the extension of language. With share, the file is also shared and the share returned.",
+		Request:     PasteRequest{},
+		Response:    PasteResponse{},
+		Status:      http.StatusCreated,
+	},
+	"PUT /api/fs/write": {
+		Summary:     "Replace a file's contents",
+		Description: "Writes the request body atomically, creating the file if needed. Send If-Match to refuse overwriting someone else's changes.",
+		Query:       []openapi.Param{pathParam},
+		Headers:     ifMatchHeaders,
+		Request:     octetStream,
+		Response:    WriteFileResponse{},
+	},
+	"POST /api/fs/append": {
+		Summary:  "Append to a file",
+		Query:    []openapi.Param{pathParam},
+		Headers:  ifMatchHeaders,
+		Request:  octetStream,
+		Response: WriteFileResponse{},
+	},
+	"PATCH /api/fs/patch": {
+		Summary:     "Overwrite part of a file",
+		Description: "Writes the request body at offset, extending the file if it runs past the end.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "offset", Type: "integer", Description: "Byte offset to write at", Required: true},
+		},
+		Headers:  ifMatchHeaders,
+		Request:  octetStream,
+		Response: WriteFileResponse{},
+	},
+	"POST /api/fs/office": {
+		Summary:     "Open a document in the office editor",
+		Description: "Only when OFFICE_URL is set. POST access_token and access_token_ttl as a form to editorUrl, e.g. into an iframe, to open the editor, which then reads and saves the file over WOPI at wopiSrc.",
+		Request:     OfficeRequest{},
+		Response:    OfficeSession{},
+	},
+	"POST /api/fs/link": {
+		Summary:  "Create a symbolic or hard link",
+		Request:  LinkRequest{},
+		Response: OperationResponse{},
+	},
+	"GET /api/fs/tags": {
+		Summary:  "Get the tags of a file or directory",
+		Query:    []openapi.Param{pathParam},
+		Response: TagsResponse{},
+	},
+	"POST /api/fs/tags": {
+		Summary:  "Add tags to a file or directory",
+		Request:  TagsRequest{},
+		Response: TagsResponse{},
+	},
+	"PUT /api/fs/tags": {
+		Summary:  "Replace the tags of a file or directory",
+		Request:  TagsRequest{},
+		Response: TagsResponse{},
+	},
+	"DELETE /api/fs/tags": {
+		Summary: "Remove tags from a file or directory",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "tag", Description: "Tag to remove; may be repeated, all tags without any"},
+		},
+		Response: TagsResponse{},
+	},
+	"DELETE /api/fs/delete": {
+		Summary:     "Delete a file or directory",
+		Description: "With TRASH_ENABLED, entries on local storage are moved to the trash instead, and the response has their trashId.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "secure", Type: "boolean", Description: "Overwrite file contents before unlinking, except of files with other hard links, which are only unlinked; skips the trash"},
+			{Name: "permanent", Type: "boolean", Description: "Delete for good instead of moving to the trash"},
+		},
+		Response: OperationResponse{},
+	},
+	"POST /api/fs/delete": {
+		ID:       "deleteFilePost",
+		Summary:  "Delete a file or directory",
+		Request:  DeleteRequest{},
+		Response: OperationResponse{},
+	},
+	"GET /api/fs/download": {
+		Summary: "Download a file, or a directory as ZIP",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "format", Description: "\"zip\" to download a directory"},
+			hiddenParam,
+		},
+		Response: octetStream,
+	},
+	"GET /files/*path": {
+		Summary:     "Serve a file at its public URL",
+		Description: "The url of listed files. Served inline with a type from the file's extension or contents, an ETag for conditional requests and Range support; sandboxed by Content-Security-Policy.",
+		Response:    octetStream,
+	},
+	"HEAD /files/*path": {
+		ID:      "servePublicFileHead",
+		Summary: "Get a public file's size, type and ETag",
+	},
+	"POST /api/fs/download-multiple": {
+		Summary:     "Download several files and directories as one ZIP",
+		Description: "With async, the archive is built in an archive job instead and answered with 202 and the job's ID; the finished job's result has the URL to download it from, with Range support.",
+		Query:       []openapi.Param{hiddenParam},
+		Request:     DownloadMultipleRequest{},
+		Response:    zipArchive,
+	},
+	"POST /api/fs/upload": {
+		Summary:     "Upload files into a directory",
+		Description: "A multipart/form-data body whose path field, naming the directory, comes before any files fields. Files are streamed to storage as they arrive and replace files of the same name. A body that takes longer than UPLOAD_TIMEOUT is answered with 408 when the client was slow and 503 when the server was, with a timeout member saying which.",
+		Request:     openapi.Raw("multipart/form-data"),
+		Response:    openapi.Object{},
+	},
+
+	"POST /api/fs/share/create": {
+		Summary:  "Share a file or directory",
+		Tag:      "shares",
+		Request:  CreateShareRequest{},
+		Response: CreateShareResponse{},
+	},
+	"GET /api/fs/shares": {
+		Summary:  "List shares, newest first",
+		Tag:      "shares",
+		Query:    pageParams,
+		Response: GetSharesResponse{},
+	},
+	"GET /api/fs/share/:shareId": {
+		Summary:  "Describe a share",
+		Tag:      "shares",
+		Response: openapi.Object{"ok": true, "share": models.SharePublic{}, "branding": Branding{}},
+	},
+	"GET /api/fs/share/:shareId/access": {
+		Summary:     "Check a share's password",
+		Description: "Takes the password in a JSON body; valid tells whether it grants access.",
+		Tag:         "shares",
+		Request:     AccessShareRequest{},
+		Response:    AccessShareResponse{},
+	},
+	"GET /api/fs/share/:shareId/meta": {
+		Summary:     "Describe a share for link previews",
+		Description: "Title, description, item count and preview image for Open Graph and Twitter cards. Password-protected shares reveal neither their name nor their contents. With format=html, a page of those tags that redirects browsers to the share, for a proxy to serve to crawlers.",
+		Tag:         "shares",
+		Query:       []openapi.Param{{Name: "format", Description: "\"html\" for a page of meta tags instead of JSON"}},
+		Response:    openapi.Object{"ok": true, "meta": ShareMeta{}},
+	},
+	"GET /api/fs/share/:shareId/thumbnail": {
+		Summary:     "Download a share's preview image",
+		Description: "A shared image, or the first image directly in a shared directory, scaled to 512 pixels. 404 for password-protected shares.",
+		Tag:         "shares",
+		Response:    openapi.Raw("image/jpeg"),
+	},
+	"GET /api/fs/share/:shareId/download": {
+		Summary:     "Download a shared file, or a shared directory as ZIP",
+		Description: "A password-protected share needs its password in X-Share-Password or the password parameter, and is refused with 401 without it and 403 with a wrong one.",
+		Tag:         "shares",
+		Query: []openapi.Param{
+			{Name: "path", Description: "File or directory inside a shared directory"},
+			{Name: "password", Description: "Password of a protected share, for links that can't send X-Share-Password"},
+		},
+		Headers:  []openapi.Param{{Name: "X-Share-Password", Description: "Password of a protected share"}},
+		Response: octetStream,
+	},
+	"GET /api/fs/share/:shareId/torrent": {
+		Summary:     "Download the .torrent of a share",
+		Description: "Web seeded by the share itself; 404 until a torrent was made.",
+		Tag:         "shares",
+		Response:    openapi.Raw("application/x-bittorrent"),
+	},
+	"POST /api/fs/share/:shareId/torrent": {
+		Summary:     "Make a torrent of a share",
+		Description: "Hashes the shared files in a torrent job and replaces the share's torrent; make it again after the files change. Not for password-protected shares.",
+		Tag:         "shares",
+		Response:    ShareTorrentResponse{},
+	},
+	"GET /api/fs/share/:shareId/seed/*path": {
+		Summary:     "Serve a file of a shared directory to BitTorrent clients",
+		Description: "The web seed of a directory share's torrent: path is the directory's name followed by the file's path within it. Supports ranges.",
+		Tag:         "shares",
+		Response:    octetStream,
+	},
+
+	"OPTIONS /api/tus/files": {
+		Summary:     "Discover the TUS server's capabilities",
+		Description: "Reports the protocol version, extensions and maximum size in Tus-* headers.",
+	},
+	"POST /api/tus/files": {
+		Summary:     "Create an upload",
+		Description: "The upload URL is returned in the Location header.",
+		Headers: []openapi.Param{
+			{Name: "Tus-Resumable", Description: "Protocol version, 1.0.0"},
+			{Name: "Upload-Length", Type: "integer", Description: "Size of the file in bytes", Required: true},
+			{Name: "Upload-Metadata", Description: "Comma-separated key and base64 value pairs; filename is required and path names the target directory. fingerprint identifies the file for GET /api/tus/lookup. extract=true unpacks a ZIP into the directory, with conflict (skip, overwrite, keep-newer or rename) for files that exist", Required: true},
+		},
+		Status: http.StatusCreated,
+	},
+	"HEAD /api/tus/files/:id": {
+		Summary:     "Get an upload's offset",
+		Description: "Reports the bytes received so far in Upload-Offset.",
+	},
+	"PATCH /api/tus/files/:id": {
+		Summary:     "Upload a chunk",
+		Description: "Appends the body at Upload-Offset. With piecewise checksums, a completed piece that doesn't match is discarded with everything after it and answered with status 460 and the Upload-Offset to resend from. The file is moved into place once the last byte arrives; a ZIP uploaded with extract=true is unpacked in an extract job named by the X-NextBrowse-Job header instead. A body that takes longer than UPLOAD_TIMEOUT is answered with 408 or 503, as for uploads, and the Upload-Offset reached; the upload goes on from there.",
+		Headers: []openapi.Param{
+			{Name: "Tus-Resumable", Description: "Protocol version, 1.0.0"},
+			{Name: "Upload-Offset", Type: "integer", Description: "Current offset of the upload", Required: true},
+		},
+		Request: openapi.Raw("application/offset+octet-stream"),
+		Status:  http.StatusNoContent,
+	},
+	"PUT /api/tus/files/:id/pieces": {
+		Summary:     "Set an upload's piecewise checksums",
+		Description: "Lists the SHA-256 of every pieceSize bytes of the upload, the last piece holding the rest. Pieces already received are checked at once and each PATCH checks the pieces it completes. A piece that doesn't match is discarded with everything after it and answered with status 460, with Upload-Offset where to resend from.",
+		Request:     PiecesRequest{},
+		Response:    openapi.Object{},
+	},
+	"DELETE /api/tus/files/:id": {
+		Summary: "Cancel an upload",
+		Status:  http.StatusNoContent,
+	},
+	"GET /api/tus/lookup": {
+		Summary:     "Find an upload to resume",
+		Description: "Returns the caller's upload in progress created with this fingerprint in its Upload-Metadata, so that another device of the same user can continue it from offset. Users are told apart by the X-NextBrowse-User header, or by address without it.",
+		Query:       []openapi.Param{{Name: "fingerprint", Description: "The fingerprint the upload was created with", Required: true}},
+		Response:    TusUploadInfo{},
+	},
+	"GET /api/tus/config": {
+		Summary:     "Get upload settings for clients",
+		Description: "chunkSize and maxConcurrentUploads are tuned to how fast the client's recent uploads arrived and how fast the server wrote them; tuning explains the choice.",
+		Response:    openapi.Object{},
+	},
+
+	"GET /api/fs/note": {
+		Summary:  "Get the note attached to a file or directory",
+		Query:    []openapi.Param{pathParam},
+		Response: NoteResponse{},
+	},
+	"PUT /api/fs/note": {
+		Summary:     "Attach a note to a file or directory",
+		Description: "Replaces any note the path had; blank text removes it.",
+		Request:     NoteRequest{},
+		Response:    NoteResponse{},
+	},
+	"DELETE /api/fs/note": {
+		Summary:  "Remove the note attached to a file or directory",
+		Query:    []openapi.Param{pathParam},
+		Response: NoteResponse{},
+	},
+
+	"GET /api/fs/activity": {
+		Summary:     "List the changes made on or below a path, newest first",
+		Description: "Uploads, writes, deletes, moves into and out of the path, new directories, links and shares made through the server, with the address of the client that made each. The path need not exist any more.",
+		Query: append([]openapi.Param{
+			{Name: "path", Description: "Directory or file whose history is listed (default /)"},
+			{Name: "type", Description: "Comma-separated event types, such as file.deleted or file for every file event"},
+		}, pageParams...),
+		Response: openapi.Object{"ok": true, "path": "", "activity": []metadata.Activity{}, "pagination": (*Pagination)(nil)},
+	},
+
+	"GET /api/fs/diff": {
+		Summary:     "Compare two files or two directories",
+		Description: "Text files up to DIFF_MAX_SIZE get a unified diff; larger or binary files are compared by checksum. Directories list the entries added, removed or changed below them, changed meaning a different type, size or modification time, or different contents with hash=true.",
+		Query: append([]openapi.Param{
+			{Name: "from", Required: true, Description: "File or directory compared"},
+			{Name: "to", Required: true, Description: "File or directory compared with"},
+			{Name: "context", Type: "integer", Description: "Unchanged lines shown around changes in a file diff (default 3)"},
+			{Name: "hash", Type: "boolean", Description: "Compare the contents of directory entries of the same size rather than their modification times"},
+		}, pageParams...),
+		Response: DiffResponse{},
+	},
+	"GET /api/fs/git": {
+		Summary:     "Get the git status of a working copy",
+		Description: "The branch of the git working copy holding path and the files at or below path that differ from it. Only local storage; 404 outside a working copy.",
+		Query:       append([]openapi.Param{{Name: "path", Description: "File or directory in the working copy (default /)"}}, pageParams...),
+		Response:    GitResponse{},
+	},
+	"GET /api/fs/table": {
+		Summary:     "Preview the rows of a CSV, TSV or XLSX file",
+		Description: "A page of rows, 50 by default, with a type inferred for each column from the page: integer, number, boolean, date or string. Delimited text is streamed, so totalItems and totalPages are -1 until the last page.",
+		Query: append([]openapi.Param{
+			pathParam,
+			{Name: "format", Description: "csv or xlsx (default from the extension)"},
+			{Name: "delimiter", Description: "Delimiter of csv, or tab (default guessed)"},
+			{Name: "sheet", Description: "Sheet of xlsx (default the first)"},
+			{Name: "header", Type: "boolean", Description: "Whether the first row names the columns (default true)"},
+		}, pageParams...),
+		Response: TableResponse{},
+	},
+	"GET /api/fs/iso": {
+		Summary:     "List a directory inside an ISO image",
+		Description: "Reads the ISO9660 image at path without mounting it, with Rock Ridge or Joliet names when it has them. UDF-only images are refused with 422.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "entry", Description: "Directory inside the image (default /)"},
+		},
+		Response: ISOListing{},
+	},
+	"GET /api/fs/iso/download": {
+		Summary: "Download a file from inside an ISO image",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "entry", Description: "File inside the image", Required: true},
+		},
+		Response: octetStream,
+	},
+	"POST /api/fs/iso/extract": {
+		Summary:     "Extract a file from an ISO image into a directory",
+		Description: "Fails with 409 if the destination already has a file of that name.",
+		Request:     ISOExtractRequest{},
+		Response: openapi.Object{
+			"ok": true, "message": "", "path": "", "size": 0, "etag": "",
+		},
+	},
+	"GET /api/fs/gallery": {
+		Summary:     "List the images of a directory for a photo grid",
+		Description: "JPEG, PNG and GIF images with their displayed dimensions, capture dates from EXIF and thumbnail URLs, sorted by capture date or, without one, modification time. Always paginated.",
+		Query: append([]openapi.Param{
+			{Name: "path", Description: "Directory (default /)"},
+			{Name: "order", Description: "asc (default) or desc for the newest first"},
+			{Name: "size", Type: "integer", Description: "Size of the thumbnails linked to, 32 to 1024 (default 256)"},
+		}, pageParams...),
+		Response: GalleryResponse{},
+	},
+	"GET /api/fs/frequent": {
+		Summary:     "List the directories the user lists most often",
+		Description: "Users are told apart by the X-NextBrowse-User header, or by address without it.",
+		Query:       []openapi.Param{{Name: "limit", Type: "integer", Description: "How many directories (default 10, at most 100)"}},
+		Response:    openapi.Object{"ok": true, "directories": []metadata.Visit{}},
+	},
+	"GET /api/fs/pins": {
+		Summary:  "List the directories the user pinned",
+		Response: PinsResponse{},
+	},
+	"POST /api/fs/pins": {
+		Summary:  "Pin a directory",
+		Request:  PinRequest{},
+		Response: PinsResponse{},
+	},
+	"PUT /api/fs/pins": {
+		Summary:     "Replace the user's pins",
+		Description: "Used to reorder pins; an empty list removes them all.",
+		Request:     SetPinsRequest{},
+		Response:    PinsResponse{},
+	},
+	"DELETE /api/fs/pins": {
+		Summary:  "Unpin a directory",
+		Query:    []openapi.Param{pathParam},
+		Response: PinsResponse{},
+	},
+
+	"GET /api/tags": {
+		Summary:  "List tags with how many paths carry them",
+		Query:    []openapi.Param{{Name: "path", Description: "Only tags used on or below this path (default /)"}},
+		Response: openapi.Object{"ok": true, "tags": []TagCount{}},
+	},
+	"GET /api/tags/:tag": {
+		Summary: "Find the files and directories with a tag",
+		Query: append([]openapi.Param{
+			{Name: "path", Description: "Only look on or below this path (default /)"},
+		}, pageParams...),
+		Response: openapi.Object{"ok": true, "tag": "", "items": []TaggedItem{}, "pagination": (*Pagination)(nil)},
+	},
+
+	"GET /api/jobs": {
+		Summary:  "List jobs, newest first",
+		Query:    pageParams,
+		Response: openapi.Object{"ok": true, "jobs": []*models.JobInfo{}, "pagination": (*Pagination)(nil)},
+	},
+	"GET /api/jobs/:id": {
+		Summary:  "Get a job's progress",
+		Response: openapi.Object{"ok": true, "job": models.JobInfo{}},
+	},
+	"GET /api/jobs/:id/events": {
+		Summary:     "Stream a job's progress as server-sent events",
+		Description: "Starts with the job as it stands, then sends job.progress about once a second with the current file, bytes done and total, and bytesPerSecond, and ends after job.finished. Each event's data is the job.",
+		Response:    openapi.Raw("text/event-stream"),
+	},
+	"GET /api/jobs/:id/archive": {
+		Summary:     "Download the archive an archive job built",
+		Description: "Supports Range requests, so an interrupted download can resume. The archive is deleted ARCHIVE_TTL after the job finishes.",
+		Response:    zipArchive,
+	},
+	"DELETE /api/jobs/:id": {
+		Summary:  "Cancel a job",
+		Response: OperationResponse{},
+	},
+
+	"GET /api/thumbnail": {
+		Summary:     "Get a JPEG thumbnail of an image",
+		Description: "Scaled to fit within size pixels square and turned upright. Thumbnails are cached in DATA_DIR/thumbnails until the image changes.",
+		Query: []openapi.Param{
+			pathParam,
+			{Name: "size", Type: "integer", Description: "Longest side, 32 to 1024 (default 256)"},
+		},
+		Response: openapi.Raw("image/jpeg"),
+	},
+
+	"GET /api/events": {
+		Summary:     "Stream changes and job progress as server-sent events",
+		Description: "Each event's data is a JSON object with id, type, path, destination, actor (the client's address), time and type-specific data. Reconnecting with Last-Event-ID replays missed events, or sends a resync event when they are no longer known.",
+		Query: []openapi.Param{
+			{Name: "path", Description: "Only events on, below or above this path; may be repeated"},
+			{Name: "type", Description: "Comma-separated event types, such as file.deleted or job for every job event"},
+		},
+		Headers:  []openapi.Param{{Name: "Last-Event-ID", Type: "integer", Description: "ID of the last event received"}},
+		Response: openapi.Raw("text/event-stream"),
+	},
+
+	"GET /api/admin/stats": {
+		Summary:     "Get usage statistics",
+		Description: "The traffic of today and of this month in the server's time zone: requests, files served, bytes uploaded and downloaded, distinct users and the share of requests that failed. Also the files downloaded most this month and the jobs running by type. Usage is kept in the metadata store, which writes it out once a minute, for this month and the last.",
+		Auth:        true,
+		Query: []openapi.Param{
+			{Name: "top", Type: "integer", Description: "How many of the most downloaded files to list, 1-100 (default 10)"},
+		},
+		Response: openapi.Object{
+			"ok":           true,
+			"today":        UsageSummary{},
+			"month":        UsageSummary{},
+			"topDownloads": []metadata.Served{},
+			"jobs":         JobStats{},
+		},
+	},
+	"GET /api/admin/maintenance": {
+		Summary:  "Get maintenance mode",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "maintenance": models.Maintenance{}},
+	},
+	"PUT /api/admin/maintenance": {
+		Summary:     "Turn maintenance mode on or off",
+		Description: "While enabled, requests that change files are refused with 503.",
+		Auth:        true,
+		Request:     MaintenanceRequest{},
+		Response:    openapi.Object{"ok": true, "maintenance": models.Maintenance{}},
+	},
+	"GET /api/admin/webhooks": {
+		Summary:  "List webhooks",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "webhooks": []webhook.Info{}},
+	},
+	"GET /api/admin/webhooks/:name/deliveries": {
+		Summary:  "List a webhook's recent deliveries",
+		Auth:     true,
+		Query:    pageParams,
+		Response: openapi.Object{"ok": true, "deliveries": []webhook.Delivery{}, "pagination": (*Pagination)(nil)},
+	},
+	"POST /api/admin/webhooks/:name/ping": {
+		Summary:  "Send a test event to a webhook",
+		Auth:     true,
+		Status:   http.StatusAccepted,
+		Response: openapi.Object{"ok": true, "delivery": webhook.Delivery{}},
+	},
+	"GET /api/admin/tasks": {
+		Summary:  "List scheduled tasks",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "tasks": []scheduler.Info{}},
+	},
+	"GET /api/admin/tasks/:name/runs": {
+		Summary:  "List a task's recent runs",
+		Auth:     true,
+		Query:    pageParams,
+		Response: openapi.Object{"ok": true, "runs": []scheduler.Run{}, "pagination": (*Pagination)(nil)},
+	},
+	"POST /api/admin/tasks/:name/run": {
+		Summary:  "Run a task now",
+		Auth:     true,
+		Status:   http.StatusAccepted,
+		Response: openapi.Object{"ok": true, "run": scheduler.Run{}},
+	},
+	"GET /api/admin/quotas": {
+		Summary:     "List directory quotas",
+		Description: "Each quota with the bytes and files below its directory. Usage is measured by walking the directory and trusted for QUOTA_USAGE_TTL, counting what uploads, copies and moves added since.",
+		Auth:        true,
+		Query:       []openapi.Param{{Name: "refresh", Description: "Walk every directory again (true)"}},
+		Response:    openapi.Object{"ok": true, "quotas": []QuotaStatus{}},
+	},
+	"PUT /api/admin/quotas": {
+		Summary:     "Set a directory's quota",
+		Description: "Caps the bytes and/or files below a directory. Uploads, copies and moves that would take it over get 507 with the quota.",
+		Auth:        true,
+		Request:     QuotaRequest{},
+		Response:    openapi.Object{"ok": true, "quota": QuotaStatus{}},
+	},
+	"DELETE /api/admin/quotas": {
+		Summary:  "Remove a directory's quota",
+		Auth:     true,
+		Query:    []openapi.Param{{Name: "path", Description: "Directory whose quota to remove", Required: true}},
+		Response: openapi.Object{"ok": true, "message": ""},
+	},
+	"GET /api/admin/preview-cache": {
+		Summary:  "Get the preview cache's size",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "cache": PreviewCacheStatus{}},
+	},
+	"PUT /api/admin/preview-cache": {
+		Summary:     "Set the preview cache's maximum size",
+		Description: "Until the server restarts, when PREVIEW_CACHE_MAX_SIZE applies again. The least recently used previews are evicted right away if the cache is over it.",
+		Auth:        true,
+		Request:     PreviewCacheRequest{},
+		Response:    openapi.Object{"ok": true, "cache": PreviewCacheStatus{}},
+	},
+	"DELETE /api/admin/preview-cache": {
+		Summary:  "Purge the preview cache",
+		Auth:     true,
+		Query:    []openapi.Param{{Name: "path", Description: "Only purge the previews of this file or directory and everything below it"}},
+		Response: openapi.Object{"ok": true, "message": "", "removed": 0, "freed": 0},
+	},
+
+	"GET /api/admin/themes": {
+		Summary:  "List share themes",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "themes": []metadata.Theme{}},
+	},
+	"PUT /api/admin/themes/:name": {
+		Summary:     "Define a share theme",
+		Description: "Replaces the theme's title and colors, keeping its logo. Shares name a theme in theme; the theme called default brands the shares that name none or one that isn't defined.",
+		Auth:        true,
+		Request:     ThemeRequest{},
+		Response:    openapi.Object{"ok": true, "theme": metadata.Theme{}},
+	},
+	"DELETE /api/admin/themes/:name": {
+		Summary:  "Remove a share theme and its logo",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "message": ""},
+	},
+	"PUT /api/admin/themes/:name/logo": {
+		Summary:     "Upload a share theme's logo",
+		Description: "The body is a PNG, JPEG, GIF or WebP image of at most 1 MiB.",
+		Auth:        true,
+		Request:     octetStream,
+		Response:    openapi.Object{"ok": true, "branding": Branding{}},
+	},
+	"DELETE /api/admin/themes/:name/logo": {
+		Summary:  "Remove a share theme's logo",
+		Auth:     true,
+		Response: openapi.Object{"ok": true, "message": ""},
+	},
+	"GET /api/themes/:name/logo": {
+		Summary:  "Download a share theme's logo",
+		Response: openapi.Raw("image/*"),
+	},
+
+	"GET /api/snapshots": {
+		Summary:  "List snapshots",
+		Query:    []openapi.Param{{Name: "path", Description: "Include this path's entry in each snapshot that has it"}},
+		Response: openapi.Object{"ok": true, "snapshots": []SnapshotInfo{}},
+	},
+	"GET /api/snapshots/:id/list": {
+		Summary:  "List a directory in a snapshot",
+		Query:    []openapi.Param{{Name: "path", Description: "Directory to list (default /)"}},
+		Response: ListResponse{},
+	},
+	"GET /api/snapshots/:id/download": {
+		Summary:  "Download a file from a snapshot",
+		Query:    []openapi.Param{pathParam},
+		Response: octetStream,
+	},
+	"POST /api/snapshots/:id/restore": {
+		Summary:     "Restore a file or directory from a snapshot",
+		Description: "Runs as a restore job; with async the job ID is returned at once with status 202.",
+		Request:     SnapshotRestoreRequest{},
+		Response:    OperationResponse{},
+	},
+
+	"GET /api/trash": {
+		Summary:     "List the trash",
+		Description: "Entries deleted while TRASH_ENABLED, newest first, with the size of them all.",
+		Query:       pageParams,
+		Response:    openapi.Object{"ok": true, "items": []TrashItem{}, "size": 0},
+	},
+	"DELETE /api/trash": {
+		Summary:  "Empty the trash",
+		Response: OperationResponse{},
+	},
+	"POST /api/trash/:id/restore": {
+		Summary:     "Restore an entry from the trash",
+		Description: "Moves the entry back where it was deleted from, or to destination; either must not exist.",
+		Request:     TrashRestoreRequest{},
+		Response:    OperationResponse{},
+	},
+	"DELETE /api/trash/:id": {
+		Summary:  "Remove an entry from the trash for good",
+		Response: OperationResponse{},
+	},
+
+	"GET /api/backups": {
+		Summary:  "List scheduled backups",
+		Response: openapi.Object{"ok": true, "backups": []BackupInfo{}},
+	},
+	"GET /api/backups/:name/runs": {
+		Summary:  "List a backup's runs",
+		Response: openapi.Object{"ok": true, "runs": []BackupRunInfo{}},
+	},
+	"POST /api/backups/:name/run": {
+		Summary:  "Run a backup now",
+		Status:   http.StatusAccepted,
+		Response: OperationResponse{},
+	},
+
+	"GET /api/openapi.json": {
+		ID:       "getOpenAPISpec",
+		Summary:  "Get this OpenAPI specification",
+		Tag:      "docs",
+		Response: openapi.Object{},
+	},
+	"GET /api/docs": {
+		ID:       "getAPIDocs",
+		Summary:  "Browse this specification in Swagger UI",
+		Tag:      "docs",
+		Response: openapi.Raw("text/html"),
+	},
+
+	"POST /api/bench/upload": {
+		Summary:     "Time an upload that isn't stored",
+		Description: "Reads the body, up to BENCH_MAX_SIZE, and discards it. Comparing the rate with a real upload's tells the network apart from storage and handler overhead.",
+		Request:     octetStream,
+		Response:    BenchResult{},
+	},
+	"GET /api/bench/download": {
+		Summary:     "Time a download that isn't read from storage",
+		Description: "Sends random bytes generated in memory.",
+		Query: []openapi.Param{
+			{Name: "size", Type: "integer", Description: "Bytes to send, at most BENCH_MAX_SIZE (default 104857600)"},
+		},
+		Response: octetStream,
+	},
+
+	"GET /health": {
+		Summary:     "Check the server and its storage backends",
+		Description: "Answers 503 when the root is unavailable.",
+		Response:    HealthResponse{},
+	},
+	"HEAD /health": {
+		ID:      "healthCheckHead",
+		Summary: "Check that the server is up",
+	},
+}
+
+// swaggerUI loads Swagger UI from a CDN and points it at the specification
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>NextBrowse API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// RegisterAPIDocs serves the OpenAPI specification of every route registered
+// so far at /api/openapi.json, and Swagger UI for it at /api/docs. Call it
+// after all other routes.
+func RegisterAPIDocs(r *gin.Engine, version string) {
+	var spec []byte
+	r.GET("/api/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+	r.GET("/api/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUI))
+	})
+
+	doc, undocumented := APISpec(r.Routes(), version)
+	for _, route := range undocumented {
+		slog.Warn("Route missing from the API documentation", "route", route)
+	}
+
+	var err error
+	if spec, err = json.Marshal(doc); err != nil {
+		slog.Error("Failed to build the OpenAPI specification", "error", err)
+	}
+}
+
+// APISpec returns the OpenAPI document for routes and the routes under /api/
+// that apiDocs does not describe
+func APISpec(routes gin.RoutesInfo, version string) (map[string]any, []string) {
+	info := openapi.Info{
+		Title:       "NextBrowse API",
+		Version:     version,
+		Description: "File browsing, editing, sharing and upload API. Errors are RFC 7807 problem details.",
+	}
+	return openapi.Build(info, routes, apiDocs)
+}
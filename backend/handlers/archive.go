@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/vfs"
+)
+
+// archivePrefix starts the names of archives built by archive jobs in the
+// system temp directory, which the temp cleanup task also sweeps
+const archivePrefix = "nextbrowse-archive-"
+
+// ArchiveReport is the result of an archive job
+type ArchiveReport struct {
+	Files     int64  `json:"files"`
+	Size      int64  `json:"size"`                // of the archive so far
+	URL       string `json:"url,omitempty"`       // where the finished archive is downloaded
+	ExpiresAt int64  `json:"expiresAt,omitempty"` // when it is deleted
+}
+
+// archivePath is where the archive of job id is kept
+func archivePath(id string) string {
+	return filepath.Join(os.TempDir(), archivePrefix+id+".zip")
+}
+
+// startArchiveJob builds a ZIP of the given files and directories in an
+// archive job, to be downloaded from /api/jobs/:id/archive once it is done.
+// Unlike a streamed ZIP, a finished archive has a size and can be fetched
+// in ranges, so a download that breaks off resumes where it stopped. The
+// archive is deleted ARCHIVE_TTL after it is finished.
+func startArchiveJob(source string, filesystems []vfs.Filesystem, paths, names []string, showHidden bool) (*models.Job, error) {
+	job, err := models.NewJob("archive", source, "files.zip")
+	if err != nil {
+		return nil, err
+	}
+	job.Limit(config.ArchiveTimeout, "ARCHIVE_TIMEOUT")
+
+	go func() {
+		// The report is kept here and the job given copies of it, as the job
+		// is read from other goroutines while this one updates the report
+		report := &ArchiveReport{}
+		job.SetResult(*report)
+		for i, safePath := range paths {
+			job.AddTotals(scanTreeFS(job.Context(), filesystems[i], safePath))
+		}
+
+		target := archivePath(job.ID)
+		err := buildArchive(job, target, filesystems, paths, names, showHidden, report)
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.SetResult(*report)
+			job.Finish(models.JobCancelled, "Archive cancelled")
+		case err != nil:
+			job.SetResult(*report)
+			job.Finish(models.JobFailed, err.Error())
+		default:
+			expires := time.Now().Add(config.ArchiveTTL)
+			report.URL = "/api/jobs/" + job.ID + "/archive"
+			report.ExpiresAt = expires.UnixMilli()
+			time.AfterFunc(config.ArchiveTTL, func() { _ = os.Remove(target) })
+			job.SetResult(*report)
+			job.Finish(models.JobCompleted, fmt.Sprintf("Archived %d files", report.Files))
+		}
+	}()
+	return job, nil
+}
+
+// buildArchive writes the archive to target, under a temporary name until
+// it is complete
+func buildArchive(job *models.Job, target string, filesystems []vfs.Filesystem, paths, names []string, showHidden bool, report *ArchiveReport) error {
+	partial := target + ".part"
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partial)
+	defer file.Close()
+
+	// A write that fails, on a full disk say, stops the archive
+	ctx, cancel := context.WithCancelCause(job.Context())
+	defer cancel(nil)
+	out := &archiveWriter{w: file, report: report, cancel: cancel}
+
+	zipWriter := newZipWriter(out)
+	pipeline := newZipPipeline(ctx)
+	pipeline.written = func(e *zipEntry) {
+		if !e.dir {
+			report.Files++
+			job.AddBytes(e.size)
+		}
+		job.SetResult(*report)
+		job.ItemDone()
+	}
+	go addAllToZip(ctx, pipeline, filesystems, paths, names, showHidden)
+	pipeline.write(zipWriter)
+
+	if err := context.Cause(ctx); err != nil {
+		return err
+	}
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, target)
+}
+
+// archiveWriter counts what is written to an archive, and cancels building
+// it on the first error
+type archiveWriter struct {
+	w      io.Writer
+	report *ArchiveReport
+	cancel context.CancelCauseFunc
+}
+
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	n, err := a.w.Write(p)
+	a.report.Size += int64(n)
+	if err != nil {
+		a.cancel(err)
+	}
+	return n, err
+}
+
+// DownloadArchive sends the archive built by an archive job. Ranges are
+// supported, so an interrupted download can be resumed until the archive
+// expires.
+func DownloadArchive(c *gin.Context) {
+	id := c.Param("id")
+	if raw, err := hex.DecodeString(id); err != nil || len(raw) != 8 {
+		problem.Respond(c, http.StatusNotFound, "Archive not found")
+		return
+	}
+	file, err := os.Open(archivePath(id))
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "Archive not found or expired")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read archive")
+		return
+	}
+
+	c.Header("Content-Disposition", attachment("files.zip"))
+	c.Header("Content-Type", "application/zip")
+	http.ServeContent(c.Writer, c.Request, "files.zip", info.ModTime(), file)
+}
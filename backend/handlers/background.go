@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
+
+	"nextbrowse-backend/config"
+)
+
+// dirReadCost is what reading a directory counts as against
+// BACKGROUND_IO_RATE, about a block of metadata
+const dirReadCost = 4 << 10
+
+// background paces the work the server does on its own: reconciling the
+// search index, thumbnails tasks and integrity checks. Each file or
+// directory they work on waits for BACKGROUND_HOURS and a free worker, and
+// what they read is held to BACKGROUND_IO_RATE, so that on a small NAS the
+// disk stays free for people browsing.
+var background = newBackgroundLimits(config.BackgroundWorkers, config.BackgroundIORate, config.BackgroundHours)
+
+type backgroundLimits struct {
+	workers *semaphore.Weighted // nil for no limit
+	io      *rate.Limiter       // nil for no limit
+	burst   int
+	hours   []config.HourWindow
+}
+
+func newBackgroundLimits(workers int, bytesPerSecond int64, hours []config.HourWindow) *backgroundLimits {
+	b := &backgroundLimits{hours: hours}
+	if workers > 0 {
+		b.workers = semaphore.NewWeighted(int64(workers))
+	}
+	if bytesPerSecond > 0 {
+		// Reads are let through a chunk at a time, so the burst must fit a
+		// buffer without letting a slow limit read far ahead
+		b.burst = int(min(max(bytesPerSecond, 64<<10), 1<<30))
+		b.io = rate.NewLimiter(rate.Limit(bytesPerSecond), b.burst)
+	}
+	return b
+}
+
+// start waits until background work may run and a worker is free, and
+// returns the function that frees the worker again
+func (b *backgroundLimits) start(ctx context.Context) (func(), error) {
+	for {
+		wait := untilActive(b.hours, time.Now())
+		if wait <= 0 {
+			break
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if b.workers == nil {
+		return func() {}, nil
+	}
+	if err := b.workers.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	return func() { b.workers.Release(1) }, nil
+}
+
+// charge waits until n more bytes may be read
+func (b *backgroundLimits) charge(ctx context.Context, n int64) error {
+	if b.io == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, int64(b.burst))
+		if err := b.io.WaitN(ctx, int(chunk)); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// reader reads r no faster than BACKGROUND_IO_RATE
+func (b *backgroundLimits) reader(ctx context.Context, r io.Reader) io.Reader {
+	if b.io == nil {
+		return &progressReader{ctx: ctx, r: r}
+	}
+	return &backgroundReader{ctx: ctx, b: b, r: r}
+}
+
+type backgroundReader struct {
+	ctx context.Context
+	b   *backgroundLimits
+	r   io.Reader
+}
+
+func (r *backgroundReader) Read(p []byte) (int, error) {
+	if len(p) > r.b.burst {
+		p = p[:r.b.burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.b.io.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// untilActive returns how long it is from now until one of the windows
+// opens, or 0 when one is open or there are none
+func untilActive(windows []config.HourWindow, now time.Time) time.Duration {
+	if len(windows) == 0 {
+		return 0
+	}
+	minute := now.Hour()*60 + now.Minute()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var wait time.Duration
+	for i, w := range windows {
+		open := minute >= w.Start && minute < w.End
+		if w.End < w.Start {
+			open = minute >= w.Start || minute < w.End
+		}
+		if open {
+			return 0
+		}
+		opens := midnight.Add(time.Duration(w.Start) * time.Minute)
+		if !opens.After(now) {
+			opens = opens.AddDate(0, 0, 1)
+		}
+		if d := opens.Sub(now); i == 0 || d < wait {
+			wait = d
+		}
+	}
+	return wait
+}
@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// backupTimeFormat stamps archive names so they sort oldest first
+const backupTimeFormat = "20060102-150405"
+
+// BackupReport is the outcome of a backup run, attached to its job
+type BackupReport struct {
+	Archive string   `json:"archive,omitempty"`
+	Size    int64    `json:"size"`
+	Pruned  []string `json:"pruned,omitempty"`
+}
+
+// BackupRunInfo describes one run of a backup
+type BackupRunInfo struct {
+	Trigger string          `json:"trigger"`
+	Job     *models.JobInfo `json:"job"`
+}
+
+// BackupInfo describes a configured backup and its most recent run
+type BackupInfo struct {
+	Name        string         `json:"name"`
+	Schedule    string         `json:"schedule"`
+	Source      string         `json:"source"`
+	Destination string         `json:"destination"`
+	Keep        int            `json:"keep"`
+	NextRun     *int64         `json:"nextRun,omitempty"`
+	LastRun     *BackupRunInfo `json:"lastRun,omitempty"`
+}
+
+var (
+	backupScheduler *cron.Cron
+	backupEntries   = make(map[string]cron.EntryID)
+)
+
+// StartBackupScheduler checks the backups in BACKUPS and starts running them
+// on their schedules
+func StartBackupScheduler() error {
+	if len(config.Backups) == 0 {
+		return nil
+	}
+
+	scheduler := cron.New()
+	for _, backup := range config.Backups {
+		if err := CheckBackup(backup); err != nil {
+			return err
+		}
+
+		id, err := scheduler.AddFunc(backup.Schedule, func() {
+			if _, err := startBackup(backup, models.BackupTriggerSchedule); err != nil {
+				slog.Warn("Backup did not start", "backup", backup.Name, "error", err)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		backupEntries[backup.Name] = id
+	}
+
+	scheduler.Start()
+	backupScheduler = scheduler
+	return nil
+}
+
+// CheckBackup reports whether a backup's schedule parses and its source and
+// destination resolve to separate trees
+func CheckBackup(backup config.BackupSchedule) error {
+	if _, err := cron.ParseStandard(backup.Schedule); err != nil {
+		return fmt.Errorf("backup %s: invalid schedule %q: %w", backup.Name, backup.Schedule, err)
+	}
+	srcFS, src, err := utils.ResolveFS(backup.Source)
+	if err != nil {
+		return fmt.Errorf("backup %s: invalid source: %w", backup.Name, err)
+	}
+	dstFS, dst, err := utils.ResolveFS(backup.Destination)
+	if err != nil {
+		return fmt.Errorf("backup %s: invalid destination: %w", backup.Name, err)
+	}
+	// Archives written inside the source would be backed up again
+	if srcFS == dstFS && utils.IsWithin(src, dst) {
+		return fmt.Errorf("backup %s: destination is inside the source", backup.Name)
+	}
+	return nil
+}
+
+// findBackup returns the configured backup with the given name
+func findBackup(name string) (config.BackupSchedule, bool) {
+	for _, backup := range config.Backups {
+		if backup.Name == name {
+			return backup, true
+		}
+	}
+	return config.BackupSchedule{}, false
+}
+
+// startBackup runs a backup in the background and returns its job
+func startBackup(backup config.BackupSchedule, trigger string) (*models.Job, error) {
+	srcFS, src, err := utils.ResolveFS(backup.Source)
+	if err != nil {
+		return nil, err
+	}
+	dstFS, dst, err := utils.ResolveFS(backup.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := models.StartBackupRun(backup.Name, trigger, backup.Source, backup.Destination)
+	if err != nil {
+		return nil, err
+	}
+
+	go runBackupJob(run.Job, backup, srcFS, src, dstFS, dst)
+	return run.Job, nil
+}
+
+// runBackupJob archives the source tree into a new timestamped ZIP in the
+// destination directory, then deletes the oldest archives beyond the
+// backup's Keep. Archives are only pruned after a run without errors.
+func runBackupJob(job *models.Job, backup config.BackupSchedule, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, dst string) {
+	archiveName := backup.Name + "-" + time.Now().UTC().Format(backupTimeFormat) + ".zip"
+	archive := filepath.Join(dst, archiveName)
+	report := &BackupReport{}
+	defer invalidateListing(dst)
+
+	unlock, err := utils.TryLockPaths(utils.ReadLock(src), utils.WriteLock(archive))
+	if err != nil {
+		job.Finish(models.JobFailed, "Path is locked by another operation")
+		return
+	}
+	defer unlock()
+
+	info, err := srcFS.Stat(src)
+	if err != nil {
+		job.Finish(models.JobFailed, "Source not found: "+err.Error())
+		return
+	}
+	if !info.IsDir() {
+		job.Finish(models.JobFailed, "Source is not a directory")
+		return
+	}
+	if err := dstFS.MkdirAll(dst, 0755); err != nil {
+		job.Finish(models.JobFailed, "Failed to create destination: "+err.Error())
+		return
+	}
+	if _, err := dstFS.Stat(archive); err == nil {
+		job.Finish(models.JobFailed, "Archive already exists: "+archiveName)
+		return
+	}
+
+	job.AddTotals(scanTreeFS(job.Context(), srcFS, src))
+	size, err := writeBackupArchive(job, srcFS, src, dstFS, archive)
+	if err != nil {
+		_ = dstFS.Remove(archive)
+		if errors.Is(err, context.Canceled) {
+			job.Finish(models.JobCancelled, "Operation cancelled")
+		} else {
+			job.Finish(models.JobFailed, err.Error())
+		}
+		return
+	}
+	report.Archive = path.Join(backup.Destination, archiveName)
+	report.Size = size
+
+	if errs := job.Errors(); len(errs) > 0 {
+		job.SetResult(report)
+		job.Finish(models.JobFailed, fmt.Sprintf("Completed with %d errors", len(errs)))
+		return
+	}
+
+	pruned, err := pruneBackups(dstFS, dst, backup.Name, backup.Keep)
+	for _, name := range pruned {
+		report.Pruned = append(report.Pruned, path.Join(backup.Destination, name))
+	}
+	job.SetResult(report)
+	if err != nil {
+		job.Finish(models.JobFailed, "Backed up but failed to prune old archives: "+err.Error())
+		return
+	}
+	job.Finish(models.JobCompleted, "")
+}
+
+// writeBackupArchive zips the tree at src into archive and returns its size.
+// Local destinations are written in place under a temporary name; other
+// backends get a local temporary file that is uploaded once complete.
+func writeBackupArchive(job *models.Job, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, archive string) (int64, error) {
+	var tmp *os.File
+	var err error
+	if vfs.IsLocal(dstFS) {
+		tmp, err = os.OpenFile(archive+vfs.AtomicSuffix, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	} else {
+		tmp, err = os.CreateTemp("", "nextbrowse-backup-*.zip")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed into place
+	defer tmp.Close()
+
+	if err := zipTree(job, srcFS, src, tmp); err != nil {
+		return 0, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	if vfs.IsLocal(dstFS) {
+		if err := tmp.Close(); err != nil {
+			return 0, err
+		}
+		return size, os.Rename(tmp.Name(), archive)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	job.SetCurrentFile(displayPath(dstFS, archive))
+	return size, vfs.WriteFrom(dstFS, archive, &progressReader{ctx: job.Context(), r: tmp}, size)
+}
+
+// zipTree writes every directory and regular file below root to w. Files
+// that can't be read are recorded on the job and left out; symlinks and
+// special files are skipped.
+func zipTree(job *models.Job, fsys vfs.Filesystem, root string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	err := vfs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := job.Context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			job.AddError(displayPath(fsys, name), err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			job.ItemDone()
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			job.AddError(displayPath(fsys, name), err)
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			header.Name += "/"
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+			job.ItemDone()
+			return nil
+		}
+		header.Method = zip.Deflate
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			job.AddError(displayPath(fsys, name), err)
+			return nil
+		}
+		defer file.Close()
+
+		job.SetCurrentFile(displayPath(fsys, name))
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		// A read error part way through leaves a truncated entry, so it
+		// fails the archive rather than being skipped
+		if _, err := buffers.Copy(entry, &progressReader{ctx: job.Context(), job: job, r: file}); err != nil {
+			return fmt.Errorf("%s: %w", displayPath(fsys, name), err)
+		}
+		job.ItemDone()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// pruneBackups deletes the oldest archives of a backup in dir so that at most
+// keep remain, returning the names it deleted. Only files named like the
+// backup's own archives are considered.
+func pruneBackups(fsys vfs.Filesystem, dir, backup string, keep int) ([]string, error) {
+	if keep == 0 {
+		return nil, nil
+	}
+
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, backup+"-")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, ".zip")
+		if _, err := time.Parse(backupTimeFormat, stamp); ok && err == nil {
+			archives = append(archives, name)
+		}
+	}
+	if len(archives) <= keep {
+		return nil, nil
+	}
+
+	sort.Strings(archives)
+	var pruned []string
+	var errs []error
+	for _, name := range archives[:len(archives)-keep] {
+		if err := fsys.Remove(filepath.Join(dir, name)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pruned = append(pruned, name)
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// backupInfo describes a configured backup for the API
+func backupInfo(backup config.BackupSchedule) BackupInfo {
+	info := BackupInfo{
+		Name:        backup.Name,
+		Schedule:    backup.Schedule,
+		Source:      backup.Source,
+		Destination: backup.Destination,
+		Keep:        backup.Keep,
+	}
+	if id, ok := backupEntries[backup.Name]; ok && backupScheduler != nil {
+		if next := backupScheduler.Entry(id).Next; !next.IsZero() {
+			nextRun := next.UnixMilli()
+			info.NextRun = &nextRun
+		}
+	}
+	if runs := models.GetBackupRuns(backup.Name); len(runs) > 0 {
+		info.LastRun = &BackupRunInfo{Trigger: runs[0].Trigger, Job: runs[0].Job.Info()}
+	}
+	return info
+}
+
+// ListBackups returns the configured backups with their next and last runs
+func ListBackups(c *gin.Context) {
+	backups := make([]BackupInfo, 0, len(config.Backups))
+	for _, backup := range config.Backups {
+		backups = append(backups, backupInfo(backup))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":      true,
+		"backups": backups,
+	})
+}
+
+// ListBackupRuns returns the remembered runs of one backup, newest first
+func ListBackupRuns(c *gin.Context) {
+	backup, exists := findBackup(c.Param("name"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Backup not found")
+		return
+	}
+
+	runs := models.GetBackupRuns(backup.Name)
+	infos := make([]BackupRunInfo, 0, len(runs))
+	for _, run := range runs {
+		infos = append(infos, BackupRunInfo{Trigger: run.Trigger, Job: run.Job.Info()})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":   true,
+		"runs": infos,
+	})
+}
+
+// RunBackup starts a backup outside its schedule. Progress is reported
+// through the returned job.
+func RunBackup(c *gin.Context) {
+	backup, exists := findBackup(c.Param("name"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Backup not found")
+		return
+	}
+
+	job, err := startBackup(backup, models.BackupTriggerManual)
+	if errors.Is(err, models.ErrBackupRunning) {
+		problem.Respond(c, http.StatusConflict, "Backup is already running")
+		return
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to start backup: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusAccepted, OperationResponse{
+		OK:      true,
+		Message: "Backup started",
+		JobID:   job.ID,
+	})
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+)
+
+// defaultBenchSize is how many bytes a download benchmark sends when not
+// asked for a size
+const defaultBenchSize = 100 << 20
+
+// benchBlock is what a download benchmark sends over and over. It is random
+// so that compressing proxies on the way can't shrink it.
+var benchBlock = func() []byte {
+	b := make([]byte, 256<<10)
+	_, _ = rand.Read(b)
+	return b
+}()
+
+// BenchResult reports how fast a benchmark's body was received
+type BenchResult struct {
+	OK             bool    `json:"ok"`
+	Bytes          int64   `json:"bytes"`
+	DurationMs     int64   `json:"durationMs"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+}
+
+// BenchUpload reads the request body and throws it away, reporting how long
+// that took. Nothing is written to disk, so a slow result points at the
+// network rather than at storage.
+func BenchUpload(c *gin.Context) {
+	start := time.Now()
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, config.BenchMaxSize)
+	n, err := io.Copy(io.Discard, body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, "Body is larger than BENCH_MAX_SIZE")
+			return
+		}
+		problem.Respond(c, http.StatusBadRequest, "Failed to read body: "+err.Error())
+		return
+	}
+	elapsed := time.Since(start)
+	c.JSON(http.StatusOK, BenchResult{
+		OK:             true,
+		Bytes:          n,
+		DurationMs:     elapsed.Milliseconds(),
+		BytesPerSecond: float64(n) / max(elapsed.Seconds(), 1e-9),
+	})
+}
+
+// BenchDownload sends ?size= bytes of random data that isn't read from disk,
+// for timing downloads against the network alone
+func BenchDownload(c *gin.Context) {
+	size := int64(defaultBenchSize)
+	if s := c.Query("size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			problem.Respond(c, http.StatusBadRequest, "Invalid size")
+			return
+		}
+		size = n
+	}
+	if size > config.BenchMaxSize {
+		problem.Respond(c, http.StatusBadRequest, "Size is larger than BENCH_MAX_SIZE")
+		return
+	}
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Length", strconv.FormatInt(size, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	ctx := c.Request.Context()
+	for size > 0 && ctx.Err() == nil {
+		chunk := benchBlock[:min(size, int64(len(benchBlock)))]
+		if _, err := c.Writer.Write(chunk); err != nil {
+			return
+		}
+		size -= int64(len(chunk))
+	}
+}
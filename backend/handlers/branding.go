@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/problem"
+)
+
+// defaultThemeName is the theme of shares that don't name one, when an
+// admin has defined it
+const defaultThemeName = "default"
+
+// maxLogoSize is the largest logo a theme may have
+const maxLogoSize = 1 << 20
+
+var (
+	themeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+	colorPattern     = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+)
+
+// logoTypes are the logo formats accepted, as sniffed from their contents
+var logoTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+// ThemeRequest defines a theme. Colors are CSS hex colors, such as #1e66f5.
+type ThemeRequest struct {
+	Title           string `json:"title,omitempty"`
+	PrimaryColor    string `json:"primaryColor,omitempty"`
+	AccentColor     string `json:"accentColor,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+}
+
+// Branding is a share's theme as its public page applies it
+type Branding struct {
+	Theme           string `json:"theme"`
+	Title           string `json:"title,omitempty"`
+	PrimaryColor    string `json:"primaryColor,omitempty"`
+	AccentColor     string `json:"accentColor,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+	LogoURL         string `json:"logoUrl,omitempty"`
+}
+
+// resolveBranding returns the branding of a share naming theme: that theme,
+// or the default theme when it names none or one that isn't defined. It is
+// nil when neither is.
+func resolveBranding(theme string) *Branding {
+	for _, name := range []string{theme, defaultThemeName} {
+		if name == "" {
+			continue
+		}
+		t, found, err := metadata.GetTheme(name)
+		if err != nil || !found {
+			continue
+		}
+		branding := &Branding{
+			Theme:           t.Name,
+			Title:           t.Title,
+			PrimaryColor:    t.PrimaryColor,
+			AccentColor:     t.AccentColor,
+			BackgroundColor: t.BackgroundColor,
+			TextColor:       t.TextColor,
+		}
+		if t.LogoType != "" {
+			// The version makes browsers fetch a replaced logo anew
+			branding.LogoURL = "/api/themes/" + url.PathEscape(t.Name) + "/logo?v=" + strconv.FormatInt(t.UpdatedAt, 10)
+		}
+		return branding
+	}
+	return nil
+}
+
+// themeName reads the :name of a theme route, answering 400 when it isn't
+// a valid name
+func themeName(c *gin.Context) (string, bool) {
+	name := c.Param("name")
+	if !themeNamePattern.MatchString(name) {
+		problem.Respond(c, http.StatusBadRequest, "Theme names are 1 to 64 lowercase letters, digits, - and _")
+		return "", false
+	}
+	return name, true
+}
+
+// ListThemes returns every theme
+func ListThemes(c *gin.Context) {
+	themes, err := metadata.Themes()
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "themes": themes})
+}
+
+// SetTheme defines a theme, replacing its title and colors but keeping its
+// logo. Shares name it in their theme; one called "default" brands the
+// shares that name none.
+func SetTheme(c *gin.Context) {
+	name, ok := themeName(c)
+	if !ok {
+		return
+	}
+	var req ThemeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	for field, color := range map[string]string{
+		"primaryColor":    req.PrimaryColor,
+		"accentColor":     req.AccentColor,
+		"backgroundColor": req.BackgroundColor,
+		"textColor":       req.TextColor,
+	} {
+		if color != "" && !colorPattern.MatchString(color) {
+			problem.Respond(c, http.StatusBadRequest, field+" must be a hex color such as #1e66f5")
+			return
+		}
+	}
+	if len(req.Title) > 200 {
+		problem.Respond(c, http.StatusBadRequest, "title is longer than 200 characters")
+		return
+	}
+
+	t := metadata.Theme{
+		Name:            name,
+		Title:           strings.TrimSpace(req.Title),
+		PrimaryColor:    req.PrimaryColor,
+		AccentColor:     req.AccentColor,
+		BackgroundColor: req.BackgroundColor,
+		TextColor:       req.TextColor,
+		UpdatedAt:       time.Now().UnixMilli(),
+	}
+	if err := metadata.SetTheme(t); err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	t, _, _ = metadata.GetTheme(name)
+	c.JSON(http.StatusOK, gin.H{"ok": true, "theme": t})
+}
+
+// DeleteTheme removes a theme and its logo; shares naming it fall back to
+// the default theme
+func DeleteTheme(c *gin.Context) {
+	name, ok := themeName(c)
+	if !ok {
+		return
+	}
+	found, err := metadata.DeleteTheme(name)
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		problem.Respond(c, http.StatusNotFound, "Theme not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "message": "Theme removed"})
+}
+
+// SetThemeLogo stores the request body as a theme's logo: a PNG, JPEG, GIF
+// or WebP image of at most 1 MiB. SVG is refused, as a logo is served from
+// the API's own origin.
+func SetThemeLogo(c *gin.Context) {
+	name, ok := themeName(c)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxLogoSize+1))
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Failed to read logo: "+err.Error())
+		return
+	}
+	if len(data) > maxLogoSize {
+		problem.Respond(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Logos are at most %d bytes", maxLogoSize))
+		return
+	}
+	mediaType := http.DetectContentType(data)
+	if len(data) == 0 || !logoTypes[mediaType] {
+		problem.Respond(c, http.StatusUnsupportedMediaType, "Logos are PNG, JPEG, GIF or WebP images")
+		return
+	}
+
+	found, err := metadata.SetThemeLogo(name, mediaType, data, time.Now().UnixMilli())
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		problem.Respond(c, http.StatusNotFound, "Theme not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "branding": resolveBranding(name)})
+}
+
+// DeleteThemeLogo removes a theme's logo
+func DeleteThemeLogo(c *gin.Context) {
+	name, ok := themeName(c)
+	if !ok {
+		return
+	}
+	found, err := metadata.SetThemeLogo(name, "", nil, time.Now().UnixMilli())
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		problem.Respond(c, http.StatusNotFound, "Theme not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "message": "Logo removed"})
+}
+
+// GetThemeLogo serves a theme's logo to share pages
+func GetThemeLogo(c *gin.Context) {
+	name, ok := themeName(c)
+	if !ok {
+		return
+	}
+	logo, t, err := metadata.ThemeLogo(name)
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if logo == nil {
+		problem.Respond(c, http.StatusNotFound, "Theme has no logo")
+		return
+	}
+
+	c.Header("Content-Type", t.LogoType)
+	c.Header("ETag", fmt.Sprintf(`"%x"`, t.UpdatedAt))
+	c.Header("Cache-Control", "public, max-age=86400")
+	http.ServeContent(c.Writer, c.Request, "", time.UnixMilli(t.UpdatedAt), bytes.NewReader(logo))
+}
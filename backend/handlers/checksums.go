@@ -0,0 +1,584 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Checksum manifest formats
+const (
+	manifestSHA256 = "sha256" // SHA256SUMS, as written and checked by sha256sum
+	manifestSFV    = "sfv"    // a CRC32 per file, as in .sfv files
+)
+
+// sha256Manifest is the name of the manifest in the sha256 format
+const sha256Manifest = "SHA256SUMS"
+
+type ChecksumRequest struct {
+	Path   string `json:"path"`
+	Format string `json:"format"` // "sha256" (default) or "sfv"
+	Async  bool   `json:"async"`
+}
+
+type VerifyRequest struct {
+	Path     string `json:"path"`     // directory the manifest covers
+	Manifest string `json:"manifest"` // defaults to SHA256SUMS, or the directory's .sfv file
+	Async    bool   `json:"async"`
+}
+
+// ChecksumReport describes a generated manifest
+type ChecksumReport struct {
+	Manifest string `json:"manifest,omitempty"` // user path of the manifest written
+	Format   string `json:"format"`
+	Files    int    `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// VerifyReport says how a directory differs from its manifest. Paths are
+// relative to the directory.
+type VerifyReport struct {
+	Manifest   string   `json:"manifest"`
+	Intact     bool     `json:"intact"` // every listed file is present and matches
+	Checked    int      `json:"checked"`
+	Matched    int      `json:"matched"`
+	Mismatched []string `json:"mismatched"`
+	Missing    []string `json:"missing"`
+	Unlisted   []string `json:"unlisted"` // files not in the manifest
+}
+
+type ChecksumResponse struct {
+	OK      bool              `json:"ok"`
+	Message string            `json:"message,omitempty"`
+	JobID   string            `json:"jobId,omitempty"`
+	Report  *ChecksumReport   `json:"report,omitempty"`
+	Errors  []models.JobError `json:"errors,omitempty"`
+}
+
+type VerifyResponse struct {
+	OK      bool              `json:"ok"`
+	Message string            `json:"message,omitempty"`
+	JobID   string            `json:"jobId,omitempty"`
+	Report  *VerifyReport     `json:"report,omitempty"`
+	Errors  []models.JobError `json:"errors,omitempty"`
+}
+
+// manifestEntry is a file listed in a manifest
+type manifestEntry struct {
+	rel  string // slash-separated, relative to the manifest's directory
+	sum  string // hex, lowercase for sha256 and uppercase for sfv
+	size int64
+}
+
+// DownloadChecksums computes a manifest of the files below ?path= and sends
+// it as a download, in the sha256 or sfv ?format=
+func DownloadChecksums(c *gin.Context) {
+	format := c.DefaultQuery("format", manifestSHA256)
+	if format != manifestSHA256 && format != manifestSFV {
+		problem.Respond(c, http.StatusBadRequest, "Invalid format: "+format)
+		return
+	}
+	fsys, dir, ok := resolveChecksumDir(c, c.Query("path"))
+	if !ok {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.ReadLock(dir))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	job, err := models.NewJob("checksum", c.Query("path"), "")
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+	defer cancelOnDisconnect(c, job)()
+
+	manifest, report, err := buildManifest(job, fsys, dir, format)
+	job.SetResult(report)
+	finishReportJob(job, err)
+	if info := job.Info(); info.Status != models.JobCompleted {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to compute checksums: "+info.Message, gin.H{
+			"jobId":  job.ID,
+			"errors": info.Errors,
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", attachment(manifestName(fsys, dir, format)))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", manifest)
+}
+
+// CreateChecksums writes a manifest of the files below a directory into it:
+// SHA256SUMS, or <directory>.sfv in the sfv format. A manifest of the same
+// name is replaced; manifests are left out of each other.
+func CreateChecksums(c *gin.Context) {
+	var req ChecksumRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Format == "" {
+		req.Format = manifestSHA256
+	}
+	if req.Format != manifestSHA256 && req.Format != manifestSFV {
+		problem.Respond(c, http.StatusBadRequest, "Invalid format: "+req.Format)
+		return
+	}
+	fsys, dir, ok := resolveChecksumDir(c, req.Path)
+	if !ok {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.ReadLock(dir))
+	if !ok {
+		return
+	}
+
+	job, err := models.NewJob("checksum", req.Path, "")
+	if err != nil {
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+
+	actor := c.ClientIP()
+	if req.Async {
+		go func() {
+			defer unlock()
+			runChecksumJob(job, actor, fsys, dir, req.Format)
+		}()
+		c.JSON(http.StatusAccepted, ChecksumResponse{OK: true, Message: "Checksums started", JobID: job.ID})
+		return
+	}
+
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runChecksumJob(job, actor, fsys, dir, req.Format)
+
+	info := job.Info()
+	report, _ := info.Result.(*ChecksumReport)
+	switch info.Status {
+	case models.JobCompleted:
+		c.JSON(http.StatusOK, ChecksumResponse{OK: true, Message: "Checksums written", JobID: job.ID, Report: report})
+	case models.JobCancelled:
+		problem.Respond(c, http.StatusConflict, "Checksum operation cancelled", gin.H{"jobId": job.ID})
+	default:
+		problem.Respond(c, http.StatusInternalServerError, "Checksum operation failed: "+info.Message, gin.H{
+			"jobId":  job.ID,
+			"errors": info.Errors,
+		})
+	}
+}
+
+// VerifyChecksums checks the files below a directory against a manifest and
+// reports those that changed, went missing or aren't listed. Finding
+// differences isn't a failure; the report's intact flag says whether there
+// were any.
+func VerifyChecksums(c *gin.Context) {
+	var req VerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	fsys, dir, ok := resolveChecksumDir(c, req.Path)
+	if !ok {
+		return
+	}
+
+	manifest, err := findManifest(fsys, dir, req.Manifest)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.ReadLock(dir))
+	if !ok {
+		return
+	}
+
+	job, err := models.NewJob("verify", req.Path, "")
+	if err != nil {
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+
+	if req.Async {
+		go func() {
+			defer unlock()
+			runVerifyJob(job, fsys, dir, manifest)
+		}()
+		c.JSON(http.StatusAccepted, VerifyResponse{OK: true, Message: "Verification started", JobID: job.ID})
+		return
+	}
+
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runVerifyJob(job, fsys, dir, manifest)
+
+	info := job.Info()
+	report, _ := info.Result.(*VerifyReport)
+	switch info.Status {
+	case models.JobCompleted:
+		message := "All files match the manifest"
+		if !report.Intact {
+			message = "Files differ from the manifest"
+		}
+		c.JSON(http.StatusOK, VerifyResponse{OK: true, Message: message, JobID: job.ID, Report: report})
+	case models.JobCancelled:
+		problem.Respond(c, http.StatusConflict, "Verification cancelled", gin.H{"jobId": job.ID, "report": report})
+	default:
+		problem.Respond(c, http.StatusInternalServerError, "Verification failed: "+info.Message, gin.H{
+			"jobId":  job.ID,
+			"report": report,
+			"errors": info.Errors,
+		})
+	}
+}
+
+func resolveChecksumDir(c *gin.Context, userPath string) (vfs.Filesystem, string, bool) {
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return nil, "", false
+	}
+	fsys, dir, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return nil, "", false
+	}
+	if info, err := fsys.Stat(dir); err != nil || !info.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Directory not found")
+		return nil, "", false
+	}
+	return fsys, dir, true
+}
+
+// manifestName is the name of the manifest of dir in format
+func manifestName(fsys vfs.Filesystem, dir, format string) string {
+	if format == manifestSHA256 {
+		return sha256Manifest
+	}
+	name := path.Base(displayPath(fsys, dir))
+	if name == "/" {
+		name = "checksums"
+	}
+	return name + ".sfv"
+}
+
+// runChecksumJob writes the manifest of dir into it
+func runChecksumJob(job *models.Job, actor string, fsys vfs.Filesystem, dir, format string) {
+	manifest, report, err := buildManifest(job, fsys, dir, format)
+	if err == nil {
+		name := filepath.Join(dir, manifestName(fsys, dir, format))
+		if err = vfs.WriteFrom(fsys, name, bytes.NewReader(manifest), int64(len(manifest))); err == nil {
+			report.Manifest = displayPath(fsys, name)
+			invalidateListing(name)
+			publishChange(actor, events.FileWritten, fsys, name, nil)
+		}
+	}
+	job.SetResult(report)
+	finishReportJob(job, err)
+}
+
+// buildManifest checksums the regular files below dir, leaving out the
+// manifests at its top
+func buildManifest(job *models.Job, fsys vfs.Filesystem, dir, format string) ([]byte, *ChecksumReport, error) {
+	report := &ChecksumReport{Format: format}
+	files, err := listManifestFiles(job, fsys, dir, "")
+	if err != nil {
+		return nil, report, err
+	}
+
+	for _, file := range files {
+		job.AddTotals(1, file.size)
+	}
+
+	var b bytes.Buffer
+	if format == manifestSFV {
+		b.WriteString("; Generated by NextBrowse\n")
+	}
+	for _, file := range files {
+		if err := job.Context().Err(); err != nil {
+			return nil, report, err
+		}
+		// sha256sum can't tell a name with a line break from two lines
+		if strings.ContainsAny(file.rel, "\r\n") {
+			job.AddError(file.rel, errors.New("names with line breaks can't be listed"))
+			continue
+		}
+		job.SetCurrentFile(file.rel)
+		sum, err := checksumFor(job, fsys, filepath.Join(dir, filepath.FromSlash(file.rel)), format)
+		if err != nil {
+			if ctxErr := job.Context().Err(); ctxErr != nil {
+				return nil, report, ctxErr
+			}
+			job.AddError(file.rel, err)
+			continue
+		}
+		job.ItemDone()
+
+		if format == manifestSFV {
+			fmt.Fprintf(&b, "%s %s\n", file.rel, sum)
+		} else {
+			fmt.Fprintf(&b, "%s  %s\n", sum, file.rel)
+		}
+		report.Files++
+		report.Bytes += file.size
+	}
+	return b.Bytes(), report, nil
+}
+
+// listManifestFiles lists the regular files below dir in path order,
+// leaving out unfinished writes, the manifest at the relative path skip and
+// any other manifests at the top of dir
+func listManifestFiles(job *models.Job, fsys vfs.Filesystem, dir, skip string) ([]manifestEntry, error) {
+	ctx := job.Context()
+	var files []manifestEntry
+	err := vfs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if name == dir {
+			return err
+		}
+		rel, relErr := filepath.Rel(dir, name)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			job.AddError(rel, err)
+			return nil
+		}
+		if !d.Type().IsRegular() || rel == skip || isManifest(rel) || strings.HasSuffix(rel, vfs.AtomicSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			job.AddError(rel, err)
+			return nil
+		}
+		files = append(files, manifestEntry{rel: rel, size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+// isManifest reports whether a relative path names a manifest at the top of
+// its directory
+func isManifest(rel string) bool {
+	return !strings.Contains(rel, "/") && (rel == sha256Manifest || strings.EqualFold(path.Ext(rel), ".sfv"))
+}
+
+// checksumFor returns the checksum of a file in a manifest format
+func checksumFor(job *models.Job, fsys vfs.Filesystem, name, format string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var h hash.Hash
+	if format == manifestSFV {
+		h = crc32.NewIEEE()
+	} else {
+		h = sha256.New()
+	}
+	if _, err := buffers.Copy(h, &progressReader{ctx: job.Context(), job: job, r: file}); err != nil {
+		return "", err
+	}
+	if format == manifestSFV {
+		return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// findManifest returns the manifest to verify dir against: the one named,
+// relative to dir, or else SHA256SUMS or the only .sfv file in dir
+func findManifest(fsys vfs.Filesystem, dir, name string) (string, error) {
+	if name != "" {
+		// Confine the name to dir
+		manifest := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+		if info, err := fsys.Stat(manifest); err != nil || !info.Mode().IsRegular() {
+			return "", fmt.Errorf("manifest %s not found", name)
+		}
+		return manifest, nil
+	}
+
+	if info, err := fsys.Stat(filepath.Join(dir, sha256Manifest)); err == nil && info.Mode().IsRegular() {
+		return filepath.Join(dir, sha256Manifest), nil
+	}
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.EqualFold(filepath.Ext(entry.Name()), ".sfv") {
+			found = append(found, entry.Name())
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", errors.New("no SHA256SUMS or .sfv manifest found")
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", fmt.Errorf("several .sfv manifests found; name one of %s", strings.Join(found, ", "))
+}
+
+// runVerifyJob checks the files below dir against a manifest
+func runVerifyJob(job *models.Job, fsys vfs.Filesystem, dir, manifest string) {
+	rel, _ := filepath.Rel(dir, manifest)
+	report := &VerifyReport{Manifest: filepath.ToSlash(rel), Mismatched: []string{}, Missing: []string{}, Unlisted: []string{}}
+	err := verifyManifest(job, report, fsys, dir, manifest)
+	report.Intact = err == nil && len(report.Mismatched) == 0 && len(report.Missing) == 0 && len(job.Errors()) == 0
+	job.SetResult(report)
+	finishReportJob(job, err)
+}
+
+func verifyManifest(job *models.Job, report *VerifyReport, fsys vfs.Filesystem, dir, manifest string) error {
+	format := manifestSHA256
+	if strings.EqualFold(filepath.Ext(manifest), ".sfv") {
+		format = manifestSFV
+	}
+	listed, err := readManifest(fsys, manifest, format)
+	if err != nil {
+		return err
+	}
+
+	// Everything in the tree is listed so that extra files can be reported
+	files, err := listManifestFiles(job, fsys, dir, report.Manifest)
+	if err != nil {
+		return err
+	}
+	inManifest := make(map[string]bool, len(listed))
+	for _, entry := range listed {
+		inManifest[entry.rel] = true
+	}
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[file.rel] = true
+		if inManifest[file.rel] {
+			job.AddTotals(1, file.size)
+		}
+	}
+
+	for _, entry := range listed {
+		if err := job.Context().Err(); err != nil {
+			return err
+		}
+		if !present[entry.rel] {
+			report.Missing = append(report.Missing, entry.rel)
+			continue
+		}
+		job.SetCurrentFile(entry.rel)
+		sum, err := checksumFor(job, fsys, filepath.Join(dir, filepath.FromSlash(entry.rel)), format)
+		if err != nil {
+			if ctxErr := job.Context().Err(); ctxErr != nil {
+				return ctxErr
+			}
+			job.AddError(entry.rel, err)
+			continue
+		}
+		job.ItemDone()
+		report.Checked++
+		if strings.EqualFold(sum, entry.sum) {
+			report.Matched++
+		} else {
+			report.Mismatched = append(report.Mismatched, entry.rel)
+		}
+	}
+	for _, file := range files {
+		if !inManifest[file.rel] {
+			report.Unlisted = append(report.Unlisted, file.rel)
+		}
+	}
+	return nil
+}
+
+// readManifest parses a manifest. sha256 lines are "<sum>  <name>", or
+// "<sum> *<name>" for binary mode; sfv lines are "<name> <crc>", with ";"
+// starting a comment.
+func readManifest(fsys vfs.Filesystem, name, format string) ([]manifestEntry, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || format == manifestSFV && strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		var entry manifestEntry
+		var ok bool
+		if format == manifestSFV {
+			entry.rel, entry.sum, ok = cutLast(text, " ")
+			ok = ok && len(entry.sum) == 8
+		} else {
+			entry.sum, entry.rel, ok = strings.Cut(text, " ")
+			if ok && len(entry.rel) > 0 && (entry.rel[0] == ' ' || entry.rel[0] == '*') {
+				entry.rel = entry.rel[1:]
+			}
+			ok = ok && len(entry.sum) == 64
+		}
+		if _, err := hex.DecodeString(entry.sum); !ok || err != nil || entry.rel == "" {
+			return nil, fmt.Errorf("manifest line %d is not in the %s format", line, format)
+		}
+		entry.rel = strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(entry.rel, "./")), "/")
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return strings.TrimSpace(s[:i]), s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// finishReportJob ends a job whose report is its result: failed when it
+// stopped on an error or some files couldn't be read
+func finishReportJob(job *models.Job, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Finish(models.JobCancelled, "Operation cancelled")
+	case err != nil:
+		job.Finish(models.JobFailed, err.Error())
+	case len(job.Errors()) > 0:
+		job.Finish(models.JobFailed, fmt.Sprintf("Completed with %d errors", len(job.Errors())))
+	default:
+		job.Finish(models.JobCompleted, "")
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// tempFilePatterns match what this server leaves in the system temp
+// directory while staging files for other backends and backups, and the
+// archives of archive jobs
+var tempFilePatterns = []string{"nextbrowse-vfs-*", "nextbrowse-backup-*.zip", archivePrefix + "*"}
+
+// SweepTempFiles removes temporary files not modified within maxAge: TUS
+// partial uploads that are no longer registered, partial files of atomic
+// writes and backup archives under the root, and staged files in the temp
+// directories. It returns how many files it removed and their total size.
+func SweepTempFiles(maxAge time.Duration) (int, int64) {
+	CleanupExpiredUploads()
+
+	// Uploads that can still be resumed are kept however long they idle
+	active := make(map[string]bool)
+	if uploads, err := uploadRegistry().List(); err == nil {
+		for _, upload := range uploads {
+			active[upload.FilePath] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var removed int
+	var freed int64
+	remove := func(path string, info fs.FileInfo) {
+		if active[path] || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				slog.Warn("Failed to remove stale temporary file", "path", path, "error", err)
+			}
+			return
+		}
+		removed++
+		freed += info.Size()
+	}
+
+	skip := make(map[string]bool)
+	for _, dir := range config.SnapshotDirs {
+		skip[filepath.Clean(dir.Dir)] = true
+	}
+
+	var uploadDirs []string
+	_ = filepath.WalkDir(config.RootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".zfs" || skip[path] {
+				return filepath.SkipDir
+			}
+			if info, err := d.Info(); err == nil && d.Name() == tusUploadDir && info.ModTime().Before(cutoff) {
+				uploadDirs = append(uploadDirs, path)
+			}
+			return nil
+		}
+		if !isTempArtifact(path) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			remove(path, info)
+		}
+		return nil
+	})
+
+	// Drop upload directories untouched for as long; removing one fails
+	// while it still holds uploads
+	for _, dir := range uploadDirs {
+		_ = os.Remove(dir)
+	}
+
+	sweepDir(config.TusStagingDir, "*"+tusPartialSuffix, remove)
+	for _, pattern := range tempFilePatterns {
+		sweepDir(os.TempDir(), pattern, remove)
+	}
+
+	if removed > 0 {
+		slog.Info("Removed stale temporary files", "files", removed, "bytes", freed)
+	}
+	return removed, freed
+}
+
+// isTempArtifact reports whether a file under the root was left by an
+// interrupted upload, atomic write or backup
+func isTempArtifact(path string) bool {
+	name := filepath.Base(path)
+	switch {
+	case filepath.Base(filepath.Dir(path)) == tusUploadDir:
+		return strings.HasSuffix(name, tusPartialSuffix)
+	case strings.HasSuffix(name, vfs.AtomicSuffix):
+		// Atomic writes use hidden names; backups add the suffix to the archive
+		return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".zip"+vfs.AtomicSuffix)
+	}
+	return false
+}
+
+// sweepDir passes the files directly in dir that match pattern to remove
+func sweepDir(dir, pattern string, remove func(string, fs.FileInfo)) {
+	matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+	for _, path := range matches {
+		if info, err := os.Lstat(path); err == nil {
+			remove(path, info)
+		}
+	}
+}
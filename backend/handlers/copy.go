@@ -0,0 +1,601 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// copyChunkSize is how much data is copied between progress and cancellation checks
+const copyChunkSize = 8 * 1024 * 1024
+
+// Conflict policies for copying or moving into an existing destination.
+// Directories that exist on both sides are always merged.
+const (
+	conflictFail      = ""           // reject with 409 if the destination exists
+	conflictSkip      = "skip"       // keep the existing destination entry
+	conflictOverwrite = "overwrite"  // replace the existing destination entry
+	conflictKeepNewer = "keep-newer" // replace only if the source is newer
+	conflictRename    = "rename"     // copy alongside as "name (1).ext"
+)
+
+// validConflictPolicy reports whether policy is a known conflict policy
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case conflictFail, conflictSkip, conflictOverwrite, conflictKeepNewer, conflictRename:
+		return true
+	}
+	return false
+}
+
+// Outcomes of resolving a destination conflict
+const (
+	actionCreate = iota // write to the (possibly renamed) target
+	actionMerge         // merge a directory into an existing directory
+	actionSkip          // leave the destination untouched
+)
+
+// resolveConflict decides what to do with src (described by srcInfo) given
+// the current state of dst. It returns the path to write to and the action,
+// with the entry being overwritten when there is one, for the caller to
+// settle once the new entry is written.
+func resolveConflict(dst string, srcInfo os.FileInfo, policy string) (string, int, *replaced, error) {
+	dstInfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return dst, actionCreate, nil, nil
+	}
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	if srcInfo.IsDir() && dstInfo.IsDir() {
+		return dst, actionMerge, nil, nil
+	}
+
+	switch policy {
+	case conflictSkip:
+		return "", actionSkip, nil, nil
+	case conflictKeepNewer:
+		if !srcInfo.ModTime().After(dstInfo.ModTime()) {
+			return "", actionSkip, nil, nil
+		}
+		fallthrough
+	case conflictOverwrite:
+		// Moved aside rather than written through, so a symlink at dst is
+		// replaced, and kept until the new entry is complete
+		kept, err := keepReplaced(vfs.OS, dst)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return dst, actionCreate, kept, nil
+	case conflictRename:
+		return utils.UniquePath(dst), actionCreate, nil, nil
+	default:
+		return "", 0, nil, os.ErrExist
+	}
+}
+
+// runCopyJob copies srcPath to dstPath under job. A cancelled copy removes the
+// partial destination unless it was merged into an existing one; failures on
+// individual entries are collected on the job.
+func runCopyJob(job *models.Job, srcPath, dstPath, conflict string) {
+	defer invalidateListing(dstPath)
+
+	existed := utils.FileExists(dstPath)
+	job.AddTotals(scanTree(job.Context(), srcPath))
+
+	err := copyRecursive(job, srcPath, dstPath, conflict)
+	finishCopyJob(job, dstPath, !existed, err)
+}
+
+// runMoveJob moves srcPath to dstPath under job, merging into an existing
+// destination according to the conflict policy
+func runMoveJob(job *models.Job, srcPath, dstPath, conflict string) {
+	defer invalidateListing(srcPath, dstPath)
+
+	existed := utils.FileExists(dstPath)
+	err := moveRecursive(job, srcPath, dstPath, conflict)
+	finishCopyJob(job, dstPath, !existed, err)
+}
+
+// runCopyOn runs a copy from srcFS to dstFS, using the local copy engine for
+// the local disk, the backend's own server-side copy within another backend,
+// and a transfer job between backends
+func runCopyOn(job *models.Job, srcFS, dstFS vfs.Filesystem, srcPath, dstPath, conflict string) {
+	if srcFS != dstFS {
+		runTransferJob(job, srcFS, srcPath, dstFS, dstPath, false)
+		return
+	}
+	if vfs.IsLocal(srcFS) {
+		runCopyJob(job, srcPath, dstPath, conflict)
+		return
+	}
+
+	copier, ok := srcFS.(vfs.Copier)
+	if !ok {
+		job.Finish(models.JobFailed, "Storage backend does not support copying")
+		return
+	}
+	runBackendJob(job, srcPath, func() error { return copier.Copy(srcPath, dstPath) })
+}
+
+// runMoveOn is runCopyOn for moves. A completed move is announced as a
+// file.moved event by actor besides the job's own events.
+func runMoveOn(job *models.Job, actor string, srcFS, dstFS vfs.Filesystem, srcPath, dstPath, conflict string) {
+	defer func() {
+		if job.Info().Status == models.JobCompleted {
+			events.Publish(events.Event{Type: events.FileMoved, Path: displayPath(srcFS, srcPath), Destination: displayPath(dstFS, dstPath), Actor: actor})
+		}
+	}()
+	if srcFS != dstFS {
+		runTransferJob(job, srcFS, srcPath, dstFS, dstPath, true)
+		return
+	}
+	if vfs.IsLocal(srcFS) {
+		runMoveJob(job, srcPath, dstPath, conflict)
+		return
+	}
+	runBackendJob(job, srcPath, func() error { return srcFS.Rename(srcPath, dstPath) })
+}
+
+// runBackendJob runs a single backend operation as one job item
+func runBackendJob(job *models.Job, srcPath string, op func() error) {
+	job.AddTotals(1, 0)
+	job.SetCurrentFile(srcPath)
+
+	if err := op(); err != nil {
+		job.Finish(models.JobFailed, err.Error())
+		return
+	}
+	job.ItemDone()
+	job.Finish(models.JobCompleted, "")
+}
+
+// finishCopyJob sets the final job status and cleans up after cancellation.
+// The destination is only removed if the job created it.
+func finishCopyJob(job *models.Job, dstPath string, created bool, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		if created {
+			_ = fastDelete(context.Background(), dstPath)
+		}
+		job.Finish(models.JobCancelled, "Operation cancelled")
+	case err != nil:
+		job.Finish(models.JobFailed, err.Error())
+	case len(job.Errors()) > 0:
+		job.Finish(models.JobFailed, fmt.Sprintf("Completed with %d errors", len(job.Errors())))
+	default:
+		job.Finish(models.JobCompleted, "")
+	}
+}
+
+// scanTree counts the items and bytes below root for progress reporting.
+// Unreadable entries are skipped here and reported by the copy itself.
+func scanTree(ctx context.Context, root string) (items, bytes int64) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return 0, 0
+	}
+	if !info.IsDir() {
+		return 1, info.Size()
+	}
+
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		items++
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	return items, bytes
+}
+
+// copyRecursive copies files/directories from src to dst. The source root is
+// followed if it is a symlink (it was already validated by SafeResolve), while
+// symlinks inside the tree are recreated as links rather than followed.
+func copyRecursive(job *models.Job, src, dst, conflict string) (err error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	target, action, kept, err := resolveConflict(dst, srcInfo, conflict)
+	if err != nil {
+		return err
+	}
+	defer func() { kept.settle(err) }()
+
+	switch {
+	case action == actionSkip:
+		job.ItemDone()
+		return nil
+	case !srcInfo.IsDir():
+		return copyFileContents(job, src, target, srcInfo)
+	}
+
+	t := newTreeCopy(job, conflict)
+	if action != actionMerge {
+		// Create destination directory
+		if err := os.MkdirAll(target, srcInfo.Mode().Perm()); err != nil {
+			t.finish()
+			return err
+		}
+		t.dirs = append(t.dirs, pendingCopy{src: src, dst: target, info: srcInfo})
+	}
+	job.ItemDone()
+
+	err = t.copyDirContents(src, target)
+	t.finish()
+	if err != nil {
+		return err
+	}
+	return job.Context().Err()
+}
+
+// treeCopy copies a directory tree. The walk creates directories in order
+// and hands regular files to a bounded pool of workers, so that trees of many
+// small files use the disk's IOPS. Directory metadata is applied once every
+// file is in, deepest first, since copying into a directory changes its
+// times.
+type treeCopy struct {
+	job      *models.Job
+	conflict string
+	files    chan pendingCopy // nil when files are copied by the walk itself
+	wg       sync.WaitGroup
+	dirs     []pendingCopy // created directories, parents first
+}
+
+// pendingCopy is an entry copied, or to be copied, from src to dst, with the
+// entry it replaces
+type pendingCopy struct {
+	src, dst string
+	info     os.FileInfo
+	kept     *replaced
+}
+
+func newTreeCopy(job *models.Job, conflict string) *treeCopy {
+	t := &treeCopy{job: job, conflict: conflict}
+
+	// Renaming around existing names only sees files already copied, so under
+	// that policy files are copied one by one, in order
+	numWorkers := min(runtime.NumCPU(), 8) // Cap at 8 workers to avoid overwhelming the filesystem
+	if numWorkers < 2 || conflict == conflictRename {
+		return t
+	}
+	t.files = make(chan pendingCopy, numWorkers*2)
+	for range numWorkers {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			for f := range t.files {
+				err := copyFileContents(job, f.src, f.dst, f.info)
+				f.kept.settle(err)
+				if err != nil && job.Context().Err() == nil {
+					job.AddError(utils.ToUserPath(f.src), err)
+				}
+			}
+		}()
+	}
+	return t
+}
+
+// copyFile copies a regular file, or hands it to a worker, settling the
+// entry it replaces once it is copied
+func (t *treeCopy) copyFile(src, dst string, info os.FileInfo, kept *replaced) error {
+	if t.files == nil {
+		err := copyFileContents(t.job, src, dst, info)
+		kept.settle(err)
+		return err
+	}
+	ctx := t.job.Context()
+	select {
+	case t.files <- pendingCopy{src: src, dst: dst, info: info, kept: kept}:
+		return nil
+	case <-ctx.Done():
+		kept.settle(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+// finish waits for the workers and, unless the job was cancelled, applies
+// the metadata of the created directories
+func (t *treeCopy) finish() {
+	if t.files != nil {
+		close(t.files)
+		t.wg.Wait()
+	}
+	if t.job.Context().Err() != nil {
+		return
+	}
+	for i := len(t.dirs) - 1; i >= 0; i-- {
+		preserveMetadata(t.job, t.dirs[i].src, t.dirs[i].dst, t.dirs[i].info)
+	}
+}
+
+// copyDirContents copies the entries of src into the existing directory dst,
+// recording per-entry failures on the job instead of aborting
+func (t *treeCopy) copyDirContents(src, dst string) error {
+	ctx := t.job.Context()
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.job.AddError(utils.ToUserPath(src), err)
+		return nil
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		srcPath := filepath.Join(src, entry.Name())
+		err := t.copyEntry(srcPath, filepath.Join(dst, utils.MatchExisting(dst, entry.Name())))
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			t.job.AddError(utils.ToUserPath(srcPath), err)
+		}
+	}
+
+	return nil
+}
+
+// copyEntry copies one directory entry without following it if it's a symlink.
+// Only cancellation is returned from nested directories; other nested failures
+// are recorded on the job.
+func (t *treeCopy) copyEntry(src, dst string) (err error) {
+	job := t.job
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	target, action, kept, err := resolveConflict(dst, info, t.conflict)
+	if err != nil {
+		return err
+	}
+	if action != actionSkip && info.Mode().IsRegular() {
+		// Whoever copies the file settles the entry it replaces
+		return t.copyFile(src, target, info, kept)
+	}
+	defer func() { kept.settle(err) }()
+
+	switch {
+	case action == actionSkip:
+		job.ItemDone()
+		return nil
+	case info.Mode()&os.ModeSymlink != 0:
+		if err := copySymlink(src, target); err != nil {
+			return err
+		}
+		preserveMetadata(job, src, target, info)
+		job.ItemDone()
+		return nil
+	case info.IsDir() && action == actionMerge:
+		job.ItemDone()
+		return t.copyDirContents(src, target)
+	case info.IsDir():
+		if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		job.ItemDone()
+		t.dirs = append(t.dirs, pendingCopy{src: src, dst: target, info: info})
+		return t.copyDirContents(src, target)
+	default:
+		return errors.New("unsupported file type")
+	}
+}
+
+// moveRecursive moves src to dst. Entries are renamed where possible; across
+// filesystems they are copied and then removed. When dst is an existing
+// directory the source directory is merged into it entry by entry, and source
+// directories are removed once empty (skipped entries keep them alive).
+func moveRecursive(job *models.Job, src, dst, conflict string) (err error) {
+	ctx := job.Context()
+
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	target, action, kept, err := resolveConflict(dst, srcInfo, conflict)
+	if err != nil {
+		return err
+	}
+	defer func() { kept.settle(err) }()
+
+	switch action {
+	case actionSkip:
+		job.AddTotals(1, 0)
+		job.ItemDone()
+		return nil
+
+	case actionMerge:
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			entrySrc := filepath.Join(src, entry.Name())
+			err := moveRecursive(job, entrySrc, filepath.Join(target, utils.MatchExisting(target, entry.Name())), conflict)
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				job.AddError(utils.ToUserPath(entrySrc), err)
+			}
+		}
+		_ = os.Remove(src) // only succeeds once the directory is empty
+		return nil
+	}
+
+	err = os.Rename(src, target)
+	if err == nil {
+		job.AddTotals(1, 0)
+		job.ItemDone()
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	// Cross-device move: copy everything, then remove the source
+	errorsBefore := len(job.Errors())
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		err = copySymlink(src, target)
+	} else {
+		job.AddTotals(scanTree(ctx, src))
+		err = copyRecursive(job, src, target, conflictFail)
+	}
+	if err != nil {
+		return err
+	}
+	if len(job.Errors()) > errorsBefore {
+		return errors.New("source kept because some entries failed to copy")
+	}
+	return fastDelete(context.Background(), src)
+}
+
+// copyFileContents copies a single regular file, reporting progress and
+// stopping early on cancellation. It first tries a copy-on-write clone, which
+// is near-instant on btrfs/XFS/APFS, then falls back to a chunked copy into a
+// temporary file that only replaces dst once complete.
+func copyFileContents(job *models.Job, src, dst string, srcInfo os.FileInfo) (err error) {
+	ctx := job.Context()
+	job.SetCurrentFile(utils.ToUserPath(src))
+
+	if err := cloneFile(src, dst, srcInfo.Mode().Perm()); err == nil {
+		job.AddBytes(srcInfo.Size())
+		preserveMetadata(job, src, dst, srcInfo)
+		job.ItemDone()
+		return nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := vfs.CreateAtomic(dst, srcInfo.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dstFile.Abort()
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := copyChunk(dstFile.File, srcFile, copyChunkSize)
+		job.AddBytes(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+
+	// Ownership goes first since chown may clear setuid/setgid bits
+	preserveMetadata(job, src, dst, srcInfo)
+
+	// Copy file permissions
+	if err := os.Chmod(dst, srcInfo.Mode()); err != nil {
+		return err
+	}
+
+	job.ItemDone()
+	return nil
+}
+
+// preserveMetadata applies the metadata selected by COPY_PRESERVE from src to
+// dst. Failures (e.g. chown without privileges) are recorded on the job but
+// don't undo the copy.
+func preserveMetadata(job *models.Job, src, dst string, srcInfo os.FileInfo) {
+	var errs []error
+
+	if config.CopyPreserveOwnership {
+		if uid, gid, ok := fileOwner(srcInfo); ok {
+			if err := os.Lchown(dst, uid, gid); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if config.CopyPreserveXattrs {
+		if err := copyXattrs(src, dst); err != nil {
+			errs = append(errs, fmt.Errorf("xattrs: %w", err))
+		}
+	}
+
+	if config.CopyPreserveTimestamps {
+		atime := fileAccessTime(srcInfo)
+		var err error
+		if srcInfo.Mode()&os.ModeSymlink != 0 {
+			err = setSymlinkTimes(dst, atime, srcInfo.ModTime())
+		} else {
+			err = os.Chtimes(dst, atime, srcInfo.ModTime())
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		job.AddError(utils.ToUserPath(dst), fmt.Errorf("failed to preserve metadata: %w", err))
+	}
+}
+
+// bufferedCopyChunk copies up to n bytes from src to dst through a pooled
+// buffer. It returns io.EOF once src is exhausted.
+func bufferedCopyChunk(dst, src *os.File, n int64) (int64, error) {
+	written, err := buffers.Copy(dst, io.LimitReader(src, n))
+	if err == nil && written < n {
+		err = io.EOF
+	}
+	return written, err
+}
+
+// copySymlink recreates the symlink src at dst with the same target
+func copySymlink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
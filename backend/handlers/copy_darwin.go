@@ -0,0 +1,33 @@
+//go:build darwin
+
+package handlers
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as an APFS copy-on-write clone of src via clonefile(2).
+// It fails on filesystems without clone support, leaving dst absent.
+func cloneFile(src, dst string, perm os.FileMode) error {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// copyChunk copies up to n bytes from src to dst
+func copyChunk(dst, src *os.File, n int64) (int64, error) {
+	return bufferedCopyChunk(dst, src, n)
+}
+
+// fileAccessTime returns the access time recorded in info
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atimespec.Sec, stat.Atimespec.Nsec)
+	}
+	return info.ModTime()
+}
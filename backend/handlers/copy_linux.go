@@ -0,0 +1,77 @@
+//go:build linux
+
+package handlers
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile creates dst as a copy-on-write clone of src using the FICLONE
+// ioctl (btrfs, XFS with reflink, bcachefs). It fails on filesystems without
+// reflink support, in which case dst does not exist afterwards.
+func cloneFile(src, dst string, perm os.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd()))
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// copyChunk copies up to n bytes from src to dst with copy_file_range, which
+// keeps the data in the kernel (and lets NFS/SMB do server-side copies).
+// It falls back to a buffered copy when the syscall isn't usable.
+func copyChunk(dst, src *os.File, n int64) (int64, error) {
+	var written int64
+	for written < n {
+		copied, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(n-written), 0)
+		if err != nil {
+			if written == 0 && isCopyRangeUnsupported(err) {
+				return bufferedCopyChunk(dst, src, n)
+			}
+			return written, err
+		}
+		if copied == 0 {
+			return written, io.EOF
+		}
+		written += int64(copied)
+	}
+	return written, nil
+}
+
+// isCopyRangeUnsupported reports errors meaning copy_file_range can't be used
+// for this pair of files (old kernel, cross-filesystem, special files)
+func isCopyRangeUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) ||
+		errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EOPNOTSUPP) ||
+		errors.Is(err, unix.EPERM)
+}
+
+// fileAccessTime returns the access time recorded in info
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
+	}
+	return info.ModTime()
+}
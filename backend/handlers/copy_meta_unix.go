@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileOwner returns the uid and gid recorded in info
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// setSymlinkTimes sets the timestamps of a symlink itself, not its target
+func setSymlinkTimes(path string, atime, mtime time.Time) error {
+	times := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(mtime.UnixNano()),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// copyXattrs copies all extended attributes from src to dst without following
+// symlinks. Filesystems without xattr support are treated as having none.
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(src, names)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+
+		valueSize, err := unix.Lgetxattr(src, attr, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(src, attr, value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := unix.Lsetxattr(dst, attr, value[:valueSize], 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
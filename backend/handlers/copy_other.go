@@ -0,0 +1,40 @@
+//go:build !linux && !darwin
+
+package handlers
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// cloneFile is not supported on this platform; callers fall back to copying
+func cloneFile(src, dst string, perm os.FileMode) error {
+	return errors.New("file cloning not supported on this platform")
+}
+
+// copyChunk copies up to n bytes from src to dst
+func copyChunk(dst, src *os.File, n int64) (int64, error) {
+	return bufferedCopyChunk(dst, src, n)
+}
+
+// fileAccessTime returns the access time recorded in info; this platform
+// doesn't expose it portably, so the modification time is used
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// fileOwner is not supported on this platform
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// setSymlinkTimes is not supported on this platform
+func setSymlinkTimes(path string, atime, mtime time.Time) error {
+	return nil
+}
+
+// copyXattrs is not supported on this platform
+func copyXattrs(src, dst string) error {
+	return nil
+}
@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// davMethods are the HTTP methods routed to the WebDAV handler
+var davMethods = []string{
+	"OPTIONS", "GET", "HEAD", "PUT", "DELETE",
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// RegisterWebDAV serves config.WebDAVRoot over WebDAV at prefix, so the tree
+// can be mapped as a network drive
+func RegisterWebDAV(r gin.IRoutes, prefix string) {
+	handler := &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: davFS{root: config.WebDAVRoot},
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	h := func(c *gin.Context) {
+		if !admitDAV(c, prefix) {
+			return
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), davActorKey{}, c.ClientIP()))
+		// Let a replacing upload see whether its body arrived in full
+		if c.Request.Method == http.MethodPut {
+			body := &davBody{ReadCloser: c.Request.Body}
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), davBodyKey{}, body))
+			c.Request.Body = body
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+	for _, method := range davMethods {
+		r.Handle(method, prefix, h)
+		r.Handle(method, prefix+"/*path", h)
+	}
+}
+
+// admitDAV checks the WebDAV requests that add to the tree against the
+// quotas they fall under, as the API does: a PUT by its Content-Length, and
+// a COPY or MOVE by the tree it copies. Requests the WebDAV handler will
+// refuse anyway are let through for it to answer.
+func admitDAV(c *gin.Context, prefix string) bool {
+	userPath := func(urlPath string) string {
+		return path.Join(config.WebDAVRoot, "/"+strings.TrimPrefix(urlPath, prefix))
+	}
+
+	switch c.Request.Method {
+	case http.MethodPut:
+		target := userPath(c.Request.URL.Path)
+		if len(quotasOver(target)) == 0 {
+			return true
+		}
+		if c.Request.ContentLength < 0 {
+			problem.Respond(c, http.StatusLengthRequired, "Uploads into a directory with a quota need a Content-Length")
+			return false
+		}
+		files := int64(1)
+		if fsys, name, err := utils.ResolveFS(target); err == nil {
+			if _, err := fsys.Stat(name); err == nil {
+				files = 0
+			}
+		}
+		return admitQuota(c, target, c.Request.ContentLength, files)
+	case "COPY", "MOVE":
+		dst, err := url.Parse(c.GetHeader("Destination"))
+		if err != nil {
+			return true
+		}
+		source := userPath(c.Request.URL.Path)
+		srcFS, srcPath, err := utils.ResolveFS(source)
+		if err != nil {
+			return true
+		}
+		if _, err := srcFS.Stat(srcPath); err != nil {
+			return true
+		}
+		return admitTree(c, srcFS, srcPath, source, userPath(dst.Path), c.Request.Method == "MOVE")
+	}
+	return true
+}
+
+// davFS exposes the tree to the WebDAV server. Every name is resolved through
+// ResolveFS, so WebDAV clients get the same root confinement, symlink policy
+// and mounts as the API.
+type davFS struct {
+	root string
+}
+
+// errCrossBackend is returned for moves between storage backends
+var errCrossBackend = errors.New("moving between storage backends is not supported")
+
+// resolve maps a WebDAV name to its backend and the user path it stands for
+func (d davFS) resolve(name string) (vfs.Filesystem, string, string, error) {
+	userPath := path.Join(d.root, "/"+name)
+	fsys, resolved, err := utils.ResolveFS(userPath)
+	if err != nil {
+		return nil, "", "", &fs.PathError{Op: "resolve", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys, resolved, userPath, nil
+}
+
+// resolveLink is resolve for operations on a link itself, like ResolveLinkFS.
+// The served root can't be removed or renamed.
+func (d davFS) resolveLink(name string) (vfs.Filesystem, string, error) {
+	if path.Clean("/"+name) == "/" {
+		return nil, "", &fs.PathError{Op: "resolve", Path: name, Err: fs.ErrPermission}
+	}
+	fsys, resolved, err := utils.ResolveLinkFS(path.Join(d.root, "/"+name))
+	if err != nil {
+		return nil, "", &fs.PathError{Op: "resolve", Path: name, Err: fs.ErrPermission}
+	}
+	return fsys, resolved, nil
+}
+
+// checkNewName refuses to create an entry whose name the API would reject
+func (d davFS) checkNewName(name string) error {
+	if err := utils.ValidateNewPath(name); err != nil {
+		return &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+	}
+	return nil
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if err := d.checkNewName(name); err != nil {
+		return err
+	}
+	fsys, resolved, _, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := utils.TryLockPaths(utils.WriteLock(resolved))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := fsys.Mkdir(resolved, perm); err != nil {
+		return err
+	}
+	invalidateListing(resolved)
+	publishChange(davActor(ctx), events.DirCreated, fsys, resolved, nil)
+	return nil
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	fsys, resolved, userPath, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) == 0 {
+		file, err := fsys.Open(resolved)
+		if err != nil {
+			return nil, err
+		}
+		return &davFile{File: file, fsys: fsys, name: resolved, userPath: userPath}, nil
+	}
+
+	if flag&os.O_CREATE != 0 {
+		if _, err := fsys.Stat(resolved); err != nil {
+			if err := d.checkNewName(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Writers hold the path lock until the upload is closed
+	unlock, err := utils.TryLockPaths(utils.WriteLock(resolved))
+	if err != nil {
+		return nil, err
+	}
+
+	// Local files being replaced (PUT, COPY) are written atomically
+	var file vfs.File
+	if vfs.IsLocal(fsys) && flag&os.O_TRUNC != 0 {
+		file, err = vfs.CreateAtomic(resolved, perm)
+	} else {
+		file, err = fsys.OpenFile(resolved, flag, perm)
+	}
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	body, _ := ctx.Value(davBodyKey{}).(*davBody)
+	return &davFile{File: file, fsys: fsys, name: resolved, userPath: userPath, unlock: unlock, body: body, actor: davActor(ctx)}, nil
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	fsys, resolved, err := d.resolveLink(name)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := utils.TryLockPaths(utils.WriteLock(resolved))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if vfs.IsLocal(fsys) {
+		err = fastDelete(ctx, resolved)
+	} else {
+		err = fsys.RemoveAll(resolved)
+	}
+	invalidateListing(resolved)
+	if err == nil {
+		publishChange(davActor(ctx), events.FileDeleted, fsys, resolved, nil)
+	}
+	return err
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	srcFS, src, err := d.resolveLink(oldName)
+	if err != nil {
+		return err
+	}
+	if err := d.checkNewName(newName); err != nil {
+		return err
+	}
+	dstFS, dst, err := d.resolveLink(newName)
+	if err != nil {
+		return err
+	}
+	if srcFS != dstFS {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errCrossBackend}
+	}
+
+	unlock, err := utils.TryLockPaths(utils.WriteLock(src), utils.WriteLock(dst))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := srcFS.Rename(src, dst); err != nil {
+		return err
+	}
+	invalidateListing(src, dst)
+	events.Publish(events.Event{Type: events.FileMoved, Path: displayPath(srcFS, src), Destination: displayPath(dstFS, dst), Actor: davActor(ctx)})
+	return nil
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fsys, resolved, _, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.Stat(resolved)
+}
+
+// davFile adds directory listing to a vfs.File and releases the write lock
+// when an upload completes
+type davFile struct {
+	vfs.File
+	fsys     vfs.Filesystem
+	name     string
+	userPath string
+	unlock   func()
+	body     *davBody // request body of the PUT writing the file
+	actor    string   // address of the client writing the file
+
+	entries []fs.FileInfo
+	read    bool
+}
+
+// Readdir lists the directory like the API does: symlinks are followed under
+// the "follow" policy and left out otherwise, since WebDAV can't show them
+func (f *davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	if !f.read {
+		entries, err := f.fsys.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.read = true
+
+		for _, entry := range entries {
+			var info fs.FileInfo
+			if entry.Type()&fs.ModeSymlink != 0 {
+				if config.SymlinkPolicy != config.SymlinkFollow {
+					continue
+				}
+				fsys, resolved, err := utils.ResolveFS(path.Join(f.userPath, entry.Name()))
+				if err != nil {
+					continue
+				}
+				if info, err = fsys.Stat(resolved); err != nil {
+					continue
+				}
+				info = renamedInfo{FileInfo: info, name: entry.Name()}
+			} else if info, err = entry.Info(); err != nil {
+				continue
+			}
+			f.entries = append(f.entries, info)
+		}
+	}
+
+	if count <= 0 {
+		infos := f.entries
+		f.entries = nil
+		return infos, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(f.entries))
+	infos := f.entries[:n]
+	f.entries = f.entries[n:]
+	return infos, nil
+}
+
+func (f *davFile) Close() error {
+	var err error
+	if atomic, ok := f.File.(*vfs.AtomicFile); ok && f.body != nil && f.body.err != nil {
+		// The upload broke off, so keep the file it was replacing
+		atomic.Abort()
+		err = f.body.err
+	} else {
+		err = f.File.Close()
+	}
+	if f.unlock != nil {
+		invalidateListing(f.name)
+		if err == nil {
+			publishChange(f.actor, events.FileWritten, f.fsys, f.name, nil)
+		}
+		f.unlock()
+		f.unlock = nil
+	}
+	return err
+}
+
+// davBodyKey is the request context key of a PUT's davBody
+type davBodyKey struct{}
+
+// davActorKey is the request context key of the client's address
+type davActorKey struct{}
+
+// davActor returns the address of the client making a WebDAV request
+func davActor(ctx context.Context) string {
+	actor, _ := ctx.Value(davActorKey{}).(string)
+	return actor
+}
+
+// davBody remembers whether reading a request body failed, e.g. because the
+// client disconnected mid-upload
+type davBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// renamedInfo reports a followed symlink's target under the link's name
+type renamedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i renamedInfo) Name() string { return i.name }
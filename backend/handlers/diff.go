@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// maxDiffEntries bounds how many entries of each directory a comparison walks
+const maxDiffEntries = 100000
+
+// Statuses of a DiffEntry
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// DiffResponse is the comparison of two files or two directories
+type DiffResponse struct {
+	OK        bool   `json:"ok"`
+	Type      string `json:"type"` // "file" or "dir"
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Identical bool   `json:"identical"`
+
+	// Files
+	Binary bool   `json:"binary,omitempty"` // not compared line by line
+	Diff   string `json:"diff,omitempty"`   // unified diff of text files
+
+	// Directories
+	Changes    []DiffEntry `json:"changes,omitempty"`
+	Truncated  bool        `json:"truncated,omitempty"` // more than maxDiffEntries entries
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// DiffEntry is an entry that differs between two directories
+type DiffEntry struct {
+	Path      string `json:"path"` // relative to both directories
+	Status    string `json:"status"`
+	Type      string `json:"type"` // of the entry in to, or in from when removed
+	FromSize  *int64 `json:"fromSize,omitempty"`
+	ToSize    *int64 `json:"toSize,omitempty"`
+	FromMTime int64  `json:"fromMtime,omitempty"`
+	ToMTime   int64  `json:"toMtime,omitempty"`
+}
+
+// diffSide is one of the two paths being compared
+type diffSide struct {
+	fsys vfs.Filesystem
+	name string
+	path string // user path
+	info fs.FileInfo
+}
+
+// DiffPaths compares ?from= with ?to=. Two text files get a unified diff
+// with ?context= lines around changes (default 3); files over DIFF_MAX_SIZE
+// or not UTF-8 are only told apart. Two directories get the entries added,
+// removed or changed below them, changed meaning a different type, size or
+// modification time, or with ?hash=true different contents.
+func DiffPaths(c *gin.Context) {
+	from, ok := resolveDiffSide(c, c.Query("from"))
+	if !ok {
+		return
+	}
+	to, ok := resolveDiffSide(c, c.Query("to"))
+	if !ok {
+		return
+	}
+
+	switch {
+	case from.info.IsDir() && to.info.IsDir():
+		diffDirectories(c, from, to)
+	case !from.info.IsDir() && !to.info.IsDir():
+		diffFiles(c, from, to)
+	default:
+		problem.Respond(c, http.StatusBadRequest, "Cannot compare a file with a directory")
+	}
+}
+
+func resolveDiffSide(c *gin.Context, userPath string) (diffSide, bool) {
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing from or to parameter")
+		return diffSide{}, false
+	}
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return diffSide{}, false
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Path not found: "+userPath)
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat path: "+err.Error())
+		}
+		return diffSide{}, false
+	}
+	return diffSide{fsys: fsys, name: name, path: displayPath(fsys, name), info: info}, true
+}
+
+// diffFiles answers with the differences between two files
+func diffFiles(c *gin.Context, from, to diffSide) {
+	contextLines := 3
+	if val, err := strconv.Atoi(c.Query("context")); err == nil && val >= 0 && val <= 100 {
+		contextLines = val
+	}
+
+	response := DiffResponse{OK: true, Type: "file", From: from.path, To: to.path}
+
+	// Large files are only compared by checksum
+	if from.info.Size() > config.DiffMaxSize || to.info.Size() > config.DiffMaxSize {
+		same, err := sameContents(c.Request.Context(), from, to)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to compare files: "+err.Error())
+			return
+		}
+		response.Identical, response.Binary = same, true
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	a, err := readAll(from)
+	if err == nil {
+		var b []byte
+		if b, err = readAll(to); err == nil {
+			response.Identical = bytes.Equal(a, b)
+			response.Binary = !isText(a) || !isText(b)
+			if !response.Identical && !response.Binary {
+				response.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+					A:        splitLines(a),
+					B:        splitLines(b),
+					FromFile: from.path,
+					ToFile:   to.path,
+					FromDate: from.info.ModTime().UTC().Format(time.RFC3339),
+					ToDate:   to.info.ModTime().UTC().Format(time.RFC3339),
+					Context:  contextLines,
+				})
+			}
+		}
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to compare files: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func readAll(side diffSide) ([]byte, error) {
+	file, err := side.fsys.Open(side.name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, config.DiffMaxSize+1))
+}
+
+// splitLines splits text into lines that keep their line endings, giving a
+// last line without one a newline
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+// isText reports whether data is UTF-8 without NUL bytes
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// sameContents reports whether two files have the same checksum
+func sameContents(ctx context.Context, a, b diffSide) (bool, error) {
+	if a.info.Size() != b.info.Size() {
+		return false, nil
+	}
+	sumA, err := checksumFile(ctx, a.fsys, a.name)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := checksumFile(ctx, b.fsys, b.name)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(sumA, sumB), nil
+}
+
+// diffDirectories answers with the entries that differ below two
+// directories, in path order
+func diffDirectories(c *gin.Context, from, to diffSide) {
+	byHash := c.Query("hash") == "true"
+	ctx := c.Request.Context()
+
+	fromEntries, fromTruncated, err := walkForDiff(ctx, from)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read "+from.path+": "+err.Error())
+		return
+	}
+	toEntries, toTruncated, err := walkForDiff(ctx, to)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read "+to.path+": "+err.Error())
+		return
+	}
+
+	var changes []DiffEntry
+	for rel, a := range fromEntries {
+		b, exists := toEntries[rel]
+		if !exists {
+			changes = append(changes, newDiffEntry(rel, diffRemoved, a, nil))
+			continue
+		}
+		changed, err := entryChanged(ctx, from, to, rel, a, b, byHash)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to compare "+rel+": "+err.Error())
+			return
+		}
+		if changed {
+			changes = append(changes, newDiffEntry(rel, diffChanged, a, b))
+		}
+	}
+	for rel, b := range toEntries {
+		if _, exists := fromEntries[rel]; !exists {
+			changes = append(changes, newDiffEntry(rel, diffAdded, nil, b))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	response := DiffResponse{
+		OK:        true,
+		Type:      "dir",
+		From:      from.path,
+		To:        to.path,
+		Identical: len(changes) == 0 && !fromTruncated && !toTruncated,
+		Truncated: fromTruncated || toTruncated,
+		Changes:   changes,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response.Changes, response.Pagination = paginate(changes, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// walkForDiff returns the entries below a directory by slash-separated
+// relative path, stopping at maxDiffEntries
+func walkForDiff(ctx context.Context, side diffSide) (map[string]fs.FileInfo, bool, error) {
+	entries := make(map[string]fs.FileInfo)
+	truncated := false
+	err := vfs.WalkDir(side.fsys, side.name, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if name == side.name {
+				return err
+			}
+			return nil // skip what can't be read
+		}
+		if name == side.name {
+			return nil
+		}
+		if len(entries) == maxDiffEntries {
+			truncated = true
+			return fs.SkipAll
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(side.name, name)
+		if err != nil {
+			return nil
+		}
+		entries[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	return entries, truncated, err
+}
+
+// entryChanged reports whether an entry present below both directories
+// differs between them
+func entryChanged(ctx context.Context, from, to diffSide, rel string, a, b fs.FileInfo, byHash bool) (bool, error) {
+	if a.Mode().Type() != b.Mode().Type() {
+		return true, nil
+	}
+	if !a.Mode().IsRegular() {
+		return false, nil
+	}
+	if a.Size() != b.Size() {
+		return true, nil
+	}
+	if !byHash {
+		// Backends keep modification times at different precisions
+		return a.ModTime().Unix() != b.ModTime().Unix(), nil
+	}
+	same, err := sameContents(ctx,
+		diffSide{fsys: from.fsys, name: filepath.Join(from.name, filepath.FromSlash(rel)), info: a},
+		diffSide{fsys: to.fsys, name: filepath.Join(to.name, filepath.FromSlash(rel)), info: b})
+	return !same, err
+}
+
+func newDiffEntry(rel, status string, a, b fs.FileInfo) DiffEntry {
+	entry := DiffEntry{Path: rel, Status: status}
+	if a != nil {
+		entry.Type = entryType(a)
+		entry.FromMTime = a.ModTime().UnixMilli()
+		if a.Mode().IsRegular() {
+			size := a.Size()
+			entry.FromSize = &size
+		}
+	}
+	if b != nil {
+		entry.Type = entryType(b)
+		entry.ToMTime = b.ModTime().UnixMilli()
+		if b.Mode().IsRegular() {
+			size := b.Size()
+			entry.ToSize = &size
+		}
+	}
+	return entry
+}
+
+func entryType(info fs.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "dir"
+	case info.Mode()&fs.ModeSymlink != 0:
+		return "symlink"
+	}
+	return "file"
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/iso"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// ISOListing is a directory inside a disc image
+type ISOListing struct {
+	OK     bool       `json:"ok"`
+	Format string     `json:"format"` // rockridge, joliet or iso9660, the names the image is read with
+	Volume string     `json:"volume,omitempty"`
+	Entry  string     `json:"entry"`
+	Items  []FileItem `json:"items"`
+}
+
+type ISOExtractRequest struct {
+	Path        string `json:"path"`           // the image
+	Entry       string `json:"entry"`          // file inside the image
+	Destination string `json:"destination"`    // directory to extract into
+	Name        string `json:"name,omitempty"` // defaults to the entry's name
+}
+
+// ListISO lists the directory at ?entry= (the root by default) inside the
+// ISO9660 image at ?path=. Only the directories on the way are read, so
+// browsing a large image is quick.
+func ListISO(c *gin.Context) {
+	file, img, ok := openISO(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	entry := path.Clean("/" + c.Query("entry"))
+	entries, err := img.ReadDir(entry)
+	if err != nil {
+		respondISOError(c, err)
+		return
+	}
+	items := make([]FileItem, 0, len(entries))
+	for _, e := range entries {
+		item := FileItem{Name: e.Name, Type: "file", MTime: e.ModTime.UnixMilli()}
+		if e.Dir {
+			item.Type = "dir"
+		} else {
+			size := e.Size
+			item.Size = &size
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, ISOListing{OK: true, Format: img.Format(), Volume: img.Volume(), Entry: entry, Items: items})
+}
+
+// DownloadISOEntry sends the file at ?entry= inside the image at ?path=,
+// with range requests
+func DownloadISOEntry(c *gin.Context) {
+	file, img, ok := openISO(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	e, ok := isoFile(c, img, c.Query("entry"))
+	if !ok {
+		return
+	}
+	c.Header("Content-Disposition", attachment(e.Name))
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, e.Name, e.ModTime, img.Open(e))
+}
+
+// ExtractISOEntry copies a file out of an image into a directory, failing
+// if a file of that name is there already
+func ExtractISOEntry(c *gin.Context) {
+	var req ISOExtractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" || req.Entry == "" || req.Destination == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path, entry or destination")
+		return
+	}
+
+	file, img, ok := openISO(c, req.Path)
+	if !ok {
+		return
+	}
+	defer file.Close()
+	e, ok := isoFile(c, img, req.Entry)
+	if !ok {
+		return
+	}
+	if req.Name == "" {
+		req.Name = e.Name
+	}
+	if err := utils.ValidateFileName(req.Name); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid file name: "+err.Error())
+		return
+	}
+
+	dstFS, dir, err := utils.ResolveFS(req.Destination)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination: "+err.Error())
+		return
+	}
+	if info, err := dstFS.Stat(dir); err != nil || !info.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Destination directory not found")
+		return
+	}
+	if !admitQuota(c, path.Join(req.Destination, req.Name), e.Size, 1) {
+		return
+	}
+
+	target := utils.JoinName(dstFS, dir, req.Name)
+	unlock, ok := lockPaths(c, utils.WriteLock(target))
+	if !ok {
+		return
+	}
+	defer unlock()
+	if _, err := dstFS.Lstat(target); err == nil {
+		problem.Respond(c, http.StatusConflict, "File already exists")
+		return
+	}
+	reader := &progressReader{ctx: c.Request.Context(), r: img.Open(e)}
+	if err := vfs.WriteFrom(dstFS, target, reader, e.Size); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to extract file: "+err.Error())
+		return
+	}
+
+	info, err := dstFS.Stat(target)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	invalidateListing(target)
+	publishChange(c.ClientIP(), events.FileCreated, dstFS, target, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"ok":      true,
+		"message": "File extracted",
+		"path":    displayPath(dstFS, target),
+		"size":    info.Size(),
+		"etag":    fileETag(info),
+	})
+}
+
+// openISO opens the image at userPath and reads its volume descriptors,
+// answering with a problem when it can't
+func openISO(c *gin.Context, userPath string) (vfs.File, *iso.Image, bool) {
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return nil, nil, false
+	}
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return nil, nil, false
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "File not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to open file: "+err.Error())
+		}
+		return nil, nil, false
+	}
+	if info, err := file.Stat(); err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		problem.Respond(c, http.StatusBadRequest, "Path is not a regular file")
+		return nil, nil, false
+	}
+	img, err := iso.Open(file)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, iso.ErrNotISO) || errors.Is(err, iso.ErrUDFOnly) {
+			problem.Respond(c, http.StatusUnprocessableEntity, err.Error())
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to read image: "+err.Error())
+		}
+		return nil, nil, false
+	}
+	return file, img, true
+}
+
+// isoFile looks up a file inside an image
+func isoFile(c *gin.Context, img *iso.Image, entry string) (iso.Entry, bool) {
+	if entry == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing entry parameter")
+		return iso.Entry{}, false
+	}
+	e, err := img.Stat(entry)
+	if err != nil {
+		respondISOError(c, err)
+		return iso.Entry{}, false
+	}
+	if e.Dir {
+		problem.Respond(c, http.StatusBadRequest, "Entry is a directory")
+		return iso.Entry{}, false
+	}
+	return e, true
+}
+
+func respondISOError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, iso.ErrNotFound):
+		problem.Respond(c, http.StatusNotFound, "Entry not found in the image")
+	case errors.Is(err, iso.ErrNotDir):
+		problem.Respond(c, http.StatusBadRequest, "Entry is not a directory")
+	default:
+		problem.Respond(c, http.StatusUnprocessableEntity, "Failed to read image: "+err.Error())
+	}
+}
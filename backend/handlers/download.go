@@ -2,147 +2,203 @@ package handlers
 
 import (
 	"archive/zip"
-	"fmt"
-	"io"
+	"context"
+	"io/fs"
+	"mime"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"nextbrowse-backend/problem"
 	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
 )
 
 type DownloadMultipleRequest struct {
 	Files []string `json:"files"`
+	Async bool     `json:"async,omitempty"` // build the archive in a job, to download with ranges
 }
 
 func DownloadFile(c *gin.Context) {
 	userPath := c.Query("path")
 	if userPath == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing path parameter",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
 		return
 	}
 
 	// Safely resolve path
-	safePath, err := utils.SafeResolve(userPath)
+	fsys, safePath, err := utils.ResolveFS(userPath)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Check if file exists
-	if !utils.FileExists(safePath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "File not found",
-		})
+	fileInfo, err := fsys.Stat(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
 		return
 	}
 
-	// Check if it's a file (not directory)
-	if utils.IsDirectory(safePath) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Cannot download directory, use download-multiple for zipping",
-		})
+	// Directories are sent as a ZIP archive when asked for one, so a plain
+	// link can download a folder
+	if fileInfo.IsDir() {
+		if c.Query("format") != "zip" {
+			problem.Respond(c, http.StatusBadRequest, "Cannot download directory, add format=zip to download it as a ZIP archive")
+			return
+		}
+		name := zipEntryName(userPath)
+		showHidden, ok := parseShowHidden(c)
+		if !ok {
+			return
+		}
+		streamZip(c, name+".zip", []vfs.Filesystem{fsys}, []string{safePath}, []string{name}, showHidden)
 		return
 	}
 
-	// Get file info
-	fileInfo, err := os.Stat(safePath)
+	file, err := fsys.Open(safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to get file info",
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to open file")
 		return
 	}
+	defer file.Close()
 
 	// Set headers for file download
 	filename := filepath.Base(safePath)
-	c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	c.Header("Content-Disposition", attachment(filename))
 	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Length", fmt.Sprintf("%d", fileInfo.Size()))
 
-	// Stream file to client
-	c.File(safePath)
+	// Stream file to client (ServeContent sets Content-Length and handles ranges)
+	http.ServeContent(c.Writer, c.Request, filename, fileInfo.ModTime(), file)
 }
 
 func DownloadMultiple(c *gin.Context) {
 	var req DownloadMultipleRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if len(req.Files) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "No files specified",
-		})
+		problem.Respond(c, http.StatusBadRequest, "No files specified")
+		return
+	}
+	showHidden, ok := parseShowHidden(c)
+	if !ok {
 		return
 	}
 
 	// Validate all paths first
 	var validPaths []string
+	var names []string
+	var filesystems []vfs.Filesystem
 	for _, userPath := range req.Files {
-		safePath, err := utils.SafeResolve(userPath)
+		fsys, safePath, err := utils.ResolveFS(userPath)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"ok":    false,
-				"error": "Invalid path: " + userPath + " - " + err.Error(),
-			})
+			problem.Respond(c, http.StatusBadRequest, "Invalid path: "+userPath+" - "+err.Error())
 			return
 		}
 
-		if !utils.FileExists(safePath) {
-			c.JSON(http.StatusNotFound, gin.H{
-				"ok":    false,
-				"error": "File not found: " + userPath,
-			})
+		if _, err := fsys.Stat(safePath); err != nil {
+			problem.Respond(c, http.StatusNotFound, "File not found: "+userPath)
 			return
 		}
 
 		validPaths = append(validPaths, safePath)
+		names = append(names, zipEntryName(userPath))
+		filesystems = append(filesystems, fsys)
 	}
 
+	if req.Async {
+		job, err := startArchiveJob(strings.Join(req.Files, ", "), filesystems, validPaths, names, showHidden)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+			return
+		}
+		c.JSON(http.StatusAccepted, OperationResponse{
+			OK:      true,
+			Message: "Archive started",
+			JobID:   job.ID,
+		})
+		return
+	}
+
+	streamZip(c, "files.zip", filesystems, validPaths, names, showHidden)
+}
+
+// streamZip sends the given files and directories as a ZIP archive named
+// filename, each stored under its name. What HIDDEN_PATTERNS excludes is left
+// out of directories unless showHidden.
+func streamZip(c *gin.Context, filename string, filesystems []vfs.Filesystem, paths, names []string, showHidden bool) {
 	// Set headers for ZIP download
-	c.Header("Content-Disposition", "attachment; filename=\"files.zip\"")
+	c.Header("Content-Disposition", attachment(filename))
 	c.Header("Content-Type", "application/zip")
 
 	// Create ZIP writer that writes directly to response
-	zipWriter := zip.NewWriter(c.Writer)
+	zipWriter := newZipWriter(c.Writer)
 	defer zipWriter.Close()
 
-	// Add each file/directory to ZIP
-	for i, safePath := range validPaths {
-		userPath := req.Files[i]
-		err := addToZip(zipWriter, safePath, filepath.Base(userPath))
-		if err != nil {
-			// Can't return JSON error here since we've already started streaming
-			// Just log the error and continue
-			continue
+	// Walk the files and directories in the background, compressing small
+	// files ahead while the ones before them are written, stopping once the
+	// client is gone
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	pipeline := newZipPipeline(ctx)
+	go addAllToZip(ctx, pipeline, filesystems, paths, names, showHidden)
+	pipeline.write(zipWriter)
+}
+
+// addAllToZip queues the given files and directories on pipeline and closes
+// it. Paths that can't be read are left out, since the archive is already
+// under way.
+func addAllToZip(ctx context.Context, pipeline *zipPipeline, filesystems []vfs.Filesystem, paths, names []string, showHidden bool) {
+	defer close(pipeline.entries)
+	for i, safePath := range paths {
+		_ = addToZip(ctx, pipeline, filesystems[i], safePath, names[i], showHidden)
+		if ctx.Err() != nil {
+			return
 		}
 	}
 }
 
-// Helper function to add files/directories to ZIP archive
-func addToZip(zw *zip.Writer, sourcePath, basePath string) error {
-	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+// zipEntryName is the name a downloaded path gets inside a ZIP archive; the
+// root directory is stored as "files"
+func zipEntryName(userPath string) string {
+	name := path.Base(path.Clean("/" + strings.TrimPrefix(userPath, "/")))
+	if name == "/" {
+		return "files"
+	}
+	return name
+}
+
+// attachment is a Content-Disposition value that makes browsers save the
+// response as filename, which may contain any characters
+func attachment(filename string) string {
+	if value := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return "attachment"
+}
+
+// addToZip queues the file or directory tree at sourcePath for a ZIP
+// archive, stored under basePath
+func addToZip(ctx context.Context, pipeline *zipPipeline, fsys vfs.Filesystem, sourcePath, basePath string, showHidden bool) error {
+	return vfs.WalkDir(fsys, sourcePath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path != sourcePath && !showHidden && utils.Excluded(d.Name()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
 
 		// Create ZIP entry path
 		relPath, err := filepath.Rel(sourcePath, path)
@@ -154,30 +210,35 @@ func addToZip(zw *zip.Writer, sourcePath, basePath string) error {
 		// Convert to forward slashes for ZIP compatibility
 		zipPath = strings.ReplaceAll(zipPath, "\\", "/")
 
-		if info.IsDir() {
+		if d.IsDir() {
 			// Create directory entry (with trailing slash)
 			if !strings.HasSuffix(zipPath, "/") {
 				zipPath += "/"
 			}
-			_, err := zw.Create(zipPath)
-			return err
+			return pipeline.add(&zipEntry{header: &zip.FileHeader{Name: zipPath}, dir: true})
 		}
 
-		// Create file entry
-		zipFile, err := zw.Create(zipPath)
+		// Walks don't follow symlinks, but opening one would. Only a link to a
+		// regular file inside the root is followed, and only under the follow
+		// policy; special files are left out.
+		info, err := d.Info()
 		if err != nil {
-			return err
+			return nil
 		}
-
-		// Open source file
-		srcFile, err := os.Open(path)
-		if err != nil {
-			return err
+		if !d.Type().IsRegular() {
+			if d.Type()&fs.ModeSymlink == 0 || !vfs.IsLocal(fsys) || utils.CheckRealPath(path) != nil {
+				return nil
+			}
+			if info, err = fsys.Stat(path); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
 		}
-		defer srcFile.Close()
 
-		// Copy file content
-		_, err = io.Copy(zipFile, srcFile)
-		return err
+		return pipeline.add(&zipEntry{
+			fsys:   fsys,
+			path:   path,
+			size:   info.Size(),
+			header: &zip.FileHeader{Name: zipPath, Method: zip.Deflate, Modified: info.ModTime()},
+		})
 	})
 }
\ No newline at end of file
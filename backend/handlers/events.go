@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/vfs"
+)
+
+// eventHeartbeat keeps idle event streams from being closed by proxies
+const eventHeartbeat = 30 * time.Second
+
+// StreamEvents streams changes to the tree as server-sent events. ?path= (may
+// be repeated) limits them to what happens on, below or above those paths,
+// and ?type= (comma-separated, "job" for all job events) to some types. A
+// client reconnecting with Last-Event-ID first gets the events it missed, or
+// a "resync" event when they are no longer known and it should reload.
+func StreamEvents(c *gin.Context) {
+	filter := events.Filter{Paths: c.QueryArray("path")}
+	for _, types := range c.QueryArray("type") {
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types = append(filter.Types, t)
+			}
+		}
+	}
+
+	after, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+	sub, missed, complete := events.Subscribe(filter, after)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx hold events back
+	c.Status(http.StatusOK)
+
+	if !complete {
+		fmt.Fprint(c.Writer, "event: resync\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		writeEvent(c, e)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// Fell behind; the client reconnects and catches up
+				return
+			}
+			writeEvent(c, e)
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeEvent(c *gin.Context, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// publishChange announces a change the client at actor made to the entry
+// name on fsys
+func publishChange(actor, eventType string, fsys vfs.Filesystem, name string, data any) {
+	events.Publish(events.Event{Type: eventType, Path: displayPath(fsys, name), Actor: actor, Data: data})
+}
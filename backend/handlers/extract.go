@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// ExtractReport is the result of an extract job
+type ExtractReport struct {
+	Files       int64 `json:"files"`
+	Directories int64 `json:"directories"`
+	Skipped     int64 `json:"skipped"` // left alone by the conflict policy
+	Bytes       int64 `json:"bytes"`
+}
+
+// startExtractJob unpacks a finished upload of a ZIP into its destination
+// directory in an extract job, holding a write lock on the directory until
+// it is done. The ZIP is removed afterwards, whether or not it could be
+// extracted.
+func startExtractJob(upload *TusUpload, actor string) (*models.Job, error) {
+	_, dir, err := utils.ResolveFS(upload.Path)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := utils.TryLockPaths(utils.WriteLock(dir))
+	if err != nil {
+		return nil, err
+	}
+	job, err := models.NewJob("extract", upload.Filename, upload.Path)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	go func() {
+		defer unlock()
+		defer func() {
+			_ = os.Remove(upload.FilePath)
+			_ = os.Remove(filepath.Dir(upload.FilePath)) // Will only succeed if empty
+		}()
+		defer invalidateListing(dir)
+
+		report := &ExtractReport{}
+		job.SetResult(report)
+		err := extractZip(job, upload.FilePath, dir, upload.Path, upload.Conflict, report)
+		forgetQuotaUsages(events.Event{Path: upload.Path})
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.Finish(models.JobCancelled, "Extraction cancelled")
+		case err != nil:
+			job.Finish(models.JobFailed, err.Error())
+		case len(job.Errors()) > 0:
+			job.Finish(models.JobFailed, fmt.Sprintf("Extracted with %d errors", len(job.Errors())))
+		default:
+			job.Finish(models.JobCompleted, fmt.Sprintf("Extracted %d files", report.Files))
+		}
+		if report.Files > 0 || report.Directories > 0 {
+			publishChange(actor, events.UploadCompleted, vfs.OS, dir, gin.H{
+				"archive":   upload.Filename,
+				"extracted": report.Files,
+				"jobId":     job.ID,
+			})
+		}
+	}()
+	return job, nil
+}
+
+// extractZip unpacks the archive at zipPath into the local directory dir,
+// shown to users as userDir. Entries whose names would leave dir, and links,
+// are refused; files are written through the symlink policy's checks and
+// never beyond the sizes the archive declares for them.
+func extractZip(job *models.Job, zipPath, dir, userDir, conflict string, report *ExtractReport) error {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return fmt.Errorf("not a readable ZIP archive: %w", err)
+	}
+	defer archive.Close()
+
+	var files, bytes int64
+	for _, f := range archive.File {
+		if !f.FileInfo().IsDir() {
+			files++
+			bytes += int64(f.UncompressedSize64)
+		}
+	}
+	job.AddTotals(files, bytes)
+	if err := reserveQuotas(quotasOver(userDir), bytes, files); err != nil {
+		return err
+	}
+
+	for _, f := range archive.File {
+		if err := job.Context().Err(); err != nil {
+			return err
+		}
+		name, err := zipEntryPath(f.Name)
+		if err != nil {
+			job.AddError(f.Name, err)
+			continue
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		job.SetCurrentFile(path.Join(userDir, name))
+
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := extractDir(dir, target); err != nil {
+				job.AddError(name, err)
+				continue
+			}
+			report.Directories++
+		case mode.IsRegular():
+			written, skipped, err := extractFile(job, f, dir, target, conflict)
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				job.AddError(name, err)
+			} else if skipped {
+				report.Skipped++
+			} else {
+				report.Files++
+				report.Bytes += written
+			}
+			job.ItemDone()
+		default:
+			job.AddError(name, errors.New("links and special files are not extracted"))
+		}
+	}
+	return nil
+}
+
+// zipEntryPath cleans the name of an archive entry into a relative slash
+// path, refusing names that are absolute or climb out of the directory
+func zipEntryPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" {
+		return "", errors.New("absolute path in archive")
+	}
+	clean := path.Clean(name)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("path escapes the destination")
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if err := utils.ValidateFileName(part); err != nil {
+			return "", err
+		}
+	}
+	return clean, nil
+}
+
+// extractDir creates a directory of the archive, and those above it, inside
+// root
+func extractDir(root, target string) error {
+	if err := utils.CheckRealPath(filepath.Dir(target)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	return utils.CheckRealPath(target)
+}
+
+// extractFile writes a file of the archive to target under the conflict
+// policy, reporting the bytes written or that it was skipped
+func extractFile(job *models.Job, f *zip.File, root, target, conflict string) (written int64, skipped bool, err error) {
+	if err := extractDir(root, filepath.Dir(target)); err != nil {
+		return 0, false, err
+	}
+	if err := utils.CheckRealPath(target); err != nil {
+		return 0, false, err
+	}
+	target, action, kept, err := resolveConflict(target, f.FileInfo(), conflict)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			err = errors.New("already exists")
+		}
+		return 0, false, err
+	}
+	if action == actionSkip {
+		return 0, true, nil
+	}
+	defer func() { kept.settle(err) }()
+
+	src, err := f.Open()
+	if err != nil {
+		return 0, false, err
+	}
+	defer src.Close()
+	file, err := vfs.CreateAtomic(target, 0644)
+	if err != nil {
+		return 0, false, err
+	}
+	// An entry may inflate to more than it declares; no more is taken
+	limit := int64(f.UncompressedSize64)
+	written, err = buffers.Copy(file, &progressReader{ctx: job.Context(), job: job, r: io.LimitReader(src, limit+1)})
+	if err == nil && written > limit {
+		err = errors.New("entry is larger than the archive declares")
+	}
+	if err != nil {
+		file.Abort()
+		return 0, false, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, false, err
+	}
+	if !f.Modified.IsZero() {
+		_ = os.Chtimes(target, f.Modified, f.Modified)
+	}
+	return written, false, nil
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jellydator/ttlcache/v3"
+
+	"nextbrowse-backend/photo"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// galleryWorkers is how many images a gallery reads the headers of at once
+const galleryWorkers = 8
+
+// photoCache maps image paths to what was read of them, so that a gallery
+// only reads the images that changed since it was last shown
+var photoCache = newPhotoCache()
+
+func newPhotoCache() *ttlcache.Cache[string, *photoCacheEntry] {
+	cache := ttlcache.New[string, *photoCacheEntry](
+		ttlcache.WithTTL[string, *photoCacheEntry](time.Hour),
+		ttlcache.WithCapacity[string, *photoCacheEntry](200000),
+	)
+	go cache.Start()
+	return cache
+}
+
+type photoCacheEntry struct {
+	fingerprint string
+	info        photo.Info
+	err         error
+}
+
+// GalleryItem is an image of a directory. Its width and height are as it is
+// displayed, and taken is when it was taken according to its EXIF data.
+type GalleryItem struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	MTime     int64  `json:"mtime"`
+	Taken     *int64 `json:"taken,omitempty"`
+	Width     int    `json:"width,omitempty"`  // unset when the image can't be read
+	Height    int    `json:"height,omitempty"` // unset when the image can't be read
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// GalleryResponse is a page of the images of a directory
+type GalleryResponse struct {
+	OK         bool          `json:"ok"`
+	Path       string        `json:"path"`
+	Items      []GalleryItem `json:"items"`
+	Pagination *Pagination   `json:"pagination"`
+}
+
+// ListGallery returns the JPEG, PNG and GIF images of the directory at ?path=
+// with their dimensions, capture dates and thumbnail URLs, in the order they
+// were taken (?order=desc for the newest first), a page at a time. Images
+// without a capture date are placed by their modification time. The headers
+// of all the images are read to sort them, several at once, and remembered
+// until the images change.
+func ListGallery(c *gin.Context) {
+	userPath := c.DefaultQuery("path", "/")
+	size, ok := thumbnailSize(c)
+	if !ok {
+		return
+	}
+	descending := c.Query("order") == "desc"
+
+	fsys, safePath, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	dirInfo, err := fsys.Stat(safePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Directory not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat directory: "+err.Error())
+		}
+		return
+	}
+	if !dirInfo.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a directory")
+		return
+	}
+
+	var items []FileItem
+	cached := false
+	if vfs.IsLocal(fsys) {
+		items, cached = getCachedListing(safePath, dirInfo)
+	}
+	if !cached {
+		if items, err = readDirectoryItems(fsys, safePath, userPath, false, true); err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to read directory: "+err.Error())
+			return
+		}
+		if vfs.IsLocal(fsys) {
+			setCachedListing(safePath, dirInfo, items)
+		}
+	}
+
+	var images []GalleryItem
+	for _, item := range items {
+		if item.Type != "file" || !photo.IsImage(item.Name) {
+			continue
+		}
+		itemPath := path.Join(userPath, item.Name)
+		images = append(images, GalleryItem{
+			Name:      item.Name,
+			Path:      itemPath,
+			Size:      *item.Size,
+			MTime:     item.MTime,
+			URL:       *item.URL,
+			Thumbnail: thumbnailURL(itemPath, item.MTime, size),
+		})
+	}
+	if err := readPhotoInfo(c.Request.Context(), fsys, safePath, images); err != nil {
+		return
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		ti, tj := galleryTime(images[i]), galleryTime(images[j])
+		if ti != tj {
+			return ti < tj != descending
+		}
+		return images[i].Name < images[j].Name
+	})
+
+	pageReq, _ := parsePageRequest(c)
+	response := GalleryResponse{OK: true, Path: userPath}
+	response.Items, response.Pagination = paginate(images, pageReq)
+	c.JSON(http.StatusOK, response)
+}
+
+// galleryTime is when an image was taken, or else modified
+func galleryTime(item GalleryItem) int64 {
+	if item.Taken != nil {
+		return *item.Taken
+	}
+	return item.MTime
+}
+
+// readPhotoInfo fills in the dimensions and capture dates of the images of a
+// directory, reading those that aren't cached several at once. Images that
+// can't be read are left as they are; only the request ending stops it.
+func readPhotoInfo(ctx context.Context, fsys vfs.Filesystem, dir string, images []GalleryItem) error {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(galleryWorkers, len(images)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				info, err := cachedPhotoInfo(fsys, filepath.Join(dir, images[i].Name), images[i])
+				if err != nil {
+					continue
+				}
+				images[i].Width, images[i].Height = info.Width, info.Height
+				if !info.Taken.IsZero() {
+					taken := info.Taken.UnixMilli()
+					images[i].Taken = &taken
+				}
+			}
+		}()
+	}
+
+	var err error
+	for i := range images {
+		select {
+		case work <- i:
+			continue
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		break
+	}
+	close(work)
+	wg.Wait()
+	return err
+}
+
+// cachedPhotoInfo reads the header of an image unless it is cached for the
+// image's current modification time and size
+func cachedPhotoInfo(fsys vfs.Filesystem, name string, item GalleryItem) (photo.Info, error) {
+	key := displayPath(fsys, name)
+	fingerprint := fmt.Sprintf("%d:%d", item.MTime, item.Size)
+	if cached := photoCache.Get(key); cached != nil && cached.Value().fingerprint == fingerprint {
+		return cached.Value().info, cached.Value().err
+	}
+
+	entry := &photoCacheEntry{fingerprint: fingerprint}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return photo.Info{}, err
+	}
+	entry.info, entry.err = photo.Read(file)
+	file.Close()
+	photoCache.Set(key, entry, ttlcache.DefaultTTL)
+	return entry.info, entry.err
+}
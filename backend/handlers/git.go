@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/gitrepo"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+var errNoRepository = errors.New("not inside a git repository")
+
+// GitResponse is the state of the git working copy holding a path
+type GitResponse struct {
+	OK         bool        `json:"ok"`
+	Root       string      `json:"root"`             // the working copy's directory
+	Branch     string      `json:"branch,omitempty"` // empty when HEAD is detached
+	Commit     string      `json:"commit,omitempty"` // empty before the first commit
+	Upstream   string      `json:"upstream,omitempty"`
+	Ahead      int         `json:"ahead"`
+	Behind     int         `json:"behind"`
+	Dirty      bool        `json:"dirty"` // anything at or below path differs
+	Files      []GitFile   `json:"files"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// GitFile is a path that differs from the commit checked out
+type GitFile struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"` // what a renamed file was
+	Status   string `json:"status"`             // modified, added, deleted, renamed, copied, untracked or conflicted
+	Staged   bool   `json:"staged,omitempty"`
+	Dir      bool   `json:"dir,omitempty"` // an untracked directory, listed as a whole
+}
+
+// GitSummary is the working copy a listed directory is in
+type GitSummary struct {
+	Root   string `json:"root"`
+	Branch string `json:"branch,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Dirty  bool   `json:"dirty"`
+}
+
+// GitStatus returns the branch of the git working copy holding ?path= and
+// the files at or below path that differ from it. Only local directories
+// are looked at.
+func GitStatus(c *gin.Context) {
+	fsys, name, err := utils.ResolveFS(c.DefaultQuery("path", "/"))
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	if !vfs.IsLocal(fsys) {
+		problem.Respond(c, http.StatusBadRequest, "Git status is only available on local storage")
+		return
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Path not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat path: "+err.Error())
+		}
+		return
+	}
+
+	root, status, err := readGitStatus(c.Request.Context(), name, info.IsDir())
+	switch {
+	case errors.Is(err, errNoRepository):
+		problem.Respond(c, http.StatusNotFound, "Not inside a git repository")
+		return
+	case errors.Is(err, gitrepo.ErrNotInstalled):
+		problem.Respond(c, http.StatusNotImplemented, "git is not installed on the server")
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		problem.Respond(c, http.StatusGatewayTimeout, "git took longer than GIT_TIMEOUT")
+		return
+	case err != nil:
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read git status: "+err.Error())
+		return
+	}
+
+	rootPath := utils.ToUserPath(root)
+	files := make([]GitFile, 0, len(status.Files))
+	for _, f := range status.Files {
+		file := GitFile{
+			Path:   path.Join(rootPath, f.Path),
+			Status: f.Status,
+			Staged: f.Staged,
+			Dir:    strings.HasSuffix(f.Path, "/"),
+		}
+		if f.OrigPath != "" {
+			file.OrigPath = path.Join(rootPath, f.OrigPath)
+		}
+		files = append(files, file)
+	}
+
+	response := GitResponse{
+		OK:       true,
+		Root:     rootPath,
+		Branch:   status.Branch,
+		Commit:   status.Commit,
+		Upstream: status.Upstream,
+		Ahead:    status.Ahead,
+		Behind:   status.Behind,
+		Dirty:    len(files) > 0,
+		Files:    files,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response.Files, response.Pagination = paginate(files, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// addGitMarkers returns a copy of the items listed from the local directory
+// dir with their git status set, and the working copy they are in. Items
+// are returned as they are when dir isn't in one or git fails.
+func addGitMarkers(ctx context.Context, dir string, items []FileItem) ([]FileItem, *GitSummary) {
+	root, status, err := readGitStatus(ctx, dir, true)
+	if err != nil {
+		return items, nil
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return items, nil
+	}
+
+	marker := status.Markers()
+	marked := make([]FileItem, len(items))
+	copy(marked, items)
+	for i := range marked {
+		marked[i].Git = marker(path.Join(filepath.ToSlash(rel), marked[i].Name))
+	}
+	return marked, &GitSummary{
+		Root:   utils.ToUserPath(root),
+		Branch: status.Branch,
+		Commit: status.Commit,
+		Dirty:  len(status.Files) > 0,
+	}
+}
+
+// readGitStatus finds the working copy holding the local path name and
+// reads the status of what is at or below name
+func readGitStatus(ctx context.Context, name string, isDir bool) (string, *gitrepo.Status, error) {
+	top, err := filepath.Abs(config.RootDir)
+	if err != nil {
+		return "", nil, err
+	}
+	dir := name
+	if !isDir {
+		dir = filepath.Dir(name)
+	}
+	root, ok := gitrepo.Find(dir, top)
+	if !ok {
+		return "", nil, errNoRepository
+	}
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if rel == "." {
+		rel = ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.GitTimeout)
+	defer cancel()
+	status, err := gitrepo.Read(ctx, root, filepath.ToSlash(rel))
+	return root, status, err
+}
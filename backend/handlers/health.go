@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/alert"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/vfs"
+)
+
+// Health statuses
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthError    = "error"
+)
+
+// errProbeTimeout is reported for a backend that didn't answer in time
+var errProbeTimeout = errors.New("timed out")
+
+// BackendCheck is the result of probing one storage backend
+type BackendCheck struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Reachable bool   `json:"reachable"`
+	Writable  bool   `json:"writable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthResponse reports the server's status and each backend's check,
+// keyed by "root" for the local disk and by mount path for mounts
+type HealthResponse struct {
+	Status      string                  `json:"status"`
+	Maintenance bool                    `json:"maintenance,omitempty"`
+	Checks      map[string]BackendCheck `json:"checks"`
+	CheckedAt   int64                   `json:"checkedAt"`
+}
+
+// Probe results are cached so frequent health polls don't write to every
+// backend each time
+var (
+	healthCache      *HealthResponse
+	healthCacheUntil time.Time
+	healthMutex      sync.Mutex
+
+	// Backends whose last probe failed, to alert only on changes
+	failingBackends = make(map[string]bool)
+)
+
+// HealthCheck probes the local root and every mounted backend. It answers
+// 503 when the root is unusable and reports "degraded" while the root works
+// but a mount doesn't.
+func HealthCheck(c *gin.Context) {
+	healthMutex.Lock()
+	if healthCache == nil || time.Now().After(healthCacheUntil) {
+		healthCache = runHealthChecks()
+		alertHealthChanges(healthCache)
+		healthCacheUntil = time.Now().Add(config.HealthCheckTTL)
+	}
+	resp := *healthCache
+	healthMutex.Unlock()
+	resp.Maintenance = models.GetMaintenance().Enabled
+
+	code := http.StatusOK
+	if resp.Checks["root"].Status != healthOK {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, resp)
+}
+
+// runHealthChecks probes all backends concurrently
+func runHealthChecks() *HealthResponse {
+	targets := map[string]vfs.Filesystem{"root": vfs.OS}
+	names := map[string]string{"root": config.RootDir}
+	for mountPath, fsys := range vfs.Mounts() {
+		targets[mountPath] = fsys
+		names[mountPath] = mountPath
+	}
+
+	resp := &HealthResponse{
+		Status:    healthOK,
+		Checks:    make(map[string]BackendCheck, len(targets)),
+		CheckedAt: time.Now().UnixMilli(),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for key, fsys := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			check := ProbeBackend(fsys, names[key])
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[key] = check
+			switch {
+			case check.Status == healthOK:
+			case key == "root":
+				resp.Status = healthError
+			case resp.Status == healthOK:
+				resp.Status = healthDegraded
+			}
+		}()
+	}
+	wg.Wait()
+	return resp
+}
+
+// alertHealthChanges sends an alert for each backend that started failing
+// its probe and for each one that recovered. Called with healthMutex held.
+func alertHealthChanges(resp *HealthResponse) {
+	for key, check := range resp.Checks {
+		failing := check.Status != healthOK
+		if failing == failingBackends[key] {
+			continue
+		}
+		failingBackends[key] = failing
+
+		if failing {
+			alert.Send(alert.Alert{
+				Kind:    alert.KindHealth,
+				Key:     key,
+				Title:   "Storage health check failed",
+				Message: key + ": " + check.Error,
+			})
+		} else {
+			alert.Resolved(alert.KindHealth, key)
+			alert.Send(alert.Alert{
+				Kind:    alert.KindRecovered,
+				Key:     key,
+				Title:   "Storage health check recovered",
+				Message: key + " is reachable and writable again",
+			})
+		}
+	}
+}
+
+// ProbeBackend stats the backend's root and writes and removes a probe file
+// there, giving up after HEALTH_CHECK_TIMEOUT. Backends don't take a
+// context, so a hung probe is left to finish in the background.
+func ProbeBackend(fsys vfs.Filesystem, root string) BackendCheck {
+	done := make(chan BackendCheck, 1)
+	start := time.Now()
+
+	go func() {
+		check := BackendCheck{Status: healthError}
+		if _, err := fsys.Stat(root); err != nil {
+			check.Error = err.Error()
+			done <- check
+			return
+		}
+		check.Reachable = true
+
+		if err := writeProbe(fsys, root); err != nil {
+			check.Error = "not writable: " + err.Error()
+			done <- check
+			return
+		}
+		check.Writable = true
+		check.Status = healthOK
+		done <- check
+	}()
+
+	var check BackendCheck
+	select {
+	case check = <-done:
+	case <-time.After(config.HealthCheckTimeout):
+		check = BackendCheck{Status: healthError, Error: errProbeTimeout.Error()}
+	}
+	check.LatencyMs = time.Since(start).Milliseconds()
+	return check
+}
+
+// writeProbe creates and deletes a uniquely named hidden file in dir
+func writeProbe(fsys vfs.Filesystem, dir string) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, ".nextbrowse-health-"+hex.EncodeToString(suffix))
+
+	if err := vfs.WriteFrom(fsys, name, strings.NewReader("ok"), 2); err != nil {
+		return err
+	}
+	return fsys.Remove(name)
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/vfs"
+)
+
+// integrityBatch is how many checksums an integrity run saves at a time
+const integrityBatch = 1000
+
+// IntegrityFinding is the data of the integrity events
+type IntegrityFinding struct {
+	Task     string `json:"task"`
+	Size     int64  `json:"size,omitempty"`
+	Expected string `json:"expected,omitempty"` // SHA-256 of the last run
+	Actual   string `json:"actual,omitempty"`   // SHA-256 of this run
+	Verified int64  `json:"verified,omitempty"` // when the contents last matched
+}
+
+// checkIntegrity hashes the regular files below dir and compares them with
+// the checksums kept from the task's earlier runs. Files that appeared,
+// changed or went missing are reported and their checksums updated; a file
+// whose contents changed while its size and modification time did not is
+// reported as corrupted and keeps its old checksum, so that every run reports
+// it until it is restored or rewritten. The first run only records checksums.
+func checkIntegrity(ctx context.Context, task string, fsys vfs.Filesystem, dir string) (string, error) {
+	if !metadata.Available() {
+		return "", metadata.ErrUnavailable
+	}
+
+	known := make(map[string]metadata.Checksum)
+	err := metadata.Checksums(displayPath(fsys, dir), func(p string, sum metadata.Checksum) error {
+		known[p] = sum
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	firstRun := len(known) == 0
+
+	report := func(eventType, p string, finding IntegrityFinding) {
+		if !firstRun {
+			finding.Task = task
+			events.Publish(events.Event{Type: eventType, Path: p, Data: finding})
+		}
+	}
+
+	var checked, added, changed, corrupted int
+	var unreadable []string // directories whose files can't be told missing
+	var errs []error
+	update := make(map[string]metadata.Checksum)
+
+	err = vfs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if name == dir {
+				return err
+			}
+			unreadable = append(unreadable, metadata.Clean(displayPath(fsys, name)))
+			errs = append(errs, err)
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(name, vfs.AtomicSuffix) {
+			return nil
+		}
+		p := metadata.Clean(displayPath(fsys, name))
+		base, exists := known[p]
+		delete(known, p)
+
+		current, err := hashForIntegrity(ctx, fsys, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, err)
+			return nil
+		}
+		if current == nil {
+			return nil // being written; left for the next run
+		}
+		checked++
+
+		switch {
+		case !exists:
+			added++
+			report(events.IntegrityNew, p, IntegrityFinding{Size: current.Size, Actual: current.SHA256})
+		case base.Size != current.Size || base.MTime != current.MTime:
+			changed++
+			report(events.IntegrityChanged, p, IntegrityFinding{Size: current.Size, Expected: base.SHA256, Actual: current.SHA256})
+		case base.SHA256 != current.SHA256:
+			corrupted++
+			report(events.IntegrityCorrupted, p, IntegrityFinding{Size: current.Size, Expected: base.SHA256, Actual: current.SHA256, Verified: base.Verified})
+			return nil
+		}
+		update[p] = *current
+		if len(update) == integrityBatch {
+			if err := metadata.UpdateChecksums(update, nil); err != nil {
+				return err
+			}
+			clear(update)
+		}
+		return nil
+	})
+	if err != nil {
+		// Keep what was hashed, but without a full walk nothing is missing
+		_ = metadata.UpdateChecksums(update, nil)
+		return "", err
+	}
+
+	var missing []string
+	for p, base := range known {
+		if underAny(unreadable, p) {
+			continue
+		}
+		missing = append(missing, p)
+		report(events.IntegrityMissing, p, IntegrityFinding{Size: base.Size, Expected: base.SHA256, Verified: base.Verified})
+	}
+	if err := metadata.UpdateChecksums(update, missing); err != nil {
+		return "", err
+	}
+
+	summary := fmt.Sprintf("Checked %d files: %d new, %d changed, %d missing, %d corrupted",
+		checked, added, changed, len(missing), corrupted)
+	if firstRun {
+		summary = fmt.Sprintf("Recorded checksums of %d files", checked)
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("%s; %d failed: %w", summary, len(errs), errors.Join(errs...))
+	}
+	if corrupted > 0 {
+		return "", errors.New(summary)
+	}
+	return summary, nil
+}
+
+// hashForIntegrity returns the checksum of a file, or nil when the file
+// changed while it was read
+func hashForIntegrity(ctx context.Context, fsys vfs.Filesystem, name string) (*metadata.Checksum, error) {
+	before, err := fsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := backgroundChecksum(ctx, fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	after, err := fsys.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return nil, nil
+	}
+	return &metadata.Checksum{
+		SHA256:   hex.EncodeToString(sum),
+		Size:     before.Size(),
+		MTime:    before.ModTime().UnixNano(),
+		Verified: time.Now().UnixMilli(),
+	}, nil
+}
+
+// backgroundChecksum is checksumFile as background work, within
+// BACKGROUND_HOURS and BACKGROUND_IO_RATE
+func backgroundChecksum(ctx context.Context, fsys vfs.Filesystem, name string) ([]byte, error) {
+	release, err := background.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := buffers.Copy(hash, background.reader(ctx, file)); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// underAny reports whether p is on or below any of dirs
+func underAny(dirs []string, p string) bool {
+	for _, dir := range dirs {
+		if p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+)
+
+// ListJobs returns all running and recently finished jobs, newest first
+func ListJobs(c *gin.Context) {
+	jobs := models.GetAllJobs()
+	infos := make([]*models.JobInfo, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, job.Info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt > infos[j].CreatedAt
+	})
+
+	response := gin.H{
+		"ok":   true,
+		"jobs": infos,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["jobs"], response["pagination"] = paginate(infos, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetJob returns the progress of a single job
+func GetJob(c *gin.Context) {
+	job, exists := models.GetJob(c.Param("id"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":  true,
+		"job": job.Info(),
+	})
+}
+
+// StreamJobEvents streams the progress of a single job as server-sent
+// events, for a transfer dialog: the job as it stands, then a job.progress
+// event with the current file, bytes done and throughput about once a second,
+// and the job.finished event, after which the stream ends. Each event's data
+// is the job. A client reconnecting starts over from the job as it stands.
+func StreamJobEvents(c *gin.Context) {
+	id := c.Param("id")
+	// Subscribed first, so that nothing between the job's state and the
+	// stream is missed
+	sub, _, _ := events.Subscribe(events.Filter{Types: []string{"job"}}, 0)
+	defer sub.Close()
+
+	job, exists := models.GetJob(id)
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx hold events back
+	c.Status(http.StatusOK)
+
+	info := job.Info()
+	if info.Status != models.JobRunning {
+		writeJobEvent(c, events.JobFinished, info)
+		c.Writer.Flush()
+		return
+	}
+	writeJobEvent(c, events.JobProgress, info)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				// Fell behind; the client reconnects and starts over
+				return
+			}
+			info, ok := e.Data.(*models.JobInfo)
+			if !ok || info.ID != id {
+				continue
+			}
+			writeJobEvent(c, e.Type, info)
+			if e.Type == events.JobFinished {
+				c.Writer.Flush()
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeJobEvent(c *gin.Context, eventType string, info *models.JobInfo) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, data)
+}
+
+// CancelJob requests cancellation of a running job
+func CancelJob(c *gin.Context) {
+	job, exists := models.GetJob(c.Param("id"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Job not found")
+		return
+	}
+
+	job.Cancel()
+
+	c.JSON(http.StatusOK, OperationResponse{
+		OK:      true,
+		Message: "Cancellation requested",
+		JobID:   job.ID,
+	})
+}
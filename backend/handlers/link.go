@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+type LinkRequest struct {
+	Source      string `json:"source"`         // existing file or directory the link points to
+	Destination string `json:"destination"`    // path of the new link
+	Type        string `json:"type,omitempty"` // "symlink" (default) or "hard"
+}
+
+// CreateLink creates a symlink or hardlink inside the root
+func CreateLink(c *gin.Context) {
+	if !config.LinkCreationEnabled {
+		problem.Respond(c, http.StatusForbidden, "Link creation is disabled")
+		return
+	}
+
+	var req LinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Source == "" || req.Destination == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing source or destination")
+		return
+	}
+
+	if req.Type == "" {
+		req.Type = "symlink"
+	}
+	if req.Type != "symlink" && req.Type != "hard" {
+		problem.Respond(c, http.StatusBadRequest, "Invalid link type: "+req.Type)
+		return
+	}
+
+	// Symlinks would be unusable (or hidden) under the show/hide policies
+	if req.Type == "symlink" && config.SymlinkPolicy != config.SymlinkFollow {
+		problem.Respond(c, http.StatusForbidden, "Symlinks are not followed by this server")
+		return
+	}
+
+	// Safely resolve both ends
+	srcPath, err := utils.SafeResolve(req.Source)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid source path: "+err.Error())
+		return
+	}
+
+	dstPath, err := utils.SafeResolveLink(req.Destination)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+	if err := utils.ValidateNewPath(req.Destination); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination name: "+err.Error())
+		return
+	}
+
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "Source file or directory not found")
+		return
+	}
+
+	if _, err := os.Lstat(dstPath); err == nil {
+		problem.Respond(c, http.StatusConflict, "Destination already exists")
+		return
+	}
+
+	if !utils.IsDirectory(filepath.Dir(dstPath)) {
+		problem.Respond(c, http.StatusNotFound, "Destination directory not found")
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.ReadLock(srcPath), utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	if req.Type == "hard" {
+		if srcInfo.IsDir() {
+			problem.Respond(c, http.StatusBadRequest, "Hardlinks to directories are not supported")
+			return
+		}
+		err = os.Link(srcPath, dstPath)
+	} else {
+		err = os.Symlink(symlinkTarget(srcPath, dstPath), dstPath)
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create link: "+err.Error())
+		return
+	}
+
+	invalidateListing(dstPath)
+	publishChange(c.ClientIP(), events.LinkCreated, vfs.OS, dstPath, gin.H{"target": req.Source, "type": req.Type})
+
+	c.JSON(http.StatusOK, OperationResponse{
+		OK:      true,
+		Message: "Link created successfully",
+	})
+}
+
+// symlinkTarget returns what a symlink at dstPath stores to point to srcPath.
+// Relative targets keep working when the root is mounted elsewhere. They're
+// followed from the directory the link really is in, so they're worked out
+// between the real directories of both ends rather than the paths as given,
+// which may pass through symlinked directories.
+func symlinkTarget(srcPath, dstPath string) string {
+	srcDir, err := filepath.EvalSymlinks(filepath.Dir(srcPath))
+	if err != nil {
+		return srcPath
+	}
+	dstDir, err := filepath.EvalSymlinks(filepath.Dir(dstPath))
+	if err != nil {
+		return srcPath
+	}
+	target, err := filepath.Rel(dstDir, filepath.Join(srcDir, filepath.Base(srcPath)))
+	if err != nil {
+		return srcPath
+	}
+	return target
+}
@@ -1,116 +1,252 @@
 package handlers
 
 import (
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/problem"
 	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
 )
 
 type FileItem struct {
-	Name  string  `json:"name"`
-	Type  string  `json:"type"`
-	Size  *int64  `json:"size,omitempty"`
-	MTime int64   `json:"mtime"`
-	URL   *string `json:"url,omitempty"`
+	Name   string  `json:"name"`
+	Type   string  `json:"type"`
+	Size   *int64  `json:"size,omitempty"`
+	MTime  int64   `json:"mtime"`
+	URL    *string `json:"url,omitempty"`
+	Target *string `json:"target,omitempty"` // symlink target, if the entry is a symlink
+
+	// Number of visible children, only set for directories when requested
+	ItemCount       *int `json:"itemCount,omitempty"`
+	ItemCountCapped bool `json:"itemCountCapped,omitempty"`
+
+	Tags []string       `json:"tags,omitempty"`
+	Note *metadata.Note `json:"note,omitempty"` // only when notes are requested
+
+	// Status in the git working copy the directory is in, when requested
+	Git string `json:"git,omitempty"`
 }
 
+// maxItemCount caps how many children are counted per directory so that
+// huge directories don't slow down the listing of their parent
+const maxItemCount = 1000
+
 type ListResponse struct {
-	OK         bool                   `json:"ok"`
-	Path       string                 `json:"path"`
-	Items      []FileItem             `json:"items"`
-	Pagination map[string]interface{} `json:"pagination,omitempty"`
+	OK         bool        `json:"ok"`
+	Path       string      `json:"path"`
+	Items      []FileItem  `json:"items"`
+	Git        *GitSummary `json:"git,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
 }
 
 func ListDirectory(c *gin.Context) {
 	userPath := c.DefaultQuery("path", "/")
+	withItemCounts := c.Query("itemCounts") == "true"
+	showHidden, ok := parseShowHidden(c)
+	if !ok {
+		return
+	}
+	fields, ok := parseListFields(c)
+	if !ok {
+		return
+	}
 	
-	// Parse pagination parameters
-	pageParam := c.Query("page")
-	pageSizeParam := c.Query("pageSize")
-	offsetParam := c.Query("offset")
-	limitParam := c.Query("limit")
-
-	// Determine pagination type
-	useOffsetPagination := offsetParam != "" || limitParam != ""
-	usePagination := pageParam != "" || pageSizeParam != "" || useOffsetPagination
-
-	var page, pageSize, offset, limit int = 1, 50, 0, 50
-
-	if useOffsetPagination {
-		if offsetParam != "" {
-			if val, err := strconv.Atoi(offsetParam); err == nil && val >= 0 {
-				offset = val
-			}
+	pageReq, usePagination := parsePageRequest(c)
+
+	// Safely resolve path
+	fsys, safePath, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if directory exists
+	dirInfo, err := fsys.Stat(safePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Directory not found")
+			return
 		}
-		if limitParam != "" {
-			if val, err := strconv.Atoi(limitParam); err == nil && val > 0 && val <= 1000 {
-				limit = val
-			}
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat directory: "+err.Error())
+		return
+	}
+
+	if !dirInfo.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a directory")
+		return
+	}
+
+	// Serve from the listing cache when the directory is unchanged. Only local
+	// directories have an mtime that reliably changes with their contents.
+	cacheable := vfs.IsLocal(fsys) && !showHidden
+	tagFilter := c.Query("tag")
+	withGit := c.Query("git") == "true" && vfs.IsLocal(fsys)
+	var items []FileItem
+	var pagination *Pagination
+	cached := false
+	if cacheable {
+		items, cached = getCachedListing(safePath, dirInfo)
+	}
+	if !cached {
+		entries, err := listDirEntries(fsys, safePath, userPath, showHidden)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to read directory: "+err.Error())
+			return
 		}
-	} else {
-		if pageParam != "" {
-			if val, err := strconv.Atoi(pageParam); err == nil && val >= 1 {
-				page = val
+		if usePagination && tagFilter == "" && !withGit {
+			// Nothing filters the page afterwards, so only its own entries
+			// need stat'ing, which in a huge directory is most of the work
+			entries, pagination = paginate(entries, pageReq)
+			items = dirEntryItems(fsys, safePath, userPath, entries, fields.stats())
+		} else {
+			items = dirEntryItems(fsys, safePath, userPath, entries, fields.stats())
+			// Only complete listings are cached
+			if cacheable && fields.stats() {
+				setCachedListing(safePath, dirInfo, items)
 			}
 		}
-		if pageSizeParam != "" {
-			if val, err := strconv.Atoi(pageSizeParam); err == nil && val > 0 && val <= 1000 {
-				pageSize = val
-			}
+	}
+	if !fields.all() {
+		items = fields.apply(items)
+	}
+
+	// Tags and notes are added after the cache, as changing them doesn't
+	// touch the directory
+	if metadata.Available() {
+		if annotated, err := addMetadata(displayPath(fsys, safePath), items, tagFilter, c.Query("notes") == "true"); err == nil {
+			items = annotated
 		}
-		offset = (page - 1) * pageSize
-		limit = pageSize
+	} else if tagFilter != "" {
+		problem.Respond(c, http.StatusServiceUnavailable, metadata.ErrUnavailable.Error())
+		return
 	}
 
-	// Safely resolve path
-	safePath, err := utils.SafeResolve(userPath)
+	// Git markers are looked up fresh, as files change without the
+	// directory doing so
+	var gitSummary *GitSummary
+	if withGit {
+		items, gitSummary = addGitMarkers(c.Request.Context(), safePath, items)
+	}
+
+	response := ListResponse{
+		OK:         true,
+		Path:       userPath,
+		Items:      items,
+		Git:        gitSummary,
+		Pagination: pagination,
+	}
+
+	// Apply pagination if requested and not done already
+	if usePagination && pagination == nil {
+		response.Items, response.Pagination = paginate(items, pageReq)
+	}
+
+	// Count children of the returned directories only
+	if withItemCounts {
+		response.Items = addItemCounts(fsys, safePath, response.Items, showHidden)
+	}
+
+	// A visit counts once, not for every page of the directory
+	if !usePagination || pageReq.offset == 0 {
+		metadata.RecordVisit(middleware.User(c), displayPath(fsys, safePath))
+	}
+
+	writeListResponse(c, http.StatusOK, &response)
+}
+
+// addItemCounts returns a copy of items with ItemCount set for each directory.
+// The input is left untouched since it may be shared with the listing cache.
+func addItemCounts(fsys vfs.Filesystem, dirPath string, items []FileItem, showHidden bool) []FileItem {
+	counted := make([]FileItem, len(items))
+	copy(counted, items)
+
+	for i := range counted {
+		if counted[i].Type != "dir" {
+			continue
+		}
+		count, capped, err := countVisibleEntries(fsys, filepath.Join(dirPath, counted[i].Name), maxItemCount, showHidden)
+		if err != nil {
+			continue
+		}
+		counted[i].ItemCount = &count
+		counted[i].ItemCountCapped = capped
+	}
+
+	return counted
+}
+
+// countVisibleEntries counts non-hidden entries of a directory, or all of them
+// with showHidden, reading at most limit+1 names. capped is true when the
+// directory holds more than limit entries.
+func countVisibleEntries(fsys vfs.Filesystem, dirPath string, limit int, showHidden bool) (count int, capped bool, err error) {
+	dir, err := fsys.Open(dirPath)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": err.Error(),
-		})
-		return
+		return 0, false, err
 	}
+	defer dir.Close()
 
-	// Check if directory exists
-	if !utils.FileExists(safePath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Directory not found",
-		})
-		return
+	for count <= limit {
+		names, err := dir.Readdirnames(128)
+		for _, name := range names {
+			if showHidden || !utils.Hidden(name) {
+				count++
+			}
+		}
+		if err != nil {
+			break
+		}
 	}
 
-	if !utils.IsDirectory(safePath) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Path is not a directory",
-		})
-		return
+	if count > limit {
+		return limit, true, nil
+	}
+	return count, false, nil
+}
+
+// readDirectoryItems reads and sorts the visible entries of a directory, or all
+// of them with showHidden. Without withStats only symlinks are stat'ed, and
+// the other entries are left without size and mtime.
+func readDirectoryItems(fsys vfs.Filesystem, safePath, userPath string, showHidden, withStats bool) ([]FileItem, error) {
+	entries, err := listDirEntries(fsys, safePath, userPath, showHidden)
+	if err != nil {
+		return nil, err
 	}
+	return dirEntryItems(fsys, safePath, userPath, entries, withStats), nil
+}
 
+// dirEntry is a listed entry of a directory, typed from the directory read
+// where possible
+type dirEntry struct {
+	entry  fs.DirEntry
+	isDir  bool
+	target *string     // of a symlink
+	info   os.FileInfo // of a followed symlink's target
+}
+
+// listDirEntries reads and sorts the visible entries of a directory (folders
+// first, then by name) without stat'ing any but symlinks, whose targets
+// decide whether they are listed and as what
+func listDirEntries(fsys vfs.Filesystem, safePath, userPath string, showHidden bool) ([]dirEntry, error) {
 	// Read directory contents
-	entries, err := os.ReadDir(safePath)
+	entries, err := fsys.ReadDir(safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to read directory: " + err.Error(),
-		})
-		return
+		return nil, err
 	}
 
-	// Convert to FileItem slice
-	var items []FileItem
+	var listed []dirEntry
 	for _, entry := range entries {
-		// Skip hidden files starting with . (except . and ..)
-		if strings.HasPrefix(entry.Name(), ".") && entry.Name() != "." && entry.Name() != ".." {
+		// Skip dotfiles and HIDDEN_PATTERNS matches (except . and ..)
+		if !showHidden && utils.Hidden(entry.Name()) && entry.Name() != "." && entry.Name() != ".." {
 			continue
 		}
 
@@ -119,85 +255,175 @@ func ListDirectory(c *gin.Context) {
 			continue
 		}
 
-		info, err := entry.Info()
-		if err != nil {
+		if entry.Type()&os.ModeSymlink == 0 {
+			listed = append(listed, dirEntry{entry: entry, isDir: entry.IsDir()})
+			continue
+		}
+		if config.SymlinkPolicy == config.SymlinkHide {
 			continue
 		}
 
+		e := dirEntry{entry: entry}
+		if link, err := fsys.Readlink(filepath.Join(safePath, entry.Name())); err == nil {
+			e.target = &link
+		}
+		if config.SymlinkPolicy != config.SymlinkShow {
+			// Follow the link, skipping broken links and links escaping the root
+			resolvedFS, resolved, err := utils.ResolveFS(filepath.Join(userPath, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if e.info, err = resolvedFS.Stat(resolved); err != nil {
+				continue
+			}
+			e.isDir = e.info.IsDir()
+		}
+		listed = append(listed, e)
+	}
+
+	// Sort items (directories first, then alphabetical), with a stable order
+	// for names differing only in case so pages don't overlap
+	sort.Slice(listed, func(i, j int) bool {
+		if listed[i].isDir != listed[j].isDir {
+			return listed[i].isDir
+		}
+		a, b := listed[i].entry.Name(), listed[j].entry.Name()
+		if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+			return la < lb
+		}
+		return a < b
+	})
+
+	return listed, nil
+}
+
+// dirEntryItems describes listed entries, stat'ing them with withStats.
+// Entries that can no longer be stat'ed are left out.
+func dirEntryItems(fsys vfs.Filesystem, safePath, userPath string, entries []dirEntry, withStats bool) []FileItem {
+	items := make([]FileItem, 0, len(entries))
+	for _, e := range entries {
+		name := e.entry.Name()
+		itemPath := filepath.Join(userPath, name)
+		isSymlink := e.entry.Type()&os.ModeSymlink != 0
+
+		info := e.info
+		if withStats && info == nil {
+			var err error
+			if vfs.IsLocal(fsys) {
+				info, err = entryInfo(safePath, e.entry)
+			} else {
+				info, err = e.entry.Info()
+			}
+			if err != nil {
+				continue
+			}
+		}
+
 		item := FileItem{
-			Name:  entry.Name(),
-			Type:  "file",
-			MTime: info.ModTime().UnixMilli(),
+			Name:   name,
+			Type:   "file",
+			Target: e.target,
+		}
+		if info != nil {
+			item.MTime = info.ModTime().UnixMilli()
 		}
 
-		if entry.IsDir() {
+		switch {
+		case isSymlink && config.SymlinkPolicy == config.SymlinkShow:
+			item.Type = "symlink"
+		case e.isDir:
 			item.Type = "dir"
-		} else {
-			size := info.Size()
-			item.Size = &size
-			
+		default:
+			if info != nil {
+				size := info.Size()
+				item.Size = &size
+			}
+
 			// Build URL for files
-			itemPath := filepath.Join(userPath, entry.Name())
 			url := utils.BuildPublicFileURL(itemPath)
 			item.URL = &url
 		}
 
 		items = append(items, item)
 	}
+	return items
+}
 
-	// Sort items (directories first, then alphabetical)
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].Type != items[j].Type {
-			return items[i].Type == "dir"
-		}
-		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
-	})
-
-	response := ListResponse{
-		OK:    true,
-		Path:  userPath,
-		Items: items,
+// parseShowHidden reads ?hidden=true, which reveals dotfiles and
+// HIDDEN_PATTERNS matches to requests carrying the admin token. Others get
+// 403 for it.
+func parseShowHidden(c *gin.Context) (bool, bool) {
+	if c.Query("hidden") != "true" {
+		return false, true
 	}
+	if !middleware.IsAdmin(c) {
+		problem.Respond(c, http.StatusForbidden, "Showing hidden entries requires the admin token")
+		return false, false
+	}
+	return true, true
+}
 
-	// Apply pagination if requested
-	if usePagination {
-		totalItems := len(items)
-		startIndex := offset
-		endIndex := offset + limit
-		if endIndex > totalItems {
-			endIndex = totalItems
-		}
+// listFields are the optional members of listed entries a client asked for
+// with ?fields=; name and type are always included. Leaving out size and
+// mtime spares the server a stat of every entry.
+type listFields struct {
+	size, mtime, url, target bool
+}
 
-		if startIndex < totalItems {
-			response.Items = items[startIndex:endIndex]
-		} else {
-			response.Items = []FileItem{}
+// parseListFields reads ?fields=, a comma-separated list of entry members,
+// answering 400 for unknown ones. Without it every member is included.
+func parseListFields(c *gin.Context) (listFields, bool) {
+	value := c.Query("fields")
+	if value == "" {
+		return listFields{size: true, mtime: true, url: true, target: true}, true
+	}
+	var fields listFields
+	for _, field := range strings.Split(value, ",") {
+		switch strings.TrimSpace(field) {
+		case "name", "type":
+		case "size":
+			fields.size = true
+		case "mtime":
+			fields.mtime = true
+		case "url":
+			fields.url = true
+		case "target":
+			fields.target = true
+		default:
+			problem.Respond(c, http.StatusBadRequest, "Unknown field: "+field)
+			return listFields{}, false
 		}
+	}
+	return fields, true
+}
 
-		hasMore := endIndex < totalItems
+// stats reports whether the fields need entries to be stat'ed
+func (f listFields) stats() bool {
+	return f.size || f.mtime
+}
 
-		if useOffsetPagination {
-			response.Pagination = map[string]interface{}{
-				"offset":     offset,
-				"limit":      limit,
-				"totalItems": totalItems,
-				"hasMore":    hasMore,
-			}
-			if hasMore {
-				response.Pagination["nextOffset"] = endIndex
-			}
-		} else {
-			totalPages := (totalItems + pageSize - 1) / pageSize
-			response.Pagination = map[string]interface{}{
-				"page":       page,
-				"pageSize":   pageSize,
-				"totalItems": totalItems,
-				"totalPages": totalPages,
-				"hasNext":    hasMore,
-				"hasPrev":    offset > 0,
-			}
+func (f listFields) all() bool {
+	return f.size && f.mtime && f.url && f.target
+}
+
+// apply returns a copy of items with only the asked for members. The input
+// is left untouched since it may be shared with the listing cache.
+func (f listFields) apply(items []FileItem) []FileItem {
+	trimmed := make([]FileItem, len(items))
+	for i, item := range items {
+		trimmed[i] = FileItem{Name: item.Name, Type: item.Type}
+		if f.size {
+			trimmed[i].Size = item.Size
+		}
+		if f.mtime {
+			trimmed[i].MTime = item.MTime
+		}
+		if f.url {
+			trimmed[i].URL = item.URL
+		}
+		if f.target {
+			trimmed[i].Target = item.Target
 		}
 	}
-
-	c.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+	return trimmed
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jellydator/ttlcache/v3"
+
+	"nextbrowse-backend/config"
+)
+
+// listCacheEntry holds the sorted entries of a directory together with the
+// fingerprint of the directory at the time it was read
+type listCacheEntry struct {
+	fingerprint string
+	items       []FileItem
+}
+
+// listCache maps resolved directory paths to their cached listings
+var listCache = newListCache()
+
+func newListCache() *ttlcache.Cache[string, *listCacheEntry] {
+	cache := ttlcache.New[string, *listCacheEntry](
+		ttlcache.WithTTL[string, *listCacheEntry](config.ListCacheTTL),
+		ttlcache.WithCapacity[string, *listCacheEntry](uint64(max(config.ListCacheSize, 1))),
+		ttlcache.WithDisableTouchOnHit[string, *listCacheEntry](),
+	)
+	if config.ListCacheTTL > 0 {
+		go cache.Start()
+	}
+	return cache
+}
+
+// dirFingerprint identifies the current state of a directory. Adding, removing
+// or renaming entries updates the directory mtime, which changes the fingerprint.
+func dirFingerprint(info os.FileInfo) string {
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// getCachedListing returns the cached items for dirPath if they are still valid
+func getCachedListing(dirPath string, info os.FileInfo) ([]FileItem, bool) {
+	if config.ListCacheTTL <= 0 {
+		return nil, false
+	}
+
+	item := listCache.Get(dirPath)
+	if item == nil {
+		return nil, false
+	}
+
+	entry := item.Value()
+	if entry.fingerprint != dirFingerprint(info) {
+		listCache.Delete(dirPath)
+		return nil, false
+	}
+
+	return entry.items, true
+}
+
+// setCachedListing stores the items read for dirPath
+func setCachedListing(dirPath string, info os.FileInfo, items []FileItem) {
+	if config.ListCacheTTL <= 0 {
+		return
+	}
+
+	listCache.Set(dirPath, &listCacheEntry{
+		fingerprint: dirFingerprint(info),
+		items:       items,
+	}, ttlcache.DefaultTTL)
+}
+
+// invalidateListing drops cached listings affected by a change to the given
+// resolved paths: the parent directory of each path, plus the path itself and
+// everything cached beneath it (for directories that were moved or deleted).
+// The same directories are read again by the next search to reach them.
+func invalidateListing(paths ...string) {
+	searchIndex.invalidate(paths...)
+	if config.ListCacheTTL <= 0 {
+		return
+	}
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		listCache.Delete(filepath.Dir(path))
+		listCache.Delete(path)
+		invalidateStats(path)
+
+		prefix := path + string(filepath.Separator)
+		for _, key := range listCache.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				listCache.Delete(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPooledListBuffer is the largest encoding buffer kept for reuse, so that
+// one huge listing doesn't pin its memory for good
+const maxPooledListBuffer = 4 << 20
+
+// listBuffers hold listings while they are encoded
+var listBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 64<<10)
+		return &b
+	},
+}
+
+// writeListResponse sends r as JSON. Listings are the largest responses
+// sent most often, and encoding/json spends most of their time reflecting
+// over FileItem and growing its buffer; this writes the same bytes by hand
+// into a pooled buffer.
+func writeListResponse(c *gin.Context, status int, r *ListResponse) {
+	bp := listBuffers.Get().(*[]byte)
+	buf := r.appendJSON((*bp)[:0])
+	c.Data(status, "application/json; charset=utf-8", buf)
+	if cap(buf) <= maxPooledListBuffer {
+		*bp = buf
+		listBuffers.Put(bp)
+	}
+}
+
+func (r *ListResponse) appendJSON(b []byte) []byte {
+	b = append(b, `{"ok":`...)
+	b = strconv.AppendBool(b, r.OK)
+	b = append(b, `,"path":`...)
+	b = appendJSONString(b, r.Path)
+	b = append(b, `,"items":`...)
+	if r.Items == nil {
+		b = append(b, "null"...)
+	} else {
+		b = append(b, '[')
+		for i := range r.Items {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = r.Items[i].appendJSON(b)
+		}
+		b = append(b, ']')
+	}
+	if r.Git != nil {
+		b = append(b, `,"git":`...)
+		b = appendJSONValue(b, r.Git)
+	}
+	if r.Pagination != nil {
+		b = append(b, `,"pagination":`...)
+		b = appendJSONValue(b, r.Pagination)
+	}
+	return append(b, '}')
+}
+
+func (f *FileItem) appendJSON(b []byte) []byte {
+	b = append(b, `{"name":`...)
+	b = appendJSONString(b, f.Name)
+	b = append(b, `,"type":`...)
+	b = appendJSONString(b, f.Type)
+	if f.Size != nil {
+		b = append(b, `,"size":`...)
+		b = strconv.AppendInt(b, *f.Size, 10)
+	}
+	b = append(b, `,"mtime":`...)
+	b = strconv.AppendInt(b, f.MTime, 10)
+	if f.URL != nil {
+		b = append(b, `,"url":`...)
+		b = appendJSONString(b, *f.URL)
+	}
+	if f.Target != nil {
+		b = append(b, `,"target":`...)
+		b = appendJSONString(b, *f.Target)
+	}
+	if f.ItemCount != nil {
+		b = append(b, `,"itemCount":`...)
+		b = strconv.AppendInt(b, int64(*f.ItemCount), 10)
+	}
+	if f.ItemCountCapped {
+		b = append(b, `,"itemCountCapped":true`...)
+	}
+	if len(f.Tags) > 0 {
+		b = append(b, `,"tags":[`...)
+		for i, tag := range f.Tags {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, tag)
+		}
+		b = append(b, ']')
+	}
+	if f.Note != nil {
+		b = append(b, `,"note":`...)
+		b = appendJSONValue(b, f.Note)
+	}
+	if f.Git != "" {
+		b = append(b, `,"git":`...)
+		b = appendJSONString(b, f.Git)
+	}
+	return append(b, '}')
+}
+
+// appendJSONValue appends the parts of a listing that are rare enough to
+// leave to encoding/json
+func appendJSONValue(b []byte, v any) []byte {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return append(b, "null"...)
+	}
+	return append(b, encoded...)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s quoted as encoding/json quotes it, HTML
+// characters, U+2028 and U+2029 escaped and invalid UTF-8 replaced
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+)
+
+func TestAppendJSONString(t *testing.T) {
+	tests := []string{
+		"",
+		"plain.txt",
+		`quote " and backslash \`,
+		"<script>&amp;</script>",
+		"tab\tnewline\ncarriage\rbackspace\bformfeed\f",
+		"\x00\x01\x1f\x7f",
+		"héllo wörld, 日本語, 🚀",
+		"line\u2028and\u2029paragraph separators",
+		"invalid \xff\xfe utf-8",
+		"truncated \xe2\x82",
+		"surrogate \xed\xa0\x80",
+		"\xc0\xafoverlong",
+	}
+	for c := 0; c < 256; c++ {
+		tests = append(tests, "a"+string(rune(c))+"b", "a"+string([]byte{byte(c)})+"b")
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		s := make([]byte, rng.Intn(32))
+		rng.Read(s)
+		tests = append(tests, string(s))
+	}
+
+	for _, s := range tests {
+		want, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := appendJSONString(nil, s); !bytes.Equal(got, want) {
+			t.Errorf("appendJSONString(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestListResponseAppendJSON(t *testing.T) {
+	r := sampleListResponse(50)
+	r.Items[0].Tags = []string{"red", "<b>"}
+	r.Items[0].Note = &metadata.Note{Text: "a <b>note</b> \u2028 & more", UpdatedAt: 1700000000000}
+	r.Items[1].ItemCountCapped = true
+	r.Git = &GitSummary{Root: "/repo", Branch: "main", Dirty: true}
+	r.Pagination = &Pagination{Offset: 0, Limit: 50, Page: 1, PageSize: 50, TotalItems: 120, TotalPages: 3, HasMore: true, HasNext: true}
+
+	for _, r := range []*ListResponse{r, {OK: true, Path: "/empty"}, {OK: true, Path: "/", Items: []FileItem{}}} {
+		want, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.appendJSON(nil); !bytes.Equal(got, want) {
+			t.Errorf("appendJSON =\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func BenchmarkWriteListResponse(b *testing.B) {
+	gin.SetMode(gin.ReleaseMode)
+	r := sampleListResponse(1000)
+
+	b.Run("custom", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			writeListResponse(c, http.StatusOK, r)
+		}
+	})
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.JSON(http.StatusOK, r)
+		}
+	})
+}
+
+// sampleListResponse returns a listing of n files and directories, with the
+// fields a listing usually sets
+func sampleListResponse(n int) *ListResponse {
+	r := &ListResponse{OK: true, Path: "/projects/nextbrowse", Items: make([]FileItem, n)}
+	for i := range r.Items {
+		name := "file-" + strconv.Itoa(i) + ".txt"
+		item := FileItem{Name: name, Type: "file", MTime: 1700000000000 + int64(i)}
+		if i%10 == 0 {
+			count := i
+			item.Name, item.Type, item.ItemCount = "dir-"+strconv.Itoa(i), "dir", &count
+		} else {
+			size := int64(i) * 1024
+			url := "/api/fs/download?path=" + r.Path + "/" + name
+			item.Size, item.URL = &size, &url
+		}
+		r.Items[i] = item
+	}
+	return r
+}
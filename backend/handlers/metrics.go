@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"nextbrowse-backend/problem"
+)
+
+// MetricSample is one labelled value of a metric. Histograms report their
+// count and sum instead of a value.
+type MetricSample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  *float64          `json:"value,omitempty"`
+	Count  *uint64           `json:"count,omitempty"`
+	Sum    *float64          `json:"sum,omitempty"`
+}
+
+// MetricFamily is a metric with all of its samples
+type MetricFamily struct {
+	Help    string         `json:"help"`
+	Type    string         `json:"type"`
+	Samples []MetricSample `json:"samples"`
+}
+
+// MetricsJSON returns the server's own metrics (those exported at /metrics
+// with the nextbrowse_ prefix) as JSON, for dashboards without a Prometheus
+// server
+func MetricsJSON(c *gin.Context) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to gather metrics: "+err.Error())
+		return
+	}
+
+	result := make(map[string]MetricFamily)
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "nextbrowse_") {
+			continue
+		}
+
+		out := MetricFamily{
+			Help:    family.GetHelp(),
+			Type:    strings.ToLower(family.GetType().String()),
+			Samples: make([]MetricSample, 0, len(family.GetMetric())),
+		}
+		for _, metric := range family.GetMetric() {
+			out.Samples = append(out.Samples, metricSample(metric))
+		}
+		result[family.GetName()] = out
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":      true,
+		"metrics": result,
+	})
+}
+
+func metricSample(metric *dto.Metric) MetricSample {
+	var sample MetricSample
+	if len(metric.GetLabel()) > 0 {
+		sample.Labels = make(map[string]string, len(metric.GetLabel()))
+		for _, label := range metric.GetLabel() {
+			sample.Labels[label.GetName()] = label.GetValue()
+		}
+	}
+
+	switch {
+	case metric.Counter != nil:
+		value := metric.Counter.GetValue()
+		sample.Value = &value
+	case metric.Gauge != nil:
+		value := metric.Gauge.GetValue()
+		sample.Value = &value
+	case metric.Histogram != nil:
+		count, sum := metric.Histogram.GetSampleCount(), metric.Histogram.GetSampleSum()
+		sample.Count = &count
+		sample.Sum = &sum
+	}
+	return sample
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/problem"
+)
+
+// maxNoteLength is the longest note, in characters
+const maxNoteLength = 4000
+
+type NoteRequest struct {
+	Path string `json:"path"`
+	Text string `json:"text"`
+}
+
+type NoteResponse struct {
+	OK   bool           `json:"ok"`
+	Path string         `json:"path"`
+	Note *metadata.Note `json:"note"` // null when there is none
+}
+
+// GetNote returns the note attached to a path
+func GetNote(c *gin.Context) {
+	fsys, name, ok := resolveMetadataPath(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	entry, err := metadata.Get(displayPath(fsys, name))
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read note: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, NoteResponse{OK: true, Path: displayPath(fsys, name), Note: entry.Note})
+}
+
+// SetNote attaches a note to a path, replacing any it had; blank text
+// removes the note
+func SetNote(c *gin.Context) {
+	var req NoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if utf8.RuneCountInString(text) > maxNoteLength {
+		problem.Respond(c, http.StatusBadRequest, "Note is longer than 4000 characters")
+		return
+	}
+
+	fsys, name, ok := resolveMetadataPath(c, req.Path)
+	if !ok {
+		return
+	}
+	entry, err := metadata.Update(displayPath(fsys, name), func(e *metadata.Entry) {
+		e.Note = nil
+		if text != "" {
+			e.Note = &metadata.Note{Text: text, UpdatedAt: time.Now().UnixMilli()}
+		}
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to save note: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, NoteResponse{OK: true, Path: displayPath(fsys, name), Note: entry.Note})
+}
+
+// DeleteNote removes the note attached to a path
+func DeleteNote(c *gin.Context) {
+	fsys, name, ok := resolveMetadataPath(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	_, err := metadata.Update(displayPath(fsys, name), func(e *metadata.Entry) {
+		e.Note = nil
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to remove note: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, NoteResponse{OK: true, Path: displayPath(fsys, name)})
+}
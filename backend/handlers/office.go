@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// officeLockDuration is how long a WOPI lock lasts unless refreshed, as the
+// protocol prescribes
+const officeLockDuration = 30 * time.Minute
+
+// officeDiscoveryTTL is how long the office server's editors are remembered
+const officeDiscoveryTTL = time.Hour
+
+type OfficeRequest struct {
+	Path string `json:"path"`
+	Name string `json:"name,omitempty"` // shown to others editing the same document
+}
+
+// OfficeSession is what the web app needs to open a document in the office
+// editor: it POSTs access_token and access_token_ttl as a form to EditorURL
+type OfficeSession struct {
+	OK             bool   `json:"ok"`
+	FileID         string `json:"fileId"`
+	AccessToken    string `json:"accessToken"`
+	AccessTokenTTL int64  `json:"accessTokenTtl"` // expiry in Unix milliseconds
+	WOPISrc        string `json:"wopiSrc"`
+	EditorURL      string `json:"editorUrl"`
+}
+
+// officeToken is what an access token grants: one file, on behalf of one
+// user, until Expires
+type officeToken struct {
+	File    string `json:"f"`
+	User    string `json:"u"`
+	Name    string `json:"n,omitempty"`
+	Actor   string `json:"a"`
+	Expires int64  `json:"e"`
+}
+
+// officeKey signs access tokens
+var officeKey = sync.OnceValue(func() []byte {
+	if config.OfficeSecret != "" {
+		return []byte(config.OfficeSecret)
+	}
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+})
+
+// OpenOffice hands out an access token for editing the file at path in the
+// office server, with the URL of the editor for its type
+func OpenOffice(c *gin.Context) {
+	var req OfficeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	fsys, name, err := utils.ResolveFS(req.Path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	if !info.Mode().IsRegular() {
+		problem.Respond(c, http.StatusBadRequest, "Only files can be edited")
+		return
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	urlsrc, err := officeEditor(ext)
+	if err != nil {
+		problem.Respond(c, http.StatusBadGateway, "Failed to reach the office server: "+err.Error())
+		return
+	}
+	if urlsrc == "" {
+		problem.Respond(c, http.StatusBadRequest, "The office server cannot open ."+ext+" files")
+		return
+	}
+
+	token := officeToken{
+		File:    base64.RawURLEncoding.EncodeToString([]byte(path.Clean("/" + displayPath(fsys, name)))),
+		User:    middleware.User(c),
+		Name:    strings.TrimSpace(req.Name),
+		Actor:   c.ClientIP(),
+		Expires: time.Now().Add(config.OfficeTokenTTL).UnixMilli(),
+	}
+	accessToken, err := signOfficeToken(token)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create access token: "+err.Error())
+		return
+	}
+
+	wopiSrc := wopiBaseURL(c) + "/wopi/files/" + token.File
+	c.JSON(http.StatusOK, OfficeSession{
+		OK:             true,
+		FileID:         token.File,
+		AccessToken:    accessToken,
+		AccessTokenTTL: token.Expires,
+		WOPISrc:        wopiSrc,
+		EditorURL:      editorURL(urlsrc, wopiSrc),
+	})
+}
+
+// wopiBaseURL is where the office server reaches this server
+func wopiBaseURL(c *gin.Context) string {
+	if config.OfficeWOPIURL != "" {
+		return config.OfficeWOPIURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// signOfficeToken encodes a token as its JSON and HMAC, both base64url
+func signOfficeToken(t officeToken) (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, officeKey())
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseOfficeToken returns the token an access token encodes, if it is
+// genuine and unexpired
+func parseOfficeToken(accessToken string) (officeToken, bool) {
+	var t officeToken
+	encoded, sig, ok := strings.Cut(accessToken, ".")
+	if !ok {
+		return t, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return t, false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return t, false
+	}
+	mac := hmac.New(sha256.New, officeKey())
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) || json.Unmarshal(payload, &t) != nil {
+		return t, false
+	}
+	return t, time.Now().UnixMilli() < t.Expires
+}
+
+// wopiFile is the file a WOPI request is about, once its access token has
+// been checked
+type wopiFile struct {
+	id    string
+	token officeToken
+	fsys  vfs.Filesystem
+	name  string
+}
+
+// resolveWOPI checks the access token of a WOPI request against the file it
+// names, answering the request itself when it is refused
+func resolveWOPI(c *gin.Context) (wopiFile, bool) {
+	id := c.Param("id")
+	token, ok := parseOfficeToken(c.Query("access_token"))
+	if !ok || token.File != id {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return wopiFile{}, false
+	}
+	userPath, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return wopiFile{}, false
+	}
+	fsys, name, err := utils.ResolveFS(string(userPath))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return wopiFile{}, false
+	}
+	return wopiFile{id: id, token: token, fsys: fsys, name: name}, true
+}
+
+// wopiVersion identifies a version of a file for the office server
+func wopiVersion(info fs.FileInfo) string {
+	return strings.Trim(fileETag(info), `"`)
+}
+
+// WOPICheckFileInfo describes a file and what the editor may do with it
+func WOPICheckFileInfo(c *gin.Context) {
+	file, ok := resolveWOPI(c)
+	if !ok {
+		return
+	}
+	info, err := file.fsys.Stat(file.name)
+	if err != nil || !info.Mode().IsRegular() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	userName := file.token.Name
+	if userName == "" {
+		userName = file.token.User
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"BaseFileName":            filepath.Base(file.name),
+		"Size":                    info.Size(),
+		"Version":                 wopiVersion(info),
+		"LastModifiedTime":        info.ModTime().UTC().Format(time.RFC3339),
+		"OwnerId":                 "nextbrowse",
+		"UserId":                  file.token.User,
+		"UserFriendlyName":        userName,
+		"UserCanWrite":            true,
+		"UserCanNotWriteRelative": true,
+		"SupportsUpdate":          true,
+		"SupportsLocks":           true,
+		"SupportsGetLock":         true,
+	})
+}
+
+// WOPIGetFile sends the contents of a file
+func WOPIGetFile(c *gin.Context) {
+	file, ok := resolveWOPI(c)
+	if !ok {
+		return
+	}
+	f, err := file.fsys.Open(file.name)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.DataFromReader(http.StatusOK, info.Size(), "application/octet-stream", f, map[string]string{
+		"X-WOPI-ItemVersion": wopiVersion(info),
+	})
+}
+
+// WOPIPutFile replaces the contents of a file with the request body. The
+// editor must hold the file's lock, unless the file is empty and unlocked,
+// as for a document just created.
+func WOPIPutFile(c *gin.Context) {
+	file, ok := resolveWOPI(c)
+	if !ok {
+		return
+	}
+	if c.GetHeader("X-WOPI-Override") != "PUT" {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	data, ok := readWriteBody(c)
+	if !ok {
+		return
+	}
+	unlock, ok := lockPaths(c, utils.WriteLock(file.name))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	info, err := file.fsys.Stat(file.name)
+	if err != nil || !info.Mode().IsRegular() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	current := officeLocks.get(file.id)
+	if current == "" && info.Size() > 0 || current != "" && current != c.GetHeader("X-WOPI-Lock") {
+		lockConflict(c, current)
+		return
+	}
+	if !admitQuota(c, displayPath(file.fsys, file.name), max(int64(len(data))-info.Size(), 0), 0) {
+		return
+	}
+
+	if err := vfs.WriteFrom(file.fsys, file.name, bytes.NewReader(data), int64(len(data))); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+	info, err = file.fsys.Stat(file.name)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	invalidateListing(file.name)
+	publishChange(file.token.Actor, events.FileWritten, file.fsys, file.name, nil)
+
+	c.Header("X-WOPI-ItemVersion", wopiVersion(info))
+	c.Status(http.StatusOK)
+}
+
+// WOPIFileOperation takes, refreshes, reports and releases locks, as chosen
+// by the X-WOPI-Override header. Other operations, such as saving under a
+// new name, are not supported.
+func WOPIFileOperation(c *gin.Context) {
+	file, ok := resolveWOPI(c)
+	if !ok {
+		return
+	}
+	lock := c.GetHeader("X-WOPI-Lock")
+	if len(lock) > 1024 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var current string
+	switch c.GetHeader("X-WOPI-Override") {
+	case "LOCK":
+		if lock == "" {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if _, err := file.fsys.Stat(file.name); err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if oldLock := c.GetHeader("X-WOPI-OldLock"); oldLock != "" {
+			ok, current = officeLocks.swap(file.id, oldLock, lock)
+		} else {
+			ok, current = officeLocks.swap(file.id, "", lock)
+			if !ok && current == lock {
+				ok = officeLocks.refresh(file.id, lock)
+			}
+		}
+	case "REFRESH_LOCK":
+		ok, current = officeLocks.refresh(file.id, lock), officeLocks.get(file.id)
+	case "UNLOCK":
+		ok, current = officeLocks.swap(file.id, lock, "")
+	case "GET_LOCK":
+		c.Header("X-WOPI-Lock", officeLocks.get(file.id))
+		c.Status(http.StatusOK)
+		return
+	default:
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	if !ok {
+		lockConflict(c, current)
+		return
+	}
+	if info, err := file.fsys.Stat(file.name); err == nil {
+		c.Header("X-WOPI-ItemVersion", wopiVersion(info))
+	}
+	c.Status(http.StatusOK)
+}
+
+// lockConflict answers that the file is locked by someone else, or not at
+// all when current is empty
+func lockConflict(c *gin.Context, current string) {
+	c.Header("X-WOPI-Lock", current)
+	if current == "" {
+		c.Header("X-WOPI-LockFailureReason", "File is not locked")
+	} else {
+		c.Header("X-WOPI-LockFailureReason", "File is locked by another editor")
+	}
+	c.AbortWithStatus(http.StatusConflict)
+}
+
+// wopiLocks are the locks office editors hold on files, by file ID. They are
+// kept in memory, so they are released when the server restarts.
+type wopiLocks struct {
+	mu    sync.Mutex
+	locks map[string]wopiLock
+}
+
+type wopiLock struct {
+	id      string
+	expires time.Time
+}
+
+var officeLocks = &wopiLocks{locks: make(map[string]wopiLock)}
+
+// get returns the lock on a file, or "" when it is unlocked
+func (l *wopiLocks) get(file string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.currentLocked(file)
+}
+
+func (l *wopiLocks) currentLocked(file string) string {
+	lock, ok := l.locks[file]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(lock.expires) {
+		delete(l.locks, file)
+		return ""
+	}
+	return lock.id
+}
+
+// swap replaces the lock on a file with next when it is old, "" meaning
+// unlocked, and returns whether it did along with the lock now held
+func (l *wopiLocks) swap(file, old, next string) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.currentLocked(file)
+	if current != old {
+		return false, current
+	}
+	if next == "" {
+		delete(l.locks, file)
+	} else {
+		l.locks[file] = wopiLock{id: next, expires: time.Now().Add(officeLockDuration)}
+	}
+	return true, next
+}
+
+// refresh extends the lock on a file if it is id
+func (l *wopiLocks) refresh(file, id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id == "" || l.currentLocked(file) != id {
+		return false
+	}
+	l.locks[file] = wopiLock{id: id, expires: time.Now().Add(officeLockDuration)}
+	return true
+}
+
+// wopiDiscovery is the part of an office server's /hosting/discovery that
+// names the editor for each file extension
+type wopiDiscovery struct {
+	Zones []struct {
+		Apps []struct {
+			Actions []struct {
+				Name   string `xml:"name,attr"`
+				Ext    string `xml:"ext,attr"`
+				URLSrc string `xml:"urlsrc,attr"`
+			} `xml:"action"`
+		} `xml:"app"`
+	} `xml:"net-zone"`
+}
+
+var (
+	officeEditors   map[string]string // editor URL templates by extension
+	officeFetchedAt time.Time
+	officeMu        sync.Mutex
+	officeClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// officeEditor returns the URL template of the editor the office server has
+// for an extension, "" when it has none. Editing actions are preferred over
+// viewing ones.
+func officeEditor(ext string) (string, error) {
+	officeMu.Lock()
+	defer officeMu.Unlock()
+	if officeEditors == nil || time.Since(officeFetchedAt) > officeDiscoveryTTL {
+		editors, err := fetchOfficeEditors()
+		if err != nil {
+			if officeEditors == nil {
+				return "", err
+			}
+			slog.Warn("Failed to refresh office editors; keeping the previous ones", "error", err)
+		} else {
+			officeEditors = editors
+		}
+		officeFetchedAt = time.Now()
+	}
+	return officeEditors[ext], nil
+}
+
+func fetchOfficeEditors() (map[string]string, error) {
+	resp, err := officeClient.Get(config.OfficeURL + "/hosting/discovery")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery answered %s", resp.Status)
+	}
+
+	var discovery wopiDiscovery
+	if err := xml.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("invalid discovery: %w", err)
+	}
+	editors := make(map[string]string)
+	viewers := make(map[string]string)
+	for _, zone := range discovery.Zones {
+		for _, app := range zone.Apps {
+			for _, action := range app.Actions {
+				ext := strings.ToLower(action.Ext)
+				switch {
+				case ext == "" || action.URLSrc == "":
+				case action.Name == "edit" && editors[ext] == "":
+					editors[ext] = action.URLSrc
+				case action.Name == "view" && viewers[ext] == "":
+					viewers[ext] = action.URLSrc
+				}
+			}
+		}
+	}
+	if len(editors) == 0 && len(viewers) == 0 {
+		return nil, errors.New("discovery names no editors")
+	}
+	for ext, urlsrc := range viewers {
+		if editors[ext] == "" {
+			editors[ext] = urlsrc
+		}
+	}
+	return editors, nil
+}
+
+// discoveryPlaceholder matches the optional <name=VALUE&> parameters of
+// discovery URL templates
+var discoveryPlaceholder = regexp.MustCompile(`<[^>]*>`)
+
+// editorURL fills in a discovery URL template for a file at wopiSrc
+func editorURL(urlsrc, wopiSrc string) string {
+	u := discoveryPlaceholder.ReplaceAllString(urlsrc, "")
+	switch {
+	case !strings.Contains(u, "?"):
+		u += "?"
+	case !strings.HasSuffix(u, "?") && !strings.HasSuffix(u, "&"):
+		u += "&"
+	}
+	return u + "WOPISrc=" + url.QueryEscape(wopiSrc)
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
-	"io"
+	"context"
+	"errors"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
@@ -9,20 +11,28 @@ import (
 	"runtime"
 	"strings"
 	"sync"
-	"syscall"
 
 	"github.com/gin-gonic/gin"
 
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
 	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
 )
 
 type CopyMoveRequest struct {
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
+	Async       bool   `json:"async,omitempty"`    // return immediately with a job ID
+	Conflict    string `json:"conflict,omitempty"` // skip, overwrite, keep-newer or rename; merges into an existing destination
 }
 
 type DeleteRequest struct {
-	Path string `json:"path"`
+	Path      string `json:"path"`
+	Secure    bool   `json:"secure,omitempty"`    // overwrite file contents before unlinking
+	Permanent bool   `json:"permanent,omitempty"` // skip the trash
 }
 
 type MkdirRequest struct {
@@ -30,180 +40,272 @@ type MkdirRequest struct {
 	Name string `json:"name"`
 }
 
-type OperationResponse struct {
-	OK      bool   `json:"ok"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+type TouchRequest struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
 }
 
-type ReadFileResponse struct {
-	OK      bool   `json:"ok"`
-	Content string `json:"content"`
-	Size    int64  `json:"size"`
-	Mtime   int64  `json:"mtime"`
-	Error   string `json:"error,omitempty"`
+type OperationResponse struct {
+	OK      bool              `json:"ok"`
+	Message string            `json:"message"`
+	JobID   string            `json:"jobId,omitempty"`
+	TrashID string            `json:"trashId,omitempty"`
+	Errors  []models.JobError `json:"errors,omitempty"`
 }
 
 func CopyFile(c *gin.Context) {
 	var req CopyMoveRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Source == "" || req.Destination == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing source or destination",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing source or destination")
+		return
+	}
+
+	if !validConflictPolicy(req.Conflict) {
+		problem.Respond(c, http.StatusBadRequest, "Invalid conflict policy: "+req.Conflict)
 		return
 	}
 
 	// Safely resolve paths
-	srcPath, err := utils.SafeResolve(req.Source)
+	srcFS, srcPath, err := utils.ResolveFS(req.Source)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid source path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid source path: "+err.Error())
 		return
 	}
 
-	dstPath, err := utils.SafeResolve(req.Destination)
+	dstFS, dstPath, err := utils.ResolveFS(req.Destination)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid destination path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+	if err := utils.ValidateNewPath(req.Destination); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination name: "+err.Error())
 		return
 	}
 
 	// Check if source exists
-	if !utils.FileExists(srcPath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Source file or directory not found",
-		})
+	if _, err := srcFS.Stat(srcPath); err != nil {
+		problem.Respond(c, http.StatusNotFound, "Source file or directory not found")
 		return
 	}
 
-	// Check if destination already exists
-	if utils.FileExists(dstPath) {
-		c.JSON(http.StatusConflict, gin.H{
-			"ok":    false,
-			"error": "Destination already exists",
-		})
+	// Check if destination already exists (unless merging into it)
+	if _, err := dstFS.Stat(dstPath); err == nil && req.Conflict == conflictFail {
+		problem.Respond(c, http.StatusConflict, "Destination already exists")
+		return
+	}
+
+	// Refuse to copy or move a directory into itself
+	if srcPath != dstPath && utils.IsWithin(srcPath, dstPath) {
+		problem.Respond(c, http.StatusBadRequest, "Destination is inside the source directory")
+		return
+	}
+
+	if !checkBackends(c, srcFS, dstFS, req.Conflict) {
+		return
+	}
+
+	if !admitTree(c, srcFS, srcPath, req.Source, req.Destination, false) {
 		return
 	}
 
 	// Ensure destination directory exists
-	err = os.MkdirAll(filepath.Dir(dstPath), 0755)
+	err = dstFS.MkdirAll(filepath.Dir(dstPath), 0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to create destination directory: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create destination directory: "+err.Error())
 		return
 	}
 
-	// Perform copy operation
-	err = copyRecursive(srcPath, dstPath)
+	// Keep the source stable and the destination to ourselves while copying
+	unlock, ok := lockPaths(c, utils.ReadLock(srcPath), utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+
+	// Perform copy operation as a tracked job
+	job, err := models.NewJob(jobType("copy", srcFS, dstFS), req.Source, req.Destination)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Copy operation failed: " + err.Error(),
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+	job.Limit(config.CopyTimeout, "COPY_TIMEOUT")
+
+	if req.Async {
+		go func() {
+			defer unlock()
+			runCopyOn(job, srcFS, dstFS, srcPath, dstPath, req.Conflict)
+		}()
+		c.JSON(http.StatusAccepted, OperationResponse{
+			OK:      true,
+			Message: "Copy started",
+			JobID:   job.ID,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, OperationResponse{
-		OK:      true,
-		Message: "File/directory copied successfully",
-	})
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runCopyOn(job, srcFS, dstFS, srcPath, dstPath, req.Conflict)
+	respondWithJob(c, job, "Copy", "File/directory copied successfully")
 }
 
 func MoveFile(c *gin.Context) {
 	var req CopyMoveRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Source == "" || req.Destination == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing source or destination",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing source or destination")
+		return
+	}
+
+	if !validConflictPolicy(req.Conflict) {
+		problem.Respond(c, http.StatusBadRequest, "Invalid conflict policy: "+req.Conflict)
 		return
 	}
 
 	// Safely resolve paths
-	srcPath, err := utils.SafeResolve(req.Source)
+	srcFS, srcPath, err := utils.ResolveLinkFS(req.Source)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid source path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid source path: "+err.Error())
 		return
 	}
 
-	dstPath, err := utils.SafeResolve(req.Destination)
+	dstFS, dstPath, err := utils.ResolveFS(req.Destination)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid destination path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+	if err := utils.ValidateNewPath(req.Destination); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination name: "+err.Error())
 		return
 	}
 
-	// Check if source exists
-	if !utils.FileExists(srcPath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Source file or directory not found",
-		})
+	// Check if source exists (a symlink is moved as-is)
+	if _, err := srcFS.Lstat(srcPath); err != nil {
+		problem.Respond(c, http.StatusNotFound, "Source file or directory not found")
 		return
 	}
 
-	// Check if destination already exists
-	if utils.FileExists(dstPath) {
-		c.JSON(http.StatusConflict, gin.H{
-			"ok":    false,
-			"error": "Destination already exists",
-		})
+	// Check if destination already exists (unless merging into it)
+	if _, err := dstFS.Stat(dstPath); err == nil && req.Conflict == conflictFail {
+		problem.Respond(c, http.StatusConflict, "Destination already exists")
+		return
+	}
+
+	// Refuse to copy or move a directory into itself
+	if srcPath != dstPath && utils.IsWithin(srcPath, dstPath) {
+		problem.Respond(c, http.StatusBadRequest, "Destination is inside the source directory")
+		return
+	}
+
+	if !checkBackends(c, srcFS, dstFS, req.Conflict) {
+		return
+	}
+
+	if !admitTree(c, srcFS, srcPath, req.Source, req.Destination, true) {
 		return
 	}
 
 	// Ensure destination directory exists
-	err = os.MkdirAll(filepath.Dir(dstPath), 0755)
+	err = dstFS.MkdirAll(filepath.Dir(dstPath), 0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to create destination directory: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create destination directory: "+err.Error())
 		return
 	}
 
-	// Perform move operation
-	err = os.Rename(srcPath, dstPath)
+	unlock, ok := lockPaths(c, utils.WriteLock(srcPath), utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+
+	// Perform move operation as a tracked job
+	job, err := models.NewJob(jobType("move", srcFS, dstFS), req.Source, req.Destination)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Move operation failed: " + err.Error(),
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+	job.Limit(config.CopyTimeout, "COPY_TIMEOUT")
+
+	if req.Async {
+		actor := c.ClientIP()
+		go func() {
+			defer unlock()
+			runMoveOn(job, actor, srcFS, dstFS, srcPath, dstPath, req.Conflict)
+		}()
+		c.JSON(http.StatusAccepted, OperationResponse{
+			OK:      true,
+			Message: "Move started",
+			JobID:   job.ID,
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, OperationResponse{
-		OK:      true,
-		Message: "File/directory moved successfully",
-	})
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runMoveOn(job, c.ClientIP(), srcFS, dstFS, srcPath, dstPath, req.Conflict)
+	respondWithJob(c, job, "Move", "File/directory moved successfully")
+}
+
+// checkBackends rejects copies and moves that the storage backends involved
+// can't perform. Only local storage supports conflict policies; copies and
+// moves between backends run as transfer jobs.
+func checkBackends(c *gin.Context, srcFS, dstFS vfs.Filesystem, conflict string) bool {
+	if vfs.IsLocal(srcFS) && vfs.IsLocal(dstFS) {
+		return true
+	}
+	if conflict != conflictFail {
+		problem.Respond(c, http.StatusBadRequest, "Conflict policies are only supported on local storage")
+		return false
+	}
+	return true
+}
+
+// lockPaths acquires the given path locks, answering 423 Locked if another
+// operation holds a conflicting lock
+func lockPaths(c *gin.Context, locks ...utils.PathLock) (func(), bool) {
+	unlock, err := utils.TryLockPaths(locks...)
+	if err != nil {
+		problem.Respond(c, http.StatusLocked, "Path is locked by another operation")
+		return nil, false
+	}
+	return unlock, true
+}
+
+// cancelOnDisconnect cancels a job that runs within the request once the
+// client goes away, since nobody is waiting for it. The returned function
+// stops watching.
+func cancelOnDisconnect(c *gin.Context, job *models.Job) func() bool {
+	return context.AfterFunc(c.Request.Context(), job.Cancel)
+}
+
+// respondWithJob renders the outcome of a finished copy/move job
+func respondWithJob(c *gin.Context, job *models.Job, operation, successMessage string) {
+	info := job.Info()
+
+	switch info.Status {
+	case models.JobCompleted:
+		c.JSON(http.StatusOK, OperationResponse{
+			OK:      true,
+			Message: successMessage,
+			JobID:   job.ID,
+		})
+	case models.JobCancelled:
+		problem.Respond(c, http.StatusConflict, operation+" operation cancelled", gin.H{"jobId": job.ID})
+	default:
+		problem.Respond(c, http.StatusInternalServerError, operation+" operation failed: "+info.Message, gin.H{
+			"jobId":  job.ID,
+			"errors": info.Errors,
+		})
+	}
 }
 
 func DeleteFile(c *gin.Context) {
@@ -220,7 +322,7 @@ func DeleteFile(c *gin.Context) {
 	}
 
 	if path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"ok": false, "error": "Missing path"})
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
 		return
 	}
 
@@ -235,35 +337,85 @@ func DeleteFile(c *gin.Context) {
 		path = unesc
 	}
 
-	// Safely resolve path
-	safePath, err := utils.SafeResolve(path)
+	// Safely resolve path (a symlink is deleted, never its target)
+	fsys, safePath, err := utils.ResolveLinkFS(path)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Check if path exists
-	if !utils.FileExists(safePath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "File or directory not found",
+	if _, err := fsys.Lstat(safePath); err != nil {
+		problem.Respond(c, http.StatusNotFound, "File or directory not found")
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.WriteLock(safePath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Move to the trash unless asked not to, or to delete securely
+	secure := req.Secure || c.Query("secure") == "true"
+	permanent := req.Permanent || c.Query("permanent") == "true"
+	if !secure && !permanent && useTrash(fsys, safePath) {
+		item, err := moveToTrash(fsys, safePath)
+		if errors.Is(err, errTrashCrossDevice) {
+			problem.Respond(c, http.StatusConflict, "Can't move to trash: "+err.Error()+"; delete permanently instead")
+			return
+		}
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to move to trash: "+err.Error())
+			return
+		}
+
+		invalidateListing(safePath)
+		publishChange(c.ClientIP(), events.FileDeleted, fsys, safePath, gin.H{"trashId": item.ID})
+
+		c.JSON(http.StatusOK, OperationResponse{
+			OK:      true,
+			Message: "Moved to trash",
+			TrashID: item.ID,
 		})
 		return
 	}
 
+	// Overwrite contents first if a secure delete was requested
+	if secure {
+		if !vfs.IsLocal(fsys) {
+			problem.Respond(c, http.StatusBadRequest, "Secure delete is only supported on local storage")
+			return
+		}
+		size, err := secureDeleteSize(safePath)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to scan for secure delete: "+err.Error())
+			return
+		}
+		if size > config.SecureDeleteMaxSize {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, "Too large for secure delete")
+			return
+		}
+		if err := shredTree(c.Request.Context(), safePath); err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Secure delete failed: "+err.Error())
+			return
+		}
+	}
+
 	// Perform fast delete operation
-	err = fastDelete(safePath)
+	if vfs.IsLocal(fsys) {
+		err = fastDelete(c.Request.Context(), safePath)
+	} else {
+		err = fsys.RemoveAll(safePath)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Delete operation failed: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Delete operation failed: "+err.Error())
 		return
 	}
 
+	invalidateListing(safePath)
+	publishChange(c.ClientIP(), events.FileDeleted, fsys, safePath, nil)
+
 	c.JSON(http.StatusOK, OperationResponse{
 		OK:      true,
 		Message: "File/directory deleted successfully",
@@ -273,184 +425,123 @@ func DeleteFile(c *gin.Context) {
 func CreateDirectory(c *gin.Context) {
 	var req MkdirRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Path == "" || req.Name == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing path or name",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing path or name")
+		return
+	}
+
+	if err := utils.ValidateFileName(req.Name); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid directory name: "+err.Error())
 		return
 	}
 
 	// Safely resolve parent path
-	parentPath, err := utils.SafeResolve(req.Path)
+	fsys, parentPath, err := utils.ResolveFS(req.Path)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid parent path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid parent path: "+err.Error())
 		return
 	}
 
 	// Create full directory path
-	newDirPath := filepath.Join(parentPath, req.Name)
+	newDirPath := utils.JoinName(fsys, parentPath, req.Name)
 
 	// Check if directory already exists
-	if utils.FileExists(newDirPath) {
-		c.JSON(http.StatusConflict, gin.H{
-			"ok":    false,
-			"error": "Directory already exists",
-		})
+	if _, err := fsys.Stat(newDirPath); err == nil {
+		problem.Respond(c, http.StatusConflict, "Directory already exists")
 		return
 	}
 
+	unlock, ok := lockPaths(c, utils.WriteLock(newDirPath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
 	// Create directory
-	err = os.MkdirAll(newDirPath, 0755)
+	err = fsys.MkdirAll(newDirPath, 0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to create directory: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create directory: "+err.Error())
 		return
 	}
 
+	invalidateListing(newDirPath)
+	publishChange(c.ClientIP(), events.DirCreated, fsys, newDirPath, nil)
+
 	c.JSON(http.StatusOK, OperationResponse{
 		OK:      true,
 		Message: "Directory created successfully",
 	})
 }
 
-// Helper function to copy files/directories recursively
-func copyRecursive(src, dst string) error {
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return err
+// CreateFile creates a new empty file, like CreateDirectory does for folders
+func CreateFile(c *gin.Context) {
+	var req TouchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
 	}
 
-	if srcInfo.IsDir() {
-		// Create destination directory
-		err = os.MkdirAll(dst, srcInfo.Mode())
-		if err != nil {
-			return err
-		}
-
-		// Copy directory contents
-		entries, err := os.ReadDir(src)
-		if err != nil {
-			return err
-		}
-
-		for _, entry := range entries {
-			srcPath := filepath.Join(src, entry.Name())
-			dstPath := filepath.Join(dst, entry.Name())
-			err = copyRecursive(srcPath, dstPath)
-			if err != nil {
-				return err
-			}
-		}
-	} else {
-		// Copy file
-		srcFile, err := os.Open(src)
-		if err != nil {
-			return err
-		}
-		defer srcFile.Close()
-
-		dstFile, err := os.Create(dst)
-		if err != nil {
-			return err
-		}
-		defer dstFile.Close()
-
-		_, err = dstFile.ReadFrom(srcFile)
-		if err != nil {
-			return err
-		}
-
-		// Copy file permissions
-		err = os.Chmod(dst, srcInfo.Mode())
-		if err != nil {
-			return err
-		}
+	if req.Path == "" || req.Name == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path or name")
+		return
 	}
 
-	return nil
-}
-
-func ReadFile(c *gin.Context) {
-	path := c.Query("path")
-	if path == "" {
-		c.JSON(http.StatusBadRequest, ReadFileResponse{
-			OK:    false,
-			Error: "Missing path parameter",
-		})
+	if err := utils.ValidateFileName(req.Name); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid file name: "+err.Error())
 		return
 	}
 
-	// Safely resolve path
-	safePath, err := utils.SafeResolve(path)
+	// Safely resolve parent path
+	fsys, parentPath, err := utils.ResolveFS(req.Path)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ReadFileResponse{
-			OK:    false,
-			Error: err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid parent path: "+err.Error())
 		return
 	}
 
-	// Check if path exists and is a file
-	fileInfo, err := os.Stat(safePath)
-	if err != nil {
-		c.JSON(http.StatusNotFound, ReadFileResponse{
-			OK:    false,
-			Error: "File not found",
-		})
+	if parentInfo, err := fsys.Stat(parentPath); err != nil || !parentInfo.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Parent directory not found")
 		return
 	}
 
-	if fileInfo.IsDir() {
-		c.JSON(http.StatusBadRequest, ReadFileResponse{
-			OK:    false,
-			Error: "Path is a directory, not a file",
-		})
+	newFilePath := utils.JoinName(fsys, parentPath, req.Name)
+	// The new file counts against the quotas of its directory
+	if !admitQuota(c, displayPath(fsys, newFilePath), 0, 1) {
 		return
 	}
-
-	// Read file content
-	file, err := os.Open(safePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ReadFileResponse{
-			OK:    false,
-			Error: "Failed to open file: " + err.Error(),
-		})
+	unlock, ok := lockPaths(c, utils.WriteLock(newFilePath))
+	if !ok {
 		return
 	}
-	defer file.Close()
+	defer unlock()
 
-	content, err := io.ReadAll(file)
+	// Create the file exclusively so a concurrent create can't be clobbered
+	file, err := fsys.OpenFile(newFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ReadFileResponse{
-			OK:    false,
-			Error: "Failed to read file: " + err.Error(),
-		})
+		if os.IsExist(err) {
+			problem.Respond(c, http.StatusConflict, "File already exists")
+			return
+		}
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create file: "+err.Error())
 		return
 	}
+	file.Close()
 
-	c.JSON(http.StatusOK, ReadFileResponse{
+	invalidateListing(newFilePath)
+	publishChange(c.ClientIP(), events.FileCreated, fsys, newFilePath, nil)
+
+	c.JSON(http.StatusOK, OperationResponse{
 		OK:      true,
-		Content: string(content),
-		Size:    fileInfo.Size(),
-		Mtime:   fileInfo.ModTime().Unix(),
+		Message: "File created successfully",
 	})
 }
 
-// fastDelete implements an optimized delete operation for large files and directories
-func fastDelete(path string) error {
+// fastDelete implements an optimized delete operation for large files and
+// directories. Cancelling ctx stops it between entries, leaving the rest.
+func fastDelete(ctx context.Context, path string) error {
 	info, err := os.Lstat(path)
 	if err != nil {
 		return err
@@ -462,116 +553,110 @@ func fastDelete(path string) error {
 	}
 
 	// For directories, use parallel deletion strategy
-	return fastDeleteDir(path)
+	return fastDeleteDir(ctx, path)
 }
 
-// fastDeleteDir uses parallel workers to delete directory contents quickly
-func fastDeleteDir(dirPath string) error {
+// fastDeleteDir deletes a directory tree in three phases: it walks the tree
+// collecting entries, unlinks all non-directories in parallel, then removes
+// directories deepest first. Every failure is reported; a directory left
+// non-empty by a failed child is not reported again.
+func fastDeleteDir(ctx context.Context, dirPath string) error {
 	// First, try to remove the directory directly (works if empty)
 	if err := os.Remove(dirPath); err == nil {
 		return nil
 	}
 
+	var (
+		errs    []error
+		errsMu  sync.Mutex
+		blocked = make(map[string]bool) // directories that cannot become empty
+	)
+	fail := func(path string, err error) {
+		errsMu.Lock()
+		defer errsMu.Unlock()
+		errs = append(errs, err)
+		blocked[filepath.Dir(path)] = true
+	}
+
+	// Collect entries. WalkDir visits parents before children, so dirs ends
+	// up in an order where reversing it removes children first.
+	var files, dirs []string
+	walkErr := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if d != nil && d.IsDir() {
+				// Unreadable directory: its contents stay, so it can't be removed
+				errs = append(errs, err)
+				blocked[path] = true
+				return filepath.SkipDir
+			}
+			fail(path, err)
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+		} else {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
 	// Get number of CPU cores for optimal parallelism
 	numWorkers := min(runtime.NumCPU(), 8) // Cap at 8 workers to avoid overwhelming the filesystem
 
-	// Channel for work items (paths to delete)
+	// Unlink files in parallel
 	workChan := make(chan string, numWorkers*2)
-	
-	// Error channel to collect any errors
-	errChan := make(chan error, numWorkers)
-	
-	// WaitGroup to wait for all workers to complete
 	var wg sync.WaitGroup
-
-	// Start worker goroutines
 	for range numWorkers {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for path := range workChan {
 				if err := deleteWorker(path); err != nil {
-					select {
-					case errChan <- err:
-					default: // Don't block if error channel is full
-					}
+					fail(path, err)
 				}
 			}
 		}()
 	}
-
-	// Walk directory tree and send work to workers
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		
-		// Skip the root directory itself (we'll delete it last)
-		if path == dirPath {
-			return nil
-		}
-		
-		// Send path to workers
-		select {
-		case workChan <- path:
-		case <-errChan:
-			// Stop if we encounter an error
-			return <-errChan
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
 		}
-		
-		return nil
-	})
-
-	// Close work channel and wait for workers to complete
+		workChan <- path
+	}
 	close(workChan)
 	wg.Wait()
-	close(errChan)
-
-	// Check for any errors from workers
-	if len(errChan) > 0 {
-		return <-errChan
-	}
-
-	// Check for walk errors
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	// Finally, remove the now-empty directory
-	return os.Remove(dirPath)
-}
-
-// deleteWorker handles deletion of individual files/directories
-func deleteWorker(path string) error {
-	info, err := os.Lstat(path)
-	if err != nil {
-		// File might already be deleted by another worker or process
-		if os.IsNotExist(err) {
-			return nil
+	// Remove directories deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := dirs[i]
+		if blocked[path] {
+			if path != dirPath {
+				blocked[filepath.Dir(path)] = true
+			}
+			continue
 		}
-		return err
-	}
-
-	if info.IsDir() {
-		// For directories, try direct removal first (works if empty)
-		if err := os.Remove(path); err == nil {
-			return nil
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fail(path, err)
 		}
-		// If directory is not empty, let the walker handle its contents
-		return nil
 	}
 
-	// For files, use unlink syscall for maximum performance
-	return unlinkFile(path)
+	return errors.Join(errs...)
 }
 
-// unlinkFile uses the fastest available method to delete a file
-func unlinkFile(path string) error {
-	// Try direct syscall first for maximum performance
-	if err := syscall.Unlink(path); err == nil {
-		return nil
+// deleteWorker unlinks a single non-directory entry
+func deleteWorker(path string) error {
+	// For files, use unlink syscall for maximum performance
+	if err := unlinkFile(path); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-	
-	// Fallback to standard library
-	return os.Remove(path)
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes used when a list is paginated
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+// Pagination describes the part of a list a response holds. Clients page
+// either by offset/limit or by page/pageSize; both views are always filled
+// in, pages being counted in steps of limit from offset 0.
+type Pagination struct {
+	Offset     int  `json:"offset"`
+	Limit      int  `json:"limit"`
+	Page       int  `json:"page"`
+	PageSize   int  `json:"pageSize"`
+	TotalItems int  `json:"totalItems"`
+	TotalPages int  `json:"totalPages"`
+	HasMore    bool `json:"hasMore"`
+	HasNext    bool `json:"hasNext"` // same as hasMore
+	HasPrev    bool `json:"hasPrev"`
+	NextOffset *int `json:"nextOffset,omitempty"` // offset of the next part, if any
+}
+
+// pageRequest is the part of a list a client asked for
+type pageRequest struct {
+	offset int
+	limit  int
+}
+
+// parsePageRequest reads offset/limit or, failing those, page/pageSize from
+// the query. Out-of-range values fall back to the defaults. It returns false
+// when the client asked for the whole list.
+func parsePageRequest(c *gin.Context) (pageRequest, bool) {
+	offsetParam, limitParam := c.Query("offset"), c.Query("limit")
+	pageParam, pageSizeParam := c.Query("page"), c.Query("pageSize")
+
+	req := pageRequest{limit: defaultPageSize}
+	switch {
+	case offsetParam != "" || limitParam != "":
+		if val, err := strconv.Atoi(offsetParam); err == nil && val >= 0 {
+			req.offset = val
+		}
+		if val, err := strconv.Atoi(limitParam); err == nil && val > 0 && val <= maxPageSize {
+			req.limit = val
+		}
+	case pageParam != "" || pageSizeParam != "":
+		page := 1
+		if val, err := strconv.Atoi(pageParam); err == nil && val >= 1 {
+			page = val
+		}
+		if val, err := strconv.Atoi(pageSizeParam); err == nil && val > 0 && val <= maxPageSize {
+			req.limit = val
+		}
+		req.offset = (page - 1) * req.limit
+	default:
+		return req, false
+	}
+	return req, true
+}
+
+// paginate returns the requested part of items and its description
+func paginate[T any](items []T, req pageRequest) ([]T, *Pagination) {
+	total := len(items)
+	start := min(req.offset, total)
+	end := min(req.offset+req.limit, total)
+
+	p := &Pagination{
+		Offset:     req.offset,
+		Limit:      req.limit,
+		Page:       req.offset/req.limit + 1,
+		PageSize:   req.limit,
+		TotalItems: total,
+		TotalPages: (total + req.limit - 1) / req.limit,
+		HasMore:    end < total,
+		HasNext:    end < total,
+		HasPrev:    req.offset > 0,
+	}
+	if p.HasMore {
+		p.NextOffset = &end
+	}
+	if items == nil {
+		items = []T{}
+	}
+	return items[start:end], p
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// maxPasteNameTries is how many generated names a paste tries before giving
+// up, when pastes made within the same second take the first ones
+const maxPasteNameTries = 100
+
+// pasteExtensions maps syntax hints to the extensions of pasted files, so
+// that editors and previews highlight them
+var pasteExtensions = map[string]string{
+	"text": "txt", "plain": "txt", "log": "log", "markdown": "md", "md": "md",
+	"json": "json", "yaml": "yaml", "yml": "yaml", "toml": "toml", "ini": "ini", "xml": "xml", "csv": "csv",
+	"html": "html", "css": "css", "javascript": "js", "js": "js", "typescript": "ts", "ts": "ts", "jsx": "jsx", "tsx": "tsx",
+	"go": "go", "python": "py", "py": "py", "ruby": "rb", "rust": "rs", "java": "java", "kotlin": "kt", "swift": "swift",
+	"c": "c", "cpp": "cpp", "c++": "cpp", "csharp": "cs", "c#": "cs", "php": "php", "lua": "lua", "perl": "pl",
+	"shell": "sh", "bash": "sh", "sh": "sh", "powershell": "ps1", "sql": "sql", "diff": "diff", "patch": "diff",
+	"dockerfile": "dockerfile", "makefile": "mk",
+}
+
+// PasteShare asks for a share link to a paste
+type PasteShare struct {
+	Password  string `json:"password,omitempty"`
+	ExpiresIn *int64 `json:"expiresIn,omitempty"` // seconds
+	Title     string `json:"title,omitempty"`
+}
+
+type PasteRequest struct {
+	Path     string      `json:"path"`               // directory to save the paste in
+	Name     string      `json:"name,omitempty"`     // file name; without one it is named after the time and language
+	Content  string      `json:"content"`            // the pasted text
+	Language string      `json:"language,omitempty"` // syntax hint, e.g. go or python
+	Share    *PasteShare `json:"share,omitempty"`    // also share the paste
+}
+
+type PasteResponse struct {
+	OK       bool                 `json:"ok"`
+	Path     string               `json:"path"`
+	Language string               `json:"language,omitempty"`
+	Size     int64                `json:"size"`
+	ETag     string               `json:"etag"`
+	Share    *CreateShareResponse `json:"share,omitempty"`
+}
+
+// CreatePaste saves pasted text as a new file in a directory and, when asked,
+// shares it in the same call. Without a name the file is called
+// paste-<date>-<time>.<extension of the language>.
+func CreatePaste(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, config.WriteMaxSize+64*1024)
+	var req PasteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, "Paste is larger than WRITE_MAX_SIZE")
+			return
+		}
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return
+	}
+	if int64(len(req.Content)) > config.WriteMaxSize {
+		problem.Respond(c, http.StatusRequestEntityTooLarge, "Paste is larger than WRITE_MAX_SIZE")
+		return
+	}
+	language := strings.ToLower(strings.TrimSpace(req.Language))
+	if req.Name != "" {
+		if err := utils.ValidateFileName(req.Name); err != nil {
+			problem.Respond(c, http.StatusBadRequest, "Invalid file name: "+err.Error())
+			return
+		}
+	}
+	if req.Share != nil && !config.Features.Shares {
+		problem.Respond(c, http.StatusBadRequest, "Shares are disabled")
+		return
+	}
+
+	fsys, dir, err := utils.ResolveFS(req.Path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	if info, err := fsys.Stat(dir); err != nil || !info.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Directory not found")
+		return
+	}
+	if req.Share != nil && !vfs.IsLocal(fsys) {
+		problem.Respond(c, http.StatusBadRequest, "Shares are only supported on local storage")
+		return
+	}
+
+	names := []string{req.Name}
+	if req.Name == "" {
+		names = pasteNames(language, time.Now())
+	}
+	if !admitQuota(c, path.Join(req.Path, names[0]), int64(len(req.Content)), 1) {
+		return
+	}
+
+	var target string
+	for _, name := range names {
+		target = utils.JoinName(fsys, dir, name)
+		unlock, ok := lockPaths(c, utils.WriteLock(target))
+		if !ok {
+			return
+		}
+		err = writeNewFile(fsys, target, req.Content)
+		unlock()
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		problem.Respond(c, http.StatusConflict, "File already exists")
+		return
+	case err != nil:
+		problem.Respond(c, http.StatusInternalServerError, "Failed to write paste: "+err.Error())
+		return
+	}
+
+	info, err := fsys.Stat(target)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	invalidateListing(target)
+	publishChange(c.ClientIP(), events.FileCreated, fsys, target, nil)
+
+	response := PasteResponse{
+		OK:       true,
+		Path:     displayPath(fsys, target),
+		Language: language,
+		Size:     info.Size(),
+		ETag:     fileETag(info),
+	}
+	if req.Share != nil {
+		shareReq := CreateShareRequest{
+			Path:      response.Path,
+			Password:  req.Share.Password,
+			ExpiresIn: req.Share.ExpiresIn,
+			Title:     req.Share.Title,
+		}
+		share, ok := createShare(c, shareReq, target, info)
+		if !ok {
+			return
+		}
+		response.Share = &share
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// pasteNames are the names a paste without one tries in turn
+func pasteNames(language string, now time.Time) []string {
+	ext, ok := pasteExtensions[language]
+	if !ok {
+		ext = "txt"
+	}
+	base := "paste-" + now.Format("20060102-150405")
+	names := make([]string, maxPasteNameTries)
+	names[0] = base + "." + ext
+	for i := 1; i < len(names); i++ {
+		names[i] = fmt.Sprintf("%s-%d.%s", base, i+1, ext)
+	}
+	return names
+}
+
+// writeNewFile creates a file with content, failing with fs.ErrExist if it
+// already exists
+func writeNewFile(fsys vfs.Filesystem, name, content string) error {
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write([]byte(content)); err != nil {
+		file.Close()
+		_ = fsys.Remove(name)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = fsys.Remove(name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+)
+
+// maxPins is how many directories a user can pin
+const maxPins = 100
+
+type PinRequest struct {
+	Path string `json:"path"`
+}
+
+type SetPinsRequest struct {
+	Paths []string `json:"paths"`
+}
+
+type PinsResponse struct {
+	OK   bool     `json:"ok"`
+	Pins []string `json:"pins"`
+}
+
+// FrequentDirectories returns the directories the user lists most often,
+// skipping those that no longer exist. ?limit= caps how many (default 10,
+// at most 100).
+func FrequentDirectories(c *gin.Context) {
+	limit := 10
+	if val, err := strconv.Atoi(c.Query("limit")); err == nil && val > 0 && val <= 100 {
+		limit = val
+	}
+
+	visits, err := metadata.Frequent(middleware.User(c))
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read visits: "+err.Error())
+		return
+	}
+
+	frequent := make([]metadata.Visit, 0, limit)
+	for _, v := range visits {
+		if len(frequent) == limit {
+			break
+		}
+		if isDirectory(v.Path) {
+			frequent = append(frequent, v)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":          true,
+		"directories": frequent,
+	})
+}
+
+// ListPins returns the directories the user pinned, in their order
+func ListPins(c *gin.Context) {
+	pins, err := metadata.Pins(middleware.User(c))
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read pins: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, PinsResponse{OK: true, Pins: orEmpty(pins)})
+}
+
+// AddPin pins a directory at the end of the user's pins
+func AddPin(c *gin.Context) {
+	var req PinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	dir, ok := resolvePin(c, req.Path)
+	if !ok {
+		return
+	}
+	updatePins(c, func(pins []string) []string {
+		if slices.Contains(pins, dir) {
+			return pins
+		}
+		return append(pins, dir)
+	})
+}
+
+// SetPins replaces the user's pins, such as to reorder them; an empty list
+// removes them all
+func SetPins(c *gin.Context) {
+	var req SetPinsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var dirs []string
+	for _, p := range req.Paths {
+		dir, ok := resolvePin(c, p)
+		if !ok {
+			return
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	updatePins(c, func([]string) []string {
+		return dirs
+	})
+}
+
+// RemovePin unpins ?path=. Directories that no longer exist can be unpinned.
+func RemovePin(c *gin.Context) {
+	if c.Query("path") == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return
+	}
+	dir := metadata.Clean(c.Query("path"))
+	updatePins(c, func(pins []string) []string {
+		return slices.DeleteFunc(pins, func(pin string) bool {
+			return pin == dir
+		})
+	})
+}
+
+// updatePins changes the user's pins and answers with the result
+func updatePins(c *gin.Context, change func(pins []string) []string) {
+	var tooMany bool
+	pins, err := metadata.UpdatePins(middleware.User(c), func(pins []string) []string {
+		changed := change(pins)
+		if len(changed) > maxPins {
+			tooMany = true
+			return pins
+		}
+		return changed
+	})
+	if tooMany {
+		problem.Respond(c, http.StatusBadRequest, "Too many pins")
+		return
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to update pins: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, PinsResponse{OK: true, Pins: orEmpty(pins)})
+}
+
+// resolvePin returns the clean user path of a directory to pin, answering
+// the request itself when it can't be pinned
+func resolvePin(c *gin.Context, userPath string) (string, bool) {
+	fsys, name, ok := resolveMetadataPath(c, userPath)
+	if !ok {
+		return "", false
+	}
+	if info, err := fsys.Stat(name); err != nil || !info.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Only directories can be pinned")
+		return "", false
+	}
+	return metadata.Clean(displayPath(fsys, name)), true
+}
+
+// isDirectory reports whether a user path names an existing directory
+func isDirectory(userPath string) bool {
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		return false
+	}
+	info, err := fsys.Stat(name)
+	return err == nil && info.IsDir()
+}
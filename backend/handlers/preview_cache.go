@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+)
+
+// previewTouchInterval is how stale the modification time of a cached preview
+// may get before a hit updates it, so that the order of use survives restarts
+// without a write on every hit
+const previewTouchInterval = time.Hour
+
+// previewCache indexes the previews kept in DATA_DIR/thumbnails. They are
+// laid out like the tree, <dir>/<name hash>-<version hash>.jpg, so the
+// previews of a path can be found without reading them. When the cache grows
+// past its maximum size the least recently used previews are removed until it
+// is down to nine tenths of it.
+var previewCache = &previewIndex{maxSize: config.PreviewCacheMaxSize}
+
+type previewIndex struct {
+	once    sync.Once
+	mu      sync.Mutex
+	entries map[string]*previewEntry // by file path
+	total   int64
+	maxSize int64
+}
+
+type previewEntry struct {
+	size int64
+	used time.Time
+}
+
+// PreviewCacheStatus is the size of the preview cache
+type PreviewCacheStatus struct {
+	Path    string `json:"path"`
+	Files   int    `json:"files"`
+	Size    int64  `json:"size"`
+	MaxSize int64  `json:"maxSize"` // 0 for no limit
+}
+
+type PreviewCacheRequest struct {
+	MaxSize *int64 `json:"maxSize"`
+}
+
+// GetPreviewCache reports the size of the preview cache
+func GetPreviewCache(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"ok": true, "cache": previewCache.status()})
+}
+
+// SetPreviewCache changes the maximum size of the preview cache until the
+// server restarts, evicting previews right away if it is over it
+func SetPreviewCache(c *gin.Context) {
+	var req PreviewCacheRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.MaxSize == nil || *req.MaxSize < 0 {
+		problem.Respond(c, http.StatusBadRequest, "maxSize must be a size in bytes, 0 for no limit")
+		return
+	}
+	previewCache.setMaxSize(*req.MaxSize)
+	c.JSON(http.StatusOK, gin.H{"ok": true, "cache": previewCache.status()})
+}
+
+// PurgePreviewCache removes the cached previews of the file or directory at
+// ?path=, including those of everything below a directory, or the whole
+// cache without one
+func PurgePreviewCache(c *gin.Context) {
+	var removed int
+	var freed int64
+	if p := c.Query("path"); p != "" {
+		removed, freed = previewCache.purge(path.Clean("/" + p))
+	} else {
+		removed, freed = previewCache.purgeAll()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ok":      true,
+		"message": fmt.Sprintf("Removed %d previews, %d bytes", removed, freed),
+		"removed": removed,
+		"freed":   freed,
+	})
+}
+
+func previewCacheRoot() string {
+	return filepath.Join(config.DataDir, "thumbnails")
+}
+
+// previewPath is where a preview of the file at userPath is cached; version
+// tells apart the previews of different contents and sizes
+func previewPath(userPath, version string) string {
+	versionSum := sha256.Sum256([]byte(userPath + "\x00" + version))
+	return filepath.Join(previewDir(userPath), previewNameHash(path.Base(userPath))+"-"+hex.EncodeToString(versionSum[:8])+".jpg")
+}
+
+func previewDir(userPath string) string {
+	return filepath.Join(previewCacheRoot(), filepath.FromSlash(path.Dir(userPath)))
+}
+
+func previewNameHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:8])
+}
+
+// load indexes what is on disk the first time the cache is used
+func (x *previewIndex) load() {
+	x.once.Do(func() {
+		x.entries = make(map[string]*previewEntry)
+		_ = filepath.WalkDir(previewCacheRoot(), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(p, ".jpg") {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				x.entries[p] = &previewEntry{size: info.Size(), used: info.ModTime()}
+				x.total += info.Size()
+			}
+			return nil
+		})
+		x.evict()
+	})
+}
+
+// get returns a cached preview, marking it as used
+func (x *previewIndex) get(p string) ([]byte, bool) {
+	x.load()
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+	now := time.Now()
+	x.mu.Lock()
+	entry := x.entries[p]
+	if entry == nil {
+		entry = &previewEntry{size: int64(len(data))}
+		x.entries[p] = entry
+		x.total += entry.size
+	}
+	touch := now.Sub(entry.used) > previewTouchInterval
+	entry.used = now
+	x.mu.Unlock()
+	if touch {
+		_ = os.Chtimes(p, now, now)
+	}
+	return data, true
+}
+
+// put stores a preview, whole or not at all, evicting others to make room
+func (x *previewIndex) put(p string, data []byte) error {
+	x.load()
+	if err := writeThumbnail(p, data); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if old := x.entries[p]; old != nil {
+		x.total -= old.size
+	}
+	x.entries[p] = &previewEntry{size: int64(len(data)), used: time.Now()}
+	x.total += int64(len(data))
+	x.evict()
+	return nil
+}
+
+// evict removes the least recently used previews while the cache is over its
+// maximum size. It is called with the lock held.
+func (x *previewIndex) evict() {
+	if x.maxSize <= 0 || x.total <= x.maxSize {
+		return
+	}
+	paths := make([]string, 0, len(x.entries))
+	for p := range x.entries {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return x.entries[paths[i]].used.Before(x.entries[paths[j]].used) })
+
+	target := x.maxSize / 10 * 9
+	removed := 0
+	for _, p := range paths {
+		if x.total <= target {
+			break
+		}
+		x.remove(p)
+		removed++
+	}
+	slog.Info("Evicted previews from the cache", "removed", removed, "size", x.total)
+}
+
+// remove deletes a preview and the directories it leaves empty. It is called
+// with the lock held.
+func (x *previewIndex) remove(p string) {
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove cached preview", "path", p, "error", err)
+		return
+	}
+	x.total -= x.entries[p].size
+	delete(x.entries, p)
+	root := previewCacheRoot()
+	for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+func (x *previewIndex) purge(userPath string) (int, int64) {
+	if userPath == "/" {
+		return x.purgeAll()
+	}
+	x.load()
+	dir := filepath.Join(previewCacheRoot(), filepath.FromSlash(userPath))
+	filePrefix := filepath.Join(previewDir(userPath), previewNameHash(path.Base(userPath))+"-")
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	removed, before := 0, x.total
+	for p := range x.entries {
+		if strings.HasPrefix(p, dir+string(filepath.Separator)) || strings.HasPrefix(p, filePrefix) {
+			x.remove(p)
+			removed++
+		}
+	}
+	return removed, before - x.total
+}
+
+func (x *previewIndex) purgeAll() (int, int64) {
+	x.load()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	removed, freed := len(x.entries), x.total
+	if err := os.RemoveAll(previewCacheRoot()); err != nil {
+		slog.Warn("Failed to purge the preview cache", "error", err)
+	}
+	x.entries = make(map[string]*previewEntry)
+	x.total = 0
+	return removed, freed
+}
+
+func (x *previewIndex) setMaxSize(size int64) {
+	x.load()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.maxSize = size
+	x.evict()
+}
+
+func (x *previewIndex) status() PreviewCacheStatus {
+	x.load()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return PreviewCacheStatus{Path: previewCacheRoot(), Files: len(x.entries), Size: x.total, MaxSize: x.maxSize}
+}
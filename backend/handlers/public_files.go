@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+)
+
+// ServePublicFile serves the file at a listing's url, under /files, so the
+// server works without nginx in front. Files are shown inline with a type
+// from their extension, or from their first bytes when it has none they
+// are known by. Ranges and conditional requests against the file's ETag
+// are answered as for downloads. Served files are sandboxed, so that an
+// HTML file can't run scripts on the server's origin.
+func ServePublicFile(c *gin.Context) {
+	userPath := c.Param("path")
+	fsys, safePath, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := fsys.Stat(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	if info.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Directories are listed with /api/fs/list")
+		return
+	}
+
+	file, err := fsys.Open(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer file.Close()
+
+	filename := filepath.Base(safePath)
+	if mediaType := mime.TypeByExtension(path.Ext(filename)); mediaType != "" {
+		c.Header("Content-Type", mediaType)
+	}
+	if value := mime.FormatMediaType("inline", map[string]string{"filename": filename}); value != "" {
+		c.Header("Content-Disposition", value)
+	}
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("ETag", fileETag(info))
+	// Cached copies are checked against the ETag before they are used
+	c.Header("Cache-Control", "public, no-cache")
+
+	// ServeContent sniffs the type when it isn't set, and answers ranges and
+	// If-None-Match, If-Match and If-Range against the ETag
+	http.ServeContent(c.Writer, c.Request, filename, info.ModTime(), file)
+}
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// quotaUsage is what a directory with a quota held when it was last walked,
+// plus what was admitted into it since
+type quotaUsage struct {
+	bytes    int64
+	files    int64
+	measured time.Time
+}
+
+var (
+	quotaMu     sync.Mutex
+	quotaUsages = make(map[string]*quotaUsage)
+	quotaGroup  singleflight.Group
+	quotaFollow sync.Once
+)
+
+// QuotaStatus is a quota with the usage it was last measured at
+type QuotaStatus struct {
+	metadata.Quota
+	UsedBytes  int64 `json:"usedBytes"`
+	UsedFiles  int64 `json:"usedFiles"`
+	MeasuredAt int64 `json:"measuredAt"`
+}
+
+type QuotaRequest struct {
+	Path     string `json:"path"`
+	MaxBytes int64  `json:"maxBytes"`
+	MaxFiles int64  `json:"maxFiles"`
+}
+
+// ListQuotas returns every quota with the usage of its directory, walking
+// those whose usage is older than QUOTA_USAGE_TTL, or all with ?refresh=true
+func ListQuotas(c *gin.Context) {
+	quotas, err := metadata.Quotas()
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	refresh := c.Query("refresh") == "true"
+	statuses := make([]QuotaStatus, 0, len(quotas))
+	for _, q := range quotas {
+		if refresh {
+			forgetQuotaUsage(q.Path)
+		}
+		u, err := measureQuotaUsage(q.Path)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to measure "+q.Path+": "+err.Error())
+			return
+		}
+		statuses = append(statuses, quotaStatus(q, u))
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "quotas": statuses})
+}
+
+// SetQuota caps the bytes and files below a directory, replacing its quota.
+// What is there already counts; a directory over its new quota takes nothing
+// more until enough is removed.
+func SetQuota(c *gin.Context) {
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return
+	}
+	if req.MaxBytes < 0 || req.MaxFiles < 0 || req.MaxBytes == 0 && req.MaxFiles == 0 {
+		problem.Respond(c, http.StatusBadRequest, "maxBytes or maxFiles must be positive")
+		return
+	}
+	fsys, name, err := utils.ResolveFS(req.Path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Directory not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat directory: "+err.Error())
+		}
+		return
+	}
+	if !info.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a directory")
+		return
+	}
+
+	q := metadata.Quota{Path: metadata.Clean(req.Path), MaxBytes: req.MaxBytes, MaxFiles: req.MaxFiles}
+	if err := metadata.SetQuota(q); err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	u, err := measureQuotaUsage(q.Path)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to measure "+q.Path+": "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "quota": quotaStatus(q, u)})
+}
+
+// DeleteQuota removes the quota of the directory at ?path=
+func DeleteQuota(c *gin.Context) {
+	p := c.Query("path")
+	if p == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+	found, err := metadata.DeleteQuota(p)
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		problem.Respond(c, http.StatusNotFound, "The directory has no quota")
+		return
+	}
+	forgetQuotaUsage(metadata.Clean(p))
+	c.JSON(http.StatusOK, gin.H{"ok": true, "message": "Quota removed"})
+}
+
+func quotaStatus(q metadata.Quota, u quotaUsage) QuotaStatus {
+	return QuotaStatus{Quota: q, UsedBytes: u.bytes, UsedFiles: u.files, MeasuredAt: u.measured.UnixMilli()}
+}
+
+// admitQuota checks that adding bytes and files at the user path p keeps the
+// quotas of the directories above it within their limits, and counts them
+// against those quotas. Otherwise it answers 507 and returns false.
+func admitQuota(c *gin.Context, p string, bytes, files int64) bool {
+	return admitQuotas(c, quotasOver(p), bytes, files)
+}
+
+// admitTree checks the quotas above destination for what copying or moving
+// the tree at source adds to them, walking the source only when a quota
+// applies. A move within a quota's directory adds nothing to it. Files an
+// overwrite would replace still count, so a merge may be refused that would
+// have fit.
+func admitTree(c *gin.Context, srcFS vfs.Filesystem, srcPath, source, destination string, move bool) bool {
+	quotas := quotasOver(destination)
+	if move {
+		source = metadata.Clean(source)
+		kept := quotas[:0]
+		for _, q := range quotas {
+			if !quotaCovers(q.Path, source) {
+				kept = append(kept, q)
+			}
+		}
+		quotas = kept
+	}
+	if len(quotas) == 0 {
+		return true
+	}
+	files, bytes, err := countFiles(c.Request.Context(), srcFS, srcPath)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to measure source: "+err.Error())
+		return false
+	}
+	return admitQuotas(c, quotas, bytes, files)
+}
+
+func admitQuotas(c *gin.Context, quotas []metadata.Quota, bytes, files int64) bool {
+	if err := reserveQuotas(quotas, bytes, files); err != nil {
+		problem.Respond(c, http.StatusInsufficientStorage, err.Error(), gin.H{"quota": err.status})
+		return false
+	}
+	return true
+}
+
+// quotaExceededError is the quota a reservation would have gone over
+type quotaExceededError struct {
+	status QuotaStatus
+}
+
+func (e *quotaExceededError) Error() string {
+	return "Quota of " + e.status.Path + " exceeded"
+}
+
+// reserveQuotas counts bytes and files against quotas if all of them have
+// room for them
+func reserveQuotas(quotas []metadata.Quota, bytes, files int64) *quotaExceededError {
+	if len(quotas) == 0 {
+		return nil
+	}
+	// Measured before locking, as walking a directory takes a while
+	for _, q := range quotas {
+		if _, err := measureQuotaUsage(q.Path); err != nil {
+			slog.Warn("Failed to measure quota usage", "path", q.Path, "error", err)
+		}
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	for _, q := range quotas {
+		u := quotaUsages[q.Path]
+		if u == nil {
+			continue
+		}
+		if q.MaxBytes > 0 && u.bytes+bytes > q.MaxBytes || q.MaxFiles > 0 && u.files+files > q.MaxFiles {
+			return &quotaExceededError{status: quotaStatus(q, *u)}
+		}
+	}
+	for _, q := range quotas {
+		if u := quotaUsages[q.Path]; u != nil {
+			u.bytes += bytes
+			u.files += files
+		}
+	}
+	return nil
+}
+
+// quotasOver returns the quotas of p and the directories above it. Without
+// the metadata store there are none to enforce.
+func quotasOver(p string) []metadata.Quota {
+	quotas, err := metadata.Quotas()
+	if err != nil {
+		return nil
+	}
+	p = metadata.Clean(p)
+	var over []metadata.Quota
+	for _, q := range quotas {
+		if quotaCovers(q.Path, p) {
+			over = append(over, q)
+		}
+	}
+	return over
+}
+
+// quotaCovers reports whether the clean user path p is dir or below it
+func quotaCovers(dir, p string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// measureQuotaUsage returns the usage of a directory with a quota, walking it
+// when its usage is unknown or older than QUOTA_USAGE_TTL
+func measureQuotaUsage(p string) (quotaUsage, error) {
+	quotaFollow.Do(func() { go followQuotaChanges() })
+
+	quotaMu.Lock()
+	u := quotaUsages[p]
+	if u != nil && time.Since(u.measured) < config.QuotaUsageTTL {
+		current := *u
+		quotaMu.Unlock()
+		return current, nil
+	}
+	quotaMu.Unlock()
+
+	measured, err, _ := quotaGroup.Do(p, func() (any, error) {
+		fsys, name, err := utils.ResolveFS(p)
+		if err != nil {
+			return nil, err
+		}
+		files, bytes, err := countFiles(context.Background(), fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		u := &quotaUsage{bytes: bytes, files: files, measured: time.Now()}
+		quotaMu.Lock()
+		quotaUsages[p] = u
+		quotaMu.Unlock()
+		return *u, nil
+	})
+	if err != nil {
+		return quotaUsage{}, err
+	}
+	return measured.(quotaUsage), nil
+}
+
+func forgetQuotaUsage(p string) {
+	quotaMu.Lock()
+	delete(quotaUsages, p)
+	quotaMu.Unlock()
+}
+
+// followQuotaChanges forgets the usage of directories that files were deleted
+// from, restored into or moved into or out of, so that the next check walks
+// them again
+// instead of waiting out QUOTA_USAGE_TTL
+func followQuotaChanges() {
+	filter := events.Filter{Types: []string{events.FileMoved, events.FileDeleted, events.FileRestored}}
+	var last int64
+	for {
+		sub, missed, _ := events.Subscribe(filter, last)
+		for _, e := range missed {
+			forgetQuotaUsages(e)
+			last = e.ID
+		}
+		for e := range sub.C {
+			forgetQuotaUsages(e)
+			last = e.ID
+		}
+	}
+}
+
+func forgetQuotaUsages(e events.Event) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	for dir := range quotaUsages {
+		if quotaCovers(dir, metadata.Clean(e.Path)) || e.Destination != "" && quotaCovers(dir, metadata.Clean(e.Destination)) {
+			delete(quotaUsages, dir)
+		}
+	}
+}
+
+// countFiles counts the files below root and their bytes. Directories, and
+// what can't be read, aren't counted; a missing root holds nothing.
+func countFiles(ctx context.Context, fsys vfs.Filesystem, root string) (files, bytes int64, err error) {
+	err = vfs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		files++
+		if info, err := d.Info(); err == nil {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("walking %s: %w", displayPath(fsys, root), err)
+	}
+	return files, bytes, nil
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+)
+
+// Encodings of ReadFileResponse.Content
+const (
+	contentText   = "text"
+	contentBase64 = "base64"
+)
+
+type ReadFileResponse struct {
+	OK       bool   `json:"ok"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`          // "text" or "base64"
+	Charset  string `json:"charset,omitempty"` // detected character set, empty for binary data
+	Binary   bool   `json:"binary"`
+	Offset   int64  `json:"offset"` // first byte returned
+	Length   int64  `json:"length"` // number of bytes returned
+	Size     int64  `json:"size"`
+	Mtime    int64  `json:"mtime"`
+	ETag     string `json:"etag"`
+}
+
+// ReadFile returns the contents of the file at ?path=. UTF-8 text comes back
+// as is; anything else, or everything with ?encoding=base64, is base64
+// encoded. Files over READ_MAX_SIZE must be read in parts with
+// ?range=start-end (inclusive), start- or -n for the last n bytes; a longer
+// range is shortened to READ_MAX_SIZE.
+func ReadFile(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+
+	encoding := c.DefaultQuery("encoding", contentText)
+	if encoding != contentText && encoding != contentBase64 {
+		problem.Respond(c, http.StatusBadRequest, "Invalid encoding: "+encoding)
+		return
+	}
+
+	// Safely resolve path
+	fsys, safePath, err := utils.ResolveFS(path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Check if path exists and is a file
+	fileInfo, err := fsys.Stat(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+
+	if fileInfo.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Path is a directory, not a file")
+		return
+	}
+
+	size := fileInfo.Size()
+	offset, length := int64(0), size
+	if spec := c.Query("range"); spec != "" {
+		offset, length, err = parseReadRange(spec, size)
+		if err != nil {
+			problem.Respond(c, http.StatusRequestedRangeNotSatisfiable, "Invalid range: "+err.Error(), gin.H{"size": size})
+			return
+		}
+		length = min(length, config.ReadMaxSize)
+	} else if size > config.ReadMaxSize {
+		problem.Respond(c, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("File is larger than %d bytes; read it in parts with the range parameter", config.ReadMaxSize),
+			gin.H{"size": size})
+		return
+	}
+
+	// Read file content
+	file, err := fsys.Open(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to open file: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(io.NewSectionReader(file, offset, length))
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read file: "+err.Error())
+		return
+	}
+
+	// A range may split a character at either end. Text is returned in whole
+	// characters; offset and length say which bytes that was.
+	cutStart, cutEnd := offset > 0, offset+int64(len(content)) < size
+	charset, binary := detectCharset(content, cutStart, cutEnd)
+	if charset == "utf-8" && encoding == contentText {
+		head, body := trimPartialRunes(content, cutStart, cutEnd)
+		offset += int64(head)
+		content = body
+	} else {
+		encoding = contentBase64
+	}
+
+	text := string(content)
+	if encoding == contentBase64 {
+		text = base64.StdEncoding.EncodeToString(content)
+	}
+
+	// Editors send the ETag back with If-Match when saving
+	etag := fileETag(fileInfo)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, ReadFileResponse{
+		OK:       true,
+		Content:  text,
+		Encoding: encoding,
+		Charset:  charset,
+		Binary:   binary,
+		Offset:   offset,
+		Length:   int64(len(content)),
+		Size:     size,
+		Mtime:    fileInfo.ModTime().Unix(),
+		ETag:     etag,
+	})
+}
+
+// parseReadRange parses "start-end" (inclusive), "start-" or "-n" against a
+// file of the given size, returning the offset and length to read
+func parseReadRange(spec string, size int64) (int64, int64, error) {
+	invalid := errors.New("expected start-end, start- or -n")
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, invalid
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, invalid
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, invalid
+	}
+	if start >= size && !(start == 0 && size == 0) {
+		return 0, 0, errors.New("starts beyond end of file")
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, invalid
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// detectCharset guesses the character set of data from a byte order mark,
+// UTF-8 validity and the bytes used; data with NUL bytes or other control
+// characters is binary. cutStart and cutEnd say whether data was cut out of
+// a larger file at either end.
+func detectCharset(data []byte, cutStart, cutEnd bool) (string, bool) {
+	if !cutStart {
+		switch {
+		case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+			return "utf-16le", false
+		case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+			return "utf-16be", false
+		}
+	}
+
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\v' && b != 0x1B {
+			return "", true
+		}
+	}
+	if _, body := trimPartialRunes(data, cutStart, cutEnd); utf8.Valid(body) {
+		return "utf-8", false
+	}
+	return "iso-8859-1", false
+}
+
+// trimPartialRunes drops the bytes of characters split by a cut at the start
+// or end of data, returning how many bytes were dropped from the start and
+// what is left
+func trimPartialRunes(data []byte, cutStart, cutEnd bool) (int, []byte) {
+	head := 0
+	if cutStart {
+		for head < len(data) && head < utf8.UTFMax-1 && !utf8.RuneStart(data[head]) {
+			head++
+		}
+	}
+	data = data[head:]
+	if cutEnd {
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	return head, data
+}
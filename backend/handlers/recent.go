@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"container/heap"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+)
+
+// maxRecentScan bounds how many entries a single recent-files request walks
+const maxRecentScan = 200000
+
+type RecentItem struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+	URL   string `json:"url"`
+}
+
+type RecentResponse struct {
+	OK        bool         `json:"ok"`
+	Path      string       `json:"path"`
+	Items     []RecentItem `json:"items"`
+	Scanned   int          `json:"scanned"`
+	Truncated bool         `json:"truncated"` // true when the walk stopped at maxRecentScan
+}
+
+// recentHeap is a min-heap on mtime holding the newest files seen so far
+type recentHeap []RecentItem
+
+func (h recentHeap) Len() int           { return len(h) }
+func (h recentHeap) Less(i, j int) bool { return h[i].MTime < h[j].MTime }
+func (h recentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *recentHeap) Push(x any)        { *h = append(*h, x.(RecentItem)) }
+func (h *recentHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// RecentFiles returns the most recently modified files in a subtree
+func RecentFiles(c *gin.Context) {
+	userPath := c.DefaultQuery("path", "/")
+
+	limit := 50
+	if val, err := strconv.Atoi(c.Query("limit")); err == nil && val > 0 && val <= 500 {
+		limit = val
+	}
+
+	// Safely resolve path
+	safePath, err := utils.SafeResolve(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !utils.IsDirectory(safePath) {
+		problem.Respond(c, http.StatusNotFound, "Directory not found")
+		return
+	}
+
+	newest := &recentHeap{}
+	scanned := 0
+	truncated := false
+
+	// Walk the subtree, skipping hidden entries like the listing does
+	err = filepath.WalkDir(safePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip unreadable entries instead of failing the whole walk
+			if d != nil && d.IsDir() && path != safePath {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if path != safePath && utils.Hidden(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		scanned++
+		if scanned > maxRecentScan {
+			truncated = true
+			return filepath.SkipAll
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		mtime := info.ModTime().UnixMilli()
+		if newest.Len() == limit && mtime <= (*newest)[0].MTime {
+			return nil
+		}
+
+		rel, err := filepath.Rel(safePath, path)
+		if err != nil {
+			return nil
+		}
+		itemPath := filepath.ToSlash(filepath.Join(userPath, rel))
+
+		heap.Push(newest, RecentItem{
+			Path:  itemPath,
+			Name:  d.Name(),
+			Size:  info.Size(),
+			MTime: mtime,
+			URL:   utils.BuildPublicFileURL(itemPath),
+		})
+		if newest.Len() > limit {
+			heap.Pop(newest)
+		}
+		return nil
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to scan directory: "+err.Error())
+		return
+	}
+
+	// Newest first
+	items := append([]RecentItem{}, *newest...)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].MTime > items[j].MTime
+	})
+
+	c.JSON(http.StatusOK, RecentResponse{
+		OK:        true,
+		Path:      userPath,
+		Items:     items,
+		Scanned:   scanned,
+		Truncated: truncated,
+	})
+}
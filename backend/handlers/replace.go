@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// replacedSuffix ends the names of entries kept aside while something
+// replaces them. One left behind by a crash is the old entry, intact.
+const replacedSuffix = ".replaced"
+
+// replaced is a local entry being replaced, kept under a hidden name next to
+// it until the replacement is in place. A kept entry is moved aside, as
+// overwriting copies do; a linked file stays where it is while a hard link
+// holds on to its contents, for writes that swap the new file in
+// atomically. settle puts a kept entry back when the replacement failed and
+// otherwise discards it, into the trash with TRASH_REPLACED. A nil replaced
+// has nothing to settle.
+type replaced struct {
+	dst, aside, userPath string
+	linked               bool
+}
+
+// keepReplaced moves the local entry at dst aside so something can take its
+// place without losing it before the replacement is complete
+func keepReplaced(fsys vfs.Filesystem, dst string) (*replaced, error) {
+	r := &replaced{dst: dst, aside: asideName(dst), userPath: displayPath(fsys, dst)}
+	if err := os.Rename(dst, r.aside); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// linkReplaced holds on to the file an atomic write to dst is about to
+// replace, so that it can go to the trash. It is nil unless TRASH_REPLACED
+// keeps replaced files and dst is a local file the trash can hold.
+func linkReplaced(fsys vfs.Filesystem, dst string) *replaced {
+	if !config.TrashReplaced || !useTrash(fsys, dst) {
+		return nil
+	}
+	if info, err := os.Lstat(dst); err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	r := &replaced{dst: dst, aside: asideName(dst), userPath: displayPath(fsys, dst), linked: true}
+	if err := os.Link(dst, r.aside); err != nil {
+		// Such as on a filesystem without hard links; the file is replaced
+		// as it would be without the trash
+		return nil
+	}
+	return r
+}
+
+// asideName is a free hidden name next to dst
+func asideName(dst string) string {
+	base := filepath.Base(dst)
+	if len(base) > 200 {
+		base = base[:200]
+	}
+	suffix := make([]byte, 6)
+	_, _ = rand.Read(suffix)
+	return filepath.Join(filepath.Dir(dst), "."+base+"."+hex.EncodeToString(suffix)+replacedSuffix)
+}
+
+// settle finishes a replacement that ended with err
+func (r *replaced) settle(err error) {
+	if r == nil {
+		return
+	}
+	if err != nil {
+		r.restore()
+		return
+	}
+	if config.TrashReplaced && useTrash(vfs.OS, r.dst) {
+		if _, err := moveToTrashAs(r.aside, r.userPath); err == nil {
+			return
+		}
+	}
+	if err := fastDelete(context.Background(), r.aside); err != nil {
+		slog.Warn("Failed to remove replaced entry", "path", r.aside, "error", err)
+	}
+}
+
+// restore puts a kept entry back in place of what was written over it; a
+// linked file was never taken away
+func (r *replaced) restore() {
+	if r.linked {
+		_ = os.Remove(r.aside)
+		return
+	}
+	if _, err := os.Lstat(r.dst); err == nil {
+		if err := fastDelete(context.Background(), r.dst); err != nil {
+			slog.Warn("Failed to remove failed replacement", "path", r.dst, "error", err)
+			return
+		}
+	}
+	if err := os.Rename(r.aside, r.dst); err != nil {
+		slog.Warn("Failed to restore replaced entry", "path", r.dst, "kept", r.aside, "error", err)
+	}
+}
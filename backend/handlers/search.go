@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+const (
+	defaultSearchLimit = 1000
+	maxSearchLimit     = 10000
+
+	// searchProgressInterval is how often a search reports how far its walk
+	// has got
+	searchProgressInterval = 500 * time.Millisecond
+)
+
+// SearchMatch is an entry whose name matched a search
+type SearchMatch struct {
+	Path  string `json:"path"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+}
+
+// SearchEvent is one line of a search's NDJSON stream, or one server-sent
+// event: a "match", "progress" while the walk goes on, and "done" at the
+// end, which is "truncated" when the limit stopped the walk
+type SearchEvent struct {
+	Type      string       `json:"type"`
+	Match     *SearchMatch `json:"match,omitempty"`
+	Scanned   int          `json:"scanned"`
+	Matches   int          `json:"matches"`
+	Truncated bool         `json:"truncated,omitempty"`
+}
+
+// SearchFiles walks a subtree for names matching ?q=, a case-insensitive
+// substring or glob, and streams each match as it is found, so the first
+// results show while a large tree is still being walked. Results are NDJSON,
+// or server-sent events for clients that accept text/event-stream.
+func SearchFiles(c *gin.Context) {
+	userPath := c.DefaultQuery("path", "/")
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if query == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing search query")
+		return
+	}
+	glob := strings.ContainsAny(query, "*?[")
+	if _, err := path.Match(query, ""); glob && err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid pattern: "+err.Error())
+		return
+	}
+	entryType := c.Query("type")
+	if entryType != "" && entryType != "file" && entryType != "dir" {
+		problem.Respond(c, http.StatusBadRequest, "type must be file or dir")
+		return
+	}
+	limit := defaultSearchLimit
+	if val, err := strconv.Atoi(c.Query("limit")); err == nil && val > 0 {
+		limit = min(val, maxSearchLimit)
+	}
+	showHidden, ok := parseShowHidden(c)
+	if !ok {
+		return
+	}
+
+	fsys, safePath, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info, err := fsys.Stat(safePath); err != nil || !info.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Directory not found")
+		return
+	}
+
+	sse := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // don't let nginx hold results back
+	c.Status(http.StatusOK)
+
+	send := func(e SearchEvent) bool {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return true
+		}
+		if sse {
+			_, err = c.Writer.WriteString("event: " + e.Type + "\ndata: " + string(data) + "\n\n")
+		} else {
+			_, err = c.Writer.Write(append(data, '\n'))
+		}
+		c.Writer.Flush()
+		return err == nil
+	}
+
+	ctx := c.Request.Context()
+	scanned, matches := 0, 0
+	truncated := false
+	lastProgress := time.Now()
+	// The index spares walking local directories once it is loaded or built
+	walk := func(root string, fn fs.WalkDirFunc) error { return vfs.WalkDir(fsys, root, fn) }
+	if vfs.IsLocal(fsys) && searchIndex.ready() {
+		walk = walkSearchIndex
+	}
+	_ = walk(safePath, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Skip unreadable entries instead of failing the whole walk
+			if d != nil && d.IsDir() && name != safePath {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if name == safePath {
+			return nil
+		}
+		if !showHidden && utils.Hidden(d.Name()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		scanned++
+		if scanned%1024 == 0 && time.Since(lastProgress) >= searchProgressInterval {
+			lastProgress = time.Now()
+			if !send(SearchEvent{Type: "progress", Scanned: scanned, Matches: matches}) {
+				return fs.SkipAll
+			}
+		}
+
+		if !searchMatches(strings.ToLower(d.Name()), query, glob) {
+			return nil
+		}
+		if entryType != "" && (entryType == "dir") != d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if matches == limit {
+			truncated = true
+			return fs.SkipAll
+		}
+		matches++
+
+		rel, err := filepath.Rel(safePath, name)
+		if err != nil {
+			return nil
+		}
+		match := &SearchMatch{
+			Path:  path.Join(userPath, filepath.ToSlash(rel)),
+			Name:  d.Name(),
+			Type:  "file",
+			MTime: info.ModTime().UnixMilli(),
+		}
+		if d.IsDir() {
+			match.Type = "dir"
+		} else {
+			match.Size = info.Size()
+		}
+		if !send(SearchEvent{Type: "match", Match: match, Scanned: scanned, Matches: matches}) {
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	send(SearchEvent{Type: "done", Scanned: scanned, Matches: matches, Truncated: truncated})
+}
+
+// searchMatches reports whether a lowercased name matches a lowercased query
+func searchMatches(name, query string, glob bool) bool {
+	if glob {
+		ok, _ := path.Match(query, name)
+		return ok
+	}
+	return strings.Contains(name, query)
+}
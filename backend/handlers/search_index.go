@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// searchIndexVersion changes whenever the saved index's layout does, so an
+// old file is rebuilt rather than misread
+const searchIndexVersion = 1
+
+// indexEntry is a name in an indexed directory
+type indexEntry struct {
+	Name string
+	Dir  bool
+}
+
+// indexDir is what the index knows of a local directory: its entries in
+// lexical order, as they were when the directory had ModTime
+type indexDir struct {
+	ModTime int64
+	Entries []indexEntry
+}
+
+// savedSearchIndex is the index as written to DATA_DIR
+type savedSearchIndex struct {
+	Version int
+	Root    string
+	Dirs    map[string]*indexDir
+}
+
+// searchIndex holds the names of every entry under the root, so searches
+// don't walk the disk. It is saved to DATA_DIR after every reconciliation
+// and loaded at startup, so search is fast again as soon as the server is
+// up; the reconciliation that follows only reads directories whose mtime
+// changed since. Changes made through the API mark their directories stale,
+// and those are read again when a search reaches them.
+var searchIndex = &nameIndex{}
+
+type nameIndex struct {
+	mu    sync.RWMutex
+	dirs  map[string]*indexDir // nil until loaded or built
+	stale map[string]bool
+
+	// rebuilding collects what goes stale while a reconciliation runs, to
+	// be marked again in the index it produces
+	rebuilding map[string]bool
+}
+
+func searchIndexPath() string {
+	return filepath.Join(config.DataDir, "search-index.gob.gz")
+}
+
+// LoadSearchIndex reads the index saved by an earlier run, if there is one
+// and it was built for the same root
+func LoadSearchIndex() {
+	if config.SearchIndexInterval <= 0 {
+		return
+	}
+	file, err := os.Open(searchIndexPath())
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		slog.Warn("Failed to read search index", "error", err)
+		return
+	}
+	var saved savedSearchIndex
+	if err := gob.NewDecoder(gz).Decode(&saved); err != nil {
+		slog.Warn("Failed to read search index", "error", err)
+		return
+	}
+	if saved.Version != searchIndexVersion || saved.Root != config.RootDir || saved.Dirs == nil {
+		return
+	}
+
+	searchIndex.mu.Lock()
+	searchIndex.dirs = saved.Dirs
+	searchIndex.stale = make(map[string]bool)
+	searchIndex.mu.Unlock()
+	slog.Info("Loaded search index", "directories", len(saved.Dirs))
+}
+
+// ready reports whether searches can use the index
+func (x *nameIndex) ready() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.dirs != nil
+}
+
+// invalidate marks the directories holding paths, and the paths themselves
+// should they be directories, to be read again
+func (x *nameIndex) invalidate(paths ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirs == nil && x.rebuilding == nil {
+		return
+	}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		for _, dir := range []string{path, filepath.Dir(path)} {
+			if x.stale != nil {
+				x.stale[dir] = true
+			}
+			if x.rebuilding != nil {
+				x.rebuilding[dir] = true
+			}
+		}
+	}
+}
+
+// dir returns the entries of a local directory, reading them when the index
+// doesn't have them or they went stale
+func (x *nameIndex) dir(path string) (*indexDir, error) {
+	x.mu.RLock()
+	entry, ok := x.dirs[path]
+	stale := x.stale[path]
+	x.mu.RUnlock()
+	if ok && !stale {
+		return entry, nil
+	}
+
+	entry, err := readIndexDir(path)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.dirs == nil {
+		return entry, err
+	}
+	delete(x.stale, path)
+	if err != nil {
+		delete(x.dirs, path)
+		return nil, err
+	}
+	x.dirs[path] = entry
+	return entry, nil
+}
+
+// readIndexDir reads a local directory's entries in lexical order
+func readIndexDir(path string) (*indexDir, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("not a directory")
+	}
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]indexEntry, len(dirEntries))
+	for i, d := range dirEntries {
+		entries[i] = indexEntry{Name: d.Name(), Dir: d.IsDir()}
+	}
+	return &indexDir{ModTime: info.ModTime().UnixNano(), Entries: entries}, nil
+}
+
+// reconcileSearchIndex brings the index in line with the disk and saves it.
+// A directory whose mtime is unchanged still has the same entries, so only
+// directories that changed are read; the others cost a stat.
+func reconcileSearchIndex(ctx context.Context) (int, error) {
+	x := searchIndex
+	x.mu.Lock()
+	old := x.dirs
+	x.rebuilding = make(map[string]bool)
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		x.rebuilding = nil
+		x.mu.Unlock()
+	}()
+
+	skip := make(map[string]bool)
+	for _, dir := range config.SnapshotDirs {
+		skip[filepath.Clean(dir.Dir)] = true
+	}
+	if trash, err := trashDir(); err == nil {
+		skip[trash] = true
+	}
+
+	next := make(map[string]*indexDir, len(old))
+	read := 0
+	var walk func(path string) error
+	walk = func(path string) error {
+		entry, err := reconcileIndexDir(ctx, path, old[path])
+		if err != nil || entry == nil {
+			return err
+		}
+		if entry != old[path] {
+			read++
+		}
+		next[path] = entry
+		for _, e := range entry.Entries {
+			child := filepath.Join(path, e.Name)
+			if e.Dir && e.Name != ".zfs" && !skip[child] {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(config.RootDir); err != nil {
+		return read, err
+	}
+
+	x.mu.Lock()
+	x.dirs = next
+	x.stale = x.rebuilding
+	x.rebuilding = nil
+	x.mu.Unlock()
+
+	return read, saveSearchIndex(next)
+}
+
+// reconcileIndexDir returns the entries of a directory, those the index has
+// when its mtime is unchanged, as background work. It returns nil when the
+// directory is gone.
+func reconcileIndexDir(ctx context.Context, path string, known *indexDir) (*indexDir, error) {
+	release, err := background.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	info, err := os.Lstat(path)
+	if err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	if known != nil && known.ModTime == info.ModTime().UnixNano() {
+		return known, nil
+	}
+	if err := background.charge(ctx, dirReadCost); err != nil {
+		return nil, err
+	}
+	entry, err := readIndexDir(path)
+	if err != nil {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// saveSearchIndex writes the index to DATA_DIR, replacing the saved one
+// only once it is complete
+func saveSearchIndex(dirs map[string]*indexDir) error {
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		return err
+	}
+	file, err := vfs.CreateAtomic(searchIndexPath(), 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(file)
+	err = gob.NewEncoder(gz).Encode(savedSearchIndex{Version: searchIndexVersion, Root: config.RootDir, Dirs: dirs})
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Close()
+}
+
+// walkSearchIndex walks the local directory root like vfs.WalkDir, from the
+// index rather than the disk. Entries are only stat'ed when fn asks for
+// their Info.
+func walkSearchIndex(root string, fn fs.WalkDirFunc) error {
+	err := walkIndexDir(root, indexDirEntry{name: filepath.Base(root), dir: true, path: root}, fn)
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkIndexDir(path string, d indexDirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.dir {
+		if err == fs.SkipDir && d.dir {
+			return nil
+		}
+		return err
+	}
+	entry, err := searchIndex.dir(path)
+	if err != nil {
+		// Called again with the error, as filepath.WalkDir does
+		if err = fn(path, d, err); err == fs.SkipDir {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entry.Entries {
+		child := filepath.Join(path, e.Name)
+		if err := walkIndexDir(child, indexDirEntry{name: e.Name, dir: e.Dir, path: child}, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// indexDirEntry is an fs.DirEntry from the index
+type indexDirEntry struct {
+	name string
+	dir  bool
+	path string
+}
+
+func (d indexDirEntry) Name() string { return d.name }
+func (d indexDirEntry) IsDir() bool  { return d.dir }
+
+func (d indexDirEntry) Type() fs.FileMode {
+	if d.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (d indexDirEntry) Info() (fs.FileInfo, error) {
+	return os.Lstat(d.path)
+}
@@ -3,13 +3,18 @@ package handlers
 import (
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
 	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
 	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
 )
 
 type CreateShareRequest struct {
@@ -24,18 +29,21 @@ type CreateShareRequest struct {
 	Description   string `json:"description,omitempty"`
 	Theme         string `json:"theme,omitempty"`
 	ViewMode      string `json:"viewMode,omitempty"`
+	Torrent       bool   `json:"torrent,omitempty"` // make a torrent of the share, web seeded by it
 }
 
 type CreateShareResponse struct {
-	OK       bool                  `json:"ok"`
-	ShareID  string                `json:"shareId"`
-	ShareURL string                `json:"shareUrl"`
-	Share    *models.SharePublic   `json:"share"`
+	OK           bool                `json:"ok"`
+	ShareID      string              `json:"shareId"`
+	ShareURL     string              `json:"shareUrl"`
+	Share        *models.SharePublic `json:"share"`
+	TorrentJobID string              `json:"torrentJobId,omitempty"` // of the job making the torrent
 }
 
 type GetSharesResponse struct {
-	OK     bool                  `json:"ok"`
-	Shares []*models.SharePublic `json:"shares"`
+	OK         bool                  `json:"ok"`
+	Shares     []*models.SharePublic `json:"shares"`
+	Pagination *Pagination           `json:"pagination,omitempty"`
 }
 
 type AccessShareRequest struct {
@@ -51,58 +59,54 @@ type AccessShareResponse struct {
 func CreateShare(c *gin.Context) {
 	var req CreateShareRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Path is required",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Path is required")
+		return
+	}
+	if req.Torrent && req.Password != "" {
+		problem.Respond(c, http.StatusBadRequest, passwordTorrentMessage)
 		return
 	}
 
 	// Safely resolve path
 	safePath, err := utils.SafeResolve(req.Path)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid path: " + err.Error(),
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
 		return
 	}
 
 	// Check if file/directory exists
 	if !utils.FileExists(safePath) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "File or directory not found",
-		})
+		problem.Respond(c, http.StatusNotFound, "File or directory not found")
 		return
 	}
 
 	// Get file info to determine type
 	fileInfo, err := os.Stat(safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to get file info",
-		})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to get file info")
 		return
 	}
 
+	response, ok := createShare(c, req, safePath, fileInfo)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// createShare shares the local file or directory at safePath as req asks,
+// answering with a problem and returning false when it fails
+func createShare(c *gin.Context, req CreateShareRequest, safePath string, fileInfo os.FileInfo) (CreateShareResponse, bool) {
 	// Generate share ID
 	shareID, err := models.CreateShareID()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"ok":    false,
-			"error": "Failed to generate share ID",
-		})
-		return
+		problem.Respond(c, http.StatusInternalServerError, "Failed to generate share ID")
+		return CreateShareResponse{}, false
 	}
 
 	// Create share object
@@ -135,6 +139,7 @@ func CreateShare(c *gin.Context) {
 
 	// Store share
 	models.SetShare(share)
+	publishChange(c.ClientIP(), events.ShareCreated, vfs.OS, safePath, share.ToPublic())
 
 	// Build share URL
 	shareURL := config.BaseURL + "/share/" + shareID
@@ -146,91 +151,78 @@ func CreateShare(c *gin.Context) {
 		Share:    share.ToPublic(),
 	}
 
-	c.JSON(http.StatusOK, response)
+	// Large shares are hashed for a while, so the torrent comes later
+	if req.Torrent {
+		job, err := startShareTorrent(share)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+			return CreateShareResponse{}, false
+		}
+		response.TorrentJobID = job.ID
+	}
+
+	return response, true
 }
 
 func GetShare(c *gin.Context) {
 	shareID := c.Param("shareId")
 	if shareID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing share ID",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing share ID")
 		return
 	}
 
 	// Get share
 	share, exists := models.GetShare(shareID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share not found",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share not found")
 		return
 	}
 
 	// Check if share has expired
 	if share.ExpiresAt != nil && *share.ExpiresAt < time.Now().UnixMilli() {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share has expired",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share has expired")
 		return
 	}
 
 	// Check if shared file/directory still exists
 	if !utils.FileExists(share.Path) {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Shared file or directory no longer exists",
-		})
+		problem.Respond(c, http.StatusNotFound, "Shared file or directory no longer exists")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"ok":    true,
-		"share": share.ToPublic(),
+		"ok":       true,
+		"share":    share.ToPublic(),
+		"branding": resolveBranding(share.Theme),
 	})
 }
 
 func AccessShare(c *gin.Context) {
 	shareID := c.Param("shareId")
 	if shareID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing share ID",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing share ID")
 		return
 	}
 
 	var req AccessShareRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Invalid request body",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Get share
 	share, exists := models.GetShare(shareID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share not found",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share not found")
 		return
 	}
 
 	// Check if share has expired
 	if share.ExpiresAt != nil && *share.ExpiresAt < time.Now().UnixMilli() {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share has expired",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share has expired")
 		return
 	}
 
@@ -258,10 +250,7 @@ func AccessShare(c *gin.Context) {
 	// Check if shared file/directory still exists
 	if !utils.FileExists(share.Path) {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Shared file or directory no longer exists",
-		})
+		problem.Respond(c, http.StatusNotFound, "Shared file or directory no longer exists")
 		return
 	}
 
@@ -272,61 +261,83 @@ func AccessShare(c *gin.Context) {
 	})
 }
 
+// sharePasswordHeader carries the password of a protected share with a
+// download, in place of the password query parameter
+const sharePasswordHeader = "X-Share-Password"
+
 func DownloadShare(c *gin.Context) {
 	shareID := c.Param("shareId")
 	if shareID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"ok":    false,
-			"error": "Missing share ID",
-		})
+		problem.Respond(c, http.StatusBadRequest, "Missing share ID")
 		return
 	}
 
 	// Get share
 	share, exists := models.GetShare(shareID)
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share not found",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share not found")
 		return
 	}
 
 	// Check if share has expired
 	if share.ExpiresAt != nil && *share.ExpiresAt < time.Now().UnixMilli() {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Share has expired",
-		})
+		problem.Respond(c, http.StatusNotFound, "Share has expired")
 		return
 	}
 
 	// Check if shared file/directory still exists
 	if !utils.FileExists(share.Path) {
 		models.DeleteShare(shareID)
-		c.JSON(http.StatusNotFound, gin.H{
-			"ok":    false,
-			"error": "Shared file or directory no longer exists",
-		})
+		problem.Respond(c, http.StatusNotFound, "Shared file or directory no longer exists")
 		return
 	}
 
-	// TODO: Implement password check for download
-	// For now, assuming access control is handled by frontend
+	// A protected share's password comes with each download, as links
+	// can't send it in a body
+	if share.Password != "" {
+		password := c.GetHeader(sharePasswordHeader)
+		if password == "" {
+			password = c.Query("password")
+		}
+		if password == "" {
+			problem.Respond(c, http.StatusUnauthorized, "Password required")
+			return
+		}
+		if password != share.Password {
+			problem.Respond(c, http.StatusForbidden, "Invalid password")
+			return
+		}
+	}
 
 	if share.Type == "file" {
 		// Download single file
 		c.File(share.Path)
-	} else {
-		// Download directory as ZIP
-		// This is a simplified implementation
-		// You might want to implement proper ZIP streaming here
-		c.JSON(http.StatusNotImplemented, gin.H{
-			"ok":    false,
-			"error": "Directory download not yet implemented",
-		})
+		return
+	}
+
+	// Anything inside a shared directory can be downloaded by its ?path=
+	// relative to the share; directories come as a ZIP archive
+	target := share.Path
+	if sub := c.Query("path"); sub != "" {
+		target = filepath.Join(share.Path, filepath.Clean("/"+sub))
+		if err := utils.CheckRealPath(target); err != nil || !utils.IsWithin(share.Path, target) {
+			problem.Respond(c, http.StatusBadRequest, "Invalid path")
+			return
+		}
 	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	if !info.IsDir() {
+		c.FileAttachment(target, info.Name())
+		return
+	}
+	name := filepath.Base(target)
+	streamZip(c, name+".zip", []vfs.Filesystem{vfs.OS}, []string{target}, []string{name}, false)
 }
 
 // GetAllShares returns all shares (for management)
@@ -334,6 +345,10 @@ func GetAllShares(c *gin.Context) {
 	validShares := models.GetAllShares()
 	var publicShares []*models.SharePublic
 	
+	// Newest first, so pages stay stable as shares are added
+	sort.Slice(validShares, func(i, j int) bool {
+		return validShares[i].CreatedAt > validShares[j].CreatedAt
+	})
 	for _, share := range validShares {
 		publicShares = append(publicShares, share.ToPublic())
 	}
@@ -342,6 +357,9 @@ func GetAllShares(c *gin.Context) {
 		OK:     true,
 		Shares: publicShares,
 	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response.Shares, response.Pagination = paginate(publicShares, pageReq)
+	}
 
 	c.JSON(http.StatusOK, response)
 }
\ No newline at end of file
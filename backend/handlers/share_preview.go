@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/photo"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// sharePreviewSize is the longest side of the image link previews show
+const sharePreviewSize = 512
+
+// shareCountTimeout bounds counting the contents of a shared directory for
+// its preview; a larger tree is described by what was counted so far
+const shareCountTimeout = 2 * time.Second
+
+// ShareMeta is what chat apps and social sites show of a pasted share link.
+// Password-protected shares reveal neither their name nor their contents.
+type ShareMeta struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	URL         string `json:"url"`             // of the share page
+	Image       string `json:"image,omitempty"` // absolute URL of a preview image
+	SiteName    string `json:"siteName"`
+	Type        string `json:"type"` // file or dir
+	Files       int64  `json:"files,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+var shareMetaPage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="{{.SiteName}}">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{if .Image}}<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.Image}}">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.URL}}">
+</head>
+<body><a href="{{.URL}}">{{.Title}}</a></body>
+</html>
+`))
+
+// GetShareMeta describes a share for link previews: as JSON for a frontend
+// to put in its page's head, or with ?format=html as a page of Open Graph
+// and Twitter card tags that sends browsers on to the share, for a proxy to
+// serve to crawlers
+func GetShareMeta(c *gin.Context) {
+	share, ok := liveShare(c)
+	if !ok {
+		return
+	}
+	meta := shareMeta(c.Request.Context(), share)
+
+	if c.Query("format") == "html" {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Status(http.StatusOK)
+		_ = shareMetaPage.Execute(c.Writer, meta)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "meta": meta})
+}
+
+// shareMeta works out the preview of a share
+func shareMeta(ctx context.Context, share *models.Share) *ShareMeta {
+	baseURL := strings.TrimSuffix(config.BaseURL, "/")
+	meta := &ShareMeta{
+		Title:       share.Title,
+		Description: share.Description,
+		URL:         baseURL + "/share/" + share.ID,
+		SiteName:    "NextBrowse",
+		Type:        share.Type,
+	}
+	branding := resolveBranding(share.Theme)
+	if branding != nil && branding.Title != "" {
+		meta.SiteName = branding.Title
+	}
+
+	protected := share.Password != ""
+	if !protected {
+		if share.Type == "dir" {
+			countCtx, cancel := context.WithTimeout(ctx, shareCountTimeout)
+			items, size := scanTree(countCtx, share.Path)
+			cancel()
+			meta.Files, meta.Size = max(items-1, 0), size // not counting the directory itself
+		} else if info, err := os.Stat(share.Path); err == nil {
+			meta.Files, meta.Size = 1, info.Size()
+		}
+		if _, ok := sharePreviewImage(share); ok {
+			meta.Image = baseURL + "/api/fs/share/" + share.ID + "/thumbnail"
+		}
+	}
+
+	if meta.Title == "" {
+		meta.Title = filepath.Base(share.Path)
+		if protected {
+			meta.Title = "Protected share"
+		}
+	}
+	if meta.Description == "" {
+		switch {
+		case protected:
+			meta.Description = "A password is needed to open this share"
+		case share.Type == "dir":
+			meta.Description = fmt.Sprintf("Folder with %d items, %s", meta.Files, formatSize(meta.Size))
+		default:
+			meta.Description = "File, " + formatSize(meta.Size)
+		}
+	}
+	if meta.Image == "" && branding != nil && branding.LogoURL != "" {
+		meta.Image = baseURL + branding.LogoURL
+	}
+	return meta
+}
+
+// sharePreviewImage picks the image a share's preview shows: a shared
+// image, or the first image directly in a shared directory
+func sharePreviewImage(share *models.Share) (string, bool) {
+	if share.Type != "dir" {
+		return share.Path, photo.IsImage(share.Path)
+	}
+	entries, err := os.ReadDir(share.Path)
+	if err != nil {
+		return "", false
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !utils.Hidden(entry.Name()) && photo.IsImage(entry.Name()) {
+			return filepath.Join(share.Path, entry.Name()), true
+		}
+	}
+	return "", false
+}
+
+// GetShareThumbnail serves the image of a share's link preview, scaled
+// down. Password-protected shares have none.
+func GetShareThumbnail(c *gin.Context) {
+	share, ok := liveShare(c)
+	if !ok {
+		return
+	}
+	name, ok := sharePreviewImage(share)
+	if !ok || share.Password != "" {
+		problem.Respond(c, http.StatusNotFound, "Share has no preview image")
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		problem.Respond(c, http.StatusNotFound, "Share has no preview image")
+		return
+	}
+	data, err := thumbnail(vfs.OS, name, info, sharePreviewSize)
+	if err != nil {
+		problem.Respond(c, http.StatusUnprocessableEntity, "Failed to make preview image: "+err.Error())
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+// formatSize shows a byte count the way people read it, as 3.4 MB
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
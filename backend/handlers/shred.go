@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// shredBufferSize is the size of the random block written over file contents
+const shredBufferSize = 1024 * 1024
+
+// secureDeleteSize returns the total size of the regular files that a secure
+// delete of path would overwrite. Symlinks are not followed, and files with
+// other hard links are left out, as shredFile doesn't overwrite them.
+func secureDeleteSize(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if linkCount(info) == 1 {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total, err
+}
+
+// shredTree overwrites every regular file under path with random data and
+// flushes it to disk, stopping between files once ctx is cancelled. Note that
+// on copy-on-write filesystems, SSDs with wear levelling, or with snapshots,
+// old blocks may still survive on the device.
+func shredTree(ctx context.Context, path string) error {
+	buf := make([]byte, shredBufferSize)
+	return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return shredFile(p, buf)
+	})
+}
+
+// shredFile overwrites the contents of a single file in place. A file with
+// other hard links is left as it is: overwriting it would destroy the
+// contents under its other names too, which may be outside what is being
+// deleted. Unlinking it leaves those names intact.
+func shredFile(path string, buf []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if linkCount(info) > 1 {
+		return nil
+	}
+
+	for remaining := info.Size(); remaining > 0; {
+		chunk := buf
+		if remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		if _, err := rand.Read(chunk); err != nil {
+			return err
+		}
+		n, err := file.Write(chunk)
+		if err != nil {
+			return err
+		}
+		remaining -= int64(n)
+	}
+
+	return file.Sync()
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// SnapshotInfo describes a filesystem snapshot. With a path filter, Item
+// describes that path as it was in the snapshot.
+type SnapshotInfo struct {
+	ID   string    `json:"id"`
+	Name string    `json:"name"`
+	Kind string    `json:"kind"`
+	Time int64     `json:"time"`
+	Item *FileItem `json:"item,omitempty"`
+}
+
+type SnapshotRestoreRequest struct {
+	Path        string `json:"path"`
+	Destination string `json:"destination"` // defaults to Path
+	Conflict    string `json:"conflict"`
+	Async       bool   `json:"async"`
+}
+
+// snapshotItem describes an entry inside a snapshot. Symlinks are listed but
+// never followed.
+func snapshotItem(name string, info os.FileInfo) FileItem {
+	item := FileItem{
+		Name:  name,
+		Type:  "file",
+		MTime: info.ModTime().UnixMilli(),
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		item.Type = "symlink"
+	case info.IsDir():
+		item.Type = "dir"
+	default:
+		size := info.Size()
+		item.Size = &size
+	}
+	return item
+}
+
+// lookupSnapshot finds the snapshot named in the URL and resolves the path
+// query parameter inside it, answering the request itself on failure
+func lookupSnapshot(c *gin.Context, userPath string) (utils.Snapshot, string, bool) {
+	snapshot, exists := utils.FindSnapshot(c.Param("id"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Snapshot not found")
+		return utils.Snapshot{}, "", false
+	}
+
+	snapPath, err := utils.ResolveInSnapshot(snapshot, userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return utils.Snapshot{}, "", false
+	}
+	return snapshot, snapPath, true
+}
+
+// ListSnapshots returns the snapshots of the root directory, newest first.
+// Given a path, only snapshots that contain it are returned, each describing
+// that path's version, which makes it a list of restorable versions.
+func ListSnapshots(c *gin.Context) {
+	userPath := c.Query("path")
+
+	snapshots := make([]SnapshotInfo, 0)
+	for _, snapshot := range utils.ListSnapshots() {
+		info := SnapshotInfo{
+			ID:   snapshot.ID,
+			Name: snapshot.Name,
+			Kind: snapshot.Kind,
+			Time: snapshot.Time.UnixMilli(),
+		}
+
+		if userPath != "" {
+			snapPath, err := utils.ResolveInSnapshot(snapshot, userPath)
+			if err != nil {
+				continue
+			}
+			stat, err := os.Lstat(snapPath)
+			if err != nil {
+				continue
+			}
+			item := snapshotItem(filepath.Base(snapPath), stat)
+			info.Item = &item
+		}
+		snapshots = append(snapshots, info)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":        true,
+		"snapshots": snapshots,
+	})
+}
+
+// ListSnapshotDirectory lists a directory as it was in a snapshot
+func ListSnapshotDirectory(c *gin.Context) {
+	userPath := c.DefaultQuery("path", "/")
+	_, snapPath, ok := lookupSnapshot(c, userPath)
+	if !ok {
+		return
+	}
+
+	entries, err := os.ReadDir(snapPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Directory not found in snapshot")
+			return
+		}
+		problem.Respond(c, http.StatusBadRequest, "Failed to read directory: "+err.Error())
+		return
+	}
+
+	items := make([]FileItem, 0, len(entries))
+	for _, entry := range entries {
+		if utils.Hidden(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		items = append(items, snapshotItem(entry.Name(), info))
+	}
+
+	// Sort items (directories first, then alphabetical)
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Type != items[j].Type {
+			return items[i].Type == "dir"
+		}
+		return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name)
+	})
+
+	writeListResponse(c, http.StatusOK, &ListResponse{
+		OK:    true,
+		Path:  userPath,
+		Items: items,
+	})
+}
+
+// DownloadSnapshotFile serves a file as it was in a snapshot
+func DownloadSnapshotFile(c *gin.Context) {
+	userPath := c.Query("path")
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+
+	_, snapPath, ok := lookupSnapshot(c, userPath)
+	if !ok {
+		return
+	}
+
+	file, err := os.Open(snapPath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found in snapshot")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a file")
+		return
+	}
+
+	filename := filepath.Base(snapPath)
+	c.Header("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, filename, info.ModTime(), file)
+}
+
+// RestoreFromSnapshot copies a file or directory out of a snapshot, back to
+// its original path or to another destination, as a "restore" job. Existing
+// destinations are handled by the same conflict policies as copies.
+func RestoreFromSnapshot(c *gin.Context) {
+	var req SnapshotRestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return
+	}
+	if req.Destination == "" {
+		req.Destination = req.Path
+	}
+
+	if !validConflictPolicy(req.Conflict) {
+		problem.Respond(c, http.StatusBadRequest, "Invalid conflict policy: "+req.Conflict)
+		return
+	}
+
+	snapshot, srcPath, ok := lookupSnapshot(c, req.Path)
+	if !ok {
+		return
+	}
+
+	if _, err := os.Lstat(srcPath); err != nil {
+		problem.Respond(c, http.StatusNotFound, "Path not found in snapshot")
+		return
+	}
+
+	dstFS, dstPath, err := utils.ResolveFS(req.Destination)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+
+	if _, err := dstFS.Stat(dstPath); err == nil && req.Conflict == conflictFail {
+		problem.Respond(c, http.StatusConflict, "Destination already exists")
+		return
+	}
+
+	if !checkBackends(c, vfs.OS, dstFS, req.Conflict) {
+		return
+	}
+
+	// What is restored counts against the quotas of the destination
+	if !admitTree(c, vfs.OS, srcPath, req.Path, req.Destination, false) {
+		return
+	}
+
+	if err := dstFS.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create destination directory: "+err.Error())
+		return
+	}
+
+	// Snapshots are read-only, so only the destination needs locking
+	unlock, ok := lockPaths(c, utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+
+	job, err := models.NewJob("restore", snapshot.ID+":"+req.Path, req.Destination)
+	if err != nil {
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+
+	if req.Async {
+		go func() {
+			defer unlock()
+			runCopyOn(job, vfs.OS, dstFS, srcPath, dstPath, req.Conflict)
+		}()
+		c.JSON(http.StatusAccepted, OperationResponse{
+			OK:      true,
+			Message: "Restore started",
+			JobID:   job.ID,
+		})
+		return
+	}
+
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runCopyOn(job, vfs.OS, dstFS, srcPath, dstPath, req.Conflict)
+	respondWithJob(c, job, "Restore", "Restored from snapshot "+snapshot.Name)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jellydator/ttlcache/v3"
+
+	"nextbrowse-backend/config"
+)
+
+// statCache maps resolved local directories to the FileInfo of their
+// entries. The listing cache only helps while a directory is unchanged; once
+// a file is added, this spares the new listing a stat of every other entry,
+// which is what makes large directories slow on NFS. A stat can't be checked
+// against the file's mtime without making the stat it saves, so stats are
+// trusted for up to LIST_CACHE_TTL like listings, and changes made through
+// the API drop them at once. A directory's stats are all forgotten together, so
+// that names deleted behind the server's back don't pile up.
+var statCache = newStatCache()
+
+// statDir holds the stats taken in one directory
+type statDir struct {
+	mu    sync.Mutex
+	stats map[string]os.FileInfo
+}
+
+func newStatCache() *ttlcache.Cache[string, *statDir] {
+	cache := ttlcache.New[string, *statDir](
+		ttlcache.WithTTL[string, *statDir](config.ListCacheTTL),
+		ttlcache.WithCapacity[string, *statDir](uint64(max(config.ListCacheSize, 1))),
+		ttlcache.WithDisableTouchOnHit[string, *statDir](),
+	)
+	if config.ListCacheTTL > 0 {
+		go cache.Start()
+	}
+	return cache
+}
+
+// entryInfo returns the FileInfo of entry, read in the local directory dir,
+// from the stat cache while it is fresh
+func entryInfo(dir string, entry fs.DirEntry) (os.FileInfo, error) {
+	if config.ListCacheTTL <= 0 {
+		return entry.Info()
+	}
+	item, _ := statCache.GetOrSet(dir, &statDir{stats: make(map[string]os.FileInfo)})
+	cached := item.Value()
+
+	cached.mu.Lock()
+	info, ok := cached.stats[entry.Name()]
+	cached.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+	cached.mu.Lock()
+	cached.stats[entry.Name()] = info
+	cached.mu.Unlock()
+	return info, nil
+}
+
+// invalidateStats drops the cached stat of a resolved path, and those taken
+// in and beneath it when it is a directory
+func invalidateStats(path string) {
+	if item := statCache.Get(filepath.Dir(path)); item != nil {
+		parent := item.Value()
+		parent.mu.Lock()
+		delete(parent.stats, filepath.Base(path))
+		parent.mu.Unlock()
+	}
+	statCache.Delete(path)
+
+	prefix := path + string(filepath.Separator)
+	for _, key := range statCache.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			statCache.Delete(key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+)
+
+// Default and largest number of top downloads the statistics list
+const (
+	defaultTopDownloads = 10
+	maxTopDownloads     = 100
+)
+
+// UsageSummary is the traffic of a period. ErrorRate is the share of its
+// requests that failed, with a client or a server error.
+type UsageSummary struct {
+	Requests        int64   `json:"requests"`
+	FilesServed     int64   `json:"filesServed"`
+	BytesUploaded   int64   `json:"bytesUploaded"`
+	BytesDownloaded int64   `json:"bytesDownloaded"`
+	ActiveUsers     int     `json:"activeUsers"`
+	ClientErrors    int64   `json:"clientErrors"`
+	ServerErrors    int64   `json:"serverErrors"`
+	ErrorRate       float64 `json:"errorRate"`
+}
+
+// JobStats counts the jobs running, in all and by type. Jobs start as soon
+// as they are made, so these are also all that are waiting to finish.
+type JobStats struct {
+	Running int            `json:"running"`
+	ByType  map[string]int `json:"byType"`
+}
+
+// GetStats returns what an admin dashboard shows at a glance: the traffic of
+// today and of this month, in the server's time zone, the files downloaded
+// most this month (?top=, 10 by default), and the jobs running
+func GetStats(c *gin.Context) {
+	top := defaultTopDownloads
+	if value := c.Query("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTopDownloads {
+			problem.Respond(c, http.StatusBadRequest, "top must be between 1 and "+strconv.Itoa(maxTopDownloads))
+			return
+		}
+		top = n
+	}
+
+	now := time.Now()
+	today, err := metadata.UsageSince(now)
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read usage: "+err.Error())
+		return
+	}
+	month, err := metadata.UsageSince(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()))
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read usage: "+err.Error())
+		return
+	}
+
+	jobs := JobStats{ByType: make(map[string]int)}
+	for _, job := range models.GetAllJobs() {
+		if job.Info().Status == models.JobRunning {
+			jobs.Running++
+			jobs.ByType[job.Type]++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":           true,
+		"today":        usageSummary(today),
+		"month":        usageSummary(month),
+		"topDownloads": month.TopFiles(top),
+		"jobs":         jobs,
+	})
+}
+
+func usageSummary(u metadata.Usage) UsageSummary {
+	s := UsageSummary{
+		Requests:        u.Requests,
+		FilesServed:     u.FilesServed,
+		BytesUploaded:   u.BytesIn,
+		BytesDownloaded: u.BytesOut,
+		ActiveUsers:     len(u.Users),
+		ClientErrors:    u.ClientErrors,
+		ServerErrors:    u.ServerErrors,
+	}
+	if u.Requests > 0 {
+		s.ErrorRate = float64(u.ClientErrors+u.ServerErrors) / float64(u.Requests)
+	}
+	return s
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Comparison modes for deciding whether a destination file is up to date
+const (
+	syncCompareModTime  = "modtime"  // same size and not older than the source
+	syncCompareChecksum = "checksum" // same size and SHA-256
+)
+
+// mtimeTolerance absorbs the coarse timestamps of some filesystems and stores
+const mtimeTolerance = time.Second
+
+type SyncRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Compare     string `json:"compare"` // "modtime" (default) or "checksum"
+	Delete      bool   `json:"delete"`  // remove destination entries missing from the source
+	DryRun      bool   `json:"dryRun"`  // report the changes without making them
+	Async       bool   `json:"async"`
+}
+
+// SyncReport lists what a sync changed (or would change, for a dry run).
+// Paths are relative to the sync root.
+type SyncReport struct {
+	DryRun      bool     `json:"dryRun,omitempty"`
+	Created     []string `json:"created"`
+	Updated     []string `json:"updated"`
+	Deleted     []string `json:"deleted"`
+	Unchanged   int      `json:"unchanged"`
+	BytesCopied int64    `json:"bytesCopied"`
+}
+
+type SyncResponse struct {
+	OK      bool              `json:"ok"`
+	Message string            `json:"message,omitempty"`
+	JobID   string            `json:"jobId,omitempty"`
+	Report  *SyncReport       `json:"report,omitempty"`
+	Errors  []models.JobError `json:"errors,omitempty"`
+}
+
+// SyncFiles makes the destination a one-way mirror of the source, across any
+// two storage backends
+func SyncFiles(c *gin.Context) {
+	var req SyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Source == "" || req.Destination == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing source or destination")
+		return
+	}
+
+	switch req.Compare {
+	case "":
+		req.Compare = syncCompareModTime
+	case syncCompareModTime, syncCompareChecksum:
+	default:
+		problem.Respond(c, http.StatusBadRequest, "Invalid compare mode: "+req.Compare)
+		return
+	}
+
+	// Safely resolve paths
+	srcFS, srcPath, err := utils.ResolveFS(req.Source)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid source path: "+err.Error())
+		return
+	}
+
+	dstFS, dstPath, err := utils.ResolveFS(req.Destination)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+
+	srcInfo, err := srcFS.Stat(srcPath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "Source directory not found")
+		return
+	}
+	if !srcInfo.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, "Source is not a directory")
+		return
+	}
+
+	// A mirror can't live inside its source or the other way round
+	if srcFS == dstFS && (utils.IsWithin(srcPath, dstPath) || utils.IsWithin(dstPath, srcPath)) {
+		problem.Respond(c, http.StatusBadRequest, "Source and destination overlap")
+		return
+	}
+
+	// The whole source counts against the quotas of the destination, as
+	// for a copy, since any of it may be sent
+	if !admitTree(c, srcFS, srcPath, req.Source, req.Destination, false) {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.ReadLock(srcPath), utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+
+	job, err := models.NewJob("sync", req.Source, req.Destination)
+	if err != nil {
+		unlock()
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+
+	if req.Async {
+		go func() {
+			defer unlock()
+			runSyncJob(job, srcFS, srcPath, dstFS, dstPath, req)
+		}()
+		c.JSON(http.StatusAccepted, SyncResponse{
+			OK:      true,
+			Message: "Sync started",
+			JobID:   job.ID,
+		})
+		return
+	}
+
+	defer unlock()
+	defer cancelOnDisconnect(c, job)()
+	runSyncJob(job, srcFS, srcPath, dstFS, dstPath, req)
+
+	info := job.Info()
+	report, _ := info.Result.(*SyncReport)
+	switch info.Status {
+	case models.JobCompleted:
+		c.JSON(http.StatusOK, SyncResponse{
+			OK:      true,
+			Message: "Sync completed successfully",
+			JobID:   job.ID,
+			Report:  report,
+		})
+	case models.JobCancelled:
+		problem.Respond(c, http.StatusConflict, "Sync operation cancelled", gin.H{
+			"jobId":  job.ID,
+			"report": report,
+		})
+	default:
+		problem.Respond(c, http.StatusInternalServerError, "Sync operation failed: "+info.Message, gin.H{
+			"jobId":  job.ID,
+			"report": report,
+			"errors": info.Errors,
+		})
+	}
+}
+
+// syncEntry is one file or directory of a sync tree, keyed by relative path
+type syncEntry struct {
+	rel  string
+	info fs.FileInfo
+}
+
+// runSyncJob compares both trees, then copies new and changed files and, if
+// requested, deletes extraneous destination entries. The report is attached
+// to the job as its result.
+func runSyncJob(job *models.Job, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, dst string, req SyncRequest) {
+	defer invalidateListing(dst)
+
+	ctx := job.Context()
+	report := &SyncReport{DryRun: req.DryRun, Created: []string{}, Updated: []string{}, Deleted: []string{}}
+
+	srcEntries, err := listSyncTree(ctx, job, srcFS, src)
+	if err == nil {
+		var dstEntries map[string]fs.FileInfo
+		dstEntries, err = syncTreeIndex(ctx, job, dstFS, dst)
+		if err == nil {
+			err = applySync(job, report, srcFS, src, srcEntries, dstFS, dst, dstEntries, req)
+		}
+	}
+	job.SetResult(report)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Finish(models.JobCancelled, "Operation cancelled")
+	case err != nil:
+		job.Finish(models.JobFailed, err.Error())
+	case len(job.Errors()) > 0:
+		job.Finish(models.JobFailed, fmt.Sprintf("Completed with %d errors", len(job.Errors())))
+	default:
+		job.Finish(models.JobCompleted, "")
+	}
+}
+
+// applySync carries out (or, for a dry run, only records) the changes
+func applySync(job *models.Job, report *SyncReport, srcFS vfs.Filesystem, src string, srcEntries []syncEntry,
+	dstFS vfs.Filesystem, dst string, dstEntries map[string]fs.FileInfo, req SyncRequest) error {
+	ctx := job.Context()
+
+	// Work out which files need copying before moving any data
+	var pending []syncEntry
+	for _, entry := range srcEntries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		srcName := filepath.Join(src, entry.rel)
+		dstName := filepath.Join(dst, entry.rel)
+		existing, exists := dstEntries[entry.rel]
+
+		if entry.info.IsDir() {
+			switch {
+			case exists && existing.IsDir():
+				continue
+			case exists:
+				report.Updated = append(report.Updated, entry.rel+"/")
+			default:
+				report.Created = append(report.Created, entry.rel+"/")
+			}
+			if req.DryRun {
+				continue
+			}
+			if exists {
+				if err := removeTree(dstFS, dstName); err != nil {
+					job.AddError(entry.rel, err)
+					continue
+				}
+			}
+			if err := dstFS.MkdirAll(dstName, 0755); err != nil {
+				job.AddError(entry.rel, err)
+			}
+			continue
+		}
+
+		if exists && !existing.IsDir() {
+			same, err := syncUpToDate(ctx, srcFS, srcName, entry.info, dstFS, dstName, existing, req.Compare)
+			if err != nil {
+				job.AddError(entry.rel, err)
+				continue
+			}
+			if same {
+				report.Unchanged++
+				continue
+			}
+		}
+
+		if exists {
+			report.Updated = append(report.Updated, entry.rel)
+		} else {
+			report.Created = append(report.Created, entry.rel)
+		}
+		pending = append(pending, entry)
+	}
+
+	// Entries only in the destination, outermost first so their contents
+	// go with them
+	var extraneous []string
+	if req.Delete {
+		inSource := make(map[string]bool, len(srcEntries))
+		for _, entry := range srcEntries {
+			inSource[entry.rel] = true
+		}
+		for rel := range dstEntries {
+			if !inSource[rel] {
+				extraneous = append(extraneous, rel)
+			}
+		}
+		sort.Strings(extraneous)
+		extraneous = outermost(extraneous)
+		report.Deleted = append(report.Deleted, extraneous...)
+	}
+
+	if req.DryRun {
+		return nil
+	}
+
+	for _, entry := range pending {
+		job.AddTotals(1, entry.info.Size())
+	}
+	for _, entry := range pending {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		dstName := filepath.Join(dst, entry.rel)
+		if existing, ok := dstEntries[entry.rel]; ok && existing.IsDir() {
+			if err := removeTree(dstFS, dstName); err != nil {
+				job.AddError(entry.rel, err)
+				continue
+			}
+		}
+
+		if err := transferFile(job, srcFS, filepath.Join(src, entry.rel), dstFS, dstName); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			job.AddError(entry.rel, err)
+			continue
+		}
+		report.BytesCopied += entry.info.Size()
+
+		// Carry the source time over where the backend allows it, so the
+		// next modtime comparison sees the file as unchanged
+		if vfs.IsLocal(dstFS) {
+			_ = os.Chtimes(dstName, time.Now(), entry.info.ModTime())
+		}
+	}
+
+	for _, rel := range extraneous {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := removeTree(dstFS, filepath.Join(dst, rel)); err != nil {
+			job.AddError(rel, err)
+		}
+	}
+	return nil
+}
+
+// syncUpToDate reports whether the destination file already matches the source
+func syncUpToDate(ctx context.Context, srcFS vfs.Filesystem, srcName string, srcInfo fs.FileInfo,
+	dstFS vfs.Filesystem, dstName string, dstInfo fs.FileInfo, compare string) (bool, error) {
+	if srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+
+	if compare == syncCompareChecksum {
+		srcSum, err := checksumFile(ctx, srcFS, srcName)
+		if err != nil {
+			return false, err
+		}
+		dstSum, err := checksumFile(ctx, dstFS, dstName)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(srcSum, dstSum), nil
+	}
+
+	// Object stores stamp uploads with the upload time, so a copy is never
+	// older than its source
+	return !dstInfo.ModTime().Before(srcInfo.ModTime().Add(-mtimeTolerance)), nil
+}
+
+// listSyncTree lists the files and directories below root in walk order.
+// Symlinks and other special files are reported on the job and skipped.
+func listSyncTree(ctx context.Context, job *models.Job, fsys vfs.Filesystem, root string) ([]syncEntry, error) {
+	var entries []syncEntry
+	err := vfs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if name == root {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(root, name)
+		if relErr != nil {
+			return relErr
+		}
+		if err != nil {
+			job.AddError(rel, err)
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			job.AddError(rel, errors.New("only regular files and directories can be synced"))
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			job.AddError(rel, err)
+			return nil
+		}
+		entries = append(entries, syncEntry{rel: rel, info: info})
+		return nil
+	})
+	return entries, err
+}
+
+// syncTreeIndex lists the destination tree by relative path. A missing
+// destination is an empty tree.
+func syncTreeIndex(ctx context.Context, job *models.Job, fsys vfs.Filesystem, root string) (map[string]fs.FileInfo, error) {
+	index := make(map[string]fs.FileInfo)
+	if _, err := fsys.Stat(root); os.IsNotExist(err) {
+		return index, nil
+	}
+
+	err := vfs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if name == root {
+			return err
+		}
+
+		rel, relErr := filepath.Rel(root, name)
+		if relErr != nil {
+			return relErr
+		}
+		if err != nil {
+			job.AddError(rel, err)
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			index[rel] = info
+		}
+		return nil
+	})
+	return index, err
+}
+
+// coveredBy reports whether rel lies beneath one of the given paths
+func coveredBy(paths []string, rel string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(rel, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// outermost drops paths that lie beneath another path of the sorted list
+func outermost(sorted []string) []string {
+	var result []string
+	for _, rel := range sorted {
+		if !coveredBy(result, rel) {
+			result = append(result, rel)
+		}
+	}
+	return result
+}
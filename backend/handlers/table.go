@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/table"
+	"nextbrowse-backend/utils"
+)
+
+// maxTableColumns caps how many columns of each row a table preview returns
+const maxTableColumns = 1000
+
+// Table formats
+const (
+	tableCSV  = "csv"
+	tableXLSX = "xlsx"
+)
+
+// TableResponse is a page of the rows of a delimited text file or a sheet
+type TableResponse struct {
+	OK               bool          `json:"ok"`
+	Format           string        `json:"format"`              // csv or xlsx
+	Delimiter        string        `json:"delimiter,omitempty"` // of csv
+	Sheets           []string      `json:"sheets,omitempty"`    // of xlsx
+	Sheet            string        `json:"sheet,omitempty"`
+	Columns          []TableColumn `json:"columns"`
+	Rows             [][]any       `json:"rows"`
+	TruncatedColumns bool          `json:"truncatedColumns,omitempty"` // rows are wider than maxTableColumns
+	Pagination       *Pagination   `json:"pagination"`
+}
+
+// TableColumn is a column of a table preview. Its type is inferred from the
+// rows returned: integer, number, boolean, date or string.
+type TableColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// PreviewTable returns a page of the rows of the CSV, TSV or XLSX file at
+// ?path=, 50 by default, with values typed by column. The first row names
+// the columns unless ?header=false, in which case they are named A, B, C
+// and so on. Delimited text is streamed, so files of any size can be
+// previewed, though later pages take longer to reach; workbooks are opened
+// up to SPREADSHEET_MAX_SIZE.
+func PreviewTable(c *gin.Context) {
+	userPath := c.Query("path")
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+	format, delimiter, ok := tableFormat(c, userPath)
+	if !ok {
+		return
+	}
+
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "File not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to open file: "+err.Error())
+		}
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a regular file")
+		return
+	}
+
+	response := TableResponse{OK: true, Format: format}
+	var rows table.Rows
+	if format == tableXLSX {
+		if info.Size() > config.SpreadsheetMaxSize {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, "Workbook is larger than SPREADSHEET_MAX_SIZE")
+			return
+		}
+		workbook, err := table.OpenWorkbook(file, info.Size())
+		if err != nil {
+			problem.Respond(c, http.StatusUnprocessableEntity, "Failed to read workbook: "+err.Error())
+			return
+		}
+		response.Sheets = workbook.Sheets
+		response.Sheet = c.Query("sheet")
+		if response.Sheet == "" && len(workbook.Sheets) > 0 {
+			response.Sheet = workbook.Sheets[0]
+		}
+		if rows, err = workbook.Rows(response.Sheet); err != nil {
+			if errors.Is(err, table.ErrNoSheet) {
+				problem.Respond(c, http.StatusNotFound, "Sheet not found: "+response.Sheet)
+			} else {
+				problem.Respond(c, http.StatusUnprocessableEntity, "Failed to read sheet: "+err.Error())
+			}
+			return
+		}
+	} else {
+		rows, delimiter = table.CSV(file, delimiter)
+		response.Delimiter = string(delimiter)
+	}
+	defer rows.Close()
+
+	pageReq, ok := parsePageRequest(c)
+	if !ok {
+		pageReq = pageRequest{limit: defaultPageSize}
+	}
+	header, page, total, err := readTablePage(c, rows, c.Query("header") != "false", pageReq)
+	if err != nil {
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		problem.Respond(c, http.StatusUnprocessableEntity, "Failed to parse file: "+err.Error())
+		return
+	}
+
+	width := len(header)
+	for _, row := range page {
+		width = max(width, len(row))
+	}
+	if width > maxTableColumns {
+		width = maxTableColumns
+		response.TruncatedColumns = true
+	}
+
+	response.Columns = make([]TableColumn, width)
+	response.Rows = make([][]any, len(page))
+	for i := range response.Rows {
+		response.Rows[i] = make([]any, width)
+	}
+	values := make([]string, len(page))
+	for col := range width {
+		name := ""
+		if col < len(header) {
+			name = strings.TrimSpace(header[col])
+		}
+		if name == "" {
+			name = table.ColumnName(col)
+		}
+		for i, row := range page {
+			values[i] = ""
+			if col < len(row) {
+				values[i] = row[col]
+			}
+		}
+		typ := table.Infer(values)
+		response.Columns[col] = TableColumn{Name: name, Type: typ}
+		for i, v := range values {
+			response.Rows[i][col] = table.Convert(v, typ)
+		}
+	}
+
+	response.Pagination = streamPagination(pageReq, len(page), total)
+	c.JSON(http.StatusOK, response)
+}
+
+// tableFormat picks the format from ?format= or the file's extension, and
+// the delimiter of delimited text from ?delimiter= ("tab" for a tab), which
+// is otherwise guessed
+func tableFormat(c *gin.Context, userPath string) (string, rune, bool) {
+	var delimiter rune
+	switch d := c.Query("delimiter"); {
+	case d == "":
+	case d == "tab" || d == `\t`:
+		delimiter = '\t'
+	case utf8.RuneCountInString(d) == 1 && d != `"` && d != "\n" && d != "\r":
+		delimiter, _ = utf8.DecodeRuneInString(d)
+	default:
+		problem.Respond(c, http.StatusBadRequest, "Invalid delimiter: "+d)
+		return "", 0, false
+	}
+
+	format := strings.ToLower(c.Query("format"))
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(userPath)) {
+		case ".csv", ".txt":
+			format = tableCSV
+		case ".tsv", ".tab":
+			format = tableCSV
+			if delimiter == 0 {
+				delimiter = '\t'
+			}
+		case ".xlsx", ".xlsm":
+			format = tableXLSX
+		}
+	}
+	if format != tableCSV && format != tableXLSX {
+		problem.Respond(c, http.StatusBadRequest, "Unsupported table format; use format=csv or format=xlsx")
+		return "", 0, false
+	}
+	return format, delimiter, true
+}
+
+// readTablePage reads the header row, if there is one, then skips to the
+// requested page of rows and reads it. total is the number of rows after the
+// header when they all were read, and -1 when more follow the page.
+func readTablePage(c *gin.Context, rows table.Rows, withHeader bool, req pageRequest) (header []string, page [][]string, total int, err error) {
+	next := func() ([]string, bool, error) {
+		row, err := rows.Next()
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		return row, err == nil, err
+	}
+
+	if withHeader {
+		row, ok, err := next()
+		if err != nil || !ok {
+			return nil, nil, 0, err
+		}
+		header = row
+	}
+
+	ctx := c.Request.Context()
+	for skipped := 0; skipped < req.offset; skipped++ {
+		if skipped%1000 == 0 && ctx.Err() != nil {
+			return nil, nil, 0, ctx.Err()
+		}
+		_, ok, err := next()
+		if err != nil || !ok {
+			return header, nil, skipped, err
+		}
+	}
+
+	for len(page) < req.limit {
+		row, ok, err := next()
+		if err != nil || !ok {
+			return header, page, req.offset + len(page), err
+		}
+		page = append(page, row)
+	}
+	if _, more, err := next(); more || err != nil {
+		return header, page, -1, err
+	}
+	return header, page, req.offset + len(page), nil
+}
+
+// streamPagination describes a page of count items of a list read as a
+// stream, whose total is only known once the stream ended: totalItems and
+// totalPages are -1 while more follows
+func streamPagination(req pageRequest, count, total int) *Pagination {
+	more := total < 0
+	p := &Pagination{
+		Offset:     req.offset,
+		Limit:      req.limit,
+		Page:       req.offset/req.limit + 1,
+		PageSize:   req.limit,
+		TotalItems: total,
+		TotalPages: -1,
+		HasMore:    more,
+		HasNext:    more,
+		HasPrev:    req.offset > 0,
+	}
+	if more {
+		next := req.offset + count
+		p.NextOffset = &next
+	} else {
+		p.TotalPages = (total + req.limit - 1) / req.limit
+	}
+	return p
+}
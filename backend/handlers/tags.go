@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Limits on tags
+const (
+	maxTagLength   = 64
+	maxTagsPerPath = 50
+)
+
+type TagsRequest struct {
+	Path string   `json:"path"`
+	Tags []string `json:"tags"`
+}
+
+type TagsResponse struct {
+	OK   bool     `json:"ok"`
+	Path string   `json:"path"`
+	Tags []string `json:"tags"`
+}
+
+// TagCount is a tag and how many paths carry it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// TaggedItem is a file or directory found by its tag
+type TaggedItem struct {
+	Path  string   `json:"path"`
+	Type  string   `json:"type"`
+	Size  *int64   `json:"size,omitempty"`
+	MTime int64    `json:"mtime"`
+	Tags  []string `json:"tags"`
+}
+
+// GetTags returns the tags of a path
+func GetTags(c *gin.Context) {
+	fsys, name, ok := resolveMetadataPath(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	entry, err := metadata.Get(displayPath(fsys, name))
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read tags: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, TagsResponse{OK: true, Path: displayPath(fsys, name), Tags: orEmpty(entry.Tags)})
+}
+
+// AddTags adds tags to a path
+func AddTags(c *gin.Context) {
+	updateTags(c, func(current, requested []string) []string {
+		return append(current, requested...)
+	})
+}
+
+// SetTags replaces the tags of a path; an empty list removes them all
+func SetTags(c *gin.Context) {
+	updateTags(c, func(_, requested []string) []string {
+		return requested
+	})
+}
+
+// RemoveTags removes the ?tag= tags (may be repeated) from a path, or all of
+// its tags without any
+func RemoveTags(c *gin.Context) {
+	fsys, name, ok := resolveMetadataPath(c, c.Query("path"))
+	if !ok {
+		return
+	}
+	remove := c.QueryArray("tag")
+
+	entry, err := metadata.Update(displayPath(fsys, name), func(e *metadata.Entry) {
+		if len(remove) == 0 {
+			e.Tags = nil
+			return
+		}
+		e.Tags = slices.DeleteFunc(e.Tags, func(tag string) bool {
+			return slices.Contains(remove, tag)
+		})
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to update tags: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, TagsResponse{OK: true, Path: displayPath(fsys, name), Tags: orEmpty(entry.Tags)})
+}
+
+// updateTags sets the tags of the path in the request body to what merge
+// makes of its current and the requested tags
+func updateTags(c *gin.Context, merge func(current, requested []string) []string) {
+	var req TagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	requested := metadata.NormalizeTags(req.Tags)
+	for _, tag := range requested {
+		if err := validateTag(tag); err != nil {
+			problem.Respond(c, http.StatusBadRequest, "Invalid tag "+tag+": "+err.Error())
+			return
+		}
+	}
+
+	fsys, name, ok := resolveMetadataPath(c, req.Path)
+	if !ok {
+		return
+	}
+
+	var tooMany bool
+	entry, err := metadata.Update(displayPath(fsys, name), func(e *metadata.Entry) {
+		tags := metadata.NormalizeTags(merge(e.Tags, requested))
+		if len(tags) > maxTagsPerPath {
+			tooMany = true
+			return
+		}
+		e.Tags = tags
+	})
+	if tooMany {
+		problem.Respond(c, http.StatusBadRequest, "Too many tags")
+		return
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to update tags: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, TagsResponse{OK: true, Path: displayPath(fsys, name), Tags: orEmpty(entry.Tags)})
+}
+
+// ListAllTags returns every tag used on or below ?path= (the whole tree by
+// default) with how many paths carry it, most used first
+func ListAllTags(c *gin.Context) {
+	counts := make(map[string]int)
+	err := metadata.Walk(c.DefaultQuery("path", "/"), func(_ string, e metadata.Entry) error {
+		for _, tag := range e.Tags {
+			counts[tag]++
+		}
+		return nil
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read tags: "+err.Error())
+		return
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"ok":   true,
+		"tags": tags,
+	})
+}
+
+// FindTagged returns the files and directories on or below ?path= that carry
+// a tag, in path order. Entries that no longer exist are left out.
+func FindTagged(c *gin.Context) {
+	tag := c.Param("tag")
+	var paths []string
+	entries := make(map[string]metadata.Entry)
+	err := metadata.Walk(c.DefaultQuery("path", "/"), func(p string, e metadata.Entry) error {
+		if slices.Contains(e.Tags, tag) {
+			paths = append(paths, p)
+			entries[p] = e
+		}
+		return nil
+	})
+	if err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Failed to read tags: "+err.Error())
+		return
+	}
+
+	items := make([]TaggedItem, 0, len(paths))
+	for _, p := range paths {
+		fsys, name, err := utils.ResolveFS(p)
+		if err != nil {
+			continue
+		}
+		info, err := fsys.Stat(name)
+		if err != nil {
+			continue
+		}
+		item := TaggedItem{Path: p, Type: "file", MTime: info.ModTime().UnixMilli(), Tags: entries[p].Tags}
+		if info.IsDir() {
+			item.Type = "dir"
+		} else {
+			size := info.Size()
+			item.Size = &size
+		}
+		items = append(items, item)
+	}
+
+	response := gin.H{
+		"ok":    true,
+		"tag":   tag,
+		"items": items,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["items"], response["pagination"] = paginate(items, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// addMetadata returns a copy of the items of the directory dir with their
+// tags, and notes if asked for, keeping only those tagged with filter when it
+// is set. The input is left untouched since it may be shared with the
+// listing cache.
+func addMetadata(dir string, items []FileItem, filter string, withNotes bool) ([]FileItem, error) {
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = path.Join(dir, item.Name)
+	}
+	entries, err := metadata.GetMany(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && filter == "" {
+		return items, nil
+	}
+
+	annotated := make([]FileItem, 0, len(items))
+	for i, item := range items {
+		entry := entries[metadata.Clean(paths[i])]
+		item.Tags = entry.Tags
+		if withNotes {
+			item.Note = entry.Note
+		}
+		if filter == "" || slices.Contains(item.Tags, filter) {
+			annotated = append(annotated, item)
+		}
+	}
+	return annotated, nil
+}
+
+// resolveMetadataPath resolves the path whose tags or note are read or
+// changed, answering the request itself when that fails
+func resolveMetadataPath(c *gin.Context, userPath string) (vfs.Filesystem, string, bool) {
+	if !metadata.Available() {
+		problem.Respond(c, http.StatusServiceUnavailable, metadata.ErrUnavailable.Error())
+		return nil, "", false
+	}
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path")
+		return nil, "", false
+	}
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return nil, "", false
+	}
+	if _, err := fsys.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "Path not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat path: "+err.Error())
+		}
+		return nil, "", false
+	}
+	return fsys, name, true
+}
+
+// validateTag rejects tags too long to show or with control characters
+func validateTag(tag string) error {
+	if len(tag) > maxTagLength {
+		return errors.New("longer than 64 bytes")
+	}
+	for _, r := range tag {
+		if unicode.IsControl(r) {
+			return errors.New("contains control characters")
+		}
+	}
+	return nil
+}
+
+func orEmpty(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
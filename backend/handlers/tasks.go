@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/scheduler"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Names of the built-in tasks
+const (
+	taskTempCleanup   = "temp-cleanup"
+	taskShareExpiry   = "share-expiry"
+	taskActivityPrune = "activity-prune"
+	taskSearchIndex   = "search-index"
+	taskTrashSweep    = "trash-sweep"
+)
+
+// defaultCompressAge is how old files must be before a compress task gzips
+// them, so that logs still being written are left alone
+const defaultCompressAge = 24 * time.Hour
+
+// compressedExtensions are skipped by compress tasks
+var compressedExtensions = []string{".gz", ".tgz", ".zip", ".bz2", ".xz", ".zst", ".7z", ".rar"}
+
+// StartTasks schedules the server's housekeeping and the tasks in TASKS.
+// Stale temporary files are swept once straight away, since a crash may just
+// have left some behind.
+func StartTasks() error {
+	if config.TempCleanupInterval > 0 {
+		err := scheduler.Add(scheduler.Task{
+			Name:        taskTempCleanup,
+			Schedule:    "@every " + config.TempCleanupInterval.String(),
+			Description: "Remove temporary files left by interrupted uploads, saves and backups",
+			Run: func(ctx context.Context) (string, error) {
+				removed, freed := SweepTempFiles(config.TempCleanupAge)
+				return fmt.Sprintf("Removed %d files, %d bytes", removed, freed), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.ShareSweepInterval > 0 && config.Features.Shares {
+		err := scheduler.Add(scheduler.Task{
+			Name:        taskShareExpiry,
+			Schedule:    "@every " + config.ShareSweepInterval.String(),
+			Description: "Remove expired share links",
+			Run: func(ctx context.Context) (string, error) {
+				return fmt.Sprintf("Removed %d expired shares", models.RemoveExpiredShares()), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.ActivityRetention > 0 && metadata.Available() {
+		err := scheduler.Add(scheduler.Task{
+			Name:        taskActivityPrune,
+			Schedule:    "@hourly",
+			Description: "Remove activity older than " + formatAge(config.ActivityRetention),
+			Run: func(ctx context.Context) (string, error) {
+				removed, err := metadata.PruneActivity(time.Now().Add(-config.ActivityRetention).UnixMilli())
+				return fmt.Sprintf("Removed %d changes", removed), err
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.SearchIndexInterval > 0 {
+		err := scheduler.Add(scheduler.Task{
+			Name:        taskSearchIndex,
+			Schedule:    "@every " + config.SearchIndexInterval.String(),
+			Description: "Bring the search index up to date with changes made outside the API",
+			Run: func(ctx context.Context) (string, error) {
+				read, err := reconcileSearchIndex(ctx)
+				return fmt.Sprintf("Read %d changed directories", read), err
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.TrashEnabled && (config.TrashRetention > 0 || config.TrashMaxSize > 0) {
+		err := scheduler.Add(scheduler.Task{
+			Name:        taskTrashSweep,
+			Schedule:    "@hourly",
+			Description: trashSweepDescription(),
+			Run: func(ctx context.Context) (string, error) {
+				removed, freed, err := sweepTrash(ctx)
+				return fmt.Sprintf("Removed %d entries, %d bytes", removed, freed), err
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, task := range config.Tasks {
+		if err := CheckTask(task); err != nil {
+			return err
+		}
+		err := scheduler.Add(scheduler.Task{
+			Name:        task.Name,
+			Schedule:    task.Schedule,
+			Description: taskDescription(task),
+			Run: func(ctx context.Context) (string, error) {
+				return runTaskAction(ctx, task)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	scheduler.Start()
+	if config.TempCleanupInterval > 0 {
+		_, _ = scheduler.Trigger(taskTempCleanup, scheduler.TriggerStartup)
+	}
+	if config.SearchIndexInterval > 0 {
+		_, _ = scheduler.Trigger(taskSearchIndex, scheduler.TriggerStartup)
+	}
+	return nil
+}
+
+// CheckTask reports whether a task's schedule parses, its action is known,
+// its argument fits the action and its path is a directory
+func CheckTask(task config.TaskSchedule) error {
+	if task.Name == taskTempCleanup || task.Name == taskShareExpiry || task.Name == taskActivityPrune || task.Name == taskSearchIndex || task.Name == taskTrashSweep {
+		return fmt.Errorf("task %s: name is taken by a built-in task", task.Name)
+	}
+	if _, err := cron.ParseStandard(task.Schedule); err != nil {
+		return fmt.Errorf("task %s: invalid schedule %q: %w", task.Name, task.Schedule, err)
+	}
+
+	switch task.Action {
+	case config.TaskCompress:
+		if task.Arg != "" {
+			if _, err := time.ParseDuration(task.Arg); err != nil {
+				return fmt.Errorf("task %s: invalid age %q: %w", task.Name, task.Arg, err)
+			}
+		}
+	case config.TaskPurge:
+		if age, err := time.ParseDuration(task.Arg); err != nil || age <= 0 {
+			return fmt.Errorf("task %s: purge needs the age of the files to delete, such as 720h", task.Name)
+		}
+	case config.TaskIntegrity:
+		if task.Arg != "" {
+			return fmt.Errorf("task %s: integrity takes no argument", task.Name)
+		}
+	case config.TaskThumbnails:
+		if _, _, err := parseThumbnailArg(task.Arg); err != nil {
+			return fmt.Errorf("task %s: %w", task.Name, err)
+		}
+	default:
+		return fmt.Errorf("task %s: unknown action %q (compress, purge, integrity or thumbnails)", task.Name, task.Action)
+	}
+
+	fsys, dir, err := utils.ResolveFS(task.Path)
+	if err != nil {
+		return fmt.Errorf("task %s: invalid path: %w", task.Name, err)
+	}
+	if info, err := fsys.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("task %s: %s is not a directory", task.Name, task.Path)
+	}
+	return nil
+}
+
+// taskDescription says what a task from TASKS does
+func taskDescription(task config.TaskSchedule) string {
+	switch task.Action {
+	case config.TaskCompress:
+		age := task.Arg
+		if age == "" {
+			age = "24h"
+		}
+		return fmt.Sprintf("Gzip files in %s older than %s", task.Path, age)
+	case config.TaskPurge:
+		return fmt.Sprintf("Delete files below %s older than %s", task.Path, task.Arg)
+	case config.TaskIntegrity:
+		return fmt.Sprintf("Check files below %s for changes and corruption", task.Path)
+	case config.TaskThumbnails:
+		perSecond, limit, _ := parseThumbnailArg(task.Arg)
+		description := fmt.Sprintf("Make thumbnails of the images below %s, %g a second", task.Path, perSecond)
+		if limit > 0 {
+			description += " for up to " + formatAge(limit)
+		}
+		return description
+	}
+	return task.Action + " " + task.Path
+}
+
+// formatAge shows a duration without zero minutes and seconds, as 720h
+// rather than 720h0m0s
+func formatAge(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// runTaskAction does one run of a task from TASKS
+func runTaskAction(ctx context.Context, task config.TaskSchedule) (string, error) {
+	fsys, dir, err := utils.ResolveFS(task.Path)
+	if err != nil {
+		return "", err
+	}
+	defer invalidateListing(dir)
+
+	switch task.Action {
+	case config.TaskCompress:
+		age := defaultCompressAge
+		if task.Arg != "" {
+			age, _ = time.ParseDuration(task.Arg)
+		}
+		return compressOldFiles(ctx, fsys, dir, time.Now().Add(-age))
+	case config.TaskPurge:
+		age, _ := time.ParseDuration(task.Arg)
+		return purgeOldFiles(ctx, fsys, dir, time.Now().Add(-age))
+	case config.TaskIntegrity:
+		return checkIntegrity(ctx, task.Name, fsys, dir)
+	case config.TaskThumbnails:
+		perSecond, limit, _ := parseThumbnailArg(task.Arg)
+		return pregenerateThumbnails(ctx, fsys, dir, perSecond, limit)
+	}
+	return "", fmt.Errorf("unknown action %q", task.Action)
+}
+
+// compressOldFiles gzips the regular files directly in dir last modified
+// before cutoff, skipping hidden and already compressed ones
+func compressOldFiles(ctx context.Context, fsys vfs.Filesystem, dir string, cutoff time.Time) (string, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var compressed int
+	var before, after int64
+	var errs []error
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || isCompressed(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		size, err := gzipFile(fsys, filepath.Join(dir, name), info)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		compressed++
+		before += info.Size()
+		after += size
+	}
+
+	summary := fmt.Sprintf("Compressed %d files from %d to %d bytes", compressed, before, after)
+	if len(errs) > 0 {
+		return "", fmt.Errorf("%s; %d failed: %w", summary, len(errs), errors.Join(errs...))
+	}
+	return summary, nil
+}
+
+func isCompressed(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, compressed := range compressedExtensions {
+		if ext == compressed {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipFile replaces name with name.gz and returns the compressed size. The
+// archive is written under a temporary name first, so an interrupted run
+// leaves the original in place.
+func gzipFile(fsys vfs.Filesystem, name string, info fs.FileInfo) (int64, error) {
+	dst := name + ".gz"
+	if _, err := fsys.Lstat(dst); err == nil {
+		return 0, fmt.Errorf("%s already exists", filepath.Base(dst))
+	}
+	tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(dst)+vfs.AtomicSuffix)
+
+	src, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	out, err := fsys.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(name)
+	zw.ModTime = info.ModTime()
+
+	_, err = buffers.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fsys.Rename(tmp, dst)
+	}
+	if err != nil {
+		_ = fsys.Remove(tmp)
+		return 0, err
+	}
+
+	compressed, err := fsys.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return compressed.Size(), fsys.Remove(name)
+}
+
+// purgeOldFiles deletes the regular files below dir last modified before
+// cutoff. Directories are kept, even when left empty.
+func purgeOldFiles(ctx context.Context, fsys vfs.Filesystem, dir string, cutoff time.Time) (string, error) {
+	var removed int
+	var freed int64
+	var errs []error
+	err := vfs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		if err := fsys.Remove(name); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	summary := fmt.Sprintf("Deleted %d files, %d bytes", removed, freed)
+	if len(errs) > 0 {
+		return "", fmt.Errorf("%s; %d failed: %w", summary, len(errs), errors.Join(errs...))
+	}
+	return summary, nil
+}
+
+// ListTasks returns the scheduled tasks with their next and last runs
+func ListTasks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"ok":    true,
+		"tasks": scheduler.List(),
+	})
+}
+
+// ListTaskRuns returns the remembered runs of one task, newest first
+func ListTaskRuns(c *gin.Context) {
+	runs, err := scheduler.Runs(c.Param("name"))
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	response := gin.H{
+		"ok":   true,
+		"runs": runs,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["runs"], response["pagination"] = paginate(runs, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RunTask starts a task outside its schedule
+func RunTask(c *gin.Context) {
+	run, err := scheduler.Trigger(c.Param("name"), scheduler.TriggerManual)
+	switch {
+	case errors.Is(err, scheduler.ErrTaskNotFound):
+		problem.Respond(c, http.StatusNotFound, "Task not found")
+		return
+	case errors.Is(err, scheduler.ErrTaskRunning):
+		problem.Respond(c, http.StatusConflict, "Task is already running")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"ok":  true,
+		"run": run,
+	})
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/photo"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Thumbnail sizes, the longest side in pixels
+const (
+	defaultThumbnailSize = 256
+	minThumbnailSize     = 32
+	maxThumbnailSize     = 1024
+)
+
+// defaultThumbnailRate is how many thumbnails a second thumbnails tasks make
+// unless told otherwise
+const defaultThumbnailRate = 2
+
+// thumbnailSlots limits how many images are decoded at once, as decoding a
+// large photo takes a lot of memory
+var thumbnailSlots = make(chan struct{}, runtime.NumCPU())
+
+// thumbnailGroup makes each thumbnail once when many ask for it at once
+var thumbnailGroup singleflight.Group
+
+// GetThumbnail returns a JPEG thumbnail of the image at ?path=, fitting
+// within ?size= pixels square (256 by default). Thumbnails are kept in the
+// preview cache, keyed by the image's path, modification time and size, so
+// each version of an image is only scaled once.
+func GetThumbnail(c *gin.Context) {
+	userPath := c.Query("path")
+	if userPath == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+	size, ok := thumbnailSize(c)
+	if !ok {
+		return
+	}
+
+	fsys, name, err := utils.ResolveFS(userPath)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	info, err := fsys.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, "File not found")
+		} else {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		}
+		return
+	}
+	if !info.Mode().IsRegular() || !photo.IsImage(name) {
+		problem.Respond(c, http.StatusUnsupportedMediaType, "Thumbnails are only made of JPEG, PNG and GIF images")
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x-%x-%d"`, info.ModTime().UnixNano(), info.Size(), size)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, max-age=86400")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	data, err := thumbnail(fsys, name, info, size)
+	switch {
+	case errors.Is(err, photo.ErrTooLarge):
+		problem.Respond(c, http.StatusRequestEntityTooLarge, "Image is larger than THUMBNAIL_MAX_PIXELS")
+		return
+	case errors.Is(err, image.ErrFormat):
+		problem.Respond(c, http.StatusUnprocessableEntity, "Not a valid image")
+		return
+	case err != nil:
+		problem.Respond(c, http.StatusUnprocessableEntity, "Failed to make thumbnail: "+err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+// thumbnailSize reads ?size=, answering 400 when it is out of range
+func thumbnailSize(c *gin.Context) (int, bool) {
+	param := c.Query("size")
+	if param == "" {
+		return defaultThumbnailSize, true
+	}
+	size, err := strconv.Atoi(param)
+	if err != nil || size < minThumbnailSize || size > maxThumbnailSize {
+		problem.Respond(c, http.StatusBadRequest, fmt.Sprintf("size must be between %d and %d", minThumbnailSize, maxThumbnailSize))
+		return 0, false
+	}
+	return size, true
+}
+
+// thumbnailURL is where the thumbnail of an image is served, with its
+// modification time so that browsers fetch a changed image anew
+func thumbnailURL(userPath string, mtime int64, size int) string {
+	query := url.Values{"path": {userPath}, "size": {strconv.Itoa(size)}, "v": {strconv.FormatInt(mtime, 10)}}
+	return "/api/thumbnail?" + query.Encode()
+}
+
+// thumbnail returns the cached thumbnail of an image, making it when missing.
+// A thumbnail that can't be cached is still returned.
+func thumbnail(fsys vfs.Filesystem, name string, info fs.FileInfo, size int) ([]byte, error) {
+	cachePath := thumbnailCachePath(fsys, name, info, size)
+	if data, ok := previewCache.get(cachePath); ok {
+		return data, nil
+	}
+
+	data, err, _ := thumbnailGroup.Do(cachePath, func() (any, error) {
+		thumbnailSlots <- struct{}{}
+		defer func() { <-thumbnailSlots }()
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		data, err := photo.Thumbnail(file, size, config.ThumbnailMaxPixels)
+		if err != nil {
+			return nil, err
+		}
+		if err := previewCache.put(cachePath, data); err != nil {
+			slog.Warn("Failed to cache thumbnail", "path", cachePath, "error", err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// thumbnailCachePath is where the thumbnail of a version of an image is cached
+func thumbnailCachePath(fsys vfs.Filesystem, name string, info fs.FileInfo, size int) string {
+	return previewPath(displayPath(fsys, name), fmt.Sprintf("%d\x00%d\x00%d", info.ModTime().UnixNano(), info.Size(), size))
+}
+
+// parseThumbnailArg reads the argument of a thumbnails task: how many
+// thumbnails to make a second, and optionally how long a run may take, as in
+// "5,6h"
+func parseThumbnailArg(arg string) (float64, time.Duration, error) {
+	rateArg, limitArg, hasLimit := strings.Cut(arg, ",")
+	perSecond := float64(defaultThumbnailRate)
+	if rateArg = strings.TrimSpace(rateArg); rateArg != "" {
+		var err error
+		if perSecond, err = strconv.ParseFloat(rateArg, 64); err != nil || perSecond <= 0 {
+			return 0, 0, fmt.Errorf("invalid thumbnail rate %q", rateArg)
+		}
+	}
+	var limit time.Duration
+	if hasLimit {
+		var err error
+		if limit, err = time.ParseDuration(strings.TrimSpace(limitArg)); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("invalid time limit %q", limitArg)
+		}
+	}
+	return perSecond, limit, nil
+}
+
+// pregenerateThumbnails makes the missing thumbnails of the images below dir
+// at the size galleries show, at most perSecond a second, and reads their headers
+// for galleries too. It is background work, within BACKGROUND_HOURS and
+// BACKGROUND_IO_RATE. Hidden directories are skipped. A run with a limit stops
+// when it is up, leaving the rest to the next run; images that can't be read
+// are counted but don't fail the run.
+func pregenerateThumbnails(ctx context.Context, fsys vfs.Filesystem, dir string, perSecond float64, limit time.Duration) (string, error) {
+	limiter := rate.NewLimiter(rate.Limit(perSecond), 1)
+	runCtx := ctx
+	if limit > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
+	var made, cached, failed int
+	stopped := false
+	err := vfs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if runCtx.Err() != nil {
+			stopped = true
+			return fs.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if name != dir && utils.Hidden(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !photo.IsImage(name) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if _, err := os.Stat(thumbnailCachePath(fsys, name, info, defaultThumbnailSize)); err == nil {
+			cached++
+			return nil
+		}
+		release, err := waitForThumbnail(runCtx, limiter, info.Size())
+		if err != nil {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stopped = true
+			return fs.SkipAll
+		}
+		defer release()
+		if _, err := thumbnail(fsys, name, info, defaultThumbnailSize); err != nil {
+			failed++
+			return nil
+		}
+		_, _ = cachedPhotoInfo(fsys, name, GalleryItem{MTime: info.ModTime().UnixMilli(), Size: info.Size()})
+		made++
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	summary := fmt.Sprintf("Made %d thumbnails, %d already made, %d images unreadable", made, cached, failed)
+	if stopped {
+		summary += "; stopped after " + formatAge(limit)
+	}
+	return summary, nil
+}
+
+// waitForThumbnail waits until a thumbnails task may make its next
+// thumbnail, of an image of the given size, and returns the function that
+// frees its background worker
+func waitForThumbnail(ctx context.Context, limiter *rate.Limiter, size int64) (func(), error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	release, err := background.start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := background.charge(ctx, size); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// writeThumbnail stores a thumbnail in the cache, whole or not at all
+func writeThumbnail(cachePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/torrent"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// passwordTorrentMessage refuses torrents of password-protected shares
+const passwordTorrentMessage = "Torrents can't be made of password-protected shares, as web seeds can't send the password"
+
+// ShareTorrentResponse reports the start of a torrent of a share being made
+type ShareTorrentResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+	JobID   string `json:"jobId"`
+}
+
+// ShareTorrentReport is the result of a torrent job
+type ShareTorrentReport struct {
+	InfoHash    string `json:"infoHash"`
+	Files       int    `json:"files"`
+	Size        int64  `json:"size"`
+	PieceLength int64  `json:"pieceLength"`
+	Pieces      int    `json:"pieces"`
+}
+
+// CreateShareTorrent starts making a torrent of a share, replacing the one
+// it has. Its web seed is the share itself, so the torrent must be made
+// again when the shared files change.
+func CreateShareTorrent(c *gin.Context) {
+	share, ok := liveShare(c)
+	if !ok {
+		return
+	}
+	if share.Password != "" {
+		problem.Respond(c, http.StatusBadRequest, passwordTorrentMessage)
+		return
+	}
+	job, err := startShareTorrent(share)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create job")
+		return
+	}
+	c.JSON(http.StatusAccepted, ShareTorrentResponse{OK: true, Message: "Torrent started", JobID: job.ID})
+}
+
+// GetShareTorrent returns the .torrent of a share
+func GetShareTorrent(c *gin.Context) {
+	share, ok := liveShare(c)
+	if !ok {
+		return
+	}
+	if share.Torrent == nil {
+		problem.Respond(c, http.StatusNotFound, "The share has no torrent")
+		return
+	}
+	c.Header("Content-Disposition", attachment(filepath.Base(share.Path)+".torrent"))
+	c.Data(http.StatusOK, "application/x-bittorrent", share.Torrent)
+}
+
+// SeedShare serves the files of a shared directory to BitTorrent clients,
+// which ask for <web seed>/<directory name>/<path>. Ranges are supported,
+// as clients fetch a piece at a time.
+func SeedShare(c *gin.Context) {
+	share, ok := liveShare(c)
+	if !ok {
+		return
+	}
+	if share.Password != "" {
+		problem.Respond(c, http.StatusForbidden, "Password-protected shares aren't web seeds")
+		return
+	}
+	top, rest, _ := strings.Cut(strings.TrimPrefix(c.Param("path"), "/"), "/")
+	if share.Type != "dir" || top != filepath.Base(share.Path) || rest == "" {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	target := filepath.Join(share.Path, filepath.Clean("/"+rest))
+	if err := utils.CheckRealPath(target); err != nil || !utils.IsWithin(share.Path, target) {
+		problem.Respond(c, http.StatusBadRequest, "Invalid path")
+		return
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// liveShare looks up the share of the route, answering 404 when it doesn't
+// exist, expired or what it shares is gone
+func liveShare(c *gin.Context) (*models.Share, bool) {
+	share, exists := models.GetShare(c.Param("shareId"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Share not found")
+		return nil, false
+	}
+	if share.ExpiresAt != nil && *share.ExpiresAt < time.Now().UnixMilli() {
+		models.DeleteShare(share.ID)
+		problem.Respond(c, http.StatusNotFound, "Share has expired")
+		return nil, false
+	}
+	if !utils.FileExists(share.Path) {
+		models.DeleteShare(share.ID)
+		problem.Respond(c, http.StatusNotFound, "Shared file or directory no longer exists")
+		return nil, false
+	}
+	return share, true
+}
+
+// startShareTorrent makes a torrent of a share in a background job
+func startShareTorrent(share *models.Share) (*models.Job, error) {
+	job, err := models.NewJob("torrent", displayPath(vfs.OS, share.Path), "")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		report, err := buildShareTorrent(job, share)
+		job.SetResult(report)
+		finishReportJob(job, err)
+	}()
+	return job, nil
+}
+
+// buildShareTorrent hashes the visible files of a share into a torrent whose
+// web seed is the share, and attaches it to the share
+func buildShareTorrent(job *models.Job, share *models.Share) (*ShareTorrentReport, error) {
+	name := filepath.Base(share.Path)
+	base := config.BaseURL + "/api/fs/share/" + share.ID
+	opts := torrent.Options{
+		Name:      name,
+		Trackers:  config.TorrentTrackers,
+		WebSeeds:  []string{base + "/download"},
+		Comment:   share.Title,
+		CreatedBy: "NextBrowse",
+	}
+
+	var files []torrent.File
+	if share.Type == "dir" {
+		opts.WebSeeds = []string{base + "/seed/"}
+		err := filepath.WalkDir(share.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p != share.Path && utils.Hidden(d.Name()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(share.Path, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, torrent.File{Path: strings.Split(filepath.ToSlash(rel), "/"), Size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, errors.New("the shared directory has no files")
+		}
+	} else {
+		info, err := os.Stat(share.Path)
+		if err != nil {
+			return nil, err
+		}
+		files = []torrent.File{{Size: info.Size()}}
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	job.AddTotals(int64(len(files)), total)
+
+	open := func(f torrent.File) (io.ReadCloser, error) {
+		p := filepath.Join(append([]string{share.Path}, f.Path...)...)
+		job.SetCurrentFile(displayPath(vfs.OS, p))
+		file, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		return jobItem{file, job}, nil
+	}
+	t, err := torrent.Create(job.Context(), opts, files, open, job.AddBytes)
+	if err != nil {
+		return nil, err
+	}
+	if !models.SetShareTorrent(share.ID, t.Data, t.InfoHash) {
+		return nil, errors.New("the share was deleted")
+	}
+	return &ShareTorrentReport{
+		InfoHash:    t.InfoHash,
+		Files:       len(files),
+		Size:        t.Size,
+		PieceLength: t.PieceLength,
+		Pieces:      t.Pieces,
+	}, nil
+}
+
+// jobItem is a file a job reads, counted as done once closed
+type jobItem struct {
+	*os.File
+	job *models.Job
+}
+
+func (f jobItem) Close() error {
+	f.job.ItemDone()
+	return f.File.Close()
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// Job types for copies and moves between storage backends
+const (
+	jobTransferCopy = "transfer"
+	jobTransferMove = "transfer-move"
+)
+
+// jobType returns the job type for op ("copy" or "move") between two backends
+func jobType(op string, srcFS, dstFS vfs.Filesystem) string {
+	if srcFS == dstFS {
+		return op
+	}
+	if op == "move" {
+		return jobTransferMove
+	}
+	return jobTransferCopy
+}
+
+// errChecksumMismatch is returned when a transferred file doesn't read back
+// with the checksum of the data that was sent
+var errChecksumMismatch = errors.New("checksum mismatch after transfer")
+
+// runTransferJob copies src on srcFS to dst on dstFS by streaming every file
+// through this server. Each file is retried on failure and, with
+// TRANSFER_VERIFY, read back and compared by SHA-256. A move removes the
+// source only if every entry transferred.
+func runTransferJob(job *models.Job, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, dst string, move bool) {
+	defer invalidateListing(src, dst)
+
+	_, err := dstFS.Stat(dst)
+	created := err != nil
+	job.AddTotals(scanTreeFS(job.Context(), srcFS, src))
+
+	err = vfs.WalkDir(srcFS, src, func(name string, d fs.DirEntry, err error) error {
+		if ctxErr := job.Context().Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			job.AddError(displayPath(srcFS, name), err)
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := dst
+		if rel != "." {
+			target = filepath.Join(dst, rel)
+		}
+
+		switch {
+		case d.IsDir():
+			if err := dstFS.MkdirAll(target, 0755); err != nil {
+				job.AddError(displayPath(srcFS, name), err)
+				return fs.SkipDir
+			}
+			job.ItemDone()
+		case d.Type().IsRegular():
+			if err := transferFile(job, srcFS, name, dstFS, target); err != nil {
+				if ctxErr := job.Context().Err(); ctxErr != nil {
+					return ctxErr
+				}
+				job.AddError(displayPath(srcFS, name), err)
+			}
+		default:
+			job.AddError(displayPath(srcFS, name), errors.New("only regular files and directories can be transferred"))
+		}
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		if created {
+			_ = dstFS.RemoveAll(dst)
+		}
+		job.Finish(models.JobCancelled, "Operation cancelled")
+	case err != nil:
+		job.Finish(models.JobFailed, err.Error())
+	case len(job.Errors()) > 0:
+		job.Finish(models.JobFailed, fmt.Sprintf("Completed with %d errors", len(job.Errors())))
+	case move:
+		if err := removeTree(srcFS, src); err != nil {
+			job.Finish(models.JobFailed, "Transferred but failed to remove source: "+err.Error())
+			return
+		}
+		job.Finish(models.JobCompleted, "")
+	default:
+		job.Finish(models.JobCompleted, "")
+	}
+}
+
+// displayPath returns the user path for a name on fsys
+func displayPath(fsys vfs.Filesystem, name string) string {
+	if vfs.IsLocal(fsys) {
+		return utils.ToUserPath(name)
+	}
+	return name
+}
+
+// removeTree deletes a file or directory tree on any backend
+func removeTree(fsys vfs.Filesystem, name string) error {
+	if vfs.IsLocal(fsys) {
+		return fastDelete(context.Background(), name)
+	}
+	return fsys.RemoveAll(name)
+}
+
+// transferFile copies one file between backends, retrying up to
+// TRANSFER_RETRIES times with a growing delay. A failed attempt's progress is
+// taken back so the job's byte count stays accurate.
+func transferFile(job *models.Job, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, dst string) error {
+	ctx := job.Context()
+	job.SetCurrentFile(displayPath(srcFS, src))
+
+	var err error
+	for attempt := 0; attempt <= config.TransferRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		var sent int64
+		sent, err = transferOnce(ctx, job, srcFS, src, dstFS, dst)
+		if err == nil {
+			job.ItemDone()
+			return nil
+		}
+		job.AddBytes(-sent)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	_ = dstFS.Remove(dst)
+	return err
+}
+
+// transferOnce streams src to dst once, returning the bytes sent
+func transferOnce(ctx context.Context, job *models.Job, srcFS vfs.Filesystem, src string, dstFS vfs.Filesystem, dst string) (int64, error) {
+	file, err := srcFS.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	hash := sha256.New()
+	reader := &progressReader{ctx: ctx, job: job, r: io.TeeReader(file, hash)}
+	if err := vfs.WriteFrom(dstFS, dst, reader, info.Size()); err != nil {
+		return reader.n, err
+	}
+	if reader.n != info.Size() {
+		return reader.n, fmt.Errorf("source changed during transfer: read %d of %d bytes", reader.n, info.Size())
+	}
+
+	if config.TransferVerify {
+		sum, err := checksumFile(ctx, dstFS, dst)
+		if err != nil {
+			return reader.n, fmt.Errorf("verify: %w", err)
+		}
+		if !bytes.Equal(sum, hash.Sum(nil)) {
+			return reader.n, errChecksumMismatch
+		}
+	}
+	return reader.n, nil
+}
+
+// checksumFile returns the SHA-256 of a file as stored on fsys
+func checksumFile(ctx context.Context, fsys vfs.Filesystem, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := buffers.Copy(hash, &progressReader{ctx: ctx, r: file}); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// progressReader reports bytes read to a job and fails once the job's context
+// is cancelled, so uploads to remote backends stop promptly
+type progressReader struct {
+	ctx context.Context
+	job *models.Job
+	r   io.Reader
+	n   int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if p.job != nil {
+		p.job.AddBytes(int64(n))
+	}
+	return n, err
+}
+
+// scanTreeFS is scanTree for any backend
+func scanTreeFS(ctx context.Context, fsys vfs.Filesystem, root string) (items, bytes int64) {
+	if vfs.IsLocal(fsys) {
+		return scanTree(ctx, root)
+	}
+
+	_ = vfs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		items++
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	return items, bytes
+}
@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// trashDirName is the directory at the top of the root that deletes move
+// entries to, each under its ID next to an <ID>.json describing it
+const trashDirName = ".trash"
+
+// TrashItem is an entry in the trash
+type TrashItem struct {
+	ID        string `json:"id"`
+	Path      string `json:"path"` // where it was deleted from
+	Type      string `json:"type"` // file or dir
+	Size      int64  `json:"size"`
+	DeletedAt int64  `json:"deletedAt"`
+}
+
+// TrashRestoreRequest restores an entry of the trash to Destination, by
+// default where it was deleted from
+type TrashRestoreRequest struct {
+	Destination string `json:"destination,omitempty"`
+}
+
+// trashMu serializes changes to the trash
+var trashMu sync.Mutex
+
+// errTrashCrossDevice is returned for entries on another filesystem than
+// the trash, which can't be moved there without copying them
+var errTrashCrossDevice = errors.New("entry is on another filesystem than the trash")
+
+// trashDir is the trash, as resolved on local storage
+func trashDir() (string, error) {
+	_, dir, err := utils.ResolveFS("/" + trashDirName)
+	return dir, err
+}
+
+// inTrash reports whether a user path is the trash or inside it
+func inTrash(userPath string) bool {
+	return userPath == "/"+trashDirName || strings.HasPrefix(userPath, "/"+trashDirName+"/")
+}
+
+// useTrash reports whether deleting safePath on fsys moves it to the trash
+func useTrash(fsys vfs.Filesystem, safePath string) bool {
+	return config.TrashEnabled && vfs.IsLocal(fsys) && !inTrash(displayPath(fsys, safePath))
+}
+
+// moveToTrash moves a local file or directory into the trash. Its
+// description is written first, so that the entry is never in the trash
+// without one.
+func moveToTrash(fsys vfs.Filesystem, safePath string) (*TrashItem, error) {
+	return moveToTrashAs(safePath, displayPath(fsys, safePath))
+}
+
+// moveToTrashAs moves the local entry at safePath into the trash as the
+// entry deleted from userPath
+func moveToTrashAs(safePath, userPath string) (*TrashItem, error) {
+	info, err := os.Lstat(safePath)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	item := &TrashItem{
+		ID:        hex.EncodeToString(id),
+		Path:      userPath,
+		Type:      "file",
+		Size:      info.Size(),
+		DeletedAt: time.Now().UnixMilli(),
+	}
+	if info.IsDir() {
+		item.Type = "dir"
+		_, item.Size = scanTree(context.Background(), safePath)
+	}
+
+	dir, err := trashDir()
+	if err != nil {
+		return nil, err
+	}
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, item.ID+".json"), data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(safePath, filepath.Join(dir, item.ID)); err != nil {
+		_ = os.Remove(filepath.Join(dir, item.ID+".json"))
+		if errors.Is(err, syscall.EXDEV) {
+			return nil, errTrashCrossDevice
+		}
+		return nil, err
+	}
+	return item, nil
+}
+
+// readTrash returns the entries in the trash, newest first
+func readTrash() ([]TrashItem, error) {
+	dir, err := trashDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []TrashItem{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	items := []TrashItem{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var item TrashItem
+		if err := json.Unmarshal(data, &item); err != nil || item.ID != id {
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt > items[j].DeletedAt })
+	return items, nil
+}
+
+// findTrashItem returns the trash entry with the given ID
+func findTrashItem(id string) (*TrashItem, bool) {
+	items, err := readTrash()
+	if err != nil {
+		return nil, false
+	}
+	for i := range items {
+		if items[i].ID == id {
+			return &items[i], true
+		}
+	}
+	return nil, false
+}
+
+// removeTrashItem deletes an entry from the trash for good; callers hold trashMu
+func removeTrashItem(ctx context.Context, id string) error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	if err := fastDelete(ctx, filepath.Join(dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sweepTrash removes the entries deleted longer than TRASH_RETENTION ago,
+// then the oldest entries until the trash is within TRASH_MAX_SIZE
+func sweepTrash(ctx context.Context) (int, int64, error) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	items, err := readTrash()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	cutoff := time.Now().Add(-config.TrashRetention).UnixMilli()
+	removed, freed := 0, int64(0)
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		expired := config.TrashRetention > 0 && item.DeletedAt < cutoff
+		if !expired && (config.TrashMaxSize <= 0 || total <= config.TrashMaxSize) {
+			break
+		}
+		if err := removeTrashItem(ctx, item.ID); err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += item.Size
+		total -= item.Size
+	}
+	return removed, freed, nil
+}
+
+// trashSweepDescription says what the trash sweep removes
+func trashSweepDescription() string {
+	var limits []string
+	if config.TrashRetention > 0 {
+		limits = append(limits, "deleted more than "+formatAge(config.TrashRetention)+" ago")
+	}
+	if config.TrashMaxSize > 0 {
+		limits = append(limits, fmt.Sprintf("the oldest beyond %d bytes", config.TrashMaxSize))
+	}
+	return "Remove trash " + strings.Join(limits, ", and ")
+}
+
+// ListTrash returns the entries in the trash, newest first, with the size
+// of them all
+func ListTrash(c *gin.Context) {
+	items, err := readTrash()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read trash: "+err.Error())
+		return
+	}
+	var size int64
+	for _, item := range items {
+		size += item.Size
+	}
+
+	response := gin.H{
+		"ok":    true,
+		"items": items,
+		"size":  size,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["items"], response["pagination"] = paginate(items, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreTrashItem moves an entry out of the trash, back where it was
+// deleted from or to another destination, which must not exist yet
+func RestoreTrashItem(c *gin.Context) {
+	var req TrashRestoreRequest
+	_ = c.ShouldBindJSON(&req) // the body is optional
+
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	item, ok := findTrashItem(c.Param("id"))
+	if !ok {
+		problem.Respond(c, http.StatusNotFound, "Not in trash")
+		return
+	}
+	if req.Destination == "" {
+		req.Destination = item.Path
+	}
+
+	fsys, dstPath, err := utils.ResolveFS(req.Destination)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid destination path: "+err.Error())
+		return
+	}
+	if !vfs.IsLocal(fsys) || inTrash(displayPath(fsys, dstPath)) {
+		problem.Respond(c, http.StatusBadRequest, "Trash can only be restored to local storage outside the trash")
+		return
+	}
+	if _, err := os.Lstat(dstPath); err == nil {
+		problem.Respond(c, http.StatusConflict, "Destination already exists")
+		return
+	}
+
+	dir, err := trashDir()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to restore: "+err.Error())
+		return
+	}
+	// Restored entries count against the quotas of their destination, as
+	// moves from elsewhere do
+	src := filepath.Join(dir, item.ID)
+	if !admitTree(c, vfs.OS, src, displayPath(vfs.OS, src), req.Destination, true) {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.WriteLock(dstPath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	err = os.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err == nil {
+		err = os.Rename(src, dstPath)
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to restore: "+err.Error())
+		return
+	}
+	_ = os.Remove(filepath.Join(dir, item.ID+".json"))
+
+	invalidateListing(dstPath)
+	publishChange(c.ClientIP(), events.FileRestored, fsys, dstPath, gin.H{"trashId": item.ID})
+
+	c.JSON(http.StatusOK, OperationResponse{
+		OK:      true,
+		Message: "Restored from trash",
+	})
+}
+
+// DeleteTrashItem removes one entry from the trash for good
+func DeleteTrashItem(c *gin.Context) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	item, ok := findTrashItem(c.Param("id"))
+	if !ok {
+		problem.Respond(c, http.StatusNotFound, "Not in trash")
+		return
+	}
+	if err := removeTrashItem(c.Request.Context(), item.ID); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to remove from trash: "+err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, OperationResponse{
+		OK:      true,
+		Message: "Removed from trash",
+	})
+}
+
+// EmptyTrash removes every entry from the trash for good
+func EmptyTrash(c *gin.Context) {
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	items, err := readTrash()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to read trash: "+err.Error())
+		return
+	}
+	var freed int64
+	for _, item := range items {
+		if err := removeTrashItem(c.Request.Context(), item.ID); err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to empty trash: "+err.Error())
+			return
+		}
+		freed += item.Size
+	}
+	c.JSON(http.StatusOK, OperationResponse{
+		OK:      true,
+		Message: fmt.Sprintf("Removed %d entries, %d bytes", len(items), freed),
+	})
+}
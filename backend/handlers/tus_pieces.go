@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+)
+
+// Piecewise checksums let a client send the SHA-256 of every piece of a large
+// upload, so that each piece is checked as soon as it has arrived rather than
+// hashing the whole file at the end. The hashes are kept next to the partial
+// file, 32 bytes each, as a long list would make every update of the upload's
+// record slow.
+const tusPiecesSuffix = ".pieces"
+
+// Limits of the piece size a client may choose
+const (
+	minPieceSize = 64 << 10
+	maxPieceSize = 1 << 30
+)
+
+// maxPiecesBody bounds the request listing the hashes of the pieces, enough
+// for 8 MiB pieces of the largest upload
+const maxPiecesBody = 16 << 20
+
+// StatusChecksumMismatch is the TUS checksum extension's status for data that
+// doesn't match its checksum
+const StatusChecksumMismatch = 460
+
+// errPieceMismatch is returned when a piece of an upload doesn't match its
+// hash
+var errPieceMismatch = errors.New("piece does not match its checksum")
+
+// PiecesRequest lists the SHA-256 of every piece of an upload, in hex. Each
+// piece is PieceSize bytes except for the last, which holds the rest.
+type PiecesRequest struct {
+	PieceSize int64    `json:"pieceSize" binding:"required"`
+	SHA256    []string `json:"sha256" binding:"required"`
+}
+
+// TusPiecesHandler sets the piecewise checksums of an upload. Pieces already
+// received are checked at once; from then on each PATCH checks the pieces it
+// completes.
+func TusPiecesHandler(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	upload, err := uploadRegistry().Get(c.Param("id"))
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Upload registry unavailable")
+		return
+	}
+	if upload == nil {
+		problem.Respond(c, http.StatusNotFound, "Upload not found")
+		return
+	}
+
+	var req PiecesRequest
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPiecesBody)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.PieceSize < minPieceSize || req.PieceSize > maxPieceSize {
+		problem.Respond(c, http.StatusBadRequest, fmt.Sprintf("pieceSize must be between %d and %d bytes", minPieceSize, maxPieceSize))
+		return
+	}
+	pieces := (upload.Size + req.PieceSize - 1) / req.PieceSize
+	if int64(len(req.SHA256)) != pieces {
+		problem.Respond(c, http.StatusBadRequest, fmt.Sprintf("An upload of %d bytes has %d pieces of %d bytes, not %d", upload.Size, pieces, req.PieceSize, len(req.SHA256)))
+		return
+	}
+	hashes := make([]byte, 0, pieces*sha256.Size)
+	for i, encoded := range req.SHA256 {
+		sum, err := hex.DecodeString(encoded)
+		if err != nil || len(sum) != sha256.Size {
+			problem.Respond(c, http.StatusBadRequest, fmt.Sprintf("sha256[%d] is not a hex SHA-256", i))
+			return
+		}
+		hashes = append(hashes, sum...)
+	}
+
+	if err := os.WriteFile(upload.FilePath+tusPiecesSuffix, hashes, 0644); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to store piece checksums")
+		return
+	}
+	upload.PieceSize = req.PieceSize
+	upload.Verified = 0
+	if stat, err := os.Stat(upload.FilePath); err == nil {
+		upload.Offset = stat.Size()
+	}
+	if !checkPieces(c, upload) {
+		return
+	}
+	if err := uploadRegistry().Put(upload); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to record upload progress")
+		return
+	}
+	c.Header("Upload-Offset", fmt.Sprintf("%d", upload.Offset))
+	c.JSON(http.StatusOK, gin.H{"ok": true, "pieces": pieces, "verified": upload.Verified})
+}
+
+// checkPieces verifies the pieces of an upload completed since the last
+// check. A piece that doesn't match is cut off with everything after it and
+// answered with StatusChecksumMismatch, leaving the client to send it again
+// from the Upload-Offset returned. It reports whether the upload can go on.
+func checkPieces(c *gin.Context, upload *TusUpload) bool {
+	if upload.PieceSize == 0 {
+		return true
+	}
+	err := verifyPieces(upload)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, errPieceMismatch) {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to verify upload: "+err.Error())
+		return false
+	}
+
+	piece := upload.Verified / upload.PieceSize
+	if err := os.Truncate(upload.FilePath, upload.Verified); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to discard corrupt piece")
+		return false
+	}
+	upload.Offset = upload.Verified
+	if err := uploadRegistry().Put(upload); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to record upload progress")
+		return false
+	}
+	c.Header("Upload-Offset", fmt.Sprintf("%d", upload.Offset))
+	problem.Respond(c, StatusChecksumMismatch, fmt.Sprintf("Piece %d does not match its checksum; resend from offset %d", piece, upload.Offset),
+		gin.H{"piece": piece, "offset": upload.Offset})
+	return false
+}
+
+// verifyPieces hashes the pieces of an upload that are complete up to its
+// offset and haven't been checked yet, advancing Verified past those that
+// match. It returns errPieceMismatch at the first that doesn't.
+func verifyPieces(upload *TusUpload) error {
+	file, err := os.Open(upload.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hashes, err := os.Open(upload.FilePath + tusPiecesSuffix)
+	if err != nil {
+		return err
+	}
+	defer hashes.Close()
+
+	want := make([]byte, sha256.Size)
+	for upload.Verified < upload.Size {
+		end := min(upload.Verified+upload.PieceSize, upload.Size)
+		if end > upload.Offset {
+			return nil
+		}
+		if _, err := hashes.ReadAt(want, upload.Verified/upload.PieceSize*sha256.Size); err != nil {
+			return err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(file, upload.Verified, end-upload.Verified)); err != nil {
+			return err
+		}
+		if !bytes.Equal(h.Sum(nil), want) {
+			return errPieceMismatch
+		}
+		upload.Verified = end
+	}
+	return nil
+}
+
+// removeUploadPieces discards the piecewise checksums of an upload
+func removeUploadPieces(upload *TusUpload) {
+	_ = os.Remove(upload.FilePath + tusPiecesSuffix)
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nextbrowse-backend/models"
+)
+
+// tusUploadExpiry is how long an upload may sit without progress before it
+// is forgotten
+const tusUploadExpiry = 24 * time.Hour
+
+// tusRegistry keeps the records of uploads in progress. Get returns nil for
+// an unknown upload, and Find for a (user, fingerprint) pair with no upload in
+// progress; a later upload with the same pair takes over the pair.
+type tusRegistry interface {
+	Get(id string) (*TusUpload, error)
+	Find(user, fingerprint string) (*TusUpload, error)
+	Put(upload *TusUpload) error
+	Delete(id string) error
+	List() ([]*TusUpload, error)
+}
+
+// uploadRegistry returns the Redis registry when Redis is configured, so that
+// any replica can continue an upload, and the in-process one otherwise
+func uploadRegistry() tusRegistry {
+	if models.Redis != nil {
+		return redisTusRegistry{client: models.Redis}
+	}
+	return memoryUploads
+}
+
+var memoryUploads = &memoryTusRegistry{uploads: make(map[string]*TusUpload)}
+
+// memoryTusRegistry keeps uploads in process. Records are copied in and out
+// so that handlers can't change them without Put, as with Redis.
+type memoryTusRegistry struct {
+	mu      sync.RWMutex
+	uploads map[string]*TusUpload
+}
+
+func (r *memoryTusRegistry) Get(id string) (*TusUpload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	upload, ok := r.uploads[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *upload
+	return &copied, nil
+}
+
+func (r *memoryTusRegistry) Find(user, fingerprint string) (*TusUpload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var found *TusUpload
+	for _, upload := range r.uploads {
+		if upload.User == user && upload.Fingerprint == fingerprint &&
+			(found == nil || upload.CreatedAt.After(found.CreatedAt)) {
+			found = upload
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	copied := *found
+	return &copied, nil
+}
+
+func (r *memoryTusRegistry) Put(upload *TusUpload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *upload
+	r.uploads[upload.ID] = &copied
+	return nil
+}
+
+func (r *memoryTusRegistry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.uploads, id)
+	return nil
+}
+
+func (r *memoryTusRegistry) List() ([]*TusUpload, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	uploads := make([]*TusUpload, 0, len(r.uploads))
+	for _, upload := range r.uploads {
+		copied := *upload
+		uploads = append(uploads, &copied)
+	}
+	return uploads, nil
+}
+
+// redisTusRegistry keeps uploads as JSON in Redis. Records expire after
+// tusUploadExpiry without progress.
+type redisTusRegistry struct {
+	client *redis.Client
+}
+
+const (
+	redisTusPrefix = models.RedisKeyPrefix + "tus:"
+	// redisTusFingerprintPrefix keys the ID of the upload of a (user,
+	// fingerprint) pair. It is outside redisTusPrefix so List doesn't see it.
+	redisTusFingerprintPrefix = models.RedisKeyPrefix + "tus-fingerprint:"
+)
+
+// redisFingerprintKey is the key of a (user, fingerprint) pair, hashed as
+// both are chosen by clients
+func redisFingerprintKey(user, fingerprint string) string {
+	sum := sha256.Sum256([]byte(user + "\x00" + fingerprint))
+	return redisTusFingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+func (r redisTusRegistry) Get(id string) (*TusUpload, error) {
+	data, err := r.client.Get(context.Background(), redisTusPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r redisTusRegistry) Find(user, fingerprint string) (*TusUpload, error) {
+	id, err := r.client.Get(context.Background(), redisFingerprintKey(user, fingerprint)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload, err := r.Get(id)
+	if err != nil || upload == nil || upload.User != user || upload.Fingerprint != fingerprint {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (r redisTusRegistry) Put(upload *TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, redisTusPrefix+upload.ID, data, tusUploadExpiry)
+	if upload.Fingerprint != "" {
+		pipe.Set(ctx, redisFingerprintKey(upload.User, upload.Fingerprint), upload.ID, tusUploadExpiry)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r redisTusRegistry) Delete(id string) error {
+	ctx := context.Background()
+	upload, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	if upload != nil && upload.Fingerprint != "" {
+		// Only while it still names this upload, not a later one
+		key := redisFingerprintKey(upload.User, upload.Fingerprint)
+		if current, err := r.client.Get(ctx, key).Result(); err == nil && current == id {
+			_ = r.client.Del(ctx, key).Err()
+		}
+	}
+	return r.client.Del(ctx, redisTusPrefix+id).Err()
+}
+
+func (r redisTusRegistry) List() ([]*TusUpload, error) {
+	ctx := context.Background()
+	var uploads []*TusUpload
+	iter := r.client.Scan(ctx, 0, redisTusPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		upload, err := r.Get(iter.Val()[len(redisTusPrefix):])
+		if err != nil {
+			return nil, err
+		}
+		if upload != nil {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, iter.Err()
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+	"nextbrowse-backend/middleware"
+)
+
+// Chunk sizes recommended to TUS clients. A chunk should take about
+// chunkTargetDuration to send: long enough that per-request overhead
+// doesn't matter, short enough that a dropped connection loses little.
+const (
+	defaultChunkSize    = 8 << 20
+	minChunkSize        = 1 << 20
+	maxChunkSize        = 64 << 20
+	chunkTargetDuration = 4 * time.Second
+
+	// defaultConcurrentUploads is recommended while nothing has been
+	// measured; more are never recommended than maxConcurrentUploads
+	defaultConcurrentUploads = 6
+	maxConcurrentUploads     = 8
+
+	// Chunks smaller or quicker than this say more about latency than speed
+	// and aren't measured
+	minMeasuredBytes    = 256 << 10
+	minMeasuredDuration = 50 * time.Millisecond
+
+	// diskRateWeight is how much a chunk's write speed counts against the
+	// speed known so far
+	diskRateWeight = 0.3
+)
+
+// diskWriteRate is how fast upload chunks have been written out. It is the
+// server's own and isn't kept across restarts.
+var diskWriteRate struct {
+	mu             sync.Mutex
+	bytesPerSecond float64
+}
+
+// UploadTuning explains a TUS configuration's recommendations
+type UploadTuning struct {
+	// Basis is "measured" when the client's recent uploads were taken into
+	// account, "default" otherwise
+	Basis                string  `json:"basis"`
+	ClientBytesPerSecond float64 `json:"clientBytesPerSecond,omitempty"`
+	DiskBytesPerSecond   float64 `json:"diskBytesPerSecond,omitempty"`
+	CPUs                 int     `json:"cpus"`
+	ChunkSize            int64   `json:"chunkSize"`
+	MaxConcurrentUploads int     `json:"maxConcurrentUploads"`
+}
+
+// timedWriter adds up the time spent in its writer's Write
+type timedWriter struct {
+	w       io.Writer
+	elapsed time.Duration
+}
+
+func (t *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	t.elapsed += time.Since(start)
+	return n, err
+}
+
+// recordUploadSpeed learns from a chunk of written bytes that took elapsed
+// to receive, writeTime of which went into writing it out
+func recordUploadSpeed(c *gin.Context, written int64, elapsed, writeTime time.Duration) {
+	if written < minMeasuredBytes || elapsed < minMeasuredDuration {
+		return
+	}
+	if writeTime > 0 {
+		rate := float64(written) / writeTime.Seconds()
+		diskWriteRate.mu.Lock()
+		if diskWriteRate.bytesPerSecond == 0 {
+			diskWriteRate.bytesPerSecond = rate
+		} else {
+			diskWriteRate.bytesPerSecond += diskRateWeight * (rate - diskWriteRate.bytesPerSecond)
+		}
+		diskWriteRate.mu.Unlock()
+	}
+	if _, err := metadata.RecordUploadRate(middleware.User(c), float64(written)/elapsed.Seconds()); err != nil && metadata.Available() {
+		slog.Warn("Failed to save upload speed", "error", err)
+	}
+}
+
+// uploadTuning recommends a chunk size and a number of parallel uploads to
+// the client of c. Chunks are sized to the speed its uploads have been
+// arriving at over one connection; connections are added while the disk
+// keeps up with them, up to one per CPU.
+func uploadTuning(c *gin.Context) UploadTuning {
+	cpus := runtime.NumCPU()
+	limit := min(max(cpus, 2), maxConcurrentUploads)
+	tuning := UploadTuning{
+		Basis:                "default",
+		CPUs:                 cpus,
+		ChunkSize:            min(defaultChunkSize, tusMaxSize),
+		MaxConcurrentUploads: min(defaultConcurrentUploads, limit),
+	}
+
+	diskWriteRate.mu.Lock()
+	tuning.DiskBytesPerSecond = diskWriteRate.bytesPerSecond
+	diskWriteRate.mu.Unlock()
+
+	hint, found, _ := metadata.GetUploadHint(middleware.User(c))
+	if !found || hint.BytesPerSecond <= 0 {
+		return tuning
+	}
+	tuning.Basis = "measured"
+	tuning.ClientBytesPerSecond = hint.BytesPerSecond
+
+	chunk := int64(hint.BytesPerSecond*chunkTargetDuration.Seconds()) / minChunkSize * minChunkSize
+	tuning.ChunkSize = min(max(chunk, minChunkSize), maxChunkSize, tusMaxSize)
+	if tuning.DiskBytesPerSecond > 0 {
+		connections := int(tuning.DiskBytesPerSecond / hint.BytesPerSecond)
+		tuning.MaxConcurrentUploads = min(max(connections, 1), limit)
+	}
+	return tuning
+}
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,10 +13,18 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/metrics"
+	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/problem"
 	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
 )
 
 // TUS upload metadata
@@ -26,27 +37,44 @@ type TusUpload struct {
 	CreatedAt    time.Time
 	LastModified time.Time
 	FilePath     string // Actual file path on disk
+	// Extract unpacks the uploaded ZIP into Path instead of keeping it,
+	// resolving clashes with existing files by the Conflict policy
+	Extract  bool
+	Conflict string
+	// User created the upload and Fingerprint is what the client called the
+	// file it is sending, so that another of the user's devices can find the
+	// upload and resume it
+	User        string
+	Fingerprint string
+	// PieceSize is the size of the pieces the client sent checksums of, if
+	// it did, and Verified how far they have been checked
+	PieceSize int64
+	Verified  int64
 }
 
+// maxFingerprintLength is the longest upload fingerprint taken
+const maxFingerprintLength = 256
+
 var (
-	// In-memory store for active uploads (in production, use Redis or DB)
-	activeUploads = make(map[string]*TusUpload)
-	
 	// TUS configuration
 	tusMaxSize = int64(10 * 1024 * 1024 * 1024) // 10GB max file size
 	tusVersion = "1.0.0"
 )
 
-// TusOptionsHandler handles OPTIONS requests for TUS discovery
+// Partial uploads to local directories are kept in a hidden directory next to
+// their destination, one file per upload
+const (
+	tusUploadDir     = ".tus-uploads"
+	tusPartialSuffix = ".part"
+)
+
+// TusOptionsHandler handles OPTIONS requests for TUS discovery. Cross-origin
+// preflights are answered by middleware.CORS, which allows the TUS headers.
 func TusOptionsHandler(c *gin.Context) {
 	c.Header("Tus-Resumable", tusVersion)
 	c.Header("Tus-Version", tusVersion)
 	c.Header("Tus-Max-Size", fmt.Sprintf("%d", tusMaxSize))
 	c.Header("Tus-Extension", "creation,expiration,checksum,termination")
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Methods", "POST,HEAD,PATCH,DELETE,OPTIONS")
-	c.Header("Access-Control-Allow-Headers", "Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset,Content-Type")
-	c.Header("Access-Control-Expose-Headers", "Tus-Resumable,Upload-Length,Upload-Metadata,Upload-Offset,Location")
 	c.Status(http.StatusOK)
 }
 
@@ -57,18 +85,18 @@ func TusPostHandler(c *gin.Context) {
 	// Get upload length
 	uploadLengthStr := c.GetHeader("Upload-Length")
 	if uploadLengthStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length header required"})
+		problem.Respond(c, http.StatusBadRequest, "Upload-Length header required")
 		return
 	}
 
 	uploadLength, err := strconv.ParseInt(uploadLengthStr, 10, 64)
 	if err != nil || uploadLength <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Length"})
+		problem.Respond(c, http.StatusBadRequest, "Invalid Upload-Length")
 		return
 	}
 
 	if uploadLength > tusMaxSize {
-		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload exceeds maximum size"})
+		problem.Respond(c, http.StatusRequestEntityTooLarge, "Upload exceeds maximum size")
 		return
 	}
 
@@ -76,31 +104,63 @@ func TusPostHandler(c *gin.Context) {
 	uploadMetadata := c.GetHeader("Upload-Metadata")
 	filename, targetPath := parseUploadMetadata(uploadMetadata)
 	if filename == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "filename metadata required"})
+		problem.Respond(c, http.StatusBadRequest, "filename metadata required")
+		return
+	}
+	if err := utils.ValidateFileName(filename); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid filename: "+err.Error())
 		return
 	}
 	if targetPath == "" {
 		targetPath = "/"
 	}
+	extract := uploadMetadataValue(uploadMetadata, "extract") == "true"
+	conflict := uploadMetadataValue(uploadMetadata, "conflict")
+	if extract && !strings.EqualFold(filepath.Ext(filename), ".zip") {
+		problem.Respond(c, http.StatusBadRequest, "Only ZIP files can be extracted")
+		return
+	}
+	if !validConflictPolicy(conflict) {
+		problem.Respond(c, http.StatusBadRequest, "Invalid conflict policy: "+conflict)
+		return
+	}
+	fingerprint := uploadMetadataValue(uploadMetadata, "fingerprint")
+	if !validFingerprint(fingerprint) {
+		problem.Respond(c, http.StatusBadRequest, fmt.Sprintf("fingerprint must be at most %d characters without control characters", maxFingerprintLength))
+		return
+	}
 
 	// Safely resolve target path
-	resolvedPath, err := utils.SafeResolve(targetPath)
+	fsys, resolvedPath, err := utils.ResolveFS(targetPath)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if extract && !vfs.IsLocal(fsys) {
+		problem.Respond(c, http.StatusBadRequest, "ZIP extraction is only supported on local storage")
+		return
+	}
+
+	// The whole upload counts against the quotas it falls under from the start
+	if !admitQuota(c, targetPath+"/"+filename, uploadLength, 1) {
 		return
 	}
 
 	// Generate unique upload ID
 	uploadID := generateUploadID()
 	
-	// Create upload directory for partial files
-	uploadDir := filepath.Join(resolvedPath, ".tus-uploads")
+	// Create upload directory for partial files. Uploads to other backends
+	// are staged on local disk and sent on completion.
+	uploadDir := filepath.Join(resolvedPath, tusUploadDir)
+	if !vfs.IsLocal(fsys) {
+		uploadDir = config.TusStagingDir
+	}
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload directory"})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create upload directory")
 		return
 	}
 
-	partialPath := filepath.Join(uploadDir, uploadID+".part")
+	partialPath := filepath.Join(uploadDir, uploadID+tusPartialSuffix)
 
 	// Create upload record
 	upload := &TusUpload{
@@ -112,18 +172,27 @@ func TusPostHandler(c *gin.Context) {
 		CreatedAt:    time.Now(),
 		LastModified: time.Now(),
 		FilePath:     partialPath,
+		Extract:      extract,
+		Conflict:     conflict,
+		User:         middleware.User(c),
+		Fingerprint:  fingerprint,
 	}
 
 	// Create empty partial file
 	file, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload file"})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to create upload file")
 		return
 	}
 	file.Close()
 
 	// Store upload record
-	activeUploads[uploadID] = upload
+	if err := uploadRegistry().Put(upload); err != nil {
+		_ = os.Remove(partialPath)
+		problem.Respond(c, http.StatusInternalServerError, "Failed to register upload")
+		return
+	}
+	metrics.ActiveUploads.Inc()
 
 	// Return created response
 	c.Header("Location", fmt.Sprintf("/api/tus/files/%s", uploadID))
@@ -131,14 +200,65 @@ func TusPostHandler(c *gin.Context) {
 	c.Status(http.StatusCreated)
 }
 
+// TusUploadInfo describes an upload in progress to the client resuming it
+type TusUploadInfo struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"` // to send HEAD and PATCH requests to
+	Filename     string    `json:"filename"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Offset       int64     `json:"offset"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// TusLookupHandler finds the caller's upload in progress with the given
+// fingerprint, so that an upload started on one device can be resumed on
+// another by the same user instead of starting over
+func TusLookupHandler(c *gin.Context) {
+	fingerprint := c.Query("fingerprint")
+	if fingerprint == "" || !validFingerprint(fingerprint) {
+		problem.Respond(c, http.StatusBadRequest, "fingerprint is required")
+		return
+	}
+	upload, err := uploadRegistry().Find(middleware.User(c), fingerprint)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Upload registry unavailable")
+		return
+	}
+	if upload == nil {
+		problem.Respond(c, http.StatusNotFound, "No upload in progress with this fingerprint")
+		return
+	}
+
+	// The partial file is the truth about what arrived, as for HEAD
+	if stat, err := os.Stat(upload.FilePath); err == nil {
+		upload.Offset = stat.Size()
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"ok": true, "upload": TusUploadInfo{
+		ID:           upload.ID,
+		URL:          "/api/tus/files/" + upload.ID,
+		Filename:     upload.Filename,
+		Path:         upload.Path,
+		Size:         upload.Size,
+		Offset:       upload.Offset,
+		CreatedAt:    upload.CreatedAt,
+		LastModified: upload.LastModified,
+	}})
+}
+
 // TusHeadHandler handles HEAD requests to get upload status
 func TusHeadHandler(c *gin.Context) {
 	c.Header("Tus-Resumable", tusVersion)
 	c.Header("Cache-Control", "no-store")
 
 	uploadID := c.Param("id")
-	upload := activeUploads[uploadID]
-	
+	upload, err := uploadRegistry().Get(uploadID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
 	if upload == nil {
 		c.Status(http.StatusNotFound)
 		return
@@ -148,6 +268,7 @@ func TusHeadHandler(c *gin.Context) {
 	if stat, err := os.Stat(upload.FilePath); err == nil {
 		upload.Offset = stat.Size()
 		upload.LastModified = time.Now()
+		_ = uploadRegistry().Put(upload)
 	}
 
 	c.Header("Upload-Offset", fmt.Sprintf("%d", upload.Offset))
@@ -160,30 +281,33 @@ func TusPatchHandler(c *gin.Context) {
 	c.Header("Tus-Resumable", tusVersion)
 
 	uploadID := c.Param("id")
-	upload := activeUploads[uploadID]
-	
+	upload, err := uploadRegistry().Get(uploadID)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Upload registry unavailable")
+		return
+	}
 	if upload == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		problem.Respond(c, http.StatusNotFound, "Upload not found")
 		return
 	}
 
 	// Validate content type
 	contentType := c.GetHeader("Content-Type")
 	if contentType != "application/offset+octet-stream" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Content-Type"})
+		problem.Respond(c, http.StatusBadRequest, "Invalid Content-Type")
 		return
 	}
 
 	// Get and validate upload offset
 	uploadOffsetStr := c.GetHeader("Upload-Offset")
 	if uploadOffsetStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset header required"})
+		problem.Respond(c, http.StatusBadRequest, "Upload-Offset header required")
 		return
 	}
 
 	uploadOffset, err := strconv.ParseInt(uploadOffsetStr, 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Upload-Offset"})
+		problem.Respond(c, http.StatusBadRequest, "Invalid Upload-Offset")
 		return
 	}
 
@@ -195,40 +319,94 @@ func TusPatchHandler(c *gin.Context) {
 
 	// Offset must match current file size
 	if uploadOffset != currentSize {
-		c.JSON(http.StatusConflict, gin.H{
-			"error": fmt.Sprintf("Upload-Offset %d does not match current size %d", uploadOffset, currentSize),
-		})
+		problem.Respond(c, http.StatusConflict, fmt.Sprintf("Upload-Offset %d does not match current size %d", uploadOffset, currentSize))
+		return
+	}
+
+	// Nothing past the Upload-Length the quotas admitted is taken
+	remaining := upload.Size - currentSize
+	if c.Request.ContentLength > remaining {
+		problem.Respond(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("The body is longer than the %d bytes left of the upload", remaining))
 		return
 	}
 
 	// Open file for appending
 	file, err := os.OpenFile(upload.FilePath, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open upload file"})
+		problem.Respond(c, http.StatusInternalServerError, "Failed to open upload file")
 		return
 	}
 	defer file.Close()
 
-	// Stream data with large buffer for performance
-	buf := make([]byte, 1024*1024) // 1MB buffer like filebrowser
-	written, err := io.CopyBuffer(file, c.Request.Body, buf)
+	// Stream data through a pooled buffer, timing it to tune the client's
+	// next uploads
+	start := time.Now()
+	disk := &timedWriter{w: file}
+	written, err := buffers.Copy(disk, io.LimitReader(c.Request.Body, remaining+1))
+	if err == nil && written > remaining {
+		// A body of unknown length ran past the end; none of it is kept
+		if err := os.Truncate(upload.FilePath, currentSize); err != nil {
+			problem.Respond(c, http.StatusInternalServerError, "Failed to discard excess upload data")
+			return
+		}
+		c.Header("Upload-Offset", fmt.Sprintf("%d", currentSize))
+		problem.Respond(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("The body is longer than the %d bytes left of the upload", remaining))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Upload failed"})
+		// Keep what arrived so the client can resume from there. A client
+		// that cancelled or disconnected is not waiting for an answer.
+		upload.Offset = currentSize + written
+		upload.LastModified = time.Now()
+		_ = uploadRegistry().Put(upload)
+		if middleware.RespondTimeout(c, err) {
+			c.Header("Upload-Offset", fmt.Sprintf("%d", upload.Offset))
+			return
+		}
+		if c.Request.Context().Err() != nil {
+			return
+		}
+		problem.Respond(c, http.StatusInternalServerError, "Upload failed")
 		return
 	}
 
+	recordUploadSpeed(c, written, time.Since(start), disk.elapsed)
+
 	// Update upload record
 	upload.Offset = currentSize + written
 	upload.LastModified = time.Now()
+	if !checkPieces(c, upload) {
+		return
+	}
 
 	// Check if upload is complete
 	if upload.Offset >= upload.Size {
-		if err := completeUpload(upload); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete upload"})
+		removeUploadPieces(upload)
+		if upload.Extract {
+			job, err := startExtractJob(upload, c.ClientIP())
+			if err != nil {
+				if errors.Is(err, utils.ErrPathLocked) {
+					problem.Respond(c, http.StatusLocked, "Upload destination is locked by another operation")
+					return
+				}
+				problem.Respond(c, http.StatusInternalServerError, "Failed to start extracting upload")
+				return
+			}
+			c.Header(middleware.JobHeader, job.ID)
+		} else if err := completeUpload(upload, c.ClientIP()); err != nil {
+			if errors.Is(err, utils.ErrPathLocked) {
+				problem.Respond(c, http.StatusLocked, "Upload destination is locked by another operation")
+				return
+			}
+			problem.Respond(c, http.StatusInternalServerError, "Failed to complete upload")
 			return
 		}
 		// Remove from active uploads
-		delete(activeUploads, uploadID)
+		_ = uploadRegistry().Delete(uploadID)
+		metrics.ActiveUploads.Dec()
+	} else if err := uploadRegistry().Put(upload); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to record upload progress")
+		return
 	}
 
 	// Return success response
@@ -241,8 +419,11 @@ func TusDeleteHandler(c *gin.Context) {
 	c.Header("Tus-Resumable", tusVersion)
 
 	uploadID := c.Param("id")
-	upload := activeUploads[uploadID]
-	
+	upload, err := uploadRegistry().Get(uploadID)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
 	if upload == nil {
 		c.Status(http.StatusNotFound)
 		return
@@ -250,17 +431,26 @@ func TusDeleteHandler(c *gin.Context) {
 
 	// Remove partial file
 	_ = os.Remove(upload.FilePath)
+	removeUploadPieces(upload)
 
 	// Remove from active uploads
-	delete(activeUploads, uploadID)
+	if err := uploadRegistry().Delete(uploadID); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	metrics.ActiveUploads.Dec()
 
 	c.Status(http.StatusNoContent)
 }
 
 // Helper functions
 
+// generateUploadID returns an ID that is unique across replicas, which all
+// tend to run as PID 1 in containers
 func generateUploadID() string {
-	return fmt.Sprintf("upload_%d_%d", time.Now().UnixNano(), os.Getpid())
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("upload_%d_%d_%s", time.Now().UnixNano(), os.Getpid(), hex.EncodeToString(suffix))
 }
 
 func parseUploadMetadata(metadata string) (filename, path string) {
@@ -290,6 +480,24 @@ func parseUploadMetadata(metadata string) (filename, path string) {
 	return filename, path
 }
 
+// validFingerprint reports whether a client's fingerprint of an upload is
+// usable
+func validFingerprint(fingerprint string) bool {
+	return len(fingerprint) <= maxFingerprintLength && strings.IndexFunc(fingerprint, unicode.IsControl) < 0
+}
+
+// uploadMetadataValue returns the decoded value of a key in Upload-Metadata
+func uploadMetadataValue(metadata, key string) string {
+	for _, part := range strings.Split(metadata, ",") {
+		name, encoded, _ := strings.Cut(strings.TrimSpace(part), " ")
+		if name == key {
+			value, _ := decodeBase64String(encoded)
+			return value
+		}
+	}
+	return ""
+}
+
 func decodeBase64String(s string) (string, error) {
 	decoded, err := base64.StdEncoding.DecodeString(s)
 	if err != nil {
@@ -299,20 +507,34 @@ func decodeBase64String(s string) (string, error) {
 	return string(decoded), nil
 }
 
-func completeUpload(upload *TusUpload) error {
+func completeUpload(upload *TusUpload, actor string) error {
 	// Resolve final destination path
-	resolvedPath, err := utils.SafeResolve(upload.Path)
+	fsys, resolvedPath, err := utils.ResolveFS(upload.Path)
 	if err != nil {
 		return err
 	}
 
-	finalPath := filepath.Join(resolvedPath, upload.Filename)
+	finalPath := utils.JoinName(fsys, resolvedPath, upload.Filename)
+
+	unlock, err := utils.TryLockPaths(utils.WriteLock(finalPath))
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Move partial file to final location
-	err = os.Rename(upload.FilePath, finalPath)
+	if vfs.IsLocal(fsys) {
+		kept := linkReplaced(fsys, finalPath)
+		err = os.Rename(upload.FilePath, finalPath)
+		kept.settle(err)
+	} else {
+		err = transferUpload(fsys, upload, finalPath)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to move completed upload: %w", err)
 	}
+	invalidateListing(finalPath)
+	publishChange(actor, events.UploadCompleted, fsys, finalPath, gin.H{"size": upload.Size})
 
 	// Clean up upload directory if empty
 	uploadDir := filepath.Dir(upload.FilePath)
@@ -321,14 +543,30 @@ func completeUpload(upload *TusUpload) error {
 	return nil
 }
 
-// GetTusConfig returns TUS configuration for clients
+// transferUpload sends a staged upload to a non-local backend, as a multipart
+// upload where the backend supports it, and removes the staged file
+func transferUpload(fsys vfs.Filesystem, upload *TusUpload, finalPath string) error {
+	file, err := os.Open(upload.FilePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(upload.FilePath)
+	defer file.Close()
+
+	return vfs.WriteFrom(fsys, finalPath, file, upload.Size)
+}
+
+// GetTusConfig returns TUS configuration for clients, with a chunk size and
+// parallelism tuned to how the client's recent uploads went
 func GetTusConfig(c *gin.Context) {
+	tuning := uploadTuning(c)
 	config := map[string]any{
 		"version":              tusVersion,
 		"maxSize":              tusMaxSize,
 		"extensions":           []string{"creation", "expiration", "checksum", "termination"},
-		"chunkSize":            8 * 1024 * 1024, // 8MB recommended chunk size
-		"maxConcurrentUploads": 6,
+		"chunkSize":            tuning.ChunkSize,
+		"maxConcurrentUploads": tuning.MaxConcurrentUploads,
+		"tuning":               tuning,
 		"resumable":            true,
 		"endpoints": map[string]string{
 			"create":   "/api/tus/files",
@@ -336,6 +574,7 @@ func GetTusConfig(c *gin.Context) {
 			"status":   "/api/tus/files/:id",
 			"delete":   "/api/tus/files/:id",
 			"options":  "/api/tus/files",
+			"lookup":   "/api/tus/lookup",
 		},
 	}
 	
@@ -344,13 +583,18 @@ func GetTusConfig(c *gin.Context) {
 
 // Cleanup function to remove expired uploads (call periodically)
 func CleanupExpiredUploads() {
-	expiry := time.Hour * 24 // 24 hours
+	uploads, err := uploadRegistry().List()
+	if err != nil {
+		return
+	}
 	now := time.Now()
 	
-	for id, upload := range activeUploads {
-		if now.Sub(upload.LastModified) > expiry {
+	for _, upload := range uploads {
+		if now.Sub(upload.LastModified) > tusUploadExpiry {
 			_ = os.Remove(upload.FilePath)
-			delete(activeUploads, id)
+			removeUploadPieces(upload)
+			_ = uploadRegistry().Delete(upload.ID)
+			metrics.ActiveUploads.Dec()
 		}
 	}
 }
\ No newline at end of file
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package handlers
+
+import (
+	"os"
+	"syscall"
+)
+
+// unlinkFile uses the fastest available method to delete a file
+func unlinkFile(path string) error {
+	// Try direct syscall first for maximum performance
+	if err := syscall.Unlink(path); err == nil {
+		return nil
+	}
+
+	// Fallback to standard library
+	return os.Remove(path)
+}
+
+// linkCount is the number of names a file has
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package handlers
+
+import (
+	"os"
+)
+
+// unlinkFile deletes a file. Windows refuses to delete read-only files, so the
+// read-only attribute is cleared and the removal retried.
+func unlinkFile(path string) error {
+	err := os.Remove(path)
+	if err == nil || !os.IsPermission(err) {
+		return err
+	}
+
+	info, statErr := os.Lstat(path)
+	if statErr != nil || info.Mode().Perm()&0200 != 0 {
+		return err
+	}
+	if chmodErr := os.Chmod(path, info.Mode().Perm()|0200); chmodErr != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// linkCount is the number of names a file has. Hard links aren't counted on
+// Windows, so every file has one.
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/semaphore"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+// maxFormFieldsSize is the most an upload's form fields other than files may
+// add up to. Fields are read into memory; files never are.
+const maxFormFieldsSize = 64 << 10
+
+// formMemory is shared by all uploads, so that however many arrive at once
+// their form fields hold no more than MULTIPART_MEMORY. An upload waits for
+// its share before reading fields and gives it back at its first file.
+var formMemory = semaphore.NewWeighted(max(config.MultipartMemory, maxFormFieldsSize))
+
+// UploadedFile is a file written by a form upload
+type UploadedFile struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// UploadFiles stores the files of a multipart form in the directory named by
+// its path field, which must come before them. Each file is streamed from
+// the request to storage as it arrives, replacing any file of the same name,
+// so memory use doesn't grow with the size or number of files.
+func UploadFiles(c *gin.Context) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := formMemory.Acquire(ctx, maxFormFieldsSize); err != nil {
+		problem.Respond(c, http.StatusServiceUnavailable, "Upload was cancelled while waiting")
+		return
+	}
+	releaseFields := sync.OnceFunc(func() { formMemory.Release(maxFormFieldsSize) })
+	defer releaseFields()
+
+	fields := make(map[string]string)
+	fieldsSize := 0
+	var fsys vfs.Filesystem
+	var dir, userDir string
+	uploaded := []UploadedFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if middleware.RespondTimeout(c, err) {
+			return
+		}
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, "Malformed multipart body: "+err.Error())
+			return
+		}
+
+		if part.FileName() == "" {
+			if fsys != nil {
+				part.Close()
+				if part.FormName() == "path" {
+					problem.Respond(c, http.StatusBadRequest, "path must come before the files", gin.H{"files": uploaded})
+					return
+				}
+				continue
+			}
+			value, err := io.ReadAll(io.LimitReader(part, int64(maxFormFieldsSize-fieldsSize+1)))
+			part.Close()
+			if err != nil {
+				problem.Respond(c, http.StatusBadRequest, "Malformed multipart body: "+err.Error())
+				return
+			}
+			fieldsSize += len(value)
+			if fieldsSize > maxFormFieldsSize {
+				problem.Respond(c, http.StatusRequestEntityTooLarge, "Form fields are too large")
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if fsys == nil {
+			releaseFields()
+			userDir = fields["path"]
+			if userDir == "" {
+				userDir = "/"
+			}
+			fsys, dir, err = utils.ResolveFS(userDir)
+			if err != nil {
+				part.Close()
+				problem.Respond(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			// The whole body is the most the files can add up to, so a body
+			// of unknown length can't be admitted under a quota
+			if c.Request.ContentLength < 0 && len(quotasOver(userDir)) > 0 {
+				part.Close()
+				problem.Respond(c, http.StatusLengthRequired, "Uploads into a directory with a quota need a Content-Length")
+				return
+			}
+			if !admitQuota(c, userDir, max(c.Request.ContentLength, 0), 0) {
+				part.Close()
+				return
+			}
+		}
+
+		// Each file counts against quotas on files as it comes
+		if err := reserveQuotas(quotasOver(userDir), 0, 1); err != nil {
+			part.Close()
+			problem.Respond(c, http.StatusInsufficientStorage, err.Error(), gin.H{"quota": err.status, "files": uploaded})
+			return
+		}
+		file, err := uploadPart(c, fsys, dir, part)
+		part.Close()
+		if err != nil {
+			if !c.Writer.Written() && !middleware.RespondTimeout(c, err) {
+				problem.Respond(c, http.StatusInternalServerError, "Failed to upload "+part.FileName()+": "+err.Error(), gin.H{"files": uploaded})
+			}
+			return
+		}
+		uploaded = append(uploaded, file)
+	}
+
+	if fsys == nil {
+		problem.Respond(c, http.StatusBadRequest, "No files uploaded")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "files": uploaded})
+}
+
+// uploadPart streams a file of an upload form into dir
+func uploadPart(c *gin.Context, fsys vfs.Filesystem, dir string, part *multipart.Part) (UploadedFile, error) {
+	name := part.FileName()
+	if err := utils.ValidateFileName(name); err != nil {
+		problem.Respond(c, http.StatusBadRequest, "Invalid filename: "+err.Error())
+		return UploadedFile{}, err
+	}
+	target := utils.JoinName(fsys, dir, name)
+	if vfs.IsLocal(fsys) {
+		if err := utils.CheckRealPath(target); err != nil {
+			problem.Respond(c, http.StatusForbidden, err.Error())
+			return UploadedFile{}, err
+		}
+	}
+
+	unlock, ok := lockPaths(c, utils.WriteLock(target))
+	if !ok {
+		return UploadedFile{}, errors.New("path is locked")
+	}
+	defer unlock()
+
+	counted := &countingReader{r: part}
+	kept := linkReplaced(fsys, target)
+	err := vfs.WriteFrom(fsys, target, counted, -1)
+	kept.settle(err)
+	if err != nil {
+		return UploadedFile{}, err
+	}
+	invalidateListing(target)
+	publishChange(c.ClientIP(), events.UploadCompleted, fsys, target, gin.H{"size": counted.n})
+
+	return UploadedFile{
+		Name: name,
+		Path: displayPath(fsys, target),
+		Size: counted.n,
+	}, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/webhook"
+)
+
+// ListWebhooks returns the configured webhooks with their last delivery
+func ListWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"ok":       true,
+		"webhooks": webhook.List(),
+	})
+}
+
+// ListWebhookDeliveries returns the remembered deliveries to one webhook,
+// newest first
+func ListWebhookDeliveries(c *gin.Context) {
+	hook, exists := webhook.Find(c.Param("name"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	deliveries := webhook.Deliveries(hook.Name)
+	response := gin.H{
+		"ok":         true,
+		"deliveries": deliveries,
+	}
+	if pageReq, ok := parsePageRequest(c); ok {
+		response["deliveries"], response["pagination"] = paginate(deliveries, pageReq)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// PingWebhook sends a test event to a webhook. Its outcome shows up in the
+// delivery log.
+func PingWebhook(c *gin.Context) {
+	hook, exists := webhook.Find(c.Param("name"))
+	if !exists {
+		problem.Respond(c, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"ok":       true,
+		"delivery": webhook.Ping(hook),
+	})
+}
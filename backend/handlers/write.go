@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/problem"
+	"nextbrowse-backend/utils"
+	"nextbrowse-backend/vfs"
+)
+
+type WriteFileResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	Size    int64  `json:"size"`
+	Mtime   int64  `json:"mtime"`
+	ETag    string `json:"etag"`
+}
+
+// fileETag identifies a version of a file by its size and modification time,
+// which is enough for an editor to tell whether the file changed since it was
+// read without hashing its contents
+func fileETag(info fs.FileInfo) string {
+	return `"` + strconv.FormatInt(info.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(info.Size(), 16) + `"`
+}
+
+// checkPreconditions enforces If-Match and If-None-Match against the current
+// version of a file, info being nil when it does not exist. It answers 412
+// with the current ETag and returns false when the write must not go ahead.
+func checkPreconditions(c *gin.Context, info fs.FileInfo) bool {
+	current := ""
+	if info != nil {
+		current = fileETag(info)
+	}
+
+	if header := c.GetHeader("If-Match"); header != "" && !etagMatches(header, current) {
+		problem.Respond(c, http.StatusPreconditionFailed, "File changed since it was read", gin.H{"etag": current})
+		return false
+	}
+	if header := c.GetHeader("If-None-Match"); header != "" && etagMatches(header, current) {
+		problem.Respond(c, http.StatusPreconditionFailed, "File already exists", gin.H{"etag": current})
+		return false
+	}
+	return true
+}
+
+// etagMatches reports whether a comma-separated If-Match/If-None-Match list
+// names current; "*" matches any existing file
+func etagMatches(header, current string) bool {
+	if current == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// readWriteBody reads the request body up to WRITE_MAX_SIZE
+func readWriteBody(c *gin.Context) ([]byte, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, config.WriteMaxSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, "Request body too large")
+			return nil, false
+		}
+		problem.Respond(c, http.StatusBadRequest, "Failed to read request body: "+err.Error())
+		return nil, false
+	}
+	return data, true
+}
+
+// SaveFile replaces the contents of the file at ?path= with the raw request
+// body, creating it if needed. Editors send the ETag they read with If-Match
+// so a save never overwrites changes made in the meantime; If-None-Match: *
+// only creates a new file.
+func SaveFile(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+
+	// Safely resolve path
+	fsys, safePath, err := utils.ResolveFS(path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data, ok := readWriteBody(c)
+	if !ok {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.WriteLock(safePath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	fileInfo, err := fsys.Stat(safePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	if err == nil && !fileInfo.Mode().IsRegular() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a regular file")
+		return
+	}
+	if err != nil {
+		fileInfo = nil
+		if err := utils.ValidateNewPath(path); err != nil {
+			problem.Respond(c, http.StatusBadRequest, "Invalid file name: "+err.Error())
+			return
+		}
+	}
+	if !checkPreconditions(c, fileInfo) {
+		return
+	}
+
+	if parent, err := fsys.Stat(filepath.Dir(safePath)); err != nil || !parent.IsDir() {
+		problem.Respond(c, http.StatusNotFound, "Parent directory not found")
+		return
+	}
+
+	// What the file grows by, and a new file, count against quotas
+	growth, files := int64(len(data)), int64(1)
+	if fileInfo != nil {
+		growth, files = growth-fileInfo.Size(), 0
+	}
+	if !admitQuota(c, path, max(growth, 0), files) {
+		return
+	}
+
+	if err := vfs.WriteFrom(fsys, safePath, bytes.NewReader(data), int64(len(data))); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+
+	eventType := events.FileWritten
+	if fileInfo == nil {
+		eventType = events.FileCreated
+	}
+	fileInfo, err = fsys.Stat(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	invalidateListing(safePath)
+	publishChange(c.ClientIP(), eventType, fsys, safePath, nil)
+
+	respondWritten(c, fileInfo)
+}
+
+// respondWritten reports the new version of a written file
+func respondWritten(c *gin.Context, fileInfo fs.FileInfo) {
+	etag := fileETag(fileInfo)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, WriteFileResponse{
+		OK:      true,
+		Message: "File written successfully",
+		Size:    fileInfo.Size(),
+		Mtime:   fileInfo.ModTime().Unix(),
+		ETag:    etag,
+	})
+}
+
+// AppendFile appends the raw request body to an existing file
+func AppendFile(c *gin.Context) {
+	writeFile(c, -1)
+}
+
+// PatchFile overwrites the bytes of an existing file starting at ?offset=,
+// extending the file if the body runs past its end
+func PatchFile(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		problem.Respond(c, http.StatusBadRequest, "Invalid offset parameter")
+		return
+	}
+	writeFile(c, offset)
+}
+
+// writeFile writes the request body into the file at ?path=, appending when
+// offset is negative. The body is read in full before the file is touched so
+// an aborted request never leaves a partial write behind.
+func writeFile(c *gin.Context, offset int64) {
+	path := c.Query("path")
+	if path == "" {
+		problem.Respond(c, http.StatusBadRequest, "Missing path parameter")
+		return
+	}
+
+	// Safely resolve path
+	fsys, safePath, err := utils.ResolveFS(path)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Read the body up to the configured limit
+	data, ok := readWriteBody(c)
+	if !ok {
+		return
+	}
+
+	unlock, ok := lockPaths(c, utils.WriteLock(safePath))
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Only existing regular files can be written to
+	fileInfo, err := fsys.Stat(safePath)
+	if err != nil {
+		problem.Respond(c, http.StatusNotFound, "File not found")
+		return
+	}
+	if !fileInfo.Mode().IsRegular() {
+		problem.Respond(c, http.StatusBadRequest, "Path is not a regular file")
+		return
+	}
+	if !checkPreconditions(c, fileInfo) {
+		return
+	}
+
+	// Patches may not leave a hole past the current end of file
+	if offset > fileInfo.Size() {
+		problem.Respond(c, http.StatusRequestedRangeNotSatisfiable, "Offset is beyond end of file", gin.H{"size": fileInfo.Size()})
+		return
+	}
+
+	growth := int64(len(data))
+	if offset >= 0 {
+		growth = max(offset+growth-fileInfo.Size(), 0)
+	}
+	if !admitQuota(c, path, growth, 0) {
+		return
+	}
+
+	flags := os.O_WRONLY
+	if offset < 0 {
+		flags |= os.O_APPEND
+	}
+	file, err := fsys.OpenFile(safePath, flags, 0)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to open file: "+err.Error())
+		return
+	}
+	defer file.Close()
+
+	if offset < 0 {
+		_, err = file.Write(data)
+	} else {
+		_, err = file.WriteAt(data, offset)
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+
+	fileInfo, err = file.Stat()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	invalidateListing(safePath)
+	publishChange(c.ClientIP(), events.FileWritten, fsys, safePath, nil)
+
+	respondWritten(c, fileInfo)
+}
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+
+	"nextbrowse-backend/buffers"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// zipAheadMaxSize is the largest file the workers compress ahead of the
+// archive; bigger files are compressed as they are written, one at a time.
+// Up to twice ZIP_WORKERS of them are held in memory per download.
+const zipAheadMaxSize = 4 << 20
+
+// flateWriters are reused across entries and downloads; a deflate writer
+// carries over a megabyte of state
+var flateWriters sync.Pool
+
+// zipEntry is a file or directory to add to an archive. Files small enough
+// are compressed ahead by a worker, which closes done when data is ready.
+type zipEntry struct {
+	fsys   vfs.Filesystem
+	path   string
+	size   int64
+	header *zip.FileHeader
+	dir    bool
+
+	done  chan struct{}
+	ahead bool // data holds the entry as stored, header describing it
+	data  []byte
+	err   error
+}
+
+// zipPipeline compresses small files on ZIP_WORKERS goroutines while the
+// entries before them are written, so archives of many files use every CPU
+// and the next files are read while the current one is being sent
+type zipPipeline struct {
+	ctx     context.Context
+	entries chan *zipEntry
+	workers chan struct{}
+
+	// written, if set, is told of each entry added to the archive
+	written func(e *zipEntry)
+}
+
+func newZipPipeline(ctx context.Context) *zipPipeline {
+	workers := max(config.ZipWorkers, 1)
+	return &zipPipeline{
+		ctx:     ctx,
+		entries: make(chan *zipEntry, workers),
+		workers: make(chan struct{}, workers),
+	}
+}
+
+// add queues an entry in archive order, starting to compress it when it is
+// a small file and a worker is free
+func (p *zipPipeline) add(e *zipEntry) error {
+	e.done = make(chan struct{})
+	if e.dir || cap(p.workers) == 1 || e.size > zipAheadMaxSize {
+		close(e.done)
+	} else {
+		select {
+		case p.workers <- struct{}{}:
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		}
+		go func() {
+			defer func() { <-p.workers }()
+			defer close(e.done)
+			e.err = compressZipEntry(p.ctx, e)
+		}()
+	}
+	select {
+	case p.entries <- e:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// write adds the queued entries to zw in order until the queue is closed or
+// the context is done. An entry that can't be read is left out.
+func (p *zipPipeline) write(zw *zip.Writer) {
+	for e := range p.entries {
+		<-e.done
+		if p.ctx.Err() != nil {
+			return
+		}
+		if e.err != nil {
+			continue
+		}
+		if err := writeZipEntry(zw, e); err == nil && p.written != nil {
+			p.written(e)
+		}
+	}
+}
+
+// newZipWriter makes a ZIP writer whose deflate uses the faster
+// klauspost/compress implementation
+func newZipWriter(w io.Writer) *zip.Writer {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return getFlateWriter(out)
+	})
+	return zw
+}
+
+// compressZipEntry reads a small file whole and deflates it, keeping it
+// uncompressed if deflating doesn't make it smaller
+func compressZipEntry(ctx context.Context, e *zipEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	file, err := e.fsys.Open(e.path)
+	if err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(io.LimitReader(file, zipAheadMaxSize+1))
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if len(raw) > zipAheadMaxSize {
+		// It grew since it was listed and is written as a large file
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	fw, err := getFlateWriter(&compressed)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(raw); err != nil {
+		fw.Close()
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+
+	e.data = compressed.Bytes()
+	if len(e.data) >= len(raw) {
+		e.header.Method = zip.Store
+		e.data = raw
+	}
+	// CreateRaw, unlike CreateHeader, doesn't derive the MS-DOS time
+	e.header.SetModTime(e.header.Modified)
+	e.header.CRC32 = crc32.ChecksumIEEE(raw)
+	e.header.UncompressedSize64 = uint64(len(raw))
+	e.header.CompressedSize64 = uint64(len(e.data))
+	e.ahead = true
+	return nil
+}
+
+// writeZipEntry writes an entry compressed ahead as it is, and compresses
+// any other file as it is copied in
+func writeZipEntry(zw *zip.Writer, e *zipEntry) error {
+	if e.dir {
+		_, err := zw.CreateHeader(e.header)
+		return err
+	}
+	if e.ahead {
+		w, err := zw.CreateRaw(e.header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(e.data)
+		return err
+	}
+
+	src, err := e.fsys.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	w, err := zw.CreateHeader(e.header)
+	if err != nil {
+		return err
+	}
+	_, err = buffers.Copy(w, src)
+	return err
+}
+
+// pooledFlateWriter goes back to flateWriters when closed
+type pooledFlateWriter struct {
+	*flate.Writer
+}
+
+func (w pooledFlateWriter) Close() error {
+	err := w.Writer.Close()
+	flateWriters.Put(w.Writer)
+	return err
+}
+
+func getFlateWriter(out io.Writer) (io.WriteCloser, error) {
+	if fw, ok := flateWriters.Get().(*flate.Writer); ok {
+		fw.Reset(out)
+		return pooledFlateWriter{fw}, nil
+	}
+	fw, err := flate.NewWriter(out, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return pooledFlateWriter{fw}, nil
+}
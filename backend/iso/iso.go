@@ -0,0 +1,402 @@
+// Package iso reads the directory tree and files of ISO9660 disc images,
+// preferring Rock Ridge or Joliet names when the image has them, so that
+// images can be browsed and single files taken out of them without mounting
+// the image or reading it whole.
+package iso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Formats of the names an image is read with
+const (
+	ISO9660   = "iso9660"
+	Joliet    = "joliet"
+	RockRidge = "rockridge"
+)
+
+const (
+	sectorSize = 2048
+	// firstDescriptor is the sector the volume descriptors start at
+	firstDescriptor = 16
+	// maxDescriptors bounds the search for the terminating descriptor
+	maxDescriptors = 64
+	// maxDirSize bounds the directory records read at once, against corrupt
+	// lengths
+	maxDirSize = 64 << 20
+)
+
+// Volume descriptor types
+const (
+	primaryDescriptor       = 1
+	supplementaryDescriptor = 2
+	terminatorDescriptor    = 255
+)
+
+// Directory record flags
+const (
+	flagDir         = 0x02
+	flagMultiExtent = 0x80
+)
+
+var (
+	// ErrNotISO is returned for files without ISO9660 volume descriptors
+	ErrNotISO = errors.New("not an ISO9660 image")
+	// ErrUDFOnly is returned for UDF images without an ISO9660 tree, which
+	// are not supported
+	ErrUDFOnly = errors.New("UDF images without an ISO9660 tree are not supported")
+	// ErrNotFound is returned for paths that aren't in the image
+	ErrNotFound = errors.New("no such file or directory in the image")
+	// ErrNotDir is returned when listing something that isn't a directory
+	ErrNotDir = errors.New("not a directory")
+)
+
+// Image is an ISO9660 image opened for reading
+type Image struct {
+	r         io.ReaderAt
+	blockSize int64
+	root      Entry
+	format    string
+	volume    string
+	// suspSkip is how many bytes of each record's system use area precede
+	// the Rock Ridge entries
+	suspSkip int
+}
+
+// Entry is a file or directory in an image
+type Entry struct {
+	Name    string
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+	extents []extent
+}
+
+type extent struct {
+	offset int64
+	size   int64
+}
+
+// Open reads the volume descriptors of the image in r
+func Open(r io.ReaderAt) (*Image, error) {
+	var primary, joliet []byte
+	udf := false
+descriptors:
+	for i := int64(0); i < maxDescriptors; i++ {
+		sector := make([]byte, sectorSize)
+		if _, err := r.ReadAt(sector, (firstDescriptor+i)*sectorSize); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, err
+		}
+		switch string(sector[1:6]) {
+		case "CD001":
+		case "BEA01", "NSR02", "NSR03", "TEA01":
+			udf = true
+			continue
+		default:
+			break descriptors
+		}
+		switch sector[0] {
+		case primaryDescriptor:
+			if primary == nil {
+				primary = sector
+			}
+		case supplementaryDescriptor:
+			if joliet == nil && isJoliet(sector) {
+				joliet = sector
+			}
+		case terminatorDescriptor:
+			// UDF's recognition sequence may follow, so the search goes on
+		}
+	}
+	if primary == nil {
+		if udf {
+			return nil, ErrUDFOnly
+		}
+		return nil, ErrNotISO
+	}
+
+	img := &Image{r: r, format: ISO9660}
+	img.blockSize = int64(binary.LittleEndian.Uint16(primary[128:130]))
+	if img.blockSize == 0 {
+		img.blockSize = sectorSize
+	}
+	img.volume = strings.TrimRight(string(primary[40:72]), " \x00")
+	root, err := img.parseRecord(primary[156:190], false)
+	if err != nil {
+		return nil, err
+	}
+	img.root = root
+
+	if skip, ok := img.rockRidge(); ok {
+		img.format = RockRidge
+		img.suspSkip = skip
+	} else if joliet != nil {
+		root, err := img.parseRecord(joliet[156:190], true)
+		if err == nil {
+			img.format = Joliet
+			img.root = root
+			img.volume = strings.TrimRight(decodeUCS2(joliet[40:72]), " \x00")
+		}
+	}
+	return img, nil
+}
+
+// Format is the kind of names the image is read with: rockridge, joliet or
+// iso9660
+func (img *Image) Format() string { return img.format }
+
+// Volume is the volume label of the image
+func (img *Image) Volume() string { return img.volume }
+
+// Stat returns the entry at the slash-separated path p, "/" being the root
+func (img *Image) Stat(p string) (Entry, error) {
+	entry := img.root
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !entry.Dir {
+			return Entry{}, ErrNotFound
+		}
+		entries, err := img.readDir(entry)
+		if err != nil {
+			return Entry{}, err
+		}
+		found := false
+		for _, e := range entries {
+			if e.Name == name {
+				entry, found = e, true
+				break
+			}
+		}
+		if !found {
+			return Entry{}, ErrNotFound
+		}
+	}
+	return entry, nil
+}
+
+// ReadDir returns the entries of the directory at p in the order they are
+// recorded, which is sorted by their ISO9660 names
+func (img *Image) ReadDir(p string) ([]Entry, error) {
+	dir, err := img.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.Dir {
+		return nil, ErrNotDir
+	}
+	return img.readDir(dir)
+}
+
+// Open returns a reader of the contents of a file entry
+func (img *Image) Open(e Entry) *io.SectionReader {
+	if len(e.extents) == 1 {
+		return io.NewSectionReader(img.r, e.extents[0].offset, e.extents[0].size)
+	}
+	return io.NewSectionReader(&extentReader{r: img.r, extents: e.extents}, 0, e.Size)
+}
+
+func (img *Image) readDir(dir Entry) ([]Entry, error) {
+	if len(dir.extents) == 0 {
+		return nil, nil
+	}
+	ext := dir.extents[0]
+	if ext.size > maxDirSize {
+		return nil, fmt.Errorf("directory %s is too large", dir.Name)
+	}
+	data := make([]byte, ext.size)
+	if _, err := img.r.ReadAt(data, ext.offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	joliet := img.format == Joliet
+	var entries []Entry
+	continued := false // the last entry has more extents to come
+	for pos := 0; pos < len(data); {
+		length := int(data[pos])
+		if length == 0 {
+			// Records don't cross sectors; the rest of this one is padding
+			pos = (pos/sectorSize + 1) * sectorSize
+			continue
+		}
+		if length < 34 || pos+length > len(data) {
+			return nil, fmt.Errorf("corrupt directory record in %s", dir.Name)
+		}
+		record := data[pos : pos+length]
+		pos += length
+
+		nameLen := int(record[32])
+		if nameLen == 1 && (record[33] == 0 || record[33] == 1) {
+			continue
+		}
+		e, err := img.parseRecord(record, joliet)
+		if err != nil {
+			return nil, err
+		}
+		// The extents of a file over 4GiB are recorded one after another
+		if n := len(entries); continued && entries[n-1].Name == e.Name {
+			entries[n-1].extents = append(entries[n-1].extents, e.extents...)
+			entries[n-1].Size += e.Size
+		} else {
+			entries = append(entries, e)
+		}
+		continued = record[25]&flagMultiExtent != 0
+	}
+	return entries, nil
+}
+
+func (img *Image) parseRecord(record []byte, joliet bool) (Entry, error) {
+	if len(record) < 34 || int(record[32])+33 > len(record) {
+		return Entry{}, errors.New("corrupt directory record")
+	}
+	nameLen := int(record[32])
+	rawName := record[33 : 33+nameLen]
+	e := Entry{
+		Dir:     record[25]&flagDir != 0,
+		ModTime: recordTime(record[18:25]),
+	}
+	offset := int64(binary.LittleEndian.Uint32(record[2:6])) * img.blockSize
+	size := int64(binary.LittleEndian.Uint32(record[10:14]))
+	e.extents = []extent{{offset: offset, size: size}}
+	if !e.Dir {
+		e.Size = size
+	}
+
+	if joliet {
+		e.Name = decodeUCS2(rawName)
+	} else {
+		e.Name = string(rawName)
+	}
+	if !e.Dir {
+		if i := strings.LastIndexByte(e.Name, ';'); i >= 0 {
+			e.Name = e.Name[:i]
+		}
+		e.Name = strings.TrimSuffix(e.Name, ".")
+	}
+	if img.format == RockRidge {
+		susp := 33 + nameLen
+		if nameLen%2 == 0 {
+			susp++
+		}
+		if name, ok := rockRidgeName(record, susp+img.suspSkip); ok {
+			e.Name = name
+		}
+	}
+	return e, nil
+}
+
+// rockRidge reports whether the image has Rock Ridge extensions, announced
+// by an SP entry in the system use area of the root's "." record, and how
+// many bytes to skip in each record's system use area
+func (img *Image) rockRidge() (int, bool) {
+	ext := img.root.extents[0]
+	head := make([]byte, min(ext.size, sectorSize))
+	if _, err := img.r.ReadAt(head, ext.offset); err != nil && !errors.Is(err, io.EOF) {
+		return 0, false
+	}
+	length := int(head[0])
+	if length < 34 || length > len(head) {
+		return 0, false
+	}
+	susp := 34 // "." has a one byte name and a padding byte
+	record := head[:length]
+	if susp+7 > len(record) || string(record[susp:susp+2]) != "SP" || record[susp+4] != 0xBE || record[susp+5] != 0xEF {
+		return 0, false
+	}
+	return int(record[susp+6]), true
+}
+
+// rockRidgeName returns the name in the NM entries of a record's system use
+// area. Entries continued in another area aren't followed.
+func rockRidgeName(record []byte, pos int) (string, bool) {
+	var name []byte
+	found := false
+	for pos+4 <= len(record) {
+		size := int(record[pos+2])
+		if size < 4 || pos+size > len(record) {
+			break
+		}
+		if string(record[pos:pos+2]) == "NM" && size >= 5 {
+			flags := record[pos+4]
+			if flags&0x06 == 0 { // not "." or ".."
+				name = append(name, record[pos+5:pos+size]...)
+				found = true
+			}
+			if flags&0x01 == 0 && found {
+				break
+			}
+		}
+		pos += size
+	}
+	if !found || len(name) == 0 || bytes.ContainsAny(name, "/\x00") {
+		return "", false
+	}
+	return string(name), true
+}
+
+// isJoliet reports whether a supplementary volume descriptor is Joliet's,
+// which names files in UCS-2
+func isJoliet(sector []byte) bool {
+	escape := sector[88:91]
+	return escape[0] == '%' && escape[1] == '/' && (escape[2] == '@' || escape[2] == 'C' || escape[2] == 'E')
+}
+
+func decodeUCS2(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// recordTime decodes the seven byte date of a directory record
+func recordTime(b []byte) time.Time {
+	if b[0] == 0 && b[1] == 0 && b[2] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// extentReader reads the extents of a file as one
+type extentReader struct {
+	r       io.ReaderAt
+	extents []extent
+}
+
+func (x *extentReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, ext := range x.extents {
+		if len(p) == 0 {
+			break
+		}
+		if off >= ext.size {
+			off -= ext.size
+			continue
+		}
+		chunk := p[:min(int64(len(p)), ext.size-off)]
+		read, err := x.r.ReadAt(chunk, ext.offset+off)
+		n += read
+		if err != nil && !(errors.Is(err, io.EOF) && read == len(chunk)) {
+			return n, err
+		}
+		p = p[read:]
+		off = 0
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -1,83 +1,430 @@
 package main
 
 import (
-	"log"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
+	"path/filepath"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
+	"nextbrowse-backend/alert"
+	"nextbrowse-backend/config"
 	"nextbrowse-backend/handlers"
+	"nextbrowse-backend/metadata"
 	"nextbrowse-backend/middleware"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/vfs"
+	"nextbrowse-backend/webhook"
 )
 
 func main() {
-	// Setup Gin
-	r := gin.Default()
-
-	// CORS configuration
-	cfg := cors.Config{
-		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		AllowCredentials: true,
-		// Dynamically allow any origin (nginx serves same-origin, but this also covers LAN IP access)
-		AllowOriginFunc: func(origin string) bool {
-			// Allow all origins; the library will echo the Origin value instead of '*'
-			// which is compatible with credentials=true
-			return true
-		},
-	}
-	r.Use(cors.New(cfg))
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// runServer mounts the configured storage, starts the scheduled jobs and
+// serves the API on port until the server fails
+func runServer(port string) {
+	setupLogging()
+
+	// Share rate limits and uploads with other replicas
+	if config.RedisURL != "" {
+		if err := models.ConnectRedis(config.RedisURL); err != nil {
+			fatal("Failed to connect to Redis", "error", err)
+		}
+		slog.Info("Sharing rate limits and uploads through Redis")
+	}
+
+	// Mount additional storage backends
+	for mountPath, rawURL := range config.Mounts {
+		fsys, err := vfs.New(mountPath, rawURL)
+		if err != nil {
+			fatal("Failed to mount storage", "mount", mountPath, "error", err)
+		}
+		vfs.Mount(mountPath, fsys)
+		slog.Info("Mounted storage backend", "mount", mountPath)
+	}
+
+	// Tags and notes users attach to paths, and the log of changes behind
+	// the activity feed
+	if err := metadata.Open(filepath.Join(config.DataDir, "metadata.db")); err != nil {
+		slog.Warn("Metadata store unavailable; tags, notes and activity are disabled", "error", err)
+	} else if config.ActivityRetention > 0 {
+		metadata.RecordActivity()
+	}
+
+	// The search index saved by the last run, until it is reconciled
+	handlers.LoadSearchIndex()
+
+	// Start scheduled backups
+	if err := handlers.StartBackupScheduler(); err != nil {
+		fatal("Failed to schedule backups", "error", err)
+	}
+
+	// Housekeeping and maintenance tasks, starting with a sweep of what
+	// uploads and writes cut short by an earlier crash left behind
+	if err := handlers.StartTasks(); err != nil {
+		fatal("Failed to schedule tasks", "error", err)
+	}
+
+	// Post changes to the tree to the configured webhooks
+	webhook.Start()
+
+	// Start server
+	if err := serve(newRouter(), ":"+port); err != nil {
+		fatal("Server stopped", "error", err)
+	}
+}
+
+// newRouter sets up the middleware and routes of the API
+func newRouter() *gin.Engine {
+	// Setup Gin with structured access logs in place of its own
+	r := gin.New()
+	// Forms parsed whole keep no more than MULTIPART_MEMORY in memory
+	r.MaxMultipartMemory = config.MultipartMemory
+	r.Use(middleware.Recovery())
+	r.Use(middleware.Deadlines(config.RequestReadTimeout, config.RequestWriteTimeout))
+	r.Use(middleware.RequestLogger())
+	if alert.Enabled() {
+		r.Use(middleware.ServerErrorAlerts())
+	}
+	if config.MetricsEnabled {
+		r.Use(middleware.Metrics())
+	}
+	// Daily usage behind the admin statistics
+	r.Use(middleware.UsageStats())
+
+	// Per-client rate limit tiers
+	if config.RateLimitEnabled {
+		r.Use(middleware.RateLimit(config.RateLimits))
+	}
+
+	// CORS for the origins in ALLOWED_ORIGINS
+	r.Use(middleware.CORS())
 
 	// Security middleware
 	r.Use(middleware.SecurityHeaders())
 
+	// Operator headers for the files served, by HEADER_RULES
+	r.Use(middleware.HeaderRules(config.HeaderRules))
+
+	// Refuse changes while in maintenance mode
+	if config.ReadOnly {
+		models.SetMaintenance(true, config.MaintenanceMessage)
+	}
+	r.Use(middleware.ReadOnlyGuard())
+
+	// Downloads count against DOWNLOAD_STREAMS_PER_CLIENT together
+	streams := middleware.StreamLimit(config.DownloadStreamsPerClient)
+	// Transfers may take as long as they keep moving
+	transfer := middleware.StreamDeadlines(config.StreamIdleTimeout)
+	// and uploads at most UPLOAD_TIMEOUT in all
+	upload := middleware.OperationBudget(config.UploadTimeout, "UPLOAD_TIMEOUT")
+
+	// Files at the URLs listings give them, for serving without nginx
+	r.GET("/files/*path", streams, transfer, handlers.ServePublicFile)
+	r.HEAD("/files/*path", streams, transfer, handlers.ServePublicFile)
+
 	// File system API routes
 	fs := r.Group("/api/fs")
 	{
 		fs.GET("/list", handlers.ListDirectory)
-		fs.GET("/read", handlers.ReadFile)
+		fs.GET("/recent", handlers.RecentFiles)
+		fs.GET("/search", transfer, handlers.SearchFiles)
+		fs.GET("/read", transfer, handlers.ReadFile)
 		fs.POST("/copy", handlers.CopyFile)
 		fs.POST("/move", handlers.MoveFile)
+		fs.POST("/sync", handlers.SyncFiles)
+		fs.GET("/checksums", streams, transfer, handlers.DownloadChecksums)
+		fs.POST("/checksums", handlers.CreateChecksums)
+		fs.POST("/checksums/verify", handlers.VerifyChecksums)
 		fs.POST("/mkdir", handlers.CreateDirectory)
+		fs.POST("/touch", handlers.CreateFile)
+		fs.POST("/paste", transfer, handlers.CreatePaste)
+		fs.PUT("/write", transfer, handlers.SaveFile)
+		fs.POST("/append", transfer, handlers.AppendFile)
+		fs.PATCH("/patch", handlers.PatchFile)
+		fs.POST("/link", handlers.CreateLink)
+		fs.GET("/tags", handlers.GetTags)
+		fs.POST("/tags", handlers.AddTags)
+		fs.PUT("/tags", handlers.SetTags)
+		fs.DELETE("/tags", handlers.RemoveTags)
+		fs.GET("/note", handlers.GetNote)
+		fs.PUT("/note", handlers.SetNote)
+		fs.DELETE("/note", handlers.DeleteNote)
+		fs.GET("/activity", handlers.GetActivity)
+		fs.GET("/diff", handlers.DiffPaths)
+		fs.GET("/git", handlers.GitStatus)
+		fs.GET("/table", handlers.PreviewTable)
+		fs.GET("/iso", handlers.ListISO)
+		fs.GET("/iso/download", streams, transfer, handlers.DownloadISOEntry)
+		fs.POST("/iso/extract", handlers.ExtractISOEntry)
+		fs.GET("/gallery", handlers.ListGallery)
+		fs.GET("/frequent", handlers.FrequentDirectories)
+		fs.GET("/pins", handlers.ListPins)
+		fs.POST("/pins", handlers.AddPin)
+		fs.PUT("/pins", handlers.SetPins)
+		fs.DELETE("/pins", handlers.RemovePin)
 		fs.DELETE("/delete", handlers.DeleteFile)
 		fs.POST("/delete", handlers.DeleteFile)
-		fs.GET("/download", handlers.DownloadFile)
-		fs.POST("/download-multiple", handlers.DownloadMultiple)
+		fs.GET("/download", streams, transfer, handlers.DownloadFile)
+		fs.POST("/download-multiple", streams, transfer, handlers.DownloadMultiple)
+		if config.Features.Uploads {
+			fs.POST("/upload", transfer, upload, handlers.UploadFiles)
+		}
 		
 		// Share endpoints
-		fs.POST("/share/create", handlers.CreateShare)
-		fs.GET("/share/:shareId", handlers.GetShare)
-		fs.GET("/share/:shareId/access", handlers.AccessShare)
-		fs.GET("/share/:shareId/download", handlers.DownloadShare)
+		if config.Features.Shares {
+			fs.POST("/share/create", handlers.CreateShare)
+			fs.GET("/shares", handlers.GetAllShares)
+			fs.GET("/share/:shareId", handlers.GetShare)
+			fs.GET("/share/:shareId/access", handlers.AccessShare)
+			fs.GET("/share/:shareId/meta", handlers.GetShareMeta)
+			fs.GET("/share/:shareId/thumbnail", handlers.GetShareThumbnail)
+			fs.GET("/share/:shareId/download", streams, transfer, handlers.DownloadShare)
+			fs.GET("/share/:shareId/torrent", handlers.GetShareTorrent)
+			fs.POST("/share/:shareId/torrent", handlers.CreateShareTorrent)
+			fs.GET("/share/:shareId/seed/*path", streams, transfer, handlers.SeedShare)
+		}
 	}
 
 	// TUS 1.0.0 Resumable File Upload endpoints
-	tus := r.Group("/api/tus")
+	if config.Features.Uploads {
+		tus := r.Group("/api/tus")
+		{
+			tus.OPTIONS("/files", handlers.TusOptionsHandler)    // TUS discovery
+			tus.POST("/files", transfer, handlers.TusPostHandler) // Create upload
+			tus.HEAD("/files/:id", handlers.TusHeadHandler)      // Get upload status  
+			tus.PATCH("/files/:id", transfer, upload, handlers.TusPatchHandler) // Upload chunks
+			tus.DELETE("/files/:id", handlers.TusDeleteHandler)  // Cancel upload
+			tus.PUT("/files/:id/pieces", handlers.TusPiecesHandler) // Set piecewise checksums
+			tus.GET("/config", handlers.GetTusConfig)            // Get TUS configuration
+			tus.GET("/lookup", handlers.TusLookupHandler)        // Find an upload to resume by fingerprint
+		}
+	}
+
+
+	// Deleted entries kept for restoring, when TRASH_ENABLED
+	if config.TrashEnabled {
+		trash := r.Group("/api/trash")
+		{
+			trash.GET("", handlers.ListTrash)
+			trash.DELETE("", handlers.EmptyTrash)
+			trash.POST("/:id/restore", handlers.RestoreTrashItem)
+			trash.DELETE("/:id", handlers.DeleteTrashItem)
+		}
+	}
+
+	// Logos of the themes share pages are branded with
+	if config.Features.Shares {
+		r.GET("/api/themes/:name/logo", handlers.GetThemeLogo)
+	}
+
+	// Scaled-down images, cached in DATA_DIR/thumbnails
+	r.GET("/api/thumbnail", handlers.GetThumbnail)
+
+	// Tags across the tree, and what carries them
+	r.GET("/api/tags", handlers.ListAllTags)
+	r.GET("/api/tags/:tag", handlers.FindTagged)
+
+	// Background job endpoints (copy/move progress and cancellation)
+	jobs := r.Group("/api/jobs")
+	{
+		jobs.GET("", handlers.ListJobs)
+		jobs.GET("/:id", handlers.GetJob)
+		jobs.GET("/:id/events", transfer, handlers.StreamJobEvents)
+		jobs.GET("/:id/archive", streams, transfer, handlers.DownloadArchive)
+		jobs.DELETE("/:id", handlers.CancelJob)
+	}
+
+	// Live notifications of changes to the tree and job progress
+	r.GET("/api/events", transfer, handlers.StreamEvents)
+
+	// Administration, behind ADMIN_TOKEN
+	admin := r.Group("/api/admin", middleware.AdminAuth())
+	{
+		admin.GET("/stats", handlers.GetStats)
+		admin.GET("/maintenance", handlers.GetMaintenance)
+		admin.PUT("/maintenance", handlers.SetMaintenance)
+		admin.GET("/webhooks", handlers.ListWebhooks)
+		admin.GET("/webhooks/:name/deliveries", handlers.ListWebhookDeliveries)
+		admin.POST("/webhooks/:name/ping", handlers.PingWebhook)
+		admin.GET("/tasks", handlers.ListTasks)
+		admin.GET("/tasks/:name/runs", handlers.ListTaskRuns)
+		admin.POST("/tasks/:name/run", handlers.RunTask)
+		admin.GET("/quotas", handlers.ListQuotas)
+		admin.PUT("/quotas", handlers.SetQuota)
+		admin.DELETE("/quotas", handlers.DeleteQuota)
+		admin.GET("/preview-cache", handlers.GetPreviewCache)
+		admin.PUT("/preview-cache", handlers.SetPreviewCache)
+		admin.DELETE("/preview-cache", handlers.PurgePreviewCache)
+		if config.Features.Shares {
+			admin.GET("/themes", handlers.ListThemes)
+			admin.PUT("/themes/:name", handlers.SetTheme)
+			admin.DELETE("/themes/:name", handlers.DeleteTheme)
+			admin.PUT("/themes/:name/logo", handlers.SetThemeLogo)
+			admin.DELETE("/themes/:name/logo", handlers.DeleteThemeLogo)
+		}
+	}
+
+	// Read-only filesystem snapshots and restoring from them
+	snapshots := r.Group("/api/snapshots")
 	{
-		tus.OPTIONS("/files", handlers.TusOptionsHandler)    // TUS discovery
-		tus.POST("/files", handlers.TusPostHandler)          // Create upload
-		tus.HEAD("/files/:id", handlers.TusHeadHandler)      // Get upload status  
-		tus.PATCH("/files/:id", handlers.TusPatchHandler)    // Upload chunks
-		tus.DELETE("/files/:id", handlers.TusDeleteHandler)  // Cancel upload
-		tus.GET("/config", handlers.GetTusConfig)            // Get TUS configuration
+		snapshots.GET("", handlers.ListSnapshots)
+		snapshots.GET("/:id/list", handlers.ListSnapshotDirectory)
+		snapshots.GET("/:id/download", streams, transfer, handlers.DownloadSnapshotFile)
+		snapshots.POST("/:id/restore", handlers.RestoreFromSnapshot)
 	}
 
+	// Scheduled backups and their runs
+	backups := r.Group("/api/backups")
+	{
+		backups.GET("", handlers.ListBackups)
+		backups.GET("/:name/runs", handlers.ListBackupRuns)
+		backups.POST("/:name/run", handlers.RunBackup)
+	}
+
+	// Editing office documents in place through OnlyOffice or Collabora,
+	// which fetch and save them over WOPI with the access token handed out
+	if config.OfficeURL != "" {
+		r.POST("/api/fs/office", handlers.OpenOffice)
+		wopi := r.Group("/wopi/files")
+		{
+			wopi.GET("/:id", handlers.WOPICheckFileInfo)
+			wopi.POST("/:id", handlers.WOPIFileOperation)
+			wopi.GET("/:id/contents", transfer, handlers.WOPIGetFile)
+			wopi.POST("/:id/contents", transfer, handlers.WOPIPutFile)
+		}
+	}
+
+	// WebDAV server for mapping the tree as a network drive
+	if config.Features.WebDAV {
+		handlers.RegisterWebDAV(r.Group("", transfer), "/dav")
+	}
+
+	// Prometheus metrics, plus a JSON summary of the server's own
+	if config.MetricsEnabled {
+		r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+		r.GET("/metrics/json", handlers.MetricsJSON)
+	}
+
+	// Network speed tests that don't touch storage
+	if config.BenchMaxSize > 0 {
+		r.POST("/api/bench/upload", transfer, handlers.BenchUpload)
+		r.GET("/api/bench/download", streams, transfer, handlers.BenchDownload)
+	}
 
 	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "ok"})
-	})
+	r.GET("/health", handlers.HealthCheck)
 	r.HEAD("/health", func(c *gin.Context) {
 		c.Status(200)
 	})
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9932"
+	// OpenAPI specification of the routes above, and Swagger UI for it
+	handlers.RegisterAPIDocs(r, version)
+
+	return r
+}
+
+// serve runs the server on addr: over HTTPS with a Let's Encrypt
+// certificate when ACME_DOMAINS is set, with TLS_CERT/TLS_KEY when those are,
+// and over plain HTTP otherwise
+func serve(handler http.Handler, addr string) error {
+	// Bodies and responses get their deadlines per route, from the Deadlines
+	// middleware, so ReadTimeout and WriteTimeout are left unset
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	if err := configureHTTP2(server); err != nil {
+		return err
+	}
+
+	switch {
+	case len(config.ACMEDomains) > 0:
+		if config.TLSCert != "" || config.TLSKey != "" {
+			return errors.New("set either ACME_DOMAINS or TLS_CERT/TLS_KEY, not both")
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+			Cache:      autocert.DirCache(config.ACMECacheDir),
+			Email:      config.ACMEEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+
+		// HTTP-01 challenges, with everything else redirected to HTTPS
+		if config.ACMEHTTPAddr != "" {
+			go func() {
+				slog.Info("Serving ACME challenges", "addr", config.ACMEHTTPAddr)
+				if err := http.ListenAndServe(config.ACMEHTTPAddr, manager.HTTPHandler(nil)); err != nil {
+					slog.Error("ACME challenge listener stopped", "error", err)
+				}
+			}()
+		}
+
+		slog.Info("Starting Go backend server with Let's Encrypt TLS", "addr", addr, "domains", config.ACMEDomains)
+		return server.ListenAndServeTLS("", "")
+	case config.TLSCert != "" || config.TLSKey != "":
+		if config.TLSCert == "" || config.TLSKey == "" {
+			return errors.New("TLS_CERT and TLS_KEY must be set together")
+		}
+		slog.Info("Starting Go backend server with TLS", "addr", addr)
+		return server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+	default:
+		slog.Info("Starting Go backend server", "addr", addr)
+		return server.ListenAndServe()
+	}
+}
+
+// configureHTTP2 serves HTTP/2 with flow-control windows sized for large
+// uploads, over TLS and, with H2C, over plain HTTP as well. With HTTP2 off
+// only HTTP/1.1 is served.
+func configureHTTP2(server *http.Server) error {
+	if !config.HTTP2Enabled {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+	h2 := &http2.Server{
+		IdleTimeout:                  config.IdleTimeout,
+		MaxUploadBufferPerStream:     int32(min(config.HTTP2StreamWindow, math.MaxInt32)),
+		MaxUploadBufferPerConnection: int32(min(config.HTTP2ConnWindow, math.MaxInt32)),
+	}
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return err
+	}
+	if config.H2CEnabled {
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+	}
+	return nil
+}
+
+// setupLogging makes slog's default logger, which the standard log package
+// also writes through, use LOG_FORMAT and LOG_LEVEL
+func setupLogging() {
+	opts := &slog.HandlerOptions{Level: config.LogLevel}
+	var handler slog.Handler
+	if config.LogFormat == config.LogFormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
 	}
+	slog.SetDefault(slog.New(handler))
+}
 
-	log.Printf("Starting Go backend server on port %s", port)
-	log.Fatal(r.Run(":" + port))
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
\ No newline at end of file
@@ -0,0 +1,171 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+
+	"nextbrowse-backend/events"
+)
+
+var activityBucket = []byte("activity")
+
+// activityTypes are the events kept in the activity log: changes to the
+// tree, not the progress of the jobs making them
+var activityTypes = []string{
+	events.UploadCompleted,
+	events.FileCreated,
+	events.FileWritten,
+	events.FileDeleted,
+	events.FileMoved,
+	events.DirCreated,
+	events.LinkCreated,
+	events.ShareCreated,
+}
+
+// Activity is one change to the tree
+type Activity struct {
+	ID          uint64 `json:"id"`
+	Type        string `json:"type"`
+	Path        string `json:"path"`
+	Destination string `json:"destination,omitempty"`
+	Actor       string `json:"actor,omitempty"`
+	Time        int64  `json:"time"`
+}
+
+// RecordActivity starts keeping a log of the changes made through the server
+// until the process exits
+func RecordActivity() {
+	go record()
+}
+
+// record appends the changes published on the event bus to the log
+func record() {
+	filter := events.Filter{Types: activityTypes}
+	var last int64
+	for {
+		sub, missed, complete := events.Subscribe(filter, last)
+		if !complete {
+			slog.Warn("Activity log fell behind; some changes are missing from it")
+		}
+		for _, e := range missed {
+			appendActivity(e)
+			last = e.ID
+		}
+		for e := range sub.C {
+			appendActivity(e)
+			last = e.ID
+		}
+	}
+}
+
+func appendActivity(e events.Event) {
+	if db == nil {
+		return
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(activityBucket)
+		if err != nil {
+			return err
+		}
+		// Event IDs start over with the process, so the log numbers its own
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(Activity{
+			Type:        e.Type,
+			Path:        Clean(e.Path),
+			Destination: cleanOptional(e.Destination),
+			Actor:       e.Actor,
+			Time:        e.Time,
+		})
+		if err != nil {
+			return err
+		}
+		return b.Put(activityKey(id), data)
+	})
+	if err != nil {
+		slog.Warn("Failed to record activity", "event", e.Type, "path", e.Path, "error", err)
+	}
+}
+
+// ActivityBelow calls fn for the changes made on or below dir, including
+// moves into and out of it, newest first, until fn returns false
+func ActivityBelow(dir string, fn func(Activity) bool) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	dir = Clean(dir)
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(activityBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var a Activity
+			if err := json.Unmarshal(v, &a); err != nil {
+				continue
+			}
+			if !within(dir, a.Path) && !within(dir, a.Destination) {
+				continue
+			}
+			a.ID = binary.BigEndian.Uint64(k)
+			if !fn(a) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// PruneActivity removes the changes made before cutoff, given in Unix
+// milliseconds, and returns how many it removed
+func PruneActivity(cutoff int64) (int, error) {
+	if db == nil {
+		return 0, ErrUnavailable
+	}
+	var removed int
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(activityBucket)
+		if b == nil {
+			return nil
+		}
+		// Changes are appended in time order, so the old ones come first
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var a Activity
+			if err := json.Unmarshal(v, &a); err == nil && a.Time >= cutoff {
+				break
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func activityKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func cleanOptional(p string) string {
+	if p == "" {
+		return ""
+	}
+	return Clean(p)
+}
+
+// within reports whether the clean path p is dir or below it
+func within(dir, p string) bool {
+	return p != "" && (p == dir || dir == "/" || strings.HasPrefix(p, dir+"/"))
+}
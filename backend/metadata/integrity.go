@@ -0,0 +1,68 @@
+package metadata
+
+import (
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var checksumsBucket = []byte("checksums")
+
+// Checksum is the baseline of a file watched for silent corruption: its
+// contents' SHA-256 as of the size and modification time it had then
+type Checksum struct {
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	MTime    int64  `json:"mtime"`    // in nanoseconds
+	Verified int64  `json:"verified"` // when the contents last matched
+}
+
+// Checksums calls fn for every baseline on or below dir, in path order
+func Checksums(dir string, fn func(p string, sum Checksum) error) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	dir = Clean(dir)
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(checksumsBucket)
+		if b == nil {
+			return nil
+		}
+		return scan(b, dir, func(k, v []byte) error {
+			var sum Checksum
+			if err := json.Unmarshal(v, &sum); err != nil {
+				return nil
+			}
+			return fn(string(k), sum)
+		})
+	})
+}
+
+// UpdateChecksums stores the baselines in set and removes those of the
+// paths in remove
+func UpdateChecksums(set map[string]Checksum, remove []string) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(checksumsBucket)
+		if err != nil {
+			return err
+		}
+		for p, sum := range set {
+			data, err := json.Marshal(sum)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(Clean(p)), data); err != nil {
+				return err
+			}
+		}
+		for _, p := range remove {
+			if err := b.Delete([]byte(Clean(p))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
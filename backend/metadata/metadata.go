@@ -0,0 +1,314 @@
+// Package metadata keeps what users attach to files and directories, tags
+// and notes, in a bolt database beside the tree, keyed by user path. Entries
+// follow their paths when the server moves them and go when it deletes them.
+// The same database holds the activity log of changes to the tree, the
+// directories each user visits and pins, how fast each user uploads, the
+// checksums of files watched for corruption, the quotas capping
+// directories, the themes share pages are branded with, and the daily usage
+// the admin statistics are drawn from.
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"nextbrowse-backend/events"
+)
+
+// ErrUnavailable is returned while the database is not open
+var ErrUnavailable = errors.New("metadata store is unavailable")
+
+var entriesBucket = []byte("entries")
+
+// pathBuckets are keyed by user path, so their keys follow moves and deletes
+var pathBuckets = [][]byte{entriesBucket, checksumsBucket}
+
+// Entry is the metadata of one path
+type Entry struct {
+	Tags []string `json:"tags,omitempty"`
+	Note *Note    `json:"note,omitempty"`
+}
+
+// Note is free text attached to a path
+type Note struct {
+	Text      string `json:"text"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+func (e Entry) empty() bool {
+	return len(e.Tags) == 0 && e.Note == nil
+}
+
+var db *bolt.DB
+
+// Open opens the database in file, creating it if needed, and starts
+// following moves and deletes
+func Open(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	d, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	err = d.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(entriesBucket)
+		return err
+	})
+	if err != nil {
+		d.Close()
+		return err
+	}
+
+	db = d
+	go follow()
+	go writeVisits()
+	go writeUsage()
+	return nil
+}
+
+// Available reports whether the database is open
+func Available() bool {
+	return db != nil
+}
+
+// Clean turns a user path into the key it is stored under
+func Clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Get returns the metadata of a path
+func Get(p string) (Entry, error) {
+	entries, err := GetMany([]string{p})
+	return entries[Clean(p)], err
+}
+
+// GetMany returns the metadata of the paths that have any, keyed by clean
+// path
+func GetMany(paths []string) (map[string]Entry, error) {
+	entries := make(map[string]Entry)
+	if db == nil {
+		return entries, ErrUnavailable
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		for _, p := range paths {
+			p = Clean(p)
+			if data := b.Get([]byte(p)); data != nil {
+				var e Entry
+				if err := json.Unmarshal(data, &e); err == nil {
+					entries[p] = e
+				}
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Update changes the metadata of a path and returns the result. An entry
+// left empty is removed.
+func Update(p string, change func(*Entry)) (Entry, error) {
+	if db == nil {
+		return Entry{}, ErrUnavailable
+	}
+	key := []byte(Clean(p))
+	var e Entry
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(entriesBucket)
+		if data := b.Get(key); data != nil {
+			if err := json.Unmarshal(data, &e); err != nil {
+				return err
+			}
+		}
+		change(&e)
+		if e.empty() {
+			return b.Delete(key)
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	return e, err
+}
+
+// Walk calls fn for every entry on or below dir, in path order
+func Walk(dir string, fn func(p string, e Entry) error) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	dir = Clean(dir)
+	return db.View(func(tx *bolt.Tx) error {
+		return scan(tx.Bucket(entriesBucket), dir, func(k, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return nil
+			}
+			return fn(string(k), e)
+		})
+	})
+}
+
+// Move carries the metadata and checksums of src and everything below it,
+// and the visits and pins of users, over to dst. As moves may merge into an existing
+// directory, what dst has is kept unless src has an entry for the same path.
+func Move(src, dst string) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	src, dst = Clean(src), Clean(dst)
+	if src == dst || src == "/" {
+		return nil
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range pathBuckets {
+			if err := moveTree(tx.Bucket(name), src, dst); err != nil {
+				return err
+			}
+		}
+		return moveUserPaths(tx, src, dst)
+	})
+}
+
+// moveTree rekeys the entries of src and below it in b to dst
+func moveTree(b *bolt.Bucket, src, dst string) error {
+	if b == nil {
+		return nil
+	}
+	moved := make(map[string][]byte)
+	_ = scan(b, src, func(k, v []byte) error {
+		moved[dst+strings.TrimPrefix(string(k), src)] = append([]byte(nil), v...)
+		return nil
+	})
+	if err := deleteTree(b, src); err != nil {
+		return err
+	}
+	for k, v := range moved {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the metadata and checksums of p and everything below it,
+// and forgets users' visits and pins there
+func Delete(p string) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	p = Clean(p)
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, name := range pathBuckets {
+			if err := deleteTree(tx.Bucket(name), p); err != nil {
+				return err
+			}
+		}
+		return deleteUserPaths(tx, p)
+	})
+}
+
+func deleteTree(b *bolt.Bucket, dir string) error {
+	if b == nil {
+		return nil
+	}
+	var keys [][]byte
+	_ = scan(b, dir, func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn for the entry of the clean path dir and those below it. Keys
+// below dir needn't follow dir itself, as "/a b" sorts between "/a" and
+// "/a/b", so they are sought by their "dir/" prefix.
+func scan(b *bolt.Bucket, dir string, fn func(k, v []byte) error) error {
+	if v := b.Get([]byte(dir)); v != nil {
+		if err := fn([]byte(dir), v); err != nil {
+			return err
+		}
+	}
+	prefix := []byte(strings.TrimSuffix(dir, "/") + "/")
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if string(k) == dir {
+			continue
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// follow keeps entries in step with moves and deletes made through the
+// server
+func follow() {
+	filter := events.Filter{Types: []string{events.FileMoved, events.FileDeleted}}
+	var last int64
+	for {
+		sub, missed, complete := events.Subscribe(filter, last)
+		if !complete {
+			slog.Warn("Metadata fell behind on moves and deletes; some entries may be stale")
+		}
+		for _, e := range missed {
+			apply(e)
+			last = e.ID
+		}
+		for e := range sub.C {
+			apply(e)
+			last = e.ID
+		}
+	}
+}
+
+func apply(e events.Event) {
+	// Visits not written yet must move or go along with the others
+	flushVisits()
+
+	var err error
+	switch e.Type {
+	case events.FileMoved:
+		err = Move(e.Path, e.Destination)
+	case events.FileDeleted:
+		err = Delete(e.Path)
+	}
+	if err != nil {
+		slog.Warn("Failed to update metadata", "event", e.Type, "path", e.Path, "error", err)
+	}
+}
+
+// NormalizeTags trims tags and drops empty and repeated ones, returning them
+// sorted
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// quotasBucket is keyed by user path but not among pathBuckets: a quota
+// caps a place in the tree and stays there when its directory moves
+var quotasBucket = []byte("quotas")
+
+// Quota caps the bytes and the number of files below a directory; a zero
+// limit leaves that measure uncapped
+type Quota struct {
+	Path     string `json:"path"`
+	MaxBytes int64  `json:"maxBytes,omitempty"`
+	MaxFiles int64  `json:"maxFiles,omitempty"`
+}
+
+// Quotas returns every quota, in path order
+func Quotas() ([]Quota, error) {
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	var quotas []Quota
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quotasBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var q Quota
+			if err := json.Unmarshal(v, &q); err != nil {
+				return nil
+			}
+			q.Path = string(k)
+			quotas = append(quotas, q)
+			return nil
+		})
+	})
+	return quotas, err
+}
+
+// SetQuota stores a quota, replacing the one its path had
+func SetQuota(q Quota) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	q.Path = Clean(q.Path)
+	data, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(quotasBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(q.Path), data)
+	})
+}
+
+// DeleteQuota removes the quota of a path, reporting whether it had one
+func DeleteQuota(p string) (bool, error) {
+	if db == nil {
+		return false, ErrUnavailable
+	}
+	key := []byte(Clean(p))
+	found := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quotasBucket)
+		if b == nil || b.Get(key) == nil {
+			return nil
+		}
+		found = true
+		return b.Delete(key)
+	})
+	return found, err
+}
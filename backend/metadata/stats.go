@@ -0,0 +1,252 @@
+package metadata
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var statsBucket = []byte("stats")
+
+// statsDayLayout keys the usage of a day, in the server's time zone
+const statsDayLayout = "2006-01-02"
+
+// Bounds of what a day's usage keeps, so that a scan of random names can't
+// grow it without end: the users counted, and the files served, which lose
+// the least served first
+const (
+	maxStatsUsers = 10000
+	maxStatsFiles = 1000
+)
+
+// statsFlushInterval is how often counted usage is written out
+const statsFlushInterval = time.Minute
+
+// Request is what a request adds to the usage of its day. Served names the
+// file it served, if it did.
+type Request struct {
+	User     string
+	Status   int
+	BytesIn  int64
+	BytesOut int64
+	Served   string
+}
+
+// Usage is the traffic of a day, or summed over several
+type Usage struct {
+	Requests     int64            `json:"requests"`
+	ClientErrors int64            `json:"clientErrors"`
+	ServerErrors int64            `json:"serverErrors"`
+	FilesServed  int64            `json:"filesServed"`
+	BytesIn      int64            `json:"bytesIn"`
+	BytesOut     int64            `json:"bytesOut"`
+	Users        map[string]bool  `json:"users,omitempty"`
+	Files        map[string]int64 `json:"files,omitempty"`
+}
+
+// Served is how often a file was served
+type Served struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+var (
+	pendingUsage   = make(map[string]*Usage) // usage not yet written, by day
+	pendingUsageMu sync.Mutex
+)
+
+// RecordRequest counts a request in the usage of today. Usage is written out
+// in the background, so the last minute of it is lost if the process dies.
+func RecordRequest(r Request) {
+	if db == nil {
+		return
+	}
+	day := time.Now().Format(statsDayLayout)
+
+	pendingUsageMu.Lock()
+	defer pendingUsageMu.Unlock()
+	u := pendingUsage[day]
+	if u == nil {
+		u = &Usage{}
+		pendingUsage[day] = u
+	}
+	u.Requests++
+	switch {
+	case r.Status >= 500:
+		u.ServerErrors++
+	case r.Status >= 400:
+		u.ClientErrors++
+	}
+	u.BytesIn += r.BytesIn
+	u.BytesOut += r.BytesOut
+	if r.User != "" {
+		u.addUser(r.User)
+	}
+	if r.Served != "" {
+		u.FilesServed++
+		u.addFiles(map[string]int64{r.Served: 1})
+	}
+}
+
+// UsageSince sums the usage of the days from since to today
+func UsageSince(since time.Time) (Usage, error) {
+	if db == nil {
+		return Usage{}, ErrUnavailable
+	}
+	from := since.Format(statsDayLayout)
+	var total Usage
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(statsBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(from)); k != nil; k, v = c.Next() {
+			var u Usage
+			if err := json.Unmarshal(v, &u); err != nil {
+				continue
+			}
+			total.add(&u)
+		}
+		return nil
+	})
+
+	pendingUsageMu.Lock()
+	for day, u := range pendingUsage {
+		if day >= from {
+			total.add(u)
+		}
+	}
+	pendingUsageMu.Unlock()
+	return total, err
+}
+
+// TopFiles returns the n files served most often, most served first
+func (u Usage) TopFiles(n int) []Served {
+	top := make([]Served, 0, len(u.Files))
+	for p, count := range u.Files {
+		top = append(top, Served{Path: p, Count: count})
+	}
+	sortServed(top)
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// add sums other into u
+func (u *Usage) add(other *Usage) {
+	u.Requests += other.Requests
+	u.ClientErrors += other.ClientErrors
+	u.ServerErrors += other.ServerErrors
+	u.FilesServed += other.FilesServed
+	u.BytesIn += other.BytesIn
+	u.BytesOut += other.BytesOut
+	for user := range other.Users {
+		u.addUser(user)
+	}
+	u.addFiles(other.Files)
+}
+
+// addUser counts user, up to maxStatsUsers
+func (u *Usage) addUser(user string) {
+	if u.Users == nil {
+		u.Users = make(map[string]bool)
+	}
+	if len(u.Users) < maxStatsUsers {
+		u.Users[user] = true
+	}
+}
+
+// addFiles counts served files, forgetting the least served beyond
+// maxStatsFiles
+func (u *Usage) addFiles(files map[string]int64) {
+	if len(files) == 0 {
+		return
+	}
+	if u.Files == nil {
+		u.Files = make(map[string]int64)
+	}
+	for p, count := range files {
+		u.Files[p] += count
+	}
+	if len(u.Files) <= maxStatsFiles {
+		return
+	}
+	for _, s := range u.TopFiles(len(u.Files))[maxStatsFiles:] {
+		delete(u.Files, s.Path)
+	}
+}
+
+// sortServed puts the most served files first, in path order among equals
+func sortServed(served []Served) {
+	sort.Slice(served, func(i, j int) bool {
+		if served[i].Count != served[j].Count {
+			return served[i].Count > served[j].Count
+		}
+		return served[i].Path < served[j].Path
+	})
+}
+
+// flushUsage writes out the usage counted since the last flush, and forgets
+// the days before last month
+func flushUsage() {
+	pendingUsageMu.Lock()
+	usage := pendingUsage
+	pendingUsage = make(map[string]*Usage)
+	pendingUsageMu.Unlock()
+	if db == nil {
+		return
+	}
+
+	now := time.Now()
+	oldest := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, now.Location()).Format(statsDayLayout)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(statsBucket)
+		if err != nil {
+			return err
+		}
+		for day, u := range usage {
+			var stored Usage
+			if data := b.Get([]byte(day)); data != nil {
+				if err := json.Unmarshal(data, &stored); err != nil {
+					return err
+				}
+			}
+			stored.add(u)
+			data, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(day), data); err != nil {
+				return err
+			}
+		}
+
+		var expired [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < oldest; k, _ = c.Next() {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to save usage statistics", "error", err)
+	}
+}
+
+// writeUsage flushes counted usage every statsFlushInterval
+func writeUsage() {
+	for range time.Tick(statsFlushInterval) {
+		flushUsage()
+	}
+}
@@ -0,0 +1,188 @@
+package metadata
+
+import (
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// themesBucket holds the themes share pages are branded with, by name, and
+// themeLogosBucket their logos, kept apart so listing themes doesn't read
+// every image
+var (
+	themesBucket     = []byte("themes")
+	themeLogosBucket = []byte("theme_logos")
+)
+
+// Theme brands the public pages of the shares that name it. Colors are CSS
+// hex colors; empty ones keep the page's own.
+type Theme struct {
+	Name            string `json:"name"`
+	Title           string `json:"title,omitempty"` // shown in place of the app's name
+	PrimaryColor    string `json:"primaryColor,omitempty"`
+	AccentColor     string `json:"accentColor,omitempty"`
+	BackgroundColor string `json:"backgroundColor,omitempty"`
+	TextColor       string `json:"textColor,omitempty"`
+	LogoType        string `json:"logoType,omitempty"` // media type of the logo, when there is one
+	UpdatedAt       int64  `json:"updatedAt"`
+}
+
+// Themes returns every theme, in name order
+func Themes() ([]Theme, error) {
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	themes := []Theme{}
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(themesBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var t Theme
+			if err := json.Unmarshal(v, &t); err != nil {
+				return nil
+			}
+			t.Name = string(k)
+			themes = append(themes, t)
+			return nil
+		})
+	})
+	return themes, err
+}
+
+// GetTheme returns the theme called name, reporting whether there is one
+func GetTheme(name string) (Theme, bool, error) {
+	if db == nil {
+		return Theme{}, false, ErrUnavailable
+	}
+	var t Theme
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(themesBucket)
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(name)); data != nil {
+			found = json.Unmarshal(data, &t) == nil
+		}
+		return nil
+	})
+	t.Name = name
+	return t, found, err
+}
+
+// SetTheme stores a theme, replacing the one of the same name but keeping
+// its logo
+func SetTheme(t Theme) error {
+	if db == nil {
+		return ErrUnavailable
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(themesBucket)
+		if err != nil {
+			return err
+		}
+		var old Theme
+		if data := b.Get([]byte(t.Name)); data != nil && json.Unmarshal(data, &old) == nil {
+			t.LogoType = old.LogoType
+		}
+		return putTheme(b, t)
+	})
+}
+
+// DeleteTheme removes a theme and its logo, reporting whether there was one
+func DeleteTheme(name string) (bool, error) {
+	if db == nil {
+		return false, ErrUnavailable
+	}
+	key := []byte(name)
+	found := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(themesBucket)
+		if b == nil || b.Get(key) == nil {
+			return nil
+		}
+		found = true
+		if logos := tx.Bucket(themeLogosBucket); logos != nil {
+			if err := logos.Delete(key); err != nil {
+				return err
+			}
+		}
+		return b.Delete(key)
+	})
+	return found, err
+}
+
+// SetThemeLogo stores the logo of a theme, or removes it when data is nil,
+// reporting whether the theme exists
+func SetThemeLogo(name, mediaType string, data []byte, updatedAt int64) (bool, error) {
+	if db == nil {
+		return false, ErrUnavailable
+	}
+	key := []byte(name)
+	found := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(themesBucket)
+		if b == nil {
+			return nil
+		}
+		var t Theme
+		if raw := b.Get(key); raw == nil || json.Unmarshal(raw, &t) != nil {
+			return nil
+		}
+		found = true
+		logos, err := tx.CreateBucketIfNotExists(themeLogosBucket)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			err = logos.Delete(key)
+			mediaType = ""
+		} else {
+			err = logos.Put(key, data)
+		}
+		if err != nil {
+			return err
+		}
+		t.Name = name
+		t.LogoType = mediaType
+		t.UpdatedAt = updatedAt
+		return putTheme(b, t)
+	})
+	return found, err
+}
+
+// ThemeLogo returns the logo of a theme with the theme, or nil when it has
+// none
+func ThemeLogo(name string) ([]byte, Theme, error) {
+	if db == nil {
+		return nil, Theme{}, ErrUnavailable
+	}
+	var logo []byte
+	var t Theme
+	err := db.View(func(tx *bolt.Tx) error {
+		b, logos := tx.Bucket(themesBucket), tx.Bucket(themeLogosBucket)
+		if b == nil || logos == nil {
+			return nil
+		}
+		raw := b.Get([]byte(name))
+		if raw == nil || json.Unmarshal(raw, &t) != nil {
+			return nil
+		}
+		if data := logos.Get([]byte(name)); data != nil {
+			logo = append([]byte(nil), data...) // only valid within the transaction
+		}
+		return nil
+	})
+	t.Name = name
+	return logo, t, err
+}
+
+func putTheme(b *bolt.Bucket, t Theme) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(t.Name), data)
+}
@@ -0,0 +1,82 @@
+package metadata
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// uploadHintsBucket is keyed by user, as told apart by the API
+var uploadHintsBucket = []byte("upload_hints")
+
+// uploadRateWeight is how much a new measurement counts against what was
+// known, smoothing out single slow or fast chunks
+const uploadRateWeight = 0.3
+
+// uploadHintMaxAge is how long a measurement stays useful; networks change
+// and a laptop's last café says little about its office
+const uploadHintMaxAge = 30 * 24 * time.Hour
+
+// UploadHint is how fast a user's uploads have been arriving over one
+// connection
+type UploadHint struct {
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	Samples        int     `json:"samples"`
+	UpdatedAt      int64   `json:"updatedAt"`
+}
+
+// GetUploadHint returns what is known of user's upload speed, reporting
+// false when nothing recent is
+func GetUploadHint(user string) (UploadHint, bool, error) {
+	if db == nil {
+		return UploadHint{}, false, ErrUnavailable
+	}
+	var hint UploadHint
+	found := false
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(uploadHintsBucket)
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(user)); data != nil {
+			found = json.Unmarshal(data, &hint) == nil
+		}
+		return nil
+	})
+	if found && time.Since(time.UnixMilli(hint.UpdatedAt)) > uploadHintMaxAge {
+		found = false
+	}
+	return hint, found, err
+}
+
+// RecordUploadRate folds a measured upload speed into user's hint and
+// returns the result
+func RecordUploadRate(user string, bytesPerSecond float64) (UploadHint, error) {
+	if db == nil {
+		return UploadHint{}, ErrUnavailable
+	}
+	var hint UploadHint
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(uploadHintsBucket)
+		if err != nil {
+			return err
+		}
+		if data := b.Get([]byte(user)); data != nil {
+			_ = json.Unmarshal(data, &hint)
+		}
+		if hint.Samples == 0 || time.Since(time.UnixMilli(hint.UpdatedAt)) > uploadHintMaxAge {
+			hint = UploadHint{BytesPerSecond: bytesPerSecond}
+		} else {
+			hint.BytesPerSecond += uploadRateWeight * (bytesPerSecond - hint.BytesPerSecond)
+		}
+		hint.Samples++
+		hint.UpdatedAt = time.Now().UnixMilli()
+		data, err := json.Marshal(hint)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(user), data)
+	})
+	return hint, err
+}
@@ -0,0 +1,303 @@
+package metadata
+
+import (
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var usersBucket = []byte("users")
+
+// How many directories are remembered per user; the least visited go first
+const maxVisitedDirs = 500
+
+// visitFlushInterval is how often counted visits are written out, sparing
+// listings a database write each
+const visitFlushInterval = time.Minute
+
+// Visit is how often and when a user last listed a directory
+type Visit struct {
+	Path      string `json:"path"`
+	Count     int    `json:"count"`
+	LastVisit int64  `json:"lastVisit"`
+}
+
+// profile is what is kept per user
+type profile struct {
+	Visits map[string]*Visit `json:"visits,omitempty"`
+	Pins   []string          `json:"pins,omitempty"`
+}
+
+var (
+	pending   = make(map[string]map[string]*Visit) // visits not yet written, by user and path
+	pendingMu sync.Mutex
+)
+
+// RecordVisit counts a listing of dir by user. Visits are written out in the
+// background, so the last minute of them is lost if the process dies.
+func RecordVisit(user, dir string) {
+	if db == nil {
+		return
+	}
+	dir = Clean(dir)
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	visits := pending[user]
+	if visits == nil {
+		visits = make(map[string]*Visit)
+		pending[user] = visits
+	}
+	v := visits[dir]
+	if v == nil {
+		v = &Visit{Path: dir}
+		visits[dir] = v
+	}
+	v.Count++
+	v.LastVisit = time.Now().UnixMilli()
+}
+
+// Frequent returns the directories user visits, most visited first
+func Frequent(user string) ([]Visit, error) {
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	p, err := loadProfile(user)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingMu.Lock()
+	addVisits(&p, pending[user])
+	pendingMu.Unlock()
+
+	visits := make([]Visit, 0, len(p.Visits))
+	for _, v := range p.Visits {
+		visits = append(visits, *v)
+	}
+	sortVisits(visits)
+	return visits, nil
+}
+
+// Pins returns the directories user pinned, in their order
+func Pins(user string) ([]string, error) {
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	p, err := loadProfile(user)
+	return p.Pins, err
+}
+
+// UpdatePins changes the directories user pinned and returns the result
+func UpdatePins(user string, change func(pins []string) []string) ([]string, error) {
+	if db == nil {
+		return nil, ErrUnavailable
+	}
+	var pins []string
+	err := updateProfile(user, func(p *profile) {
+		p.Pins = change(p.Pins)
+		pins = p.Pins
+	})
+	return pins, err
+}
+
+func loadProfile(user string) (profile, error) {
+	var p profile
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		if b == nil {
+			return nil
+		}
+		if data := b.Get([]byte(user)); data != nil {
+			return json.Unmarshal(data, &p)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func updateProfile(user string, change func(*profile)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		return putProfile(b, []byte(user), b.Get([]byte(user)), change)
+	})
+}
+
+// putProfile stores what change makes of the profile in data under key
+func putProfile(b *bolt.Bucket, key, data []byte, change func(*profile)) error {
+	var p profile
+	if data != nil {
+		if err := json.Unmarshal(data, &p); err != nil {
+			return err
+		}
+	}
+	change(&p)
+	if len(p.Visits) == 0 && len(p.Pins) == 0 {
+		return b.Delete(key)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
+
+// addVisits adds counted visits to a profile, forgetting the least visited
+// directories beyond maxVisitedDirs
+func addVisits(p *profile, visits map[string]*Visit) {
+	if len(visits) == 0 {
+		return
+	}
+	if p.Visits == nil {
+		p.Visits = make(map[string]*Visit)
+	}
+	for dir, v := range visits {
+		if known := p.Visits[dir]; known != nil {
+			p.Visits[dir] = &Visit{Path: dir, Count: known.Count + v.Count, LastVisit: max(known.LastVisit, v.LastVisit)}
+		} else {
+			p.Visits[dir] = &Visit{Path: dir, Count: v.Count, LastVisit: v.LastVisit}
+		}
+	}
+	if len(p.Visits) <= maxVisitedDirs {
+		return
+	}
+	all := make([]Visit, 0, len(p.Visits))
+	for _, v := range p.Visits {
+		all = append(all, *v)
+	}
+	sortVisits(all)
+	for _, v := range all[maxVisitedDirs:] {
+		delete(p.Visits, v.Path)
+	}
+}
+
+// sortVisits puts the most visited directories first, the most recently
+// visited first among equals
+func sortVisits(visits []Visit) {
+	sort.Slice(visits, func(i, j int) bool {
+		if visits[i].Count != visits[j].Count {
+			return visits[i].Count > visits[j].Count
+		}
+		return visits[i].LastVisit > visits[j].LastVisit
+	})
+}
+
+// flushVisits writes out the visits counted since the last flush
+func flushVisits() {
+	pendingMu.Lock()
+	visits := pending
+	pending = make(map[string]map[string]*Visit)
+	pendingMu.Unlock()
+	if len(visits) == 0 || db == nil {
+		return
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(usersBucket)
+		if err != nil {
+			return err
+		}
+		for user, userVisits := range visits {
+			err := putProfile(b, []byte(user), b.Get([]byte(user)), func(p *profile) {
+				addVisits(p, userVisits)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to save directory visits", "error", err)
+	}
+}
+
+// writeVisits flushes counted visits every visitFlushInterval
+func writeVisits() {
+	for range time.Tick(visitFlushInterval) {
+		flushVisits()
+	}
+}
+
+// moveUserPaths carries visits and pins on or below src over to dst
+func moveUserPaths(tx *bolt.Tx, src, dst string) error {
+	return rewriteUserPaths(tx, src, func(p string) string {
+		return dst + strings.TrimPrefix(p, src)
+	})
+}
+
+// deleteUserPaths forgets visits and pins on or below dir
+func deleteUserPaths(tx *bolt.Tx, dir string) error {
+	return rewriteUserPaths(tx, dir, func(string) string { return "" })
+}
+
+// rewriteUserPaths replaces the visited and pinned paths on or below dir in
+// every profile with what rewrite makes of them, dropping them for ""
+func rewriteUserPaths(tx *bolt.Tx, dir string, rewrite func(string) string) error {
+	b := tx.Bucket(usersBucket)
+	if b == nil {
+		return nil
+	}
+	var affected [][]byte
+	_ = b.ForEach(func(k, v []byte) error {
+		var p profile
+		if json.Unmarshal(v, &p) == nil && p.mentions(dir) {
+			affected = append(affected, append([]byte(nil), k...))
+		}
+		return nil
+	})
+
+	for _, user := range affected {
+		err := putProfile(b, user, b.Get(user), func(p *profile) {
+			moved := make(map[string]*Visit)
+			for old, v := range p.Visits {
+				if within(dir, old) {
+					delete(p.Visits, old)
+					if to := rewrite(old); to != "" {
+						moved[to] = v
+					}
+				}
+			}
+			addVisits(p, moved)
+
+			var pins []string
+			for _, pin := range p.Pins {
+				if within(dir, pin) {
+					pin = rewrite(pin)
+				}
+				if pin != "" && !slices.Contains(pins, pin) {
+					pins = append(pins, pin)
+				}
+			}
+			p.Pins = pins
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mentions reports whether the profile has visits or pins on or below dir
+func (p profile) mentions(dir string) bool {
+	for visited := range p.Visits {
+		if within(dir, visited) {
+			return true
+		}
+	}
+	for _, pin := range p.Pins {
+		if within(dir, pin) {
+			return true
+		}
+	}
+	return false
+}
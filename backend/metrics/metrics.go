@@ -0,0 +1,63 @@
+// Package metrics defines the Prometheus metrics the server exports at
+// /metrics. They are registered with the default registry, alongside the Go
+// runtime and process collectors.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Requests is the number of HTTP requests by method, route and status
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextbrowse_http_requests_total",
+		Help: "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// RequestDuration is the time taken to handle HTTP requests
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nextbrowse_http_request_duration_seconds",
+		Help:    "Time taken to handle HTTP requests, by method and route.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"method", "route"})
+
+	// BytesUploaded is the number of request body bytes read per route
+	BytesUploaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextbrowse_uploaded_bytes_total",
+		Help: "Request body bytes received, by route.",
+	}, []string{"route"})
+
+	// BytesDownloaded is the number of response body bytes written per route
+	BytesDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextbrowse_downloaded_bytes_total",
+		Help: "Response body bytes sent, by route.",
+	}, []string{"route"})
+
+	// JobDuration is the run time of finished background jobs
+	JobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "nextbrowse_job_duration_seconds",
+		Help:    "Run time of finished jobs (copies, moves, transfers, syncs, backups), by type and status.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"type", "status"})
+
+	// RateLimitRejections is the number of requests refused by the rate
+	// limiter, by the path of the tier that refused them
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "nextbrowse_rate_limit_rejections_total",
+		Help: "Requests rejected with 429 by the rate limiter, by tier path.",
+	}, []string{"tier"})
+
+	// StreamLimitRejections is the number of downloads refused because the
+	// client already had DOWNLOAD_STREAMS_PER_CLIENT in flight
+	StreamLimitRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "nextbrowse_stream_limit_rejections_total",
+		Help: "Downloads rejected with 429 for too many concurrent streams from one client.",
+	})
+
+	// ActiveUploads is the number of unfinished TUS uploads
+	ActiveUploads = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "nextbrowse_active_uploads",
+		Help: "Resumable uploads created but not yet completed or cancelled.",
+	})
+)
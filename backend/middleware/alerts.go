@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/alert"
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/problem"
+)
+
+// Recovery answers 500 to a request whose handler panicked, after gin has
+// logged the stack, and sends a panic alert
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, err any) {
+		alert.Send(alert.Alert{
+			Kind:    alert.KindPanic,
+			Key:     c.FullPath(),
+			Title:   "Handler panicked",
+			Message: fmt.Sprint(err),
+			Details: map[string]string{
+				"request":    c.Request.Method + " " + c.Request.URL.Path,
+				"request_id": RequestID(c),
+			},
+		})
+		problem.Abort(c, http.StatusInternalServerError, "Internal server error")
+	})
+}
+
+// ServerErrorAlerts sends an alert when ALERT_5XX_THRESHOLD requests within
+// ALERT_5XX_WINDOW end in a server error
+func ServerErrorAlerts() gin.HandlerFunc {
+	var (
+		mu     sync.Mutex
+		recent []time.Time // times of the latest server errors, oldest first
+	)
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusInternalServerError || config.AlertServerErrors <= 0 {
+			return
+		}
+
+		now := time.Now()
+		mu.Lock()
+		recent = append(recent, now)
+		if len(recent) > config.AlertServerErrors {
+			recent = recent[len(recent)-config.AlertServerErrors:]
+		}
+		burst := len(recent) == config.AlertServerErrors && now.Sub(recent[0]) <= config.AlertServerErrorWindow
+		mu.Unlock()
+
+		if burst {
+			alert.Send(alert.Alert{
+				Kind:  alert.KindServerErrors,
+				Title: "Burst of server errors",
+				Message: fmt.Sprintf("%d requests failed with a server error within %s",
+					config.AlertServerErrors, config.AlertServerErrorWindow),
+				Details: map[string]string{
+					"last_request": c.Request.Method + " " + c.Request.URL.Path,
+					"last_status":  strconv.Itoa(status),
+					"request_id":   RequestID(c),
+				},
+			})
+		}
+	}
+}
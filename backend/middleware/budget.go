@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/problem"
+)
+
+// OperationBudget gives the body of the requests it guards at most budget to
+// arrive, however steadily it moves, so that a client trickling an upload
+// can't hold a connection for days. setting names the budget's variable in
+// errors. It relies on Deadlines, and on StreamDeadlines coming first; a
+// budget of 0 or less sets none.
+func OperationBudget(budget time.Duration, setting string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := c.Get(deadlinesKey); ok && budget > 0 && hasBody(c.Request) {
+			d := value.(*deadlines)
+			d.end, d.budget, d.setting = d.start.Add(budget), budget, setting
+			if d.idle > 0 {
+				_ = d.rc.SetReadDeadline(d.capped(time.Now().Add(d.idle)))
+			} else {
+				_ = d.rc.SetReadDeadline(d.end)
+			}
+		}
+		c.Next()
+	}
+}
+
+// RespondTimeout answers a request whose body stopped arriving in time, and
+// reports whether err was such a timeout. The answer says whose slowness it
+// was: 408 when the handler spent most of the time waiting for the client,
+// and 503 when it spent most of it at work, such as writing to a slow disk,
+// so that the client didn't read the body sooner. Its timeout member is
+// "client" or "server" accordingly.
+func RespondTimeout(c *gin.Context, err error) bool {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	value, ok := c.Get(deadlinesKey)
+	if !ok {
+		return false
+	}
+	d := value.(*deadlines)
+	elapsed := time.Since(d.start).Round(time.Second)
+	waited := d.reading.Round(time.Second)
+
+	if working := elapsed - waited; working > waited {
+		problem.Respond(c, http.StatusServiceUnavailable,
+			fmt.Sprintf("The server was too slow: of %s, it spent %s processing the body and %s waiting for it", elapsed, working, waited),
+			gin.H{"timeout": "server"})
+		return true
+	}
+	var detail string
+	switch {
+	case !d.end.IsZero() && !time.Now().Before(d.end):
+		detail = fmt.Sprintf("The client sent the body too slowly: it took longer than the %s of %s", d.budget, d.setting)
+	case d.idle > 0:
+		detail = fmt.Sprintf("The client sent nothing for %s (STREAM_IDLE_TIMEOUT)", d.idle)
+	default:
+		detail = fmt.Sprintf("The client didn't send the body within %s (REQUEST_READ_TIMEOUT)", d.read)
+	}
+	problem.Respond(c, http.StatusRequestTimeout, detail, gin.H{"timeout": "client"})
+	return true
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+)
+
+// JobHeader names the job a response started, such as extracting an uploaded
+// ZIP, for clients that can't read a JSON body
+const JobHeader = "X-NextBrowse-Job"
+
+// Headers cross-origin clients may send and read, including those of
+// conditional writes, share passwords and TUS uploads
+var (
+	corsAllowHeaders = []string{
+		"Origin", "Content-Type", "Accept", "Authorization", RequestIDHeader, UserHeader,
+		"If-Match", "If-None-Match", "X-Share-Password",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata",
+	}
+	corsExposeHeaders = []string{
+		RequestIDHeader, JobHeader, "ETag", "Location",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset",
+	}
+)
+
+// CORS allows cross-origin requests from the origins in ALLOWED_ORIGINS.
+// Entries are exact origins ("https://files.example.com"), wildcard
+// subdomains ("https://*.example.com") or "*" for any origin. Requests whose
+// Origin names the host they were sent to are always allowed, since that's
+// how browsers reach the app through nginx, including by LAN IP.
+func CORS() gin.HandlerFunc {
+	if config.CORSAllowCredentials && allowsAnyOrigin(config.AllowedOrigins) {
+		slog.Warn("ALLOWED_ORIGINS contains \"*\" while credentials are allowed: any website can make authenticated requests to this server. " +
+			"List the allowed origins or set CORS_ALLOW_CREDENTIALS=false.")
+	}
+
+	return cors.New(cors.Config{
+		AllowMethods:     []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     corsAllowHeaders,
+		ExposeHeaders:    corsExposeHeaders,
+		AllowCredentials: config.CORSAllowCredentials,
+		// The library echoes the allowed Origin rather than "*", as
+		// credentialed requests require
+		AllowOriginWithContextFunc: func(c *gin.Context, origin string) bool {
+			return sameHost(c.Request.Host, origin) || OriginAllowed(config.AllowedOrigins, origin)
+		},
+	})
+}
+
+func allowsAnyOrigin(patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// sameHost reports whether origin names the host a request was sent to. Ports
+// are ignored because proxies commonly forward the host without one.
+func sameHost(requestHost, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(requestHost)
+	if err != nil {
+		host = requestHost
+	}
+	return strings.EqualFold(u.Hostname(), strings.Trim(host, "[]"))
+}
+
+// OriginAllowed reports whether origin matches one of the patterns
+func OriginAllowed(patterns []string, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		p, err := url.Parse(pattern)
+		if err != nil || !strings.EqualFold(p.Scheme, u.Scheme) || p.Port() != u.Port() {
+			continue
+		}
+
+		patternHost, originHost := strings.ToLower(p.Hostname()), strings.ToLower(u.Hostname())
+		if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+			// Any depth of subdomain, but not the bare domain
+			if strings.HasSuffix(originHost, "."+suffix) {
+				return true
+			}
+		} else if patternHost == originHost {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deadlinesKey holds a request's *deadlines in the gin context
+const deadlinesKey = "deadlines"
+
+// extendEvery is how far a rolling deadline may fall behind the progress
+// that moves it, so that a stream doesn't reset its timer on every write
+const extendEvery = time.Second
+
+// deadlines are the connection deadlines of one request
+type deadlines struct {
+	rc    *http.ResponseController
+	write time.Duration
+
+	// idle is set on streaming routes, whose deadlines move forward by
+	// this much whenever the body or response makes progress
+	idle time.Duration
+
+	writing    bool
+	readMoved  time.Time
+	writeMoved time.Time
+
+	// read is the deadline the body had to arrive by when not streaming.
+	// start is when the request began and reading how long of that its
+	// handler spent waiting for the body, to tell whose slowness a timeout
+	// was.
+	read    time.Duration
+	start   time.Time
+	reading time.Duration
+
+	// end is when an OperationBudget runs out, which no deadline moves
+	// past, and setting what set it
+	end     time.Time
+	budget  time.Duration
+	setting string
+}
+
+// capped returns t, or the end of the request's budget if that is sooner
+func (d *deadlines) capped(t time.Time) time.Time {
+	if !d.end.IsZero() && (t.IsZero() || t.After(d.end)) {
+		return d.end
+	}
+	return t
+}
+
+// Deadlines gives a request's body read long from the start of the request
+// to arrive, and its response write long from its first byte to be sent,
+// so that clients trickling either can't hold connections open. Handlers
+// may take as long as they need in between. Streaming routes add
+// StreamDeadlines, which replaces both with deadlines that follow progress.
+// A duration of 0 or less leaves that side without a deadline.
+func Deadlines(read, write time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := &deadlines{rc: http.NewResponseController(c.Writer), write: write, read: read, start: time.Now()}
+		// A keep-alive connection still has the previous request's deadline
+		_ = d.rc.SetWriteDeadline(time.Time{})
+
+		if hasBody(c.Request) {
+			if read > 0 {
+				_ = d.rc.SetReadDeadline(time.Now().Add(read))
+			}
+			c.Request.Body = &deadlineBody{ReadCloser: c.Request.Body, d: d}
+		}
+		c.Writer = &deadlineWriter{ResponseWriter: c.Writer, d: d}
+		c.Set(deadlinesKey, d)
+		c.Next()
+	}
+}
+
+// StreamDeadlines lets the upload or download of the routes it guards take
+// as long as it needs, as long as it never stalls for more than idle. It
+// relies on Deadlines running first; an idle of 0 or less lifts both
+// deadlines.
+func StreamDeadlines(idle time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, ok := c.Get(deadlinesKey); ok {
+			d := value.(*deadlines)
+			if idle > 0 {
+				d.idle = idle
+				if hasBody(c.Request) {
+					d.readMoved = time.Now()
+					_ = d.rc.SetReadDeadline(d.capped(d.readMoved.Add(idle)))
+				}
+			} else {
+				d.write = 0
+				_ = d.rc.SetReadDeadline(d.capped(time.Time{}))
+			}
+		}
+		c.Next()
+	}
+}
+
+// hasBody reports whether r has a body to read
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+}
+
+// deadlineBody moves the read deadline of a streaming request as its body
+// arrives, and lifts it once the body is read so that the server's watch
+// for the client going away doesn't time out while the handler works
+type deadlineBody struct {
+	io.ReadCloser
+	d *deadlines
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	started := time.Now()
+	n, err := b.ReadCloser.Read(p)
+	d := b.d
+	d.reading += time.Since(started)
+	switch {
+	case err == io.EOF:
+		_ = d.rc.SetReadDeadline(time.Time{})
+	case n > 0 && d.idle > 0:
+		if now := time.Now(); now.Sub(d.readMoved) >= extendEvery {
+			d.readMoved = now
+			_ = d.rc.SetReadDeadline(d.capped(now.Add(d.idle)))
+		}
+	}
+	return n, err
+}
+
+// deadlineWriter sets the write deadline at the first write of a response,
+// and on streaming routes moves it forward as the response is written
+type deadlineWriter struct {
+	gin.ResponseWriter
+	d *deadlines
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.extend()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) WriteString(s string) (int, error) {
+	w.extend()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *deadlineWriter) extend() {
+	d := w.d
+	switch {
+	case d.idle > 0:
+		if now := time.Now(); now.Sub(d.writeMoved) >= extendEvery {
+			d.writeMoved = now
+			_ = d.rc.SetWriteDeadline(now.Add(d.idle))
+		}
+	case !d.writing && d.write > 0:
+		d.writing = true
+		_ = d.rc.SetWriteDeadline(time.Now().Add(d.write))
+	}
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+)
+
+// sharePattern marks header rule patterns for files served through shares
+const sharePattern = "share:"
+
+// headerRule is a HEADER_RULES entry with its pattern compiled
+type headerRule struct {
+	pattern *regexp.Regexp
+	headers [][2]string
+}
+
+// HeaderRules adds the headers of the HEADER_RULES entries whose pattern
+// matches the file a GET or HEAD request serves: the file named by its path
+// query or *path parameter, or for the share routes, share:/<share ID>
+// followed by the path within the share. The headers are set as the response
+// starts, so they replace those the handler set.
+func HeaderRules(rules []config.HeaderRule) gin.HandlerFunc {
+	compiled := make([]headerRule, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := compilePathPattern(rule.Pattern)
+		if err != nil {
+			slog.Warn("Ignoring invalid header rule", "pattern", rule.Pattern, "error", err)
+			continue
+		}
+		compiled = append(compiled, headerRule{pattern: pattern, headers: rule.Headers})
+	}
+
+	return func(c *gin.Context) {
+		if len(compiled) == 0 || (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+			c.Next()
+			return
+		}
+		name, ok := servedPath(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		var headers [][2]string
+		for _, rule := range compiled {
+			if rule.pattern.MatchString(name) {
+				headers = append(headers, rule.headers...)
+			}
+		}
+		if len(headers) > 0 {
+			c.Writer = &headerRuleWriter{ResponseWriter: c.Writer, headers: headers}
+		}
+		c.Next()
+	}
+}
+
+// servedPath works out the path header rules match a request against
+func servedPath(c *gin.Context) (string, bool) {
+	if shareID := c.Param("shareId"); shareID != "" {
+		return sharePattern + path.Join("/", shareID, c.Param("path")), true
+	}
+	name := c.Query("path")
+	if name == "" {
+		name = c.Param("path")
+	}
+	if name == "" {
+		return "", false
+	}
+	return path.Join("/", name), true
+}
+
+// compilePathPattern turns a glob into a regular expression matching whole
+// paths: ** stands for anything, * and ? for any characters of one name
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	prefix := ""
+	if rest, ok := strings.CutPrefix(pattern, sharePattern); ok {
+		prefix, pattern = sharePattern, rest
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") {
+		pattern = "/" + pattern
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^" + regexp.QuoteMeta(prefix))
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "/**"):
+			// Also matches the directory itself
+			expr.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// headerRuleWriter sets the headers of matching rules just before the
+// response's headers are sent, when it serves a file. Errors and JSON API
+// responses, such as listings, are left alone, so that a CDN told to keep
+// files for a year doesn't keep a 404 or a listing as long.
+type headerRuleWriter struct {
+	gin.ResponseWriter
+	headers [][2]string
+	applied bool
+}
+
+func (w *headerRuleWriter) apply(status int) {
+	if w.applied || w.Written() {
+		return
+	}
+	w.applied = true
+	mediaType := w.Header().Get("Content-Type")
+	if status >= http.StatusBadRequest || strings.HasPrefix(mediaType, "application/json") || strings.HasPrefix(mediaType, "application/problem+json") {
+		return
+	}
+	for _, header := range w.headers {
+		w.Header().Set(header[0], header[1])
+	}
+}
+
+// WriteHeader applies the rules at once when the handler has already said
+// what it serves, as http.ServeContent does; otherwise the first write does
+func (w *headerRuleWriter) WriteHeader(code int) {
+	if w.Header().Get("Content-Type") != "" {
+		w.apply(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerRuleWriter) WriteHeaderNow() {
+	w.apply(w.Status())
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *headerRuleWriter) Write(p []byte) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerRuleWriter) WriteString(s string) (int, error) {
+	w.apply(w.Status())
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (w *headerRuleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
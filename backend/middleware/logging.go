@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"nextbrowse-backend/config"
+)
+
+// RequestIDHeader carries the request ID in requests and responses
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key holding the request ID
+const requestIDKey = "requestID"
+
+// validRequestID limits which client-supplied IDs are echoed and logged
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID returns the ID assigned to the request by RequestLogger
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// RequestLogger assigns each request an ID, reusing a well-formed
+// X-Request-ID from a proxy, and writes one access log entry per request
+// once it completes, in ACCESS_LOG_FORMAT. Through the application log,
+// server errors are logged as errors, client errors as warnings and health
+// checks only at debug level.
+func RequestLogger() gin.HandlerFunc {
+	write := accessLogWriter()
+
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		c.Next()
+
+		write(c, accessEntry{
+			Time:       start,
+			RequestID:  id,
+			ClientIP:   c.ClientIP(),
+			Method:     c.Request.Method,
+			URI:        loggedURI(c.Request.URL),
+			Route:      c.FullPath(),
+			Protocol:   c.Request.Proto,
+			Status:     c.Writer.Status(),
+			Bytes:      max(c.Writer.Size(), 0),
+			DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			Referer:    c.Request.Referer(),
+			UserAgent:  c.Request.UserAgent(),
+			Error:      c.Errors.String(),
+		})
+	}
+}
+
+// loggedURI is the request URI with share passwords sent as parameters
+// blanked out
+func loggedURI(u *url.URL) string {
+	query := u.Query()
+	if !query.Has("password") {
+		return u.RequestURI()
+	}
+	query.Set("password", "REDACTED")
+	redacted := *u
+	redacted.RawQuery = query.Encode()
+	return redacted.RequestURI()
+}
+
+// accessEntry is one completed request. The JSON form is the json access
+// log format.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	ClientIP   string    `json:"client_ip"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Route      string    `json:"route,omitempty"`
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMs float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// accessLogWriter returns the function writing entries in the configured
+// format, to ACCESS_LOG_FILE (rotated by size) or stdout
+func accessLogWriter() func(c *gin.Context, entry accessEntry) {
+	if config.AccessLogFormat == config.AccessLogApp {
+		return logAccess
+	}
+
+	var out io.Writer = os.Stdout
+	if config.AccessLogFile != "" {
+		out = &lumberjack.Logger{
+			Filename:   config.AccessLogFile,
+			MaxSize:    config.AccessLogMaxSize,
+			MaxBackups: config.AccessLogMaxBackups,
+		}
+	}
+
+	// Entries are written whole, one at a time, so lines never interleave
+	var mu sync.Mutex
+	return func(c *gin.Context, entry accessEntry) {
+		var line []byte
+		if config.AccessLogFormat == config.AccessLogJSON {
+			line, _ = json.Marshal(entry)
+			line = append(line, '\n')
+		} else {
+			line = []byte(combinedLogLine(entry))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := out.Write(line); err != nil {
+			slog.Error("Failed to write access log", "error", err)
+		}
+	}
+}
+
+// logAccess writes an entry through the application log
+func logAccess(c *gin.Context, entry accessEntry) {
+	level := slog.LevelInfo
+	switch {
+	case entry.Status >= 500:
+		level = slog.LevelError
+	case entry.Status >= 400:
+		level = slog.LevelWarn
+	case entry.Route == "/health":
+		level = slog.LevelDebug
+	}
+
+	attrs := []slog.Attr{
+		slog.String("request_id", entry.RequestID),
+		slog.String("method", entry.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.String("route", entry.Route),
+		slog.Int("status", entry.Status),
+		slog.Int("bytes", entry.Bytes),
+		slog.Float64("duration_ms", entry.DurationMs),
+		slog.String("client_ip", entry.ClientIP),
+	}
+	if entry.Error != "" {
+		attrs = append(attrs, slog.String("error", entry.Error))
+	}
+	slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
+
+// combinedLogLine formats an entry in Apache's Combined Log Format:
+// host ident user [time] "request" status bytes "referer" "user-agent"
+func combinedLogLine(entry accessEntry) string {
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.Itoa(entry.Bytes)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		entry.ClientIP,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method, escapeLogField(entry.URI), entry.Protocol,
+		entry.Status, bytes,
+		escapeLogField(orDash(entry.Referer)), escapeLogField(orDash(entry.UserAgent)),
+	)
+}
+
+// escapeLogField escapes quotes, backslashes and control characters so a
+// client can't break out of a quoted field or forge log lines
+func escapeLogField(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 8)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+)
+
+// readOnlyMethods never change anything, including WebDAV's PROPFIND
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// readOnlyPosts are POST routes that only read, like building a ZIP download
+var readOnlyPosts = map[string]bool{
+	"/api/fs/download-multiple": true,
+}
+
+// ReadOnlyGuard answers 503 to every request that could change files while
+// maintenance mode is on. The admin API stays usable so the mode can be
+// turned off again.
+func ReadOnlyGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := models.GetMaintenance()
+		path := c.Request.URL.Path
+		if !state.Enabled || readOnlyMethods[c.Request.Method] || strings.HasPrefix(path, "/api/admin/") ||
+			(c.Request.Method == http.MethodPost && readOnlyPosts[path]) {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", "300")
+		problem.Abort(c, http.StatusServiceUnavailable, state.Message, gin.H{"maintenance": true})
+	}
+}
+
+// AdminAuth requires "Authorization: Bearer <ADMIN_TOKEN>". Without an
+// ADMIN_TOKEN the admin API is disabled.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if config.AdminToken == "" {
+			problem.Abort(c, http.StatusForbidden, "Admin API is disabled; set ADMIN_TOKEN to enable it")
+			return
+		}
+
+		if !IsAdmin(c) {
+			problem.Abort(c, http.StatusUnauthorized, "Invalid admin token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// IsAdmin reports whether a request carries the ADMIN_TOKEN, for routes open
+// to everyone that reveal more to admins
+func IsAdmin(c *gin.Context) bool {
+	if config.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
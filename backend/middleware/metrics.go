@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metrics"
+)
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Metrics records request counts, durations and body sizes per route.
+// Requests that match no route share the "unmatched" label so that scanners
+// can't blow up the number of series.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		body := &countingBody{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil {
+			c.Request.Body = body
+		}
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		metrics.Requests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.RequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		if body.n > 0 {
+			metrics.BytesUploaded.WithLabelValues(route).Add(float64(body.n))
+		}
+		if size := c.Writer.Size(); size > 0 {
+			metrics.BytesDownloaded.WithLabelValues(route).Add(float64(size))
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/metrics"
+	"nextbrowse-backend/models"
+	"nextbrowse-backend/problem"
+)
+
+// Limiters of clients idle for this long are dropped; they start over with a
+// full bucket
+const rateLimiterIdleTTL = 10 * time.Minute
+
+// rateLimiter takes a token from a client's bucket for a tier. It reports
+// whether the request may pass, the whole tokens left and, when it may not,
+// how long until a token is available.
+type rateLimiter interface {
+	take(key string, rule config.RateLimitRule) (ok bool, remaining int, wait time.Duration, err error)
+}
+
+// RateLimit limits each client IP to the requests per minute of the matching
+// RATE_LIMITS tier. Every tier has its own token bucket per client, holding a
+// minute's worth of requests so that short bursts pass. Rejected requests
+// get 429 with a Retry-After header. Buckets are kept in Redis when it is
+// configured so that all replicas share them.
+func RateLimit(rules []config.RateLimitRule) gin.HandlerFunc {
+	var limiter rateLimiter = newLocalLimiter()
+	if models.Redis != nil {
+		limiter = redisLimiter{client: models.Redis}
+	}
+
+	return func(c *gin.Context) {
+		index := matchRateLimit(rules, c.Request.Method, c.Request.URL.Path)
+		if index < 0 || rules[index].PerMinute == 0 {
+			c.Next()
+			return
+		}
+		rule := rules[index]
+
+		key := strconv.Itoa(index) + "|" + c.ClientIP()
+		ok, remaining, wait, err := limiter.take(key, rule)
+		if err != nil {
+			// An unreachable Redis shouldn't take the whole server down
+			slog.Warn("Rate limiter unavailable", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(rule.PerMinute))
+		if !ok {
+			metrics.RateLimitRejections.WithLabelValues(rule.Path).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			problem.Abort(c, http.StatusTooManyRequests, "Too many requests, please slow down")
+			return
+		}
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Next()
+	}
+}
+
+// localLimiter keeps token buckets in process
+type localLimiter struct {
+	limiters *ttlcache.Cache[string, *rate.Limiter]
+}
+
+func newLocalLimiter() localLimiter {
+	limiters := ttlcache.New[string, *rate.Limiter](
+		ttlcache.WithTTL[string, *rate.Limiter](rateLimiterIdleTTL),
+	)
+	go limiters.Start()
+	return localLimiter{limiters: limiters}
+}
+
+func (l localLimiter) take(key string, rule config.RateLimitRule) (bool, int, time.Duration, error) {
+	item, _ := l.limiters.GetOrSet(key, rate.NewLimiter(rate.Limit(float64(rule.PerMinute)/60), rule.PerMinute))
+	limiter := item.Value()
+
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, 0, delay, nil
+	}
+	return true, int(limiter.Tokens()), 0, nil
+}
+
+// redisTokenBucket refills and takes from a bucket stored as a hash, timed by
+// the Redis server's clock so replicas with skewed clocks agree. It returns
+// {allowed, tokens left, seconds to wait} with the numbers as strings, since
+// Lua numbers would be truncated to integers.
+var redisTokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = redis.call("TIME")
+now = tonumber(now[1]) + tonumber(now[2]) / 1000000
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = (1 - tokens) / rate
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+return {allowed, tostring(tokens), tostring(wait)}
+`)
+
+// redisLimiter keeps token buckets in Redis, shared by all replicas
+type redisLimiter struct {
+	client *redis.Client
+}
+
+func (l redisLimiter) take(key string, rule config.RateLimitRule) (bool, int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	args := []any{float64(rule.PerMinute) / 60, rule.PerMinute, int(rateLimiterIdleTTL.Seconds())}
+	res, err := redisTokenBucket.Run(ctx, l.client, []string{models.RedisKeyPrefix + "ratelimit:" + key}, args...).Slice()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if len(res) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit reply %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(res[1]), 64)
+	wait, _ := strconv.ParseFloat(fmt.Sprint(res[2]), 64)
+	return allowed == 1, int(tokens), time.Duration(wait * float64(time.Second)), nil
+}
+
+// matchRateLimit returns the index of the rule that applies to a request, or
+// -1 if none does
+func matchRateLimit(rules []config.RateLimitRule, method, path string) int {
+	best, bestLen, bestSpecific := -1, -1, false
+	for i, rule := range rules {
+		if !pathWithin(rule.Path, path) {
+			continue
+		}
+		specific := len(rule.Methods) > 0
+		if specific && !hasMethod(rule.Methods, method) {
+			continue
+		}
+		// Among equals, later rules (RATE_LIMITS overrides) win
+		if len(rule.Path) > bestLen || (len(rule.Path) == bestLen && (specific || !bestSpecific)) {
+			best, bestLen, bestSpecific = i, len(rule.Path), specific
+		}
+	}
+	return best
+}
+
+// pathWithin reports whether path equals prefix or lies beneath it
+func pathWithin(prefix, path string) bool {
+	return prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metrics"
+	"nextbrowse-backend/problem"
+)
+
+// downloadKey marks the requests StreamLimit guards as downloads, for the
+// usage statistics
+const downloadKey = "download"
+
+// StreamLimit caps how many of the routes it guards one client IP may have
+// in flight at once, answering 429 beyond that, so that a download manager
+// opening dozens of connections can't take all the disk bandwidth. One
+// handler shares its count across the routes it is used on. Counts are kept
+// per replica. A limit of 0 or less lets everything through.
+func StreamLimit(perClient int) gin.HandlerFunc {
+	if perClient <= 0 {
+		return func(c *gin.Context) {
+			c.Set(downloadKey, true)
+			c.Next()
+		}
+	}
+	var mu sync.Mutex
+	active := make(map[string]int)
+
+	return func(c *gin.Context) {
+		c.Set(downloadKey, true)
+		client := c.ClientIP()
+		mu.Lock()
+		if active[client] >= perClient {
+			mu.Unlock()
+			metrics.StreamLimitRejections.Inc()
+			c.Header("Retry-After", "5")
+			problem.Abort(c, http.StatusTooManyRequests, "Too many downloads at once, at most "+strconv.Itoa(perClient)+" per client")
+			return
+		}
+		active[client]++
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			if active[client]--; active[client] == 0 {
+				delete(active, client)
+			}
+			mu.Unlock()
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"nextbrowse-backend/metadata"
+)
+
+// UsageStats counts every request in the daily usage behind the admin
+// statistics: who made it, how it ended and the bytes it moved. A download
+// whose response starts at the file's first byte counts as the file served
+// once, so that a video played in ranges isn't counted for every range.
+func UsageStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := &countingBody{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil {
+			c.Request.Body = body
+		}
+
+		c.Next()
+
+		r := metadata.Request{
+			User:     User(c),
+			Status:   c.Writer.Status(),
+			BytesIn:  body.n,
+			BytesOut: max(int64(c.Writer.Size()), 0),
+		}
+		if c.GetBool(downloadKey) && c.Request.Method == http.MethodGet && servedWhole(c) {
+			if name, ok := servedPath(c); ok {
+				r.Served = name
+			} else {
+				r.Served = c.Request.URL.Path
+			}
+		}
+		metadata.RecordRequest(r)
+	}
+}
+
+// servedWhole reports whether a response serves a file from its start
+func servedWhole(c *gin.Context) bool {
+	switch c.Writer.Status() {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(c.Writer.Header().Get("Content-Range"), "bytes 0-")
+	}
+	return false
+}
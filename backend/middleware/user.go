@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHeader names who is making a request. NextBrowse has no accounts, so
+// this is whatever the client picks (the web app sends a random ID it keeps
+// in the browser); it only separates conveniences such as pinned
+// directories, never access.
+const UserHeader = "X-NextBrowse-User"
+
+// maxUserLength is the longest UserHeader value taken
+const maxUserLength = 128
+
+// User returns who is making a request: the UserHeader value, or the
+// client's address when it is missing or unusable
+func User(c *gin.Context) string {
+	user := strings.TrimSpace(c.GetHeader(UserHeader))
+	if user == "" || len(user) > maxUserLength || strings.IndexFunc(user, unicode.IsControl) >= 0 {
+		return c.ClientIP()
+	}
+	return user
+}
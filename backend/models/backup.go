@@ -0,0 +1,71 @@
+package models
+
+import (
+	"errors"
+	"sync"
+)
+
+// How many runs are remembered per backup
+const maxBackupRuns = 50
+
+// Backup run triggers
+const (
+	BackupTriggerSchedule = "schedule"
+	BackupTriggerManual   = "manual"
+)
+
+// ErrBackupRunning is returned when a backup is started while its previous
+// run is still in progress
+var ErrBackupRunning = errors.New("backup is already running")
+
+// BackupRun is one run of a scheduled backup. Its job carries the progress
+// and outcome, and is kept here after it drops out of the job list.
+type BackupRun struct {
+	Backup  string
+	Trigger string
+	Job     *Job
+}
+
+// In-memory history of backup runs, oldest first per backup
+var (
+	backupRuns      = make(map[string][]*BackupRun)
+	backupRunsMutex = sync.RWMutex{}
+)
+
+// StartBackupRun creates the job for a new run of a backup, unless the
+// previous run is still going
+func StartBackupRun(backup, trigger, source, destination string) (*BackupRun, error) {
+	backupRunsMutex.Lock()
+	defer backupRunsMutex.Unlock()
+
+	runs := backupRuns[backup]
+	if len(runs) > 0 && runs[len(runs)-1].Job.Info().Status == JobRunning {
+		return nil, ErrBackupRunning
+	}
+
+	job, err := NewJob("backup", source, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &BackupRun{Backup: backup, Trigger: trigger, Job: job}
+	runs = append(runs, run)
+	if len(runs) > maxBackupRuns {
+		runs = runs[len(runs)-maxBackupRuns:]
+	}
+	backupRuns[backup] = runs
+	return run, nil
+}
+
+// GetBackupRuns returns the remembered runs of a backup, newest first
+func GetBackupRuns(backup string) []*BackupRun {
+	backupRunsMutex.RLock()
+	defer backupRunsMutex.RUnlock()
+
+	runs := backupRuns[backup]
+	result := make([]*BackupRun, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		result = append(result, runs[i])
+	}
+	return result
+}
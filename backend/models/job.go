@@ -0,0 +1,334 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"nextbrowse-backend/events"
+	"nextbrowse-backend/metrics"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// How long finished jobs are kept around for status queries
+const jobRetention = time.Hour
+
+// Minimum time between progress events of a job
+const jobProgressInterval = time.Second
+
+// JobError records a failure on a single path that did not abort the job
+type JobError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// Job tracks a long-running operation such as a tree copy or move
+type Job struct {
+	ID          string
+	Type        string
+	Source      string
+	Destination string
+	CreatedAt   int64
+
+	mu          sync.Mutex
+	status      string
+	totalItems  int64
+	doneItems   int64
+	totalBytes  int64
+	doneBytes   int64
+	currentFile string
+	errors      []JobError
+	message     string
+	result      any
+	finishedAt  *int64
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	lastProgress time.Time // when the last progress event was published
+	rateBytes    int64     // bytes done when throughput was last measured
+	rateAt       time.Time // and when
+	throughput   float64   // bytes per second between the last two progress events
+}
+
+// JobInfo is a point-in-time, JSON-friendly view of a Job. BytesPerSecond is
+// the throughput since the previous progress event, while the job runs.
+type JobInfo struct {
+	ID             string     `json:"id"`
+	Type           string     `json:"type"`
+	Status         string     `json:"status"`
+	Source         string     `json:"source,omitempty"`
+	Destination    string     `json:"destination,omitempty"`
+	TotalItems     int64      `json:"totalItems"`
+	DoneItems      int64      `json:"doneItems"`
+	TotalBytes     int64      `json:"totalBytes"`
+	DoneBytes      int64      `json:"doneBytes"`
+	CurrentFile    string     `json:"currentFile,omitempty"`
+	BytesPerSecond float64    `json:"bytesPerSecond,omitempty"`
+	Errors         []JobError `json:"errors,omitempty"`
+	Message        string     `json:"message,omitempty"`
+	Result         any        `json:"result,omitempty"`
+	CreatedAt      int64      `json:"createdAt"`
+	FinishedAt     *int64     `json:"finishedAt,omitempty"`
+}
+
+// In-memory storage for jobs
+var (
+	jobs      = make(map[string]*Job)
+	jobsMutex = sync.RWMutex{}
+)
+
+// NewJob creates and registers a running job. The job's context is cancelled
+// when the job is cancelled or finishes.
+func NewJob(jobType, source, destination string) (*Job, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:          hex.EncodeToString(bytes),
+		Type:        jobType,
+		Source:      source,
+		Destination: destination,
+		CreatedAt:   time.Now().UnixMilli(),
+		status:      JobRunning,
+		rateAt:      time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	jobsMutex.Lock()
+	pruneJobsLocked()
+	jobs[job.ID] = job
+	jobsMutex.Unlock()
+
+	job.publish(events.JobStarted)
+	return job, nil
+}
+
+// JobTimeoutError stops a job that ran longer than its budget, set by the
+// variable Setting
+type JobTimeoutError struct {
+	Type    string
+	Budget  time.Duration
+	Setting string
+}
+
+func (e *JobTimeoutError) Error() string {
+	return fmt.Sprintf("The %s job was stopped after %s, the budget of %s; the server could not finish it in time", e.Type, e.Budget, e.Setting)
+}
+
+// Limit stops the job once it has run for budget, failing it with a
+// JobTimeoutError. It must be called before the job's work starts; a budget
+// of 0 or less sets no limit.
+func (j *Job) Limit(budget time.Duration, setting string) {
+	if budget <= 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ctx, cancel := context.WithTimeoutCause(j.ctx, budget, &JobTimeoutError{Type: j.Type, Budget: budget, Setting: setting})
+	parent := j.cancel
+	j.ctx, j.cancel = ctx, func() { cancel(); parent() }
+}
+
+// GetJob retrieves a job by ID
+func GetJob(id string) (*Job, bool) {
+	jobsMutex.RLock()
+	defer jobsMutex.RUnlock()
+
+	job, exists := jobs[id]
+	return job, exists
+}
+
+// GetAllJobs returns all known jobs, dropping finished ones past retention
+func GetAllJobs() []*Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	pruneJobsLocked()
+	all := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		all = append(all, job)
+	}
+	return all
+}
+
+// pruneJobsLocked removes finished jobs older than jobRetention
+func pruneJobsLocked() {
+	cutoff := time.Now().Add(-jobRetention).UnixMilli()
+	for id, job := range jobs {
+		job.mu.Lock()
+		expired := job.finishedAt != nil && *job.finishedAt < cutoff
+		job.mu.Unlock()
+		if expired {
+			delete(jobs, id)
+		}
+	}
+}
+
+// Context returns the job's context, cancelled when the job is cancelled
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+// Cancel requests cancellation of a running job
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// AddTotals adds pre-scanned work to the job's expected totals
+func (j *Job) AddTotals(items, bytes int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.totalItems += items
+	j.totalBytes += bytes
+}
+
+// SetCurrentFile records the path currently being processed
+func (j *Job) SetCurrentFile(path string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.currentFile = path
+}
+
+// AddBytes records progress within the current file
+func (j *Job) AddBytes(n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.doneBytes += n
+	j.progressLocked()
+}
+
+// ItemDone records that one more item has been processed
+func (j *Job) ItemDone() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.doneItems++
+	j.progressLocked()
+}
+
+// AddError records a per-path failure without stopping the job
+func (j *Job) AddError(path string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.errors = append(j.errors, JobError{Path: path, Error: err.Error()})
+}
+
+// Errors returns the per-path failures recorded so far
+func (j *Job) Errors() []JobError {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return append([]JobError(nil), j.errors...)
+}
+
+// SetResult attaches an operation-specific report (e.g. a sync's changes)
+func (j *Job) SetResult(result any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.result = result
+}
+
+// Finish marks the job as done with the given status and message
+func (j *Job) Finish(status, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Work stopped by its budget fails with that, rather than with the
+	// context error it was stopped by
+	var timeout *JobTimeoutError
+	if status != JobCompleted && errors.As(context.Cause(j.ctx), &timeout) {
+		status, message = JobFailed, timeout.Error()
+	}
+
+	now := time.Now().UnixMilli()
+	if j.finishedAt == nil {
+		metrics.JobDuration.WithLabelValues(j.Type, status).Observe(float64(now-j.CreatedAt) / 1000)
+	}
+	j.status = status
+	j.message = message
+	j.currentFile = ""
+	j.finishedAt = &now
+	j.cancel()
+	j.publishLocked(events.JobFinished)
+}
+
+// progressLocked publishes the job's progress, at most once a second
+func (j *Job) progressLocked() {
+	if time.Since(j.lastProgress) < jobProgressInterval {
+		return
+	}
+	j.lastProgress = time.Now()
+	if elapsed := j.lastProgress.Sub(j.rateAt).Seconds(); elapsed > 0 {
+		j.throughput = float64(j.doneBytes-j.rateBytes) / elapsed
+	}
+	j.rateBytes, j.rateAt = j.doneBytes, j.lastProgress
+	j.publishLocked(events.JobProgress)
+}
+
+func (j *Job) publish(eventType string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.publishLocked(eventType)
+}
+
+func (j *Job) publishLocked(eventType string) {
+	events.Publish(events.Event{
+		Type:        eventType,
+		Path:        j.Source,
+		Destination: j.Destination,
+		Data:        j.infoLocked(),
+	})
+}
+
+// Info returns a snapshot of the job's state
+func (j *Job) Info() *JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.infoLocked()
+}
+
+func (j *Job) infoLocked() *JobInfo {
+	info := &JobInfo{
+		ID:          j.ID,
+		Type:        j.Type,
+		Status:      j.status,
+		Source:      j.Source,
+		Destination: j.Destination,
+		TotalItems:  j.totalItems,
+		DoneItems:   j.doneItems,
+		TotalBytes:  j.totalBytes,
+		DoneBytes:   j.doneBytes,
+		CurrentFile: j.currentFile,
+		Errors:      append([]JobError(nil), j.errors...),
+		Message:     j.message,
+		Result:      j.result,
+		CreatedAt:   j.CreatedAt,
+		FinishedAt:  j.finishedAt,
+	}
+	if j.status == JobRunning {
+		info.BytesPerSecond = j.throughput
+	}
+	return info
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaintenanceMessage is shown when maintenance mode has no message
+const DefaultMaintenanceMessage = "The server is in read-only maintenance mode, please try again later"
+
+// Maintenance is the server-wide read-only mode
+type Maintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	Since   *int64 `json:"since,omitempty"`
+}
+
+var (
+	maintenance      Maintenance
+	maintenanceMutex = sync.RWMutex{}
+)
+
+// GetMaintenance returns the current maintenance state
+func GetMaintenance() Maintenance {
+	maintenanceMutex.RLock()
+	defer maintenanceMutex.RUnlock()
+
+	return maintenance
+}
+
+// SetMaintenance turns maintenance mode on or off. Turning it on again keeps
+// the original start time but updates the message.
+func SetMaintenance(enabled bool, message string) Maintenance {
+	maintenanceMutex.Lock()
+	defer maintenanceMutex.Unlock()
+
+	if !enabled {
+		maintenance = Maintenance{}
+		return maintenance
+	}
+
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	maintenance.Message = message
+	if !maintenance.Enabled {
+		now := time.Now().UnixMilli()
+		maintenance.Enabled = true
+		maintenance.Since = &now
+	}
+	return maintenance
+}
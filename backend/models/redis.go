@@ -0,0 +1,36 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisKeyPrefix namespaces every key nextbrowse stores in Redis
+const RedisKeyPrefix = "nextbrowse:"
+
+// Redis is the client shared by replicas, or nil when REDIS_URL is unset and
+// state is kept in process
+var Redis *redis.Client
+
+// ConnectRedis connects to the Redis server at url (e.g.
+// redis://:password@host:6379/0) and checks that it answers
+func ConnectRedis(url string) error {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("redis: %w", err)
+	}
+
+	Redis = client
+	return nil
+}
@@ -22,6 +22,8 @@ type Share struct {
 	Description   string `json:"description,omitempty"`
 	Theme         string `json:"theme,omitempty"`
 	ViewMode      string `json:"viewMode,omitempty"` // "list" or "grid"
+	Torrent       []byte `json:"-"`                  // the share's .torrent, once made
+	InfoHash      string `json:"infoHash,omitempty"` // of Torrent
 }
 
 type SharePublic struct {
@@ -35,6 +37,7 @@ type SharePublic struct {
 	QuickDownload bool   `json:"quickDownload,omitempty"`
 	Title         string `json:"title,omitempty"`
 	Description   string `json:"description,omitempty"`
+	InfoHash      string `json:"infoHash,omitempty"` // set once a torrent of the share was made
 }
 
 // In-memory storage for shares (replace with DB in production)
@@ -70,6 +73,24 @@ func SetShare(share *Share) {
 	shares[share.ID] = share
 }
 
+// SetShareTorrent attaches a torrent to a share, returning false when the
+// share no longer exists. The share is replaced rather than changed, as
+// others may be reading it.
+func SetShareTorrent(id string, torrent []byte, infoHash string) bool {
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	share, exists := shares[id]
+	if !exists {
+		return false
+	}
+	updated := *share
+	updated.Torrent = torrent
+	updated.InfoHash = infoHash
+	shares[id] = &updated
+	return true
+}
+
 // DeleteShare removes a share
 func DeleteShare(id string) {
 	sharesMutex.Lock()
@@ -98,6 +119,23 @@ func GetAllShares() []*Share {
 	return validShares
 }
 
+// RemoveExpiredShares deletes the shares whose links have expired and
+// returns how many there were
+func RemoveExpiredShares() int {
+	sharesMutex.Lock()
+	defer sharesMutex.Unlock()
+
+	now := time.Now().UnixMilli()
+	removed := 0
+	for id, share := range shares {
+		if share.ExpiresAt != nil && *share.ExpiresAt < now {
+			delete(shares, id)
+			removed++
+		}
+	}
+	return removed
+}
+
 // ToPublic converts a Share to SharePublic (hiding sensitive data)
 func (s *Share) ToPublic() *SharePublic {
 	return &SharePublic{
@@ -111,5 +149,6 @@ func (s *Share) ToPublic() *SharePublic {
 		QuickDownload: s.QuickDownload,
 		Title:         s.Title,
 		Description:   s.Description,
+		InfoHash:      s.InfoHash,
 	}
 }
\ No newline at end of file
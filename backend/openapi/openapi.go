@@ -0,0 +1,409 @@
+// Package openapi describes the HTTP API as an OpenAPI 3.1 document. Paths
+// and methods come from the routes registered with gin, and request and
+// response schemas are derived from the Go types the handlers exchange, so
+// the document cannot drift from the routes actually served.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the OpenAPI version of the generated documents
+const Version = "3.1.0"
+
+// Param describes a query parameter or request header
+type Param struct {
+	Name        string
+	Description string
+	Type        string // JSON schema type, "string" by default
+	Required    bool
+}
+
+// Raw is the media type of a body that is not JSON, such as a file download
+// or the bytes written to a file
+type Raw string
+
+// Object describes a JSON object by example: each key maps to a value of the
+// type the member holds, a nil pointer marking an optional member. It is used
+// for responses built with gin.H.
+type Object map[string]any
+
+// Op documents one route. Request and Response are values of the Go types
+// sent and returned, a Raw media type or an Object; nil means no body.
+type Op struct {
+	ID          string // operationId, the handler name by default
+	Summary     string
+	Description string
+	Tag         string // the path segment after /api/ by default
+	Query       []Param
+	Headers     []Param
+	Request     any
+	Response    any
+	Status      int  // status of a successful response, 200 by default
+	Auth        bool // requires the admin bearer token
+}
+
+// Info names the API in the document
+type Info struct {
+	Title       string
+	Version     string
+	Description string
+}
+
+// Build returns the document for the given routes. A route is included when
+// ops documents it (keyed "METHOD /path" with gin's :param syntax) or its
+// path is under /api/; the second result lists included routes missing from
+// ops, which appear with only their path parameters and generic responses.
+func Build(info Info, routes gin.RoutesInfo, ops map[string]Op) (map[string]any, []string) {
+	g := &generator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+
+	paths := map[string]map[string]any{}
+	usedIDs := map[string]bool{}
+	var undocumented []string
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		op, documented := ops[key]
+		if !documented {
+			if !strings.HasPrefix(route.Path, "/api/") {
+				continue
+			}
+			undocumented = append(undocumented, key)
+		}
+
+		path, pathParams := convertPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = g.operation(op, route, pathParams, usedIDs)
+	}
+
+	g.schemas["Problem"] = problemSchema
+	doc := map[string]any{
+		"openapi": Version,
+		"info": map[string]any{
+			"title":       info.Title,
+			"version":     info.Version,
+			"description": info.Description,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]any{
+				"adminToken": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "The ADMIN_TOKEN the server was started with",
+				},
+			},
+		},
+	}
+	sort.Strings(undocumented)
+	return doc, undocumented
+}
+
+// problemSchema describes the RFC 7807 errors written by package problem
+var problemSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"type", "title", "status", "path", "ok", "error"},
+	"properties": map[string]any{
+		"type":      map[string]any{"type": "string"},
+		"title":     map[string]any{"type": "string"},
+		"status":    map[string]any{"type": "integer"},
+		"detail":    map[string]any{"type": "string"},
+		"requestId": map[string]any{"type": "string"},
+		"path":      map[string]any{"type": "string"},
+		"ok":        map[string]any{"type": "boolean", "const": false},
+		"error":     map[string]any{"type": "string"},
+	},
+	"additionalProperties": true,
+}
+
+// convertPath turns gin's /:id and /*path segments into OpenAPI's /{id},
+// returning the parameter names
+func convertPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	var params []string
+	for i, segment := range segments {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// generator collects the named schemas referenced by operations
+type generator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+func (g *generator) operation(op Op, route gin.RouteInfo, pathParams []string, usedIDs map[string]bool) map[string]any {
+	id := op.ID
+	if id == "" {
+		id = handlerName(route.Handler)
+	}
+	if usedIDs[id] {
+		id += strings.ToUpper(route.Method[:1]) + strings.ToLower(route.Method[1:])
+	}
+	usedIDs[id] = true
+
+	tag := op.Tag
+	if tag == "" {
+		rest := strings.TrimPrefix(strings.TrimPrefix(route.Path, "/api"), "/")
+		tag, _, _ = strings.Cut(rest, "/")
+	}
+
+	result := map[string]any{
+		"operationId": id,
+		"tags":        []string{tag},
+	}
+	if op.Summary != "" {
+		result["summary"] = op.Summary
+	}
+	if op.Description != "" {
+		result["description"] = op.Description
+	}
+
+	var params []any
+	for _, name := range pathParams {
+		params = append(params, map[string]any{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, p := range op.Query {
+		params = append(params, parameter(p, "query"))
+	}
+	for _, p := range op.Headers {
+		params = append(params, parameter(p, "header"))
+	}
+	if params != nil {
+		result["parameters"] = params
+	}
+
+	if op.Request != nil {
+		result["requestBody"] = map[string]any{
+			"required": true,
+			"content":  g.content(op.Request),
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	if op.Response != nil && route.Method != http.MethodHead {
+		success["content"] = g.content(op.Response)
+	}
+	result["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default": map[string]any{
+			"description": "Error",
+			"content": map[string]any{
+				"application/problem+json": map[string]any{
+					"schema": map[string]any{"$ref": "#/components/schemas/Problem"},
+				},
+			},
+		},
+	}
+
+	if op.Auth {
+		result["security"] = []any{map[string]any{"adminToken": []string{}}}
+	}
+	return result
+}
+
+func parameter(p Param, in string) map[string]any {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	param := map[string]any{
+		"name":   p.Name,
+		"in":     in,
+		"schema": map[string]any{"type": typ},
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	if p.Required {
+		param["required"] = true
+	}
+	return param
+}
+
+// content describes a body given as a Go value, a Raw media type or an Object
+func (g *generator) content(body any) map[string]any {
+	if mediaType, ok := body.(Raw); ok {
+		return map[string]any{
+			string(mediaType): map[string]any{
+				"schema": map[string]any{"type": "string", "format": "binary"},
+			},
+		}
+	}
+	return map[string]any{
+		"application/json": map[string]any{"schema": g.schema(body)},
+	}
+}
+
+// schema describes a value given by example
+func (g *generator) schema(value any) map[string]any {
+	if obj, ok := value.(Object); ok {
+		properties := map[string]any{}
+		required := make([]string, 0, len(obj))
+		for name, member := range obj {
+			properties[name] = g.schema(member)
+			if v := reflect.ValueOf(member); v.Kind() != reflect.Pointer || !v.IsNil() {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	}
+	if value == nil {
+		return map[string]any{}
+	}
+	return g.typeSchema(reflect.TypeOf(value))
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	durationType = reflect.TypeFor[time.Duration]()
+)
+
+// typeSchema describes how encoding/json marshals values of t. Named
+// structs are described once under components/schemas and referenced.
+func (g *generator) typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]any{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + g.structName(t)}
+	}
+	// Interfaces and anything else hold arbitrary JSON
+	return map[string]any{}
+}
+
+// structName registers a named struct under components/schemas, qualifying
+// the name with its package when two packages use the same one
+func (g *generator) structName(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = exported(pkg) + name
+	}
+	g.names[t] = name
+	g.schemas[name] = map[string]any{} // placeholder for recursive types
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+// structSchema lists the fields encoding/json would marshal. Fields without
+// omitempty are always present and so required.
+func (g *generator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Pointer {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct {
+					addFields(embedded)
+					continue
+				}
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.typeSchema(field.Type)
+			if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// handlerName turns gin's handler name, such as
+// "nextbrowse-backend/handlers.ListDirectory", into "listDirectory"
+func handlerName(handler string) string {
+	name := handler[strings.LastIndex(handler, ".")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	if name == "" {
+		return "operation"
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,259 @@
+package openapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tsRuntime is the fetch wrapper the generated functions call
+const tsRuntime = `let baseUrl = "";
+
+/** Sets the URL the API is served from; requests are relative by default. */
+export function setBaseUrl(url: string) {
+  baseUrl = url.replace(/\/$/, "");
+}
+
+let defaultHeaders: Record<string, string> = {};
+
+/** Sets headers sent with every request, such as X-NextBrowse-User. */
+export function setDefaultHeaders(headers: Record<string, string>) {
+  defaultHeaders = { ...headers };
+}
+
+/** Parsed body of a JSON endpoint; check ok to tell it from a Problem. */
+export type ApiResult<T> = (T & { ok: true }) | Problem;
+
+async function call(
+  method: string,
+  path: string,
+  query: Record<string, unknown>,
+  body: unknown,
+  json: boolean,
+  init?: RequestInit
+): Promise<Response> {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(query)) {
+    if (value !== undefined && value !== null) params.set(key, String(value));
+  }
+  const search = params.toString();
+  const headers = new Headers(defaultHeaders);
+  new Headers(init?.headers).forEach((value, key) => headers.set(key, value));
+  if (json && body !== undefined) headers.set("Content-Type", "application/json");
+  return fetch(baseUrl + path + (search ? "?" + search : ""), {
+    ...init,
+    method,
+    headers,
+    body: json && body !== undefined ? JSON.stringify(body) : (body as BodyInit | undefined),
+  });
+}
+`
+
+// TypeScript renders a document made by Build as a TypeScript module: an
+// interface per schema and a fetch-based function per operation. Functions
+// for JSON responses resolve to the parsed body, which is a Problem when the
+// request failed; the others resolve to the Response itself.
+func TypeScript(doc map[string]any) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by `nextbrowse openapi --typescript`. DO NOT EDIT.\n\n")
+	b.WriteString(tsRuntime)
+
+	components, _ := doc["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	for _, name := range sortedKeys(schemas) {
+		schema, _ := schemas[name].(map[string]any)
+		fmt.Fprintf(&b, "\nexport interface %s %s\n", name, tsObject(schema, ""))
+	}
+
+	paths, _ := doc["paths"].(map[string]map[string]any)
+	for _, path := range sortedKeys(paths) {
+		for _, method := range sortedKeys(paths[path]) {
+			op, _ := paths[path][method].(map[string]any)
+			writeTSFunction(&b, path, method, op)
+		}
+	}
+	return b.String()
+}
+
+func writeTSFunction(b *strings.Builder, path, method string, op map[string]any) {
+	// Path and query parameters, and the body, are fields of one argument
+	var fields, query []string
+	optional := true
+	urlPath := "`" + path + "`"
+	params, _ := op["parameters"].([]any)
+	for _, p := range params {
+		param := p.(map[string]any)
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		required, _ := param["required"].(bool)
+		typ := tsType(param["schema"].(map[string]any))
+		switch in {
+		case "path":
+			urlPath = strings.Replace(urlPath, "{"+name+"}", "${encodeURIComponent(params"+tsAccess(name)+")}", 1)
+		case "query":
+			query = append(query, fmt.Sprintf("%q: params%s", name, tsAccess(name)))
+		default:
+			continue // headers go in init
+		}
+		fields = append(fields, tsField(name, typ, required, "  "))
+		optional = optional && !required
+	}
+
+	json := true
+	if body, ok := op["requestBody"].(map[string]any); ok {
+		typ, isJSON := tsContent(body["content"].(map[string]any), "BodyInit")
+		json = isJSON
+		fields = append(fields, tsField("body", typ, true, "  "))
+		optional = false
+	}
+
+	result := "Response"
+	responses, _ := op["responses"].(map[string]any)
+	for status, r := range responses {
+		if status == "default" {
+			continue
+		}
+		if content, ok := r.(map[string]any)["content"].(map[string]any); ok {
+			if typ, isJSON := tsContent(content, ""); isJSON {
+				result = "ApiResult<" + typ + ">"
+			}
+		}
+	}
+
+	id, _ := op["operationId"].(string)
+	fmt.Fprintln(b)
+	if summary, ok := op["summary"].(string); ok {
+		fmt.Fprintf(b, "/** %s */\n", summary)
+	}
+	arg := "init?: RequestInit"
+	if len(fields) > 0 {
+		arg = "params: {\n" + strings.Join(fields, "\n") + "\n}"
+		if optional {
+			arg += " = {}"
+		}
+		arg += ", init?: RequestInit"
+	}
+	bodyArg := "undefined"
+	if _, ok := op["requestBody"]; ok {
+		bodyArg = "params.body"
+	}
+	fmt.Fprintf(b, "export async function %s(%s): Promise<%s> {\n", id, arg, result)
+	fmt.Fprintf(b, "  const response = await call(%q, %s, {%s}, %s, %t, init);\n",
+		strings.ToUpper(method), urlPath, strings.Join(query, ", "), bodyArg, json)
+	if result == "Response" {
+		b.WriteString("  return response;\n}\n")
+	} else {
+		b.WriteString("  return response.json();\n}\n")
+	}
+}
+
+// tsContent returns the type of a request or response body and whether it is
+// JSON; other media types are given rawType
+func tsContent(content map[string]any, rawType string) (string, bool) {
+	if media, ok := content["application/json"].(map[string]any); ok {
+		return tsType(media["schema"].(map[string]any)), true
+	}
+	return rawType, false
+}
+
+// tsType renders a JSON schema made by Build as a TypeScript type
+func tsType(schema map[string]any) string {
+	if ref, ok := schema["$ref"].(string); ok {
+		return ref[strings.LastIndex(ref, "/")+1:]
+	}
+	if value, ok := schema["const"]; ok {
+		return fmt.Sprintf("%v", value)
+	}
+	switch schema["type"] {
+	case "string":
+		if schema["format"] == "binary" {
+			return "Blob"
+		}
+		return "string"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		item := tsType(schema["items"].(map[string]any))
+		if strings.ContainsAny(item, " |") {
+			item = "(" + item + ")"
+		}
+		return item + "[]"
+	case "object":
+		if values, ok := schema["additionalProperties"].(map[string]any); ok {
+			return "Record<string, " + tsType(values) + ">"
+		}
+		if properties, _ := schema["properties"].(map[string]any); len(properties) > 0 {
+			return tsObject(schema, "")
+		}
+		return "Record<string, unknown>"
+	}
+	return "unknown"
+}
+
+// tsObject renders the properties of an object schema, each line indented
+// by indent plus two spaces
+func tsObject(schema map[string]any, indent string) string {
+	properties, _ := schema["properties"].(map[string]any)
+	names, _ := schema["required"].([]string)
+	required := map[string]bool{}
+	for _, name := range names {
+		required[name] = true
+	}
+
+	var lines []string
+	for _, name := range sortedKeys(properties) {
+		prop, _ := properties[name].(map[string]any)
+		typ := tsType(prop)
+		if strings.HasPrefix(typ, "{") {
+			typ = tsObject(prop, indent+"  ")
+		}
+		lines = append(lines, tsField(name, typ, required[name], indent+"  "))
+	}
+	if additional, ok := schema["additionalProperties"].(bool); ok && additional {
+		lines = append(lines, indent+"  [key: string]: unknown;")
+	}
+	if len(lines) == 0 {
+		return "{}"
+	}
+	return "{\n" + strings.Join(lines, "\n") + "\n" + indent + "}"
+}
+
+func tsField(name, typ string, required bool, indent string) string {
+	opt := "?"
+	if required {
+		opt = ""
+	}
+	key := name
+	if !tsIdentifier(name) {
+		key = fmt.Sprintf("%q", name)
+	}
+	return fmt.Sprintf("%s%s%s: %s;", indent, key, opt, typ)
+}
+
+// tsAccess is the expression that reads the property name of an object
+func tsAccess(name string) string {
+	if tsIdentifier(name) {
+		return "." + name
+	}
+	return fmt.Sprintf("[%q]", name)
+}
+
+func tsIdentifier(name string) bool {
+	for i, r := range name {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
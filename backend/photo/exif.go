@@ -0,0 +1,200 @@
+package photo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var errNoExif = errors.New("no exif data")
+
+// EXIF tags read
+const (
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+)
+
+// typeSizes are the sizes of the values of each TIFF field type
+var typeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type exifData struct {
+	orientation int
+	taken       time.Time
+}
+
+// readJPEGExif finds the EXIF block among the segments of a JPEG that come
+// before its image data
+func readJPEGExif(r *bufio.Reader) (exifData, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return exifData{}, err
+	}
+	if soi != [2]byte{0xFF, 0xD8} {
+		return exifData{}, errNoExif
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return exifData{}, err
+		}
+		if b != 0xFF {
+			return exifData{}, errNoExif
+		}
+		marker := byte(0xFF)
+		for marker == 0xFF {
+			if marker, err = r.ReadByte(); err != nil {
+				return exifData{}, err
+			}
+		}
+		switch {
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			continue // no length
+		case marker == 0xDA || marker == 0xD9:
+			return exifData{}, errNoExif // image data, then the end
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return exifData{}, err
+		}
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return exifData{}, errNoExif
+		}
+		if marker != 0xE1 {
+			if _, err := r.Discard(n); err != nil {
+				return exifData{}, err
+			}
+			continue
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return exifData{}, err
+		}
+		if tiff, ok := bytes.CutPrefix(data, []byte("Exif\x00\x00")); ok {
+			return parseExif(tiff)
+		}
+	}
+}
+
+// parseExif reads the orientation and capture date from the TIFF structure
+// of an EXIF block. The date is taken as UTC unless the camera recorded its
+// offset, so that photos without one still sort by their local time.
+func parseExif(tiff []byte) (exifData, error) {
+	if len(tiff) < 8 {
+		return exifData{}, errNoExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return exifData{}, errNoExif
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return exifData{}, errNoExif
+	}
+
+	var meta exifData
+	var dateTime, original, offset string
+	exifIFD := -1
+	err := walkIFD(tiff, order, int(order.Uint32(tiff[4:])), func(tag, typ uint16, value []byte) {
+		switch {
+		case tag == tagOrientation && typ == 3:
+			meta.orientation = int(order.Uint16(value))
+		case tag == tagDateTime && typ == 2:
+			dateTime = asciiValue(value)
+		case tag == tagExifIFD && (typ == 4 || typ == 13):
+			exifIFD = int(order.Uint32(value))
+		}
+	})
+	if err != nil {
+		return exifData{}, err
+	}
+	if exifIFD >= 0 {
+		_ = walkIFD(tiff, order, exifIFD, func(tag, typ uint16, value []byte) {
+			switch {
+			case tag == tagDateTimeOriginal && typ == 2:
+				original = asciiValue(value)
+			case tag == tagOffsetTimeOriginal && typ == 2:
+				offset = asciiValue(value)
+			}
+		})
+	}
+
+	if original != "" {
+		meta.taken = parseExifTime(original, offset)
+	}
+	if meta.taken.IsZero() && dateTime != "" {
+		meta.taken = parseExifTime(dateTime, "")
+	}
+	return meta, nil
+}
+
+// walkIFD calls fn with the tag, type and value of each entry of the image
+// file directory at offset, skipping values that don't fit in the block
+func walkIFD(tiff []byte, order binary.ByteOrder, offset int, fn func(tag, typ uint16, value []byte)) error {
+	if offset < 8 || offset+2 > len(tiff) {
+		return errNoExif
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	if count*12 > len(entries) {
+		return errNoExif
+	}
+	for i := range count {
+		entry := entries[i*12 : i*12+12]
+		tag, typ := order.Uint16(entry), order.Uint16(entry[2:])
+		size, ok := typeSizes[typ]
+		if typ == 13 {
+			size, ok = 4, true // an IFD offset
+		}
+		if !ok {
+			continue
+		}
+		n := int64(order.Uint32(entry[4:])) * int64(size)
+		if n <= 4 {
+			fn(tag, typ, entry[8:8+n])
+			continue
+		}
+		start := int64(order.Uint32(entry[8:]))
+		if start+n > int64(len(tiff)) {
+			continue
+		}
+		fn(tag, typ, tiff[start:start+n])
+	}
+	return nil
+}
+
+// asciiValue returns the text of an ASCII field, up to its terminating NUL
+func asciiValue(value []byte) string {
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// parseExifTime parses an EXIF date such as "2024:05:17 14:03:59", with an
+// offset such as "+02:00" when known. Unset dates give the zero time.
+func parseExifTime(value, offset string) time.Time {
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,69 @@
+// Package photo reads the dimensions and capture dates of images and makes
+// thumbnails of them, with the decoders of the standard library: JPEG, PNG
+// and GIF.
+package photo
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrTooLarge is returned for images with more pixels than allowed
+var ErrTooLarge = errors.New("image has too many pixels")
+
+// Info describes an image as it is displayed: the width and height of
+// photos the camera recorded sideways are swapped
+type Info struct {
+	Format      string // jpeg, png or gif
+	Width       int
+	Height      int
+	Taken       time.Time // when the photo was taken, zero when unknown
+	Orientation int       // EXIF orientation, 1 when upright
+}
+
+// IsImage reports whether a file name has the extension of an image this
+// package reads
+func IsImage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".jpe", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// Read reads the header of an image, and the EXIF metadata of a JPEG. A
+// broken EXIF block is ignored, as it doesn't keep the image from showing.
+func Read(r io.ReadSeeker) (Info, error) {
+	config, format, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Format: format, Width: config.Width, Height: config.Height, Orientation: 1}
+	if format != "jpeg" {
+		return info, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return info, nil
+	}
+	meta, err := readJPEGExif(bufio.NewReader(r))
+	if err != nil {
+		return info, nil
+	}
+	info.Taken = meta.taken
+	if meta.orientation >= 1 && meta.orientation <= 8 {
+		info.Orientation = meta.orientation
+	}
+	if info.Orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	return info, nil
+}
@@ -0,0 +1,137 @@
+package photo
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// thumbnailQuality is the JPEG quality thumbnails are encoded with
+const thumbnailQuality = 80
+
+// Thumbnail decodes an image and scales it down to fit within size by size
+// pixels, turned upright, as a JPEG. Transparent parts are drawn on white.
+// Images with more than maxPixels pixels are refused before being decoded.
+func Thumbnail(r io.ReadSeeker, size int, maxPixels int64) ([]byte, error) {
+	info, err := Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(info.Width)*int64(info.Height) > maxPixels {
+		return nil, ErrTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	thumb := orient(scale(img, size), info.Orientation)
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scale shrinks an image to fit within size by size pixels, averaging the
+// pixels that make up each pixel of the result. Smaller images keep their
+// size.
+func scale(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	columns := make([]int, w)
+	for x := range columns {
+		columns[x] = x * tw / w
+	}
+	sums := make([]uint64, tw*th*4)
+	counts := make([]uint64, tw*th)
+	ycbcr, isYCbCr := src.(*image.YCbCr)
+	for y := 0; y < h; y++ {
+		row := y * th / h * tw
+		for x := 0; x < w; x++ {
+			var r, g, bl, a uint32
+			if isYCbCr {
+				// Much faster than going through At for JPEG photos
+				yi := ycbcr.YOffset(b.Min.X+x, b.Min.Y+y)
+				ci := ycbcr.COffset(b.Min.X+x, b.Min.Y+y)
+				r8, g8, b8 := color.YCbCrToRGB(ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci])
+				r, g, bl, a = uint32(r8)*0x101, uint32(g8)*0x101, uint32(b8)*0x101, 0xffff
+			} else {
+				r, g, bl, a = src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			}
+			i := row + columns[x]
+			sums[i*4] += uint64(r)
+			sums[i*4+1] += uint64(g)
+			sums[i*4+2] += uint64(bl)
+			sums[i*4+3] += uint64(a)
+			counts[i]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for i, n := range counts {
+		if n == 0 {
+			continue
+		}
+		// The colors are premultiplied by alpha, so adding the missing
+		// alpha draws them on white
+		white := 0xffff - sums[i*4+3]/n
+		for c := range 3 {
+			dst.Pix[i*4+c] = uint8((sums[i*4+c]/n + white) >> 8)
+		}
+		dst.Pix[i*4+3] = 0xff
+	}
+	return dst
+}
+
+// orient turns an image as its EXIF orientation says to show it
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-dx, dy
+			case 3: // upside down
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // upside down and mirrored
+				sx, sy = dx, h-1-dy
+			case 5: // on its side and mirrored
+				sx, sy = dy, dx
+			case 6: // to be turned clockwise
+				sx, sy = dy, h-1-dx
+			case 7: // on its other side and mirrored
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // to be turned counterclockwise
+				sx, sy = w-1-dy, dx
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,83 @@
+// Package problem renders API errors as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem detail bodies
+const ContentType = "application/problem+json"
+
+// requestIDHeader is set on the response by the request logger
+const requestIDHeader = "X-Request-ID"
+
+// Details is an RFC 7807 problem. OK and Error repeat the failure in the
+// {"ok": false, "error": ...} shape that existing clients read, and
+// Extensions adds further members such as the ID of a cancelled job.
+type Details struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	RequestID  string
+	Path       string
+	Extensions gin.H
+}
+
+// MarshalJSON flattens the extension members into the problem object
+func (d Details) MarshalJSON() ([]byte, error) {
+	body := gin.H{}
+	for key, value := range d.Extensions {
+		body[key] = value
+	}
+	body["type"] = d.Type
+	body["title"] = d.Title
+	body["status"] = d.Status
+	if d.Detail != "" {
+		body["detail"] = d.Detail
+	}
+	if d.RequestID != "" {
+		body["requestId"] = d.RequestID
+	}
+	body["path"] = d.Path
+	body["ok"] = false
+	body["error"] = d.Detail
+	return json.Marshal(body)
+}
+
+// New describes a failed request. Without a more specific type the problem
+// is "about:blank", whose title is the status text.
+func New(c *gin.Context, status int, detail string, extensions ...gin.H) Details {
+	d := Details{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		RequestID: c.Writer.Header().Get(requestIDHeader),
+		Path:      c.Request.URL.Path,
+	}
+	for _, ext := range extensions {
+		if d.Extensions == nil {
+			d.Extensions = gin.H{}
+		}
+		for key, value := range ext {
+			d.Extensions[key] = value
+		}
+	}
+	return d
+}
+
+// Respond writes a problem response
+func Respond(c *gin.Context, status int, detail string, extensions ...gin.H) {
+	c.Header("Content-Type", ContentType)
+	c.JSON(status, New(c, status, detail, extensions...))
+}
+
+// Abort writes a problem response and stops the remaining handlers
+func Abort(c *gin.Context, status int, detail string, extensions ...gin.H) {
+	Respond(c, status, detail, extensions...)
+	c.Abort()
+}
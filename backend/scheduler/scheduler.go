@@ -0,0 +1,209 @@
+// Package scheduler runs recurring tasks — the server's own housekeeping and
+// the maintenance tasks in TASKS — on cron schedules, one run of a task at a
+// time, and remembers their recent runs.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// How many runs are remembered per task
+const maxRuns = 50
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerStartup  = "startup"
+)
+
+// Run statuses
+const (
+	RunRunning   = "running"
+	RunCompleted = "completed"
+	RunFailed    = "failed"
+)
+
+// ErrTaskRunning is returned when a task is started while its previous run
+// is still in progress
+var ErrTaskRunning = errors.New("task is already running")
+
+// ErrTaskNotFound is returned for a task that was never added
+var ErrTaskNotFound = errors.New("task not found")
+
+// Task is work done on a schedule. Run returns a short summary of what it
+// did, such as how many files it removed.
+type Task struct {
+	Name        string
+	Schedule    string // cron expression or descriptor such as "@every 1h"
+	Description string
+	Run         func(ctx context.Context) (string, error)
+}
+
+// Run is one run of a task
+type Run struct {
+	ID         string `json:"id"`
+	Task       string `json:"task"`
+	Trigger    string `json:"trigger"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"` // the summary, or why it failed
+	StartedAt  int64  `json:"startedAt"`
+	FinishedAt *int64 `json:"finishedAt,omitempty"`
+}
+
+// Info describes a task, when it runs next and how its last run went
+type Info struct {
+	Name        string `json:"name"`
+	Schedule    string `json:"schedule"`
+	Description string `json:"description,omitempty"`
+	NextRun     *int64 `json:"nextRun,omitempty"`
+	LastRun     *Run   `json:"lastRun,omitempty"`
+}
+
+type entry struct {
+	task    Task
+	id      cron.EntryID
+	runs    []*Run // oldest first
+	running bool
+}
+
+var (
+	mu      sync.Mutex
+	crons   = cron.New()
+	tasks   = make(map[string]*entry)
+	ordered []*entry
+)
+
+// Add schedules a task. Tasks start running on their schedules once Start
+// is called.
+func Add(task Task) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exists := tasks[task.Name]; exists {
+		return fmt.Errorf("task %s: defined twice", task.Name)
+	}
+	if _, err := cron.ParseStandard(task.Schedule); err != nil {
+		return fmt.Errorf("task %s: invalid schedule %q: %w", task.Name, task.Schedule, err)
+	}
+
+	e := &entry{task: task}
+	id, err := crons.AddFunc(task.Schedule, func() {
+		if _, err := Trigger(task.Name, TriggerSchedule); err != nil {
+			slog.Warn("Scheduled task did not start", "task", task.Name, "error", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	e.id = id
+	tasks[task.Name] = e
+	ordered = append(ordered, e)
+	return nil
+}
+
+// Start runs the added tasks on their schedules
+func Start() {
+	crons.Start()
+}
+
+// Trigger starts a run of a task in the background, unless its previous run
+// is still going
+func Trigger(name, trigger string) (Run, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, exists := tasks[name]
+	if !exists {
+		return Run{}, ErrTaskNotFound
+	}
+	if e.running {
+		return Run{}, ErrTaskRunning
+	}
+
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	run := &Run{
+		ID:        hex.EncodeToString(id),
+		Task:      name,
+		Trigger:   trigger,
+		Status:    RunRunning,
+		StartedAt: time.Now().UnixMilli(),
+	}
+	e.running = true
+	e.runs = append(e.runs, run)
+	if len(e.runs) > maxRuns {
+		e.runs = e.runs[len(e.runs)-maxRuns:]
+	}
+
+	go execute(e, run)
+	return *run, nil
+}
+
+// execute runs a task and records the outcome
+func execute(e *entry, run *Run) {
+	start := time.Now()
+	message, err := e.task.Run(context.Background())
+
+	status := RunCompleted
+	if err != nil {
+		status, message = RunFailed, err.Error()
+		slog.Warn("Task failed", "task", e.task.Name, "error", err)
+	} else {
+		slog.Debug("Task completed", "task", e.task.Name, "duration", time.Since(start), "result", message)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now().UnixMilli()
+	run.Status = status
+	run.Message = message
+	run.FinishedAt = &now
+	e.running = false
+}
+
+// List describes the tasks in the order they were added
+func List() []Info {
+	mu.Lock()
+	defer mu.Unlock()
+
+	infos := make([]Info, 0, len(ordered))
+	for _, e := range ordered {
+		info := Info{Name: e.task.Name, Schedule: e.task.Schedule, Description: e.task.Description}
+		if next := crons.Entry(e.id).Next; !next.IsZero() {
+			nextRun := next.UnixMilli()
+			info.NextRun = &nextRun
+		}
+		if len(e.runs) > 0 {
+			last := *e.runs[len(e.runs)-1]
+			info.LastRun = &last
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Runs returns the remembered runs of a task, newest first
+func Runs(name string) ([]Run, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	e, exists := tasks[name]
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+	runs := make([]Run, 0, len(e.runs))
+	for i := len(e.runs) - 1; i >= 0; i-- {
+		runs = append(runs, *e.runs[i])
+	}
+	return runs, nil
+}
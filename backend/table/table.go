@@ -0,0 +1,84 @@
+// Package table reads the rows of delimited text files and Excel workbooks
+// one at a time, so that large files can be previewed a page at a time
+// without being loaded whole.
+package table
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"io"
+)
+
+// Rows yields the rows of a table in order
+type Rows interface {
+	// Next returns the next row, or io.EOF after the last one
+	Next() ([]string, error)
+	Close() error
+}
+
+// sniffSize is how much of a file is looked at to guess its delimiter
+const sniffSize = 64 * 1024
+
+// delimiters are the separators guessed between, in order of preference
+var delimiters = []rune{',', '\t', ';', '|'}
+
+// CSV returns the rows of delimited text read from r along with the
+// delimiter, which is guessed from the first line when zero. A leading
+// UTF-8 byte order mark is skipped and rows may have any number of fields.
+func CSV(r io.Reader, delimiter rune) (Rows, rune) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		_, _ = br.Discard(3)
+	}
+	if delimiter == 0 {
+		head, _ := br.Peek(sniffSize)
+		delimiter = guessDelimiter(head)
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return csvRows{reader}, delimiter
+}
+
+type csvRows struct {
+	reader *csv.Reader
+}
+
+func (r csvRows) Next() ([]string, error) {
+	return r.reader.Read()
+}
+
+func (r csvRows) Close() error {
+	return nil
+}
+
+// guessDelimiter picks the delimiter that occurs most often outside quotes
+// in the first line of head, preferring a comma
+func guessDelimiter(head []byte) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, b := range head {
+		if b == '"' {
+			quoted = !quoted
+			continue
+		}
+		if quoted {
+			continue
+		}
+		if b == '\n' {
+			break
+		}
+		counts[rune(b)]++
+	}
+
+	best := delimiters[0]
+	for _, d := range delimiters[1:] {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,106 @@
+package table
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types, from the most to the least specific
+const (
+	Integer = "integer"
+	Number  = "number"
+	Boolean = "boolean"
+	Date    = "date"
+	String  = "string"
+)
+
+// dateLayouts are the date and time formats recognized in cells
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	time.RFC3339Nano,
+}
+
+// Infer returns the type that fits all the non-empty values: integer when
+// they all are, number when they are all numbers, and so on, string when
+// they have nothing in common or there are none
+func Infer(values []string) string {
+	typ := ""
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		t := typeOf(v)
+		switch {
+		case typ == "" || typ == t:
+			typ = t
+		case typ == Integer && t == Number || typ == Number && t == Integer:
+			typ = Number
+		default:
+			return String
+		}
+	}
+	if typ == "" {
+		return String
+	}
+	return typ
+}
+
+func typeOf(v string) string {
+	// Leading zeros mark codes, such as ZIP codes, rather than numbers
+	if len(v) > 1 && v[0] == '0' && v[1] != '.' {
+		return String
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return Integer
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return Number
+	}
+	if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+		return Boolean
+	}
+	for _, layout := range dateLayouts {
+		if _, err := time.Parse(layout, v); err == nil {
+			return Date
+		}
+	}
+	return String
+}
+
+// Convert returns a value as the JSON value of its column's type: a number,
+// a boolean, nil when empty, or the text itself for dates and strings
+func Convert(v, typ string) any {
+	trimmed := strings.TrimSpace(v)
+	if trimmed == "" {
+		return nil
+	}
+	switch typ {
+	case Integer:
+		if n, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+			return n
+		}
+	case Number:
+		if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
+			return f
+		}
+	case Boolean:
+		return strings.EqualFold(trimmed, "true")
+	}
+	return v
+}
+
+// ColumnName returns the spreadsheet name of a zero-based column: A, B, ...,
+// Z, AA, AB and so on
+func ColumnName(col int) string {
+	var name []byte
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = append([]byte{byte('A' + (col-1)%26)}, name...)
+	}
+	return string(name)
+}
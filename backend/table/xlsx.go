@@ -0,0 +1,343 @@
+package table
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoSheet is returned for a sheet the workbook doesn't have
+var ErrNoSheet = errors.New("no such sheet")
+
+// Workbook is an Excel .xlsx file opened for reading its sheets
+type Workbook struct {
+	Sheets []string // names, in the workbook's order
+
+	files      map[string]*zip.File
+	targets    map[string]string // part of each sheet, by name
+	shared     []string          // the shared strings table
+	dateStyles []bool            // whether each cell style shows a date
+	date1904   bool
+}
+
+// OpenWorkbook reads the sheet names, shared strings and styles of an .xlsx
+// file. Sheets are only read when their rows are asked for.
+func OpenWorkbook(r io.ReaderAt, size int64) (*Workbook, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not an xlsx file: %w", err)
+	}
+	w := &Workbook{files: make(map[string]*zip.File), targets: make(map[string]string)}
+	for _, f := range archive.File {
+		w.files[f.Name] = f
+	}
+
+	var workbook struct {
+		Properties struct {
+			Date1904 bool `xml:"date1904,attr"`
+		} `xml:"workbookPr"`
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := w.decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := w.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	for _, sheet := range workbook.Sheets {
+		if target, ok := targets[sheet.RID]; ok {
+			w.Sheets = append(w.Sheets, sheet.Name)
+			w.targets[sheet.Name] = target
+		}
+	}
+	w.date1904 = workbook.Properties.Date1904
+
+	if err := w.readSharedStrings(); err != nil {
+		return nil, err
+	}
+	if err := w.readStyles(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// decode unmarshals a part of the workbook
+func (w *Workbook) decode(name string, v any) error {
+	f, ok := w.files[name]
+	if !ok {
+		return fmt.Errorf("not an xlsx file: %s is missing", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
+
+func (w *Workbook) readSharedStrings() error {
+	if _, ok := w.files["xl/sharedStrings.xml"]; !ok {
+		return nil
+	}
+	var sst struct {
+		Items []richText `xml:"si"`
+	}
+	if err := w.decode("xl/sharedStrings.xml", &sst); err != nil {
+		return err
+	}
+	w.shared = make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		w.shared[i] = item.String()
+	}
+	return nil
+}
+
+// richText is a string that is either plain or made of formatted runs
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var sb strings.Builder
+	for _, run := range t.Runs {
+		sb.WriteString(run.Text)
+	}
+	return sb.String()
+}
+
+// readStyles notes which cell styles format numbers as dates, as dates are
+// stored as numbers of days
+func (w *Workbook) readStyles() error {
+	if _, ok := w.files["xl/styles.xml"]; !ok {
+		return nil
+	}
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := w.decode("xl/styles.xml", &styles); err != nil {
+		return err
+	}
+	custom := make(map[int]string)
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = f.Code
+	}
+	w.dateStyles = make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			w.dateStyles[i] = isDateFormat(code)
+		} else {
+			w.dateStyles[i] = xf.NumFmtID >= 14 && xf.NumFmtID <= 22 || xf.NumFmtID >= 45 && xf.NumFmtID <= 47
+		}
+	}
+	return nil
+}
+
+// isDateFormat reports whether a number format code shows a date or time,
+// ignoring quoted text, escaped characters and colors like [Red]
+func isDateFormat(code string) bool {
+	inQuotes, inBrackets := false, false
+	for i := 0; i < len(code); i++ {
+		switch ch := code[i]; {
+		case ch == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case ch == '[':
+			inBrackets = true
+		case ch == ']':
+			inBrackets = false
+		case inBrackets:
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++
+		case strings.IndexByte("dmyhsDMYHS", ch) >= 0:
+			return true
+		}
+	}
+	return false
+}
+
+// Rows returns the rows of a sheet, the first one for "". Empty rows the
+// sheet leaves out are skipped, while empty cells within a row are kept.
+func (w *Workbook) Rows(sheet string) (Rows, error) {
+	if sheet == "" && len(w.Sheets) > 0 {
+		sheet = w.Sheets[0]
+	}
+	target, ok := w.targets[sheet]
+	if !ok {
+		return nil, ErrNoSheet
+	}
+	f, ok := w.files[target]
+	if !ok {
+		return nil, fmt.Errorf("not an xlsx file: %s is missing", target)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &sheetRows{workbook: w, rc: rc, decoder: xml.NewDecoder(rc)}, nil
+}
+
+type sheetRows struct {
+	workbook *Workbook
+	rc       io.ReadCloser
+	decoder  *xml.Decoder
+}
+
+// xlsxCell is a <c> element of a sheet
+type xlsxCell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Style  int      `xml:"s,attr"`
+	Value  string   `xml:"v"`
+	Inline richText `xml:"is"`
+}
+
+func (r *sheetRows) Next() ([]string, error) {
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "row" {
+			return r.readRow()
+		}
+	}
+}
+
+// readRow reads the cells of a <row> up to its end
+func (r *sheetRows) readRow() ([]string, error) {
+	var row []string
+	for {
+		token, err := r.decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			var cell xlsxCell
+			if err := r.decoder.DecodeElement(&cell, &t); err != nil {
+				return nil, err
+			}
+			col := len(row)
+			if cell.Ref != "" {
+				col = columnIndex(cell.Ref)
+			}
+			if col < len(row) || col >= maxColumns {
+				continue
+			}
+			for len(row) < col {
+				row = append(row, "")
+			}
+			row = append(row, r.workbook.cellText(cell))
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return row, nil
+			}
+		}
+	}
+}
+
+func (r *sheetRows) Close() error {
+	return r.rc.Close()
+}
+
+// maxColumns is the width of the largest sheet Excel allows
+const maxColumns = 16384
+
+// columnIndex returns the zero-based column of a cell reference like "AB12"
+func columnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A') + 1
+	}
+	return col - 1
+}
+
+// cellText returns a cell's value as text: numbers as written, dates in
+// ISO 8601 and booleans as TRUE or FALSE
+func (w *Workbook) cellText(cell xlsxCell) string {
+	switch cell.Type {
+	case "s":
+		i, err := strconv.Atoi(cell.Value)
+		if err != nil || i < 0 || i >= len(w.shared) {
+			return ""
+		}
+		return w.shared[i]
+	case "inlineStr":
+		return cell.Inline.String()
+	case "b":
+		if cell.Value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "", "n":
+		if cell.Style >= 0 && cell.Style < len(w.dateStyles) && w.dateStyles[cell.Style] {
+			if days, err := strconv.ParseFloat(cell.Value, 64); err == nil {
+				return w.formatDate(days)
+			}
+		}
+	}
+	return cell.Value
+}
+
+// formatDate turns a number of days since the workbook's epoch into a date,
+// with the time of day when there is one
+func (w *Workbook) formatDate(days float64) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if w.date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	whole, frac := math.Modf(days)
+	t := epoch.AddDate(0, 0, int(whole)).Add(time.Duration(math.Round(frac*86400)) * time.Second)
+	if frac == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
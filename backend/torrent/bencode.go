@@ -0,0 +1,61 @@
+package torrent
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// dict is a bencoded dictionary; its keys are written in sorted order as
+// the format requires
+type dict map[string]any
+
+// encode bencodes strings, byte strings, integers, lists and dictionaries
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.WriteString(v)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)))
+		buf.WriteByte(':')
+		buf.Write(v)
+	case int:
+		fmt.Fprintf(buf, "i%de", v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case []string:
+		buf.WriteByte('l')
+		for _, s := range v {
+			_ = encode(buf, s)
+		}
+		buf.WriteByte('e')
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case dict:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			_ = encode(buf, key)
+			if err := encode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package torrent makes BitTorrent metainfo (.torrent) files. Torrents list
+// web seeds (BEP 19), HTTP servers clients fetch pieces from when no peer
+// has them, so that a torrent works before anyone else seeds it.
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"time"
+)
+
+// Piece lengths torrents are cut into, chosen to make about targetPieces
+// pieces
+const (
+	minPieceLength = 256 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	targetPieces   = 2000
+)
+
+// File is a file of a torrent, its path relative to the torrent's top
+// directory split into its elements
+type File struct {
+	Path []string
+	Size int64
+}
+
+// Options are the parts of a torrent besides its files
+type Options struct {
+	Name      string   // the file's name, or the top directory's
+	Trackers  []string // announce URLs; without any, peers are found through the DHT
+	WebSeeds  []string
+	Comment   string
+	CreatedBy string
+}
+
+// Torrent is a made .torrent file
+type Torrent struct {
+	Data        []byte
+	InfoHash    string // hex SHA-1 of the info dictionary, as in magnet links
+	PieceLength int64
+	Pieces      int
+	Size        int64
+}
+
+// Open opens a file of a torrent for reading
+type Open func(f File) (io.ReadCloser, error)
+
+// PieceLength picks the piece length of a torrent of total bytes: a power
+// of two between 256 KiB and 16 MiB
+func PieceLength(total int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// Create hashes files, in order, into pieces and returns the torrent. A
+// single file with an empty path makes a single-file torrent named
+// opts.Name. progress is told how many bytes were read as hashing goes.
+// Files whose size differs from the one given fail it, as their pieces
+// would not match what the web seeds serve.
+func Create(ctx context.Context, opts Options, files []File, open Open, progress func(n int64)) (*Torrent, error) {
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+	pieceLength := PieceLength(total)
+
+	buf := make([]byte, pieceLength)
+	fill := 0
+	var pieces []byte
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		rc, err := open(f)
+		if err != nil {
+			return nil, err
+		}
+		var read int64
+		for {
+			n, err := rc.Read(buf[fill:])
+			fill += n
+			read += int64(n)
+			if n > 0 && progress != nil {
+				progress(int64(n))
+			}
+			if fill == len(buf) {
+				sum := sha1.Sum(buf)
+				pieces = append(pieces, sum[:]...)
+				fill = 0
+				if err := ctx.Err(); err != nil {
+					rc.Close()
+					return nil, err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+		}
+		rc.Close()
+		if read != f.Size {
+			return nil, fmt.Errorf("%s changed while it was hashed", path.Join(append([]string{opts.Name}, f.Path...)...))
+		}
+	}
+	if fill > 0 {
+		sum := sha1.Sum(buf[:fill])
+		pieces = append(pieces, sum[:]...)
+	}
+
+	info := dict{
+		"name":         opts.Name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	if len(files) == 1 && len(files[0].Path) == 0 {
+		info["length"] = files[0].Size
+	} else {
+		list := make([]any, len(files))
+		for i, f := range files {
+			list[i] = dict{"length": f.Size, "path": f.Path}
+		}
+		info["files"] = list
+	}
+
+	var infoData bytes.Buffer
+	if err := encode(&infoData, info); err != nil {
+		return nil, err
+	}
+	infoHash := sha1.Sum(infoData.Bytes())
+
+	meta := dict{
+		"info":          info,
+		"creation date": time.Now().Unix(),
+	}
+	if len(opts.Trackers) > 0 {
+		meta["announce"] = opts.Trackers[0]
+		tiers := make([]any, len(opts.Trackers))
+		for i, tracker := range opts.Trackers {
+			tiers[i] = []string{tracker}
+		}
+		meta["announce-list"] = tiers
+	}
+	if len(opts.WebSeeds) > 0 {
+		meta["url-list"] = opts.WebSeeds
+	}
+	if opts.Comment != "" {
+		meta["comment"] = opts.Comment
+	}
+	if opts.CreatedBy != "" {
+		meta["created by"] = opts.CreatedBy
+	}
+
+	var data bytes.Buffer
+	if err := encode(&data, meta); err != nil {
+		return nil, err
+	}
+	return &Torrent{
+		Data:        data.Bytes(),
+		InfoHash:    hex.EncodeToString(infoHash[:]),
+		PieceLength: pieceLength,
+		Pieces:      len(pieces) / sha1.Size,
+		Size:        total,
+	}, nil
+}
@@ -2,14 +2,54 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
 )
 
+// ResolveFS resolves a user path to the filesystem that stores it and the
+// name to use with that filesystem. Paths under a mount are served by the
+// mounted backend and named by their cleaned user path; local paths go
+// through SafeResolve.
+func ResolveFS(userPath string) (vfs.Filesystem, string, error) {
+	if fsys, name, ok := lookupMount(userPath); ok {
+		return fsys, name, nil
+	}
+
+	safePath, err := SafeResolve(userPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return vfs.OS, safePath, nil
+}
+
+// ResolveLinkFS is ResolveFS for operations on a link itself, like
+// SafeResolveLink
+func ResolveLinkFS(userPath string) (vfs.Filesystem, string, error) {
+	if fsys, name, ok := lookupMount(userPath); ok {
+		return fsys, name, nil
+	}
+
+	safePath, err := SafeResolveLink(userPath)
+	if err != nil {
+		return nil, "", err
+	}
+	return vfs.OS, safePath, nil
+}
+
+// lookupMount finds the mounted backend serving userPath, if any
+func lookupMount(userPath string) (vfs.Filesystem, string, bool) {
+	name := path.Clean("/" + strings.TrimPrefix(userPath, "/"))
+	fsys, _, ok := vfs.Lookup(name)
+	return fsys, name, ok
+}
+
 // SafeResolve safely resolves a user path within the root directory
 func SafeResolve(userPath string) (string, error) {
 	if userPath == "" {
@@ -19,6 +59,15 @@ func SafeResolve(userPath string) (string, error) {
 	// Normalize the user path
 	userPath = filepath.Clean("/" + strings.TrimPrefix(userPath, "/"))
 
+	// Mounted paths are not on the local disk; callers that support other
+	// backends use ResolveFS instead
+	if _, _, ok := vfs.Lookup(filepath.ToSlash(userPath)); ok {
+		return "", errors.New("not supported on mounted storage")
+	}
+
+	// Map Unicode variants of existing names to their on-disk spelling
+	userPath = normalizePath(config.RootDir, userPath)
+
 	// Join with root directory
 	fullPath := filepath.Join(config.RootDir, userPath)
 
@@ -34,13 +83,208 @@ func SafeResolve(userPath string) (string, error) {
 	}
 
 	// Check if the path is within the root directory
-	if !strings.HasPrefix(absPath+string(filepath.Separator), absRoot+string(filepath.Separator)) && absPath != absRoot {
+	if !IsWithin(absRoot, absPath) {
 		return "", errors.New("path traversal blocked")
 	}
 
+	// Apply the symlink policy to every component of the path
+	if err := checkSymlinks(absRoot, absPath); err != nil {
+		return "", err
+	}
+
 	return absPath, nil
 }
 
+// ToUserPath converts a resolved path back into the "/"-rooted path clients use
+func ToUserPath(absPath string) string {
+	absRoot, err := filepath.Abs(config.RootDir)
+	if err != nil {
+		return "/"
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// SafeResolveLink resolves a user path like SafeResolve, but allows the final
+// component to be a symlink. Use it for operations that act on the link itself
+// (delete, rename) rather than on its target.
+func SafeResolveLink(userPath string) (string, error) {
+	userPath = filepath.Clean("/" + strings.TrimPrefix(userPath, "/"))
+	if userPath == "/" {
+		return SafeResolve(userPath)
+	}
+
+	// Only the parent chain is subject to the symlink policy
+	parentPath, err := SafeResolve(filepath.Dir(userPath))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(parentPath, MatchExisting(parentPath, filepath.Base(userPath))), nil
+}
+
+// IsSymlink checks if the path itself is a symlink (without following it)
+func IsSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// IsWithin reports whether path equals root or lies beneath it
+func IsWithin(root, path string) bool {
+	return path == root || strings.HasPrefix(path+string(filepath.Separator), root+string(filepath.Separator))
+}
+
+// CheckRealPath applies the symlink policy to a local path reached by walking
+// a directory, whose own entries SafeResolve has not seen. Under the follow
+// policy a symlink must resolve inside the root; otherwise it is refused.
+func CheckRealPath(absPath string) error {
+	absRoot, err := filepath.Abs(config.RootDir)
+	if err != nil {
+		return err
+	}
+	if !IsWithin(absRoot, absPath) {
+		return errors.New("path traversal blocked")
+	}
+	return checkSymlinks(absRoot, absPath)
+}
+
+// checkSymlinks enforces config.SymlinkPolicy for absPath. With the follow
+// policy the real (symlink-free) path must stay inside the real root; with the
+// show and hide policies no component below the root may be a symlink.
+func checkSymlinks(absRoot, absPath string) error {
+	if config.SymlinkPolicy == config.SymlinkFollow {
+		realRoot, err := filepath.EvalSymlinks(absRoot)
+		if err != nil {
+			realRoot = absRoot
+		}
+
+		realPath, err := realPath(absPath)
+		if err != nil {
+			return errors.New("unresolvable symlink")
+		}
+
+		if !IsWithin(realRoot, realPath) {
+			return errors.New("symlink escapes root directory")
+		}
+		return nil
+	}
+
+	rel, err := filepath.Rel(absRoot, absPath)
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	current := absRoot
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			// The rest of the path does not exist yet
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errors.New("symlink traversal blocked")
+		}
+	}
+
+	return nil
+}
+
+// realPath resolves symlinks in path. Trailing components that do not exist yet
+// (e.g. the destination of a copy) are appended to the resolved existing prefix.
+func realPath(path string) (string, error) {
+	existing := path
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
+// reservedNames are device names Windows won't let a file have, with or
+// without an extension; such files can't be synced or unpacked there
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// ValidateFileName checks that name is a single, safe path component. Every
+// handler that names a new file or directory uses it; anything else, such as
+// Unicode, spaces, parentheses or '#', is allowed.
+func ValidateFileName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return errors.New("name is empty or reserved")
+	}
+	if strings.ContainsAny(name, "/\\\x00") {
+		return errors.New("name must not contain path separators or NUL bytes")
+	}
+	if len(name) > 255 {
+		return errors.New("name is too long")
+	}
+	stem, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return errors.New("name is reserved on Windows")
+	}
+	return nil
+}
+
+// ValidateNewPath checks the last component of a user path that is about to
+// be created, like the destination of a copy or move
+func ValidateNewPath(userPath string) error {
+	userPath = path.Clean("/" + strings.TrimPrefix(userPath, "/"))
+	if userPath == "/" {
+		return errors.New("name is empty or reserved")
+	}
+	return ValidateFileName(path.Base(userPath))
+}
+
+// UniquePath returns path if nothing exists there yet, otherwise the first free
+// variant of the form "name (1).ext", "name (2).ext", ...
+func UniquePath(path string) string {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path
+	}
+
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	if name == "" {
+		// Dotfiles like ".env" have no extension to preserve
+		name, ext = base, ""
+	}
+
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", name, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
 // EncodePathForURL encodes a file system path for safe use in URLs
 func EncodePathForURL(userPath string) string {
 	if userPath == "" {
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+
+	"nextbrowse-backend/config"
+)
+
+// Excluded reports whether a file or directory name matches one of
+// HIDDEN_PATTERNS. Excluded entries are hidden from listings and walks, and
+// left out of ZIP downloads and item counts, along with everything below
+// them.
+func Excluded(name string) bool {
+	for _, pattern := range config.HiddenPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Hidden reports whether a name is kept out of listings and walks: dotfiles
+// and excluded names
+func Hidden(name string) bool {
+	return strings.HasPrefix(name, ".") || Excluded(name)
+}
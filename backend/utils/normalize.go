@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// NormalizeName converts a file name to the configured normalization form
+func NormalizeName(name string) string {
+	switch config.PathNormalization {
+	case config.NormalizeNFC:
+		return norm.NFC.String(name)
+	case config.NormalizeNFD:
+		return norm.NFD.String(name)
+	default:
+		return name
+	}
+}
+
+// SameName reports whether two file names are equal after normalization, so
+// that an NFD name from a macOS client matches the NFC name stored on disk
+func SameName(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if config.PathNormalization == config.NormalizeNone {
+		return false
+	}
+	return norm.NFC.String(a) == norm.NFC.String(b)
+}
+
+// MatchExisting returns the on-disk spelling of name inside dir. If no entry
+// exists under exactly that name, an entry whose name differs only in Unicode
+// normalization is returned; otherwise name is returned in the configured form
+// so new files are created consistently.
+func MatchExisting(dir, name string) string {
+	if config.PathNormalization == config.NormalizeNone {
+		return name
+	}
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		return name
+	}
+
+	normalized := NormalizeName(name)
+	if normalized != name {
+		if _, err := os.Lstat(filepath.Join(dir, normalized)); err == nil {
+			return normalized
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return normalized
+	}
+	for _, entry := range entries {
+		if SameName(entry.Name(), name) {
+			return entry.Name()
+		}
+	}
+	return normalized
+}
+
+// JoinName joins a new or existing entry name onto dir for fsys, matching
+// Unicode variants of existing names on the local disk
+func JoinName(fsys vfs.Filesystem, dir, name string) string {
+	if vfs.IsLocal(fsys) {
+		return filepath.Join(dir, MatchExisting(dir, name))
+	}
+	return path.Join(dir, NormalizeName(name))
+}
+
+// normalizePath maps each component of a cleaned, "/"-rooted user path to its
+// on-disk spelling under root
+func normalizePath(root, userPath string) string {
+	if config.PathNormalization == config.NormalizeNone || userPath == "/" {
+		return userPath
+	}
+
+	dir := root
+	parts := strings.Split(strings.TrimPrefix(userPath, "/"), "/")
+	for i, part := range parts {
+		parts[i] = MatchExisting(dir, part)
+		dir = filepath.Join(dir, parts[i])
+	}
+	return "/" + strings.Join(parts, "/")
+}
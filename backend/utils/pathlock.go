@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPathLocked is returned when a path is locked by a conflicting operation
+var ErrPathLocked = errors.New("path is locked by another operation")
+
+// PathLock describes a lock on a resolved path and everything beneath it.
+// Shared locks are compatible with each other; an exclusive lock conflicts
+// with any lock on the same path, an ancestor, or a descendant.
+type PathLock struct {
+	Path      string
+	Exclusive bool
+}
+
+// ReadLock returns a shared lock on path (e.g. the source of a copy)
+func ReadLock(path string) PathLock {
+	return PathLock{Path: path}
+}
+
+// WriteLock returns an exclusive lock on path (e.g. a delete target)
+func WriteLock(path string) PathLock {
+	return PathLock{Path: path, Exclusive: true}
+}
+
+var (
+	heldLocks      = make(map[*PathLock]struct{})
+	heldLocksMutex sync.Mutex
+)
+
+// TryLockPaths acquires all requested locks at once, or none of them if any
+// conflicts with a lock held by another operation. It never blocks. The
+// returned function releases the locks.
+func TryLockPaths(locks ...PathLock) (func(), error) {
+	heldLocksMutex.Lock()
+	defer heldLocksMutex.Unlock()
+
+	for i := range locks {
+		for held := range heldLocks {
+			if locksConflict(&locks[i], held) {
+				return nil, ErrPathLocked
+			}
+		}
+	}
+
+	acquired := make([]*PathLock, len(locks))
+	for i := range locks {
+		lock := locks[i]
+		acquired[i] = &lock
+		heldLocks[&lock] = struct{}{}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			heldLocksMutex.Lock()
+			defer heldLocksMutex.Unlock()
+
+			for _, lock := range acquired {
+				delete(heldLocks, lock)
+			}
+		})
+	}, nil
+}
+
+// locksConflict reports whether two locks overlap and at least one is exclusive
+func locksConflict(a, b *PathLock) bool {
+	if !a.Exclusive && !b.Exclusive {
+		return false
+	}
+	return IsWithin(a.Path, b.Path) || IsWithin(b.Path, a.Path)
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/vfs"
+)
+
+// Snapshot is a read-only copy of the root directory taken by the filesystem
+type Snapshot struct {
+	ID   string
+	Name string
+	Kind string // "zfs" or "btrfs"
+	Time time.Time
+	// Root is where the root directory lives inside the snapshot
+	Root string
+}
+
+// ListSnapshots returns the available snapshots, newest first
+func ListSnapshots() []Snapshot {
+	var snapshots []Snapshot
+	if config.SnapshotZFS {
+		snapshots = append(snapshots, zfsSnapshots()...)
+	}
+	for _, dir := range config.SnapshotDirs {
+		snapshots = append(snapshots, dirSnapshots(dir)...)
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].Time.After(snapshots[j].Time) })
+	return snapshots
+}
+
+// FindSnapshot returns the snapshot with the given ID
+func FindSnapshot(id string) (Snapshot, bool) {
+	for _, snapshot := range ListSnapshots() {
+		if snapshot.ID == id {
+			return snapshot, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// zfsSnapshots lists the snapshots of the ZFS dataset holding the root
+// directory, found through the .zfs/snapshot directory at the dataset's
+// mountpoint. Datasets nested below the root aren't included.
+func zfsSnapshots() []Snapshot {
+	root, err := filepath.EvalSymlinks(config.RootDir)
+	if err != nil {
+		return nil
+	}
+
+	for mountpoint := root; ; mountpoint = filepath.Dir(mountpoint) {
+		snapDir := filepath.Join(mountpoint, ".zfs", "snapshot")
+		if info, err := os.Stat(snapDir); err == nil && info.IsDir() {
+			rel, _ := filepath.Rel(mountpoint, root)
+			return readSnapshots(snapDir, "zfs", func(name string) string {
+				return filepath.Join(snapDir, name, rel)
+			})
+		}
+		if mountpoint == filepath.Dir(mountpoint) {
+			return nil
+		}
+	}
+}
+
+// dirSnapshots lists the snapshots in a configured snapshot directory. Snapper
+// layouts, which keep each snapshot in "<number>/snapshot", are recognized.
+func dirSnapshots(dir config.SnapshotDir) []Snapshot {
+	return readSnapshots(dir.Dir, "btrfs", func(name string) string {
+		base := filepath.Join(dir.Dir, name)
+		if info, err := os.Stat(filepath.Join(base, "snapshot")); err == nil && info.IsDir() {
+			base = filepath.Join(base, "snapshot")
+		}
+		return filepath.Join(base, dir.Subpath)
+	})
+}
+
+// readSnapshots turns each subdirectory of dir into a snapshot
+func readSnapshots(dir, kind string, rootOf func(name string) string) []Snapshot {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			ID:   kind + ":" + entry.Name(),
+			Name: entry.Name(),
+			Kind: kind,
+			Time: info.ModTime(),
+			Root: rootOf(entry.Name()),
+		})
+	}
+	return snapshots
+}
+
+// ResolveInSnapshot maps a user path to its location inside a snapshot.
+// Symlinks are never followed inside snapshots, whatever the symlink policy,
+// since their targets point back into the live tree or outside the root.
+func ResolveInSnapshot(snapshot Snapshot, userPath string) (string, error) {
+	userPath = filepath.Clean("/" + strings.TrimPrefix(userPath, "/"))
+
+	// Snapshots only cover the local disk
+	if _, _, ok := vfs.Lookup(filepath.ToSlash(userPath)); ok {
+		return "", errors.New("mounted storage has no snapshots")
+	}
+
+	fullPath := filepath.Join(snapshot.Root, userPath)
+	if !IsWithin(snapshot.Root, fullPath) {
+		return "", errors.New("path traversal blocked")
+	}
+
+	rel, _ := filepath.Rel(snapshot.Root, fullPath)
+	if rel == "." {
+		return fullPath, nil
+	}
+	current := snapshot.Root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			break
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New("symlink traversal blocked")
+		}
+	}
+	return fullPath, nil
+}
@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// AtomicSuffix ends the names of files still being written by CreateAtomic
+const AtomicSuffix = ".partial"
+
+// maxAtomicBase keeps temporary names within the usual 255-byte limit
+const maxAtomicBase = 200
+
+// AtomicFile is a local file written under a temporary name next to its
+// destination and renamed over it on Close, so a crash or a failed upload
+// never leaves a truncated file at the destination. Abort discards it.
+type AtomicFile struct {
+	*os.File
+	dst  string
+	done bool
+}
+
+// CreateAtomic starts writing dst. A new file gets perm (less the umask); a
+// replaced file keeps its current permissions.
+func CreateAtomic(dst string, perm fs.FileMode) (*AtomicFile, error) {
+	if info, err := os.Stat(dst); err == nil {
+		if info.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: dst, Err: errIsDir}
+		}
+		perm = info.Mode().Perm()
+	}
+
+	base := filepath.Base(dst)
+	if len(base) > maxAtomicBase {
+		base = base[:maxAtomicBase]
+	}
+	for range 10 {
+		suffix := make([]byte, 6)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		name := filepath.Join(filepath.Dir(dst), "."+base+"."+hex.EncodeToString(suffix)+AtomicSuffix)
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &AtomicFile{File: file, dst: dst}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: dst, Err: fs.ErrExist}
+}
+
+// Close flushes the file to disk and moves it into place
+func (f *AtomicFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	err := f.File.Sync()
+	if closeErr := f.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.File.Name(), f.dst)
+	}
+	if err != nil {
+		os.Remove(f.File.Name())
+	}
+	return err
+}
+
+// Abort discards the file, leaving the destination untouched
+func (f *AtomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
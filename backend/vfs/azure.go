@@ -0,0 +1,222 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// azureCopyPollInterval is how often a pending server-side copy is checked
+const azureCopyPollInterval = 500 * time.Millisecond
+
+// NewAzure creates a backend for an Azure Blob Storage container from a URL
+// of the form
+//
+//	azblob://[account:key@]container[/prefix]?endpoint=https://host/account
+//
+// Without credentials in the URL they are read from AZURE_STORAGE_ACCOUNT and
+// AZURE_STORAGE_KEY. The key must be URL-encoded when given in the URL. The
+// endpoint defaults to https://<account>.blob.core.windows.net.
+func NewAzure(mountPath string, u *url.URL) (*ObjectStore, error) {
+	if u.Host == "" {
+		return nil, errors.New("azblob mount requires a container name")
+	}
+
+	account, key := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY")
+	if u.User != nil {
+		account = u.User.Username()
+		key, _ = u.User.Password()
+	}
+	if account == "" || key == "" {
+		return nil, errors.New("azblob mount requires an account name and key")
+	}
+
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+
+	cred, err := container.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, err
+	}
+	client, err := container.NewClientWithSharedKeyCredential(strings.TrimSuffix(endpoint, "/")+"/"+u.Host, cred, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newObjectStore(&azureClient{client: client}, u.Path, mountPath), nil
+}
+
+// azureClient is the objectClient for Azure Blob Storage, storing files as
+// block blobs
+type azureClient struct {
+	client *container.Client
+}
+
+func convertAzureErr(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ResourceNotFound):
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	case bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists):
+		return fmt.Errorf("%w: %v", fs.ErrExist, err)
+	}
+
+	// HEAD responses carry no error code in the body
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == 404 {
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+func (c *azureClient) stat(ctx context.Context, key string) (objectInfo, error) {
+	props, err := c.client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return objectInfo{}, convertAzureErr(err)
+	}
+
+	info := objectInfo{key: key}
+	if props.ContentLength != nil {
+		info.size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.mtime = *props.LastModified
+	}
+	return info, nil
+}
+
+func (c *azureClient) get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := c.client.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: length},
+	})
+	if err != nil {
+		return nil, convertAzureErr(err)
+	}
+	return resp.Body, nil
+}
+
+func (c *azureClient) put(ctx context.Context, key string, r io.Reader, size int64, exclusive bool) error {
+	opts := &blockblob.UploadStreamOptions{}
+	if exclusive {
+		// Fail if another writer created the blob in the meantime
+		etag := azcore.ETagAny
+		opts.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etag},
+		}
+	}
+	_, err := c.client.NewBlockBlobClient(key).UploadStream(ctx, r, opts)
+	return convertAzureErr(err)
+}
+
+func (c *azureClient) list(ctx context.Context, prefix string, recursive bool, fn func(objectInfo) error) error {
+	emit := func(items []*container.BlobItem, prefixes []*container.BlobPrefix) error {
+		for _, item := range items {
+			info := objectInfo{key: *item.Name}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					info.size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					info.mtime = *item.Properties.LastModified
+				}
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		for _, p := range prefixes {
+			if err := fn(objectInfo{key: *p.Name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if recursive {
+		pager := c.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+		for err == nil && pager.More() {
+			var page container.ListBlobsFlatResponse
+			if page, err = pager.NextPage(ctx); err == nil {
+				err = emit(page.Segment.BlobItems, nil)
+			}
+		}
+	} else {
+		pager := c.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+		for err == nil && pager.More() {
+			var page container.ListBlobsHierarchyResponse
+			if page, err = pager.NextPage(ctx); err == nil {
+				err = emit(page.Segment.BlobItems, page.Segment.BlobPrefixes)
+			}
+		}
+	}
+
+	if err == errStopListing {
+		return nil
+	}
+	return convertAzureErr(err)
+}
+
+func (c *azureClient) remove(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		_, err := c.client.NewBlobClient(key).Delete(ctx, nil)
+		if err = convertAzureErr(err); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copy starts a server-side copy and waits for it to finish. Copies within
+// one storage account are authorized by the account key.
+func (c *azureClient) copy(ctx context.Context, srcKey, dstKey string, size int64) error {
+	src := c.client.NewBlobClient(srcKey)
+	dst := c.client.NewBlobClient(dstKey)
+
+	resp, err := dst.StartCopyFromURL(ctx, src.URL(), nil)
+	if err != nil {
+		return convertAzureErr(err)
+	}
+
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			if resp.CopyID != nil {
+				_, _ = dst.AbortCopyFromURL(context.Background(), *resp.CopyID, nil)
+			}
+			return ctx.Err()
+		case <-time.After(azureCopyPollInterval):
+		}
+
+		props, err := dst.GetProperties(ctx, nil)
+		if err != nil {
+			return convertAzureErr(err)
+		}
+		status = props.CopyStatus
+		if status != nil && *status != blob.CopyStatusTypePending && *status != blob.CopyStatusTypeSuccess {
+			description := ""
+			if props.CopyStatusDescription != nil {
+				description = *props.CopyStatusDescription
+			}
+			return fmt.Errorf("copy %s: %s", *status, description)
+		}
+	}
+	return nil
+}
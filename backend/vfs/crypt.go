@@ -0,0 +1,557 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+
+	"nextbrowse-backend/buffers"
+)
+
+// Layout of encrypted files: a header of cryptMagic and a random salt, then
+// the contents in chunks of cryptChunkSize bytes, each sealed with AES-GCM
+// under a key derived from the mount's key and the salt. A chunk's nonce is
+// its index and whether it is the last, so chunks can't be reordered, and a
+// file can't be truncated at a chunk boundary without it showing.
+const (
+	cryptMagic      = "NBCRYPT1"
+	cryptSaltSize   = 32
+	cryptHeaderSize = len(cryptMagic) + cryptSaltSize
+	cryptChunkSize  = 64 * 1024
+	cryptTagSize    = 16
+	cryptMinKeySize = 16
+)
+
+var (
+	errNotEncrypted = errors.New("not an encrypted file")
+	errDecrypt      = errors.New("decryption failed: wrong key or damaged file")
+)
+
+// nameEncoding spells encrypted names in lower-case letters and digits, so
+// they survive case-insensitive disks
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// Encrypted keeps the files of another backend encrypted, so that the disk
+// or bucket only ever holds ciphertext while the API serves plaintext. Names
+// can be encrypted too; sizes, modification times and the shape of the tree
+// are not hidden.
+type Encrypted struct {
+	inner     Filesystem
+	mountPath string
+	key       []byte
+	nameKey   []byte // nil when names are kept in the clear
+	nameMAC   []byte
+}
+
+// NewEncrypted creates an encrypted backend from a URL of the form
+//
+//	crypt+<backend URL>?keyFile=/path/to/key[&names=encrypt]
+//
+// where the backend is file:///absolute/path for a local directory, or any
+// other mountable backend. The key file holds at least 16 bytes of secret
+// key material, e.g. from `openssl rand -base64 32`; losing it loses the
+// files. names=encrypt encrypts file and directory names as well.
+func NewEncrypted(mountPath string, u *url.URL) (*Encrypted, error) {
+	query := u.Query()
+	keyFile, names := query.Get("keyFile"), query.Get("names")
+	query.Del("keyFile")
+	query.Del("names")
+	inner := *u
+	inner.Scheme = strings.TrimPrefix(u.Scheme, "crypt+")
+	inner.RawQuery = query.Encode()
+
+	if keyFile == "" {
+		return nil, errors.New("encrypted mount requires keyFile")
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimSpace(key)
+	if len(key) < cryptMinKeySize {
+		return nil, fmt.Errorf("key file must hold at least %d bytes", cryptMinKeySize)
+	}
+
+	e := &Encrypted{mountPath: mountPath, key: key}
+	switch names {
+	case "", "plain":
+	case "encrypt":
+		e.nameKey = deriveKey(key, nil, "nextbrowse name encryption")
+		e.nameMAC = deriveKey(key, nil, "nextbrowse name authentication")
+	default:
+		return nil, fmt.Errorf("names must be plain or encrypt, not %q", names)
+	}
+
+	switch {
+	case inner.Scheme == "file":
+		e.inner, err = newDirFS(mountPath, &inner)
+	case strings.HasPrefix(inner.Scheme, "crypt+"):
+		err = errors.New("encrypted mounts can't be nested")
+	default:
+		e.inner, err = New(mountPath, inner.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func deriveKey(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		panic(err) // hkdf yields far more than 32 bytes
+	}
+	return key
+}
+
+// fileCipher is the AEAD of the file with the given salt
+func (e *Encrypted) fileCipher(salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(e.key, salt, "nextbrowse file"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the nonce of the chunk at index
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], uint64(index))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// plainSize is the size of the contents of an encrypted file of size bytes
+func plainSize(size int64) int64 {
+	size -= int64(cryptHeaderSize)
+	if size < cryptTagSize {
+		return 0
+	}
+	chunks := (size + cryptChunkSize + cryptTagSize - 1) / (cryptChunkSize + cryptTagSize)
+	return size - chunks*cryptTagSize
+}
+
+// encryptedSize is the size of the encrypted file of size bytes of contents;
+// an empty file is a single empty chunk. An unknown size, below 0, stays
+// unknown.
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	chunks := size / cryptChunkSize
+	if size%cryptChunkSize != 0 || size == 0 {
+		chunks++
+	}
+	return int64(cryptHeaderSize) + size + chunks*cryptTagSize
+}
+
+// innerName maps a user path to the path of its ciphertext on the backend
+func (e *Encrypted) innerName(name string) string {
+	if e.nameKey == nil {
+		return name
+	}
+	rel := strings.Trim(strings.TrimPrefix(filepath.ToSlash(name), e.mountPath), "/")
+	if rel == "" {
+		return e.mountPath
+	}
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		parts[i] = e.encryptName(part)
+	}
+	return path.Join(e.mountPath, path.Join(parts...))
+}
+
+// encryptName encrypts a name deterministically, so that it can be looked
+// up: the IV is a MAC of the name, which also authenticates it
+func (e *Encrypted) encryptName(name string) string {
+	mac := hmac.New(sha256.New, e.nameMAC)
+	mac.Write([]byte(name))
+	iv := mac.Sum(nil)[:aes.BlockSize]
+
+	block, _ := aes.NewCipher(e.nameKey)
+	sealed := make([]byte, aes.BlockSize+len(name))
+	copy(sealed, iv)
+	cipher.NewCTR(block, iv).XORKeyStream(sealed[aes.BlockSize:], []byte(name))
+	return strings.ToLower(nameEncoding.EncodeToString(sealed))
+}
+
+// plainName is the user-visible name of a name on the backend
+func (e *Encrypted) plainName(name string) (string, error) {
+	if e.nameKey == nil {
+		return name, nil
+	}
+	sealed, err := nameEncoding.DecodeString(strings.ToUpper(name))
+	if err != nil || len(sealed) <= aes.BlockSize {
+		return "", errDecrypt
+	}
+	iv := sealed[:aes.BlockSize]
+	block, _ := aes.NewCipher(e.nameKey)
+	plain := make([]byte, len(sealed)-aes.BlockSize)
+	cipher.NewCTR(block, iv).XORKeyStream(plain, sealed[aes.BlockSize:])
+
+	mac := hmac.New(sha256.New, e.nameMAC)
+	mac.Write(plain)
+	if !hmac.Equal(mac.Sum(nil)[:aes.BlockSize], iv) {
+		return "", errDecrypt
+	}
+	return string(plain), nil
+}
+
+func (e *Encrypted) Open(name string) (File, error) {
+	info, err := e.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{fsys: e, name: name, info: info}, nil
+	}
+
+	file, err := e.inner.Open(e.innerName(name))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := e.newCryptReader(file)
+	if err != nil {
+		file.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &cryptFile{
+		SectionReader: io.NewSectionReader(reader, 0, info.Size()),
+		file:          file,
+		info:          info,
+	}, nil
+}
+
+// OpenFile opens name for reading, or for writing through a local temporary
+// file that is encrypted and stored when the file is closed, so appends and
+// partial writes rewrite the whole file. The temporary file holds plaintext.
+func (e *Encrypted) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if !isWrite(flag) {
+		return e.Open(name)
+	}
+	return openForWrite(e, name, flag)
+}
+
+func (e *Encrypted) fetch(name string, w io.Writer) error {
+	file, err := e.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = buffers.Copy(w, file)
+	return err
+}
+
+func (e *Encrypted) store(name string, r io.Reader, size int64, exclusive bool) error {
+	target := e.innerName(name)
+	if exclusive {
+		if _, err := e.inner.Stat(target); err == nil {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := e.newSealWriter(pw)
+		if err == nil {
+			var n int64
+			n, err = buffers.Copy(w, r)
+			if err == nil && size >= 0 && n != size {
+				err = fmt.Errorf("expected %d bytes, got %d", size, n)
+			}
+			if err == nil {
+				err = w.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	err := WriteFrom(e.inner, target, pr, encryptedSize(size))
+	pr.Close()
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Upload encrypts r into name as it is read
+func (e *Encrypted) Upload(name string, r io.Reader, size int64) error {
+	return e.store(name, r, size, false)
+}
+
+func (e *Encrypted) Stat(name string) (fs.FileInfo, error) {
+	info, err := e.inner.Stat(e.innerName(name))
+	if err != nil {
+		return nil, err
+	}
+	return e.plainInfo(info, path.Base(filepath.ToSlash(name))), nil
+}
+
+func (e *Encrypted) Lstat(name string) (fs.FileInfo, error) {
+	info, err := e.inner.Lstat(e.innerName(name))
+	if err != nil {
+		return nil, err
+	}
+	return e.plainInfo(info, path.Base(filepath.ToSlash(name))), nil
+}
+
+func (e *Encrypted) plainInfo(info fs.FileInfo, name string) fs.FileInfo {
+	size := info.Size()
+	if info.Mode().IsRegular() {
+		size = plainSize(size)
+	}
+	return &cryptFileInfo{FileInfo: info, name: name, size: size}
+}
+
+// ReadDir lists the files and directories of name. Entries whose names don't
+// decrypt, files still being written, and anything other than files and
+// directories are left out.
+func (e *Encrypted) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := e.inner.ReadDir(e.innerName(name))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && !entry.Type().IsRegular() || strings.HasSuffix(entry.Name(), AtomicSuffix) {
+			continue
+		}
+		plain, err := e.plainName(entry.Name())
+		if err != nil {
+			continue
+		}
+		result = append(result, &cryptDirEntry{DirEntry: entry, fsys: e, name: plain})
+	}
+	return result, nil
+}
+
+func (e *Encrypted) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func (e *Encrypted) Mkdir(name string, perm fs.FileMode) error {
+	return e.inner.Mkdir(e.innerName(name), perm)
+}
+
+func (e *Encrypted) MkdirAll(name string, perm fs.FileMode) error {
+	return e.inner.MkdirAll(e.innerName(name), perm)
+}
+
+func (e *Encrypted) Rename(oldname, newname string) error {
+	return e.inner.Rename(e.innerName(oldname), e.innerName(newname))
+}
+
+// Copy copies the ciphertext within the backend; files are keyed by their
+// salt, not their path, so copies decrypt just the same
+func (e *Encrypted) Copy(src, dst string) error {
+	copier, ok := e.inner.(Copier)
+	if !ok {
+		return errors.New("storage backend does not support copying")
+	}
+	return copier.Copy(e.innerName(src), e.innerName(dst))
+}
+
+func (e *Encrypted) Remove(name string) error    { return e.inner.Remove(e.innerName(name)) }
+func (e *Encrypted) RemoveAll(name string) error { return e.inner.RemoveAll(e.innerName(name)) }
+
+// cryptFileInfo describes a file by its plaintext name and size
+type cryptFileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+func (i *cryptFileInfo) Name() string { return i.name }
+func (i *cryptFileInfo) Size() int64  { return i.size }
+
+type cryptDirEntry struct {
+	fs.DirEntry
+	fsys *Encrypted
+	name string
+}
+
+func (d *cryptDirEntry) Name() string { return d.name }
+
+func (d *cryptDirEntry) Info() (fs.FileInfo, error) {
+	info, err := d.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return d.fsys.plainInfo(info, d.name), nil
+}
+
+// cryptReader decrypts an encrypted file a chunk at a time, keeping the last
+// chunk it decrypted for the reads that follow
+type cryptReader struct {
+	file   File
+	aead   cipher.AEAD
+	size   int64 // of the ciphertext
+	chunks int64
+
+	mu     sync.Mutex
+	index  int64
+	plain  []byte
+	sealed []byte
+}
+
+func (e *Encrypted) newCryptReader(file File) (*cryptReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, cryptHeaderSize)
+	if n, _ := file.ReadAt(header, 0); n < len(header) || string(header[:len(cryptMagic)]) != cryptMagic {
+		return nil, errNotEncrypted
+	}
+	aead, err := e.fileCipher(header[len(cryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	body := info.Size() - int64(cryptHeaderSize)
+	return &cryptReader{
+		file:   file,
+		aead:   aead,
+		size:   info.Size(),
+		chunks: (body + cryptChunkSize + cryptTagSize - 1) / (cryptChunkSize + cryptTagSize),
+		index:  -1,
+		sealed: make([]byte, cryptChunkSize+cryptTagSize),
+	}, nil
+}
+
+func (r *cryptReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		index := pos / cryptChunkSize
+		if index >= r.chunks {
+			return n, io.EOF
+		}
+		chunk, err := r.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		start := pos - index*cryptChunkSize
+		if start >= int64(len(chunk)) {
+			return n, io.EOF
+		}
+		n += copy(p[n:], chunk[start:])
+	}
+	return n, nil
+}
+
+// chunk decrypts the chunk at index
+func (r *cryptReader) chunk(index int64) ([]byte, error) {
+	if index == r.index {
+		return r.plain, nil
+	}
+	start := int64(cryptHeaderSize) + index*(cryptChunkSize+cryptTagSize)
+	sealed := r.sealed[:min(cryptChunkSize+cryptTagSize, r.size-start)]
+	if n, err := r.file.ReadAt(sealed, start); n < len(sealed) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(index, index == r.chunks-1), sealed, nil)
+	if err != nil {
+		r.index = -1
+		return nil, errDecrypt
+	}
+	r.index, r.plain = index, plain
+	return plain, nil
+}
+
+// cryptFile is an encrypted file opened for reading its plaintext
+type cryptFile struct {
+	*io.SectionReader
+	file File
+	info fs.FileInfo
+}
+
+func (f *cryptFile) Close() error                       { return f.file.Close() }
+func (f *cryptFile) Stat() (fs.FileInfo, error)         { return f.info, nil }
+func (f *cryptFile) Sync() error                        { return nil }
+func (f *cryptFile) Write([]byte) (int, error)          { return 0, errReadOnlyFile }
+func (f *cryptFile) WriteAt([]byte, int64) (int, error) { return 0, errReadOnlyFile }
+func (f *cryptFile) Readdirnames(int) ([]string, error) { return nil, errNotDir }
+
+// sealWriter encrypts what is written to it into w. The last chunk is only
+// sealed on Close, as its nonce marks it as the last.
+type sealWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	index int64
+	buf   []byte
+	out   []byte
+}
+
+func (e *Encrypted) newSealWriter(w io.Writer) (*sealWriter, error) {
+	header := make([]byte, cryptHeaderSize)
+	copy(header, cryptMagic)
+	if _, err := rand.Read(header[len(cryptMagic):]); err != nil {
+		return nil, err
+	}
+	aead, err := e.fileCipher(header[len(cryptMagic):])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, cryptChunkSize),
+		out:  make([]byte, 0, cryptChunkSize+cryptTagSize),
+	}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == cryptChunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):cryptChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk; it doesn't close w
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+func (s *sealWriter) seal(last bool) error {
+	s.out = s.aead.Seal(s.out[:0], chunkNonce(s.index, last), s.buf, nil)
+	s.index++
+	s.buf = s.buf[:0]
+	_, err := s.w.Write(s.out)
+	return err
+}
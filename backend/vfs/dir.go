@@ -0,0 +1,114 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"nextbrowse-backend/buffers"
+)
+
+// dirFS is a directory on the local disk outside the root, holding the files
+// of an encrypted mount
+type dirFS struct {
+	root      string
+	mountPath string
+}
+
+// newDirFS creates a directory backend from a URL of the form
+//
+//	file:///absolute/path
+func newDirFS(mountPath string, u *url.URL) (*dirFS, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, errors.New("file mount must be a local path")
+	}
+	root := filepath.Clean(filepath.FromSlash(u.Path))
+	if !filepath.IsAbs(root) {
+		return nil, errors.New("file mount requires an absolute path")
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "mount", Path: root, Err: errNotDir}
+	}
+	return &dirFS{root: root, mountPath: mountPath}, nil
+}
+
+// local maps a user path beneath the mount to a path on disk
+func (d *dirFS) local(name string) string {
+	rel := strings.TrimPrefix(filepath.ToSlash(name), d.mountPath)
+	return filepath.Join(d.root, filepath.FromSlash(path.Clean("/"+rel)))
+}
+
+func (d *dirFS) Open(name string) (File, error) { return OS.Open(d.local(name)) }
+func (d *dirFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	return OS.OpenFile(d.local(name), flag, perm)
+}
+func (d *dirFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(d.local(name)) }
+func (d *dirFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(d.local(name)) }
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(d.local(name)) }
+func (d *dirFS) Readlink(name string) (string, error)       { return os.Readlink(d.local(name)) }
+func (d *dirFS) Mkdir(name string, perm fs.FileMode) error  { return os.Mkdir(d.local(name), perm) }
+func (d *dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(d.local(name), perm)
+}
+func (d *dirFS) Rename(oldname, newname string) error {
+	return os.Rename(d.local(oldname), d.local(newname))
+}
+func (d *dirFS) Remove(name string) error    { return os.Remove(d.local(name)) }
+func (d *dirFS) RemoveAll(name string) error { return os.RemoveAll(d.local(name)) }
+
+// Upload replaces name atomically with the bytes of r
+func (d *dirFS) Upload(name string, r io.Reader, size int64) error {
+	file, err := CreateAtomic(d.local(name), 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := buffers.Copy(file, r); err != nil {
+		file.Abort()
+		return err
+	}
+	return file.Close()
+}
+
+// Copy duplicates a file or a directory tree within the directory
+func (d *dirFS) Copy(src, dst string) error {
+	root, target := d.local(src), d.local(dst)
+	return filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		to := filepath.Join(target, rel)
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(to, 0755)
+		case !entry.Type().IsRegular():
+			return nil
+		}
+		from, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer from.Close()
+		file, err := CreateAtomic(to, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, from); err != nil {
+			file.Abort()
+			return err
+		}
+		return file.Close()
+	})
+}
@@ -0,0 +1,233 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+var (
+	errNotDir       = errors.New("not a directory")
+	errIsDir        = errors.New("is a directory")
+	errDirNotEmpty  = errors.New("directory not empty")
+	errReadOnlyFile = errors.New("file not opened for writing")
+)
+
+// wholeFileStore is implemented by remote backends that can only read and
+// write whole files. It lets them share the temp-file based write path.
+type wholeFileStore interface {
+	Filesystem
+	// fetch writes the current contents of name to w
+	fetch(name string, w io.Writer) error
+	// store replaces name with size bytes from r; exclusive fails if name
+	// was created by someone else in the meantime
+	store(name string, r io.Reader, size int64, exclusive bool) error
+}
+
+// openForWrite implements OpenFile's write path for a wholeFileStore. Writes
+// go to a local temporary file that is stored when the file is closed, so
+// appends and partial writes rewrite the whole remote file.
+func openForWrite(fsys wholeFileStore, name string, flag int) (File, error) {
+	info, err := fsys.Stat(name)
+	exists := err == nil
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	case exists && info.IsDir():
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case exists && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	tmp, err := os.CreateTemp("", "nextbrowse-vfs-*")
+	if err != nil {
+		return nil, err
+	}
+	writer := &tempWriter{
+		File:      tmp,
+		fsys:      fsys,
+		name:      name,
+		appending: flag&os.O_APPEND != 0,
+		exclusive: flag&os.O_EXCL != 0,
+	}
+
+	// Start from the current contents unless they are being replaced
+	if exists && flag&os.O_TRUNC == 0 {
+		err := fsys.fetch(name, tmp)
+		if err == nil {
+			_, err = tmp.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			writer.discard()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	return writer, nil
+}
+
+// isWrite reports whether OpenFile flags ask for anything beyond reading
+func isWrite(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_APPEND|os.O_TRUNC) != 0
+}
+
+// tempWriter buffers writes in a temporary file and stores it on Close
+type tempWriter struct {
+	*os.File
+	fsys      wholeFileStore
+	name      string
+	appending bool
+	exclusive bool
+}
+
+func (w *tempWriter) Write(p []byte) (int, error) {
+	if w.appending {
+		if _, err := w.File.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
+	return w.File.Write(p)
+}
+
+func (w *tempWriter) Close() error {
+	defer w.discard()
+
+	info, err := w.File.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.fsys.store(w.name, w.File, info.Size(), w.exclusive)
+}
+
+// discard removes the temporary file
+func (w *tempWriter) discard() {
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
+
+// dirFile is a directory on a remote backend opened for reading its names
+type dirFile struct {
+	fsys  Filesystem
+	name  string
+	info  fs.FileInfo
+	names []string
+	read  bool
+}
+
+func (d *dirFile) Read([]byte) (int, error)           { return 0, errIsDir }
+func (d *dirFile) ReadAt([]byte, int64) (int, error)  { return 0, errIsDir }
+func (d *dirFile) Seek(int64, int) (int64, error)     { return 0, errIsDir }
+func (d *dirFile) Write([]byte) (int, error)          { return 0, errIsDir }
+func (d *dirFile) WriteAt([]byte, int64) (int, error) { return 0, errIsDir }
+func (d *dirFile) Close() error                       { return nil }
+func (d *dirFile) Stat() (fs.FileInfo, error)         { return d.info, nil }
+func (d *dirFile) Sync() error                        { return nil }
+
+// Readdirnames follows os.File semantics: n > 0 returns at most n names and
+// io.EOF at the end, n <= 0 returns everything that is left
+func (d *dirFile) Readdirnames(n int) ([]string, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			d.names = append(d.names, entry.Name())
+		}
+		d.read = true
+	}
+
+	if n <= 0 {
+		names := d.names
+		d.names = nil
+		return names, nil
+	}
+	if len(d.names) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.names))
+	names := d.names[:n]
+	d.names = d.names[n:]
+	return names, nil
+}
+
+// rangeFile is a read-only remote file that fetches byte ranges on demand,
+// so it can be seeked (e.g. for HTTP range requests) without downloading
+// everything first
+type rangeFile struct {
+	info   fs.FileInfo
+	open   func(offset, length int64) (io.ReadCloser, error)
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *rangeFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		body, err := f.open(f.offset, f.info.Size()-f.offset)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *rangeFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.Size() {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), f.info.Size()-off)
+	body, err := f.open(off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:length])
+	if err == nil && length < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *rangeFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *rangeFile) Stat() (fs.FileInfo, error)         { return f.info, nil }
+func (f *rangeFile) Sync() error                        { return nil }
+func (f *rangeFile) Write([]byte) (int, error)          { return 0, errReadOnlyFile }
+func (f *rangeFile) WriteAt([]byte, int64) (int, error) { return 0, errReadOnlyFile }
+func (f *rangeFile) Readdirnames(int) ([]string, error) { return nil, errNotDir }
@@ -0,0 +1,127 @@
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"nextbrowse-backend/buffers"
+)
+
+// Copier is implemented by backends that can copy within themselves without
+// streaming the data through this server
+type Copier interface {
+	Copy(src, dst string) error
+}
+
+// Uploader is implemented by backends that can store a stream of known size
+// more efficiently than through OpenFile, e.g. as a multipart upload
+type Uploader interface {
+	Upload(name string, r io.Reader, size int64) error
+}
+
+// mounts maps user-visible path prefixes to the backends mounted there
+var (
+	mounts      = make(map[string]Filesystem)
+	mountPaths  []string // longest first
+	mountsMutex sync.RWMutex
+)
+
+// Mount attaches fsys at the user path mountPath. Names passed to fsys are
+// full user paths beneath mountPath.
+func Mount(mountPath string, fsys Filesystem) {
+	mountPath = path.Clean("/" + mountPath)
+
+	mountsMutex.Lock()
+	defer mountsMutex.Unlock()
+
+	if _, exists := mounts[mountPath]; !exists {
+		mountPaths = append(mountPaths, mountPath)
+		sort.Slice(mountPaths, func(i, j int) bool { return len(mountPaths[i]) > len(mountPaths[j]) })
+	}
+	mounts[mountPath] = fsys
+}
+
+// Lookup returns the backend mounted at or above the cleaned user path, along
+// with the mount point
+func Lookup(userPath string) (Filesystem, string, bool) {
+	mountsMutex.RLock()
+	defer mountsMutex.RUnlock()
+
+	for _, mountPath := range mountPaths {
+		if userPath == mountPath || strings.HasPrefix(userPath, mountPath+"/") {
+			return mounts[mountPath], mountPath, true
+		}
+	}
+	return nil, "", false
+}
+
+// Mounts returns the mount points in use
+func Mounts() map[string]Filesystem {
+	mountsMutex.RLock()
+	defer mountsMutex.RUnlock()
+
+	all := make(map[string]Filesystem, len(mounts))
+	for mountPath, fsys := range mounts {
+		all[mountPath] = fsys
+	}
+	return all
+}
+
+// New creates the backend described by rawURL for mounting at mountPath
+func New(mountPath, rawURL string) (Filesystem, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return NewS3(path.Clean("/"+mountPath), u)
+	case "gs":
+		return NewGCS(path.Clean("/"+mountPath), u)
+	case "azblob":
+		return NewAzure(path.Clean("/"+mountPath), u)
+	case "webdav", "webdavs":
+		return NewWebDAV(path.Clean("/"+mountPath), u)
+	case "crypt+file", "crypt+s3", "crypt+gs", "crypt+azblob", "crypt+webdav", "crypt+webdavs":
+		return NewEncrypted(path.Clean("/"+mountPath), u)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", u.Scheme)
+	}
+}
+
+// WriteFrom stores size bytes from r at name, or all of r for a size below 0,
+// using the backend's Uploader when it has one. Local files are replaced
+// atomically.
+func WriteFrom(fsys Filesystem, name string, r io.Reader, size int64) error {
+	if uploader, ok := fsys.(Uploader); ok {
+		return uploader.Upload(name, r, size)
+	}
+	if IsLocal(fsys) {
+		file, err := CreateAtomic(name, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := buffers.Copy(file, r); err != nil {
+			file.Abort()
+			return err
+		}
+		return file.Close()
+	}
+
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := buffers.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,370 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nextbrowse-backend/buffers"
+)
+
+// errStopListing ends a listing early without reporting an error
+var errStopListing = errors.New("stop listing")
+
+// objectClient is the driver for one object storage service. Keys are flat
+// and "/"-separated; missing objects are reported as fs.ErrNotExist.
+type objectClient interface {
+	// stat returns the object stored at key
+	stat(ctx context.Context, key string) (objectInfo, error)
+	// get reads length bytes of an object starting at offset
+	get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// put stores size bytes from r at key; exclusive fails with fs.ErrExist
+	// if the object already exists
+	put(ctx context.Context, key string, r io.Reader, size int64, exclusive bool) error
+	// list calls fn for every object below prefix. Without recursive it
+	// stops at the next "/", reporting each sub-prefix once as a key ending
+	// in "/". fn may return errStopListing to end the listing early.
+	list(ctx context.Context, prefix string, recursive bool, fn func(objectInfo) error) error
+	// remove deletes the objects at keys, skipping ones that don't exist
+	remove(ctx context.Context, keys []string) error
+	// copy duplicates an object of the given size within the store
+	copy(ctx context.Context, srcKey, dstKey string, size int64) error
+}
+
+// objectInfo describes a stored object or, for keys ending in "/", a prefix
+type objectInfo struct {
+	key   string
+	size  int64
+	mtime time.Time
+}
+
+// ObjectStore stores files in an object storage bucket or container through
+// an objectClient. Directories are key prefixes; empty directories are kept
+// alive by a zero-byte "dir/" marker object.
+type ObjectStore struct {
+	client    objectClient
+	prefix    string // key prefix for the mount, without surrounding slashes
+	mountPath string
+}
+
+func newObjectStore(client objectClient, prefix, mountPath string) *ObjectStore {
+	return &ObjectStore{
+		client:    client,
+		prefix:    strings.Trim(prefix, "/"),
+		mountPath: mountPath,
+	}
+}
+
+// key maps a user path beneath the mount to an object key
+func (s *ObjectStore) key(name string) string {
+	rel := strings.Trim(strings.TrimPrefix(filepath.ToSlash(name), s.mountPath), "/")
+	return strings.Trim(path.Join(s.prefix, rel), "/")
+}
+
+// dirPrefix returns the listing prefix for the directory at key
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func (s *ObjectStore) Open(name string) (File, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{fsys: s, name: name, info: info}, nil
+	}
+
+	key := s.key(name)
+	return &rangeFile{
+		info: info,
+		open: func(offset, length int64) (io.ReadCloser, error) {
+			body, err := s.client.get(context.Background(), key, offset, length)
+			if err != nil {
+				return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+			}
+			return body, nil
+		},
+	}, nil
+}
+
+// OpenFile opens name for reading, or for writing through a local temporary
+// file that is uploaded when the file is closed. Objects are immutable, so
+// appends and partial writes rewrite the whole object.
+func (s *ObjectStore) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if !isWrite(flag) {
+		return s.Open(name)
+	}
+	return openForWrite(s, name, flag)
+}
+
+func (s *ObjectStore) fetch(name string, w io.Writer) error {
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	body, err := s.client.get(context.Background(), s.key(name), 0, info.Size())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	_, err = buffers.Copy(w, body)
+	return err
+}
+
+func (s *ObjectStore) store(name string, r io.Reader, size int64, exclusive bool) error {
+	if err := s.client.put(context.Background(), s.key(name), r, size, exclusive); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Upload streams r to the object at name; large uploads are sent in parts
+// by the driver
+func (s *ObjectStore) Upload(name string, r io.Reader, size int64) error {
+	return s.store(name, r, size, false)
+}
+
+func (s *ObjectStore) Stat(name string) (fs.FileInfo, error) {
+	key := s.key(name)
+	base := path.Base(filepath.ToSlash(name))
+	if key == s.prefix {
+		return &objectFileInfo{name: base, dir: true}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stops any listing still in flight on early return
+	obj, err := s.client.stat(ctx, key)
+	if err == nil {
+		return &objectFileInfo{name: base, size: obj.size, mtime: obj.mtime}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	// A key prefix with anything below it is a directory
+	var info fs.FileInfo
+	err = s.client.list(ctx, key+"/", false, func(obj objectInfo) error {
+		info = &objectFileInfo{name: base, dir: true, mtime: obj.mtime}
+		return errStopListing
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if info == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+// Lstat is Stat, since object storage has no symlinks
+func (s *ObjectStore) Lstat(name string) (fs.FileInfo, error) {
+	return s.Stat(name)
+}
+
+func (s *ObjectStore) ReadDir(name string) ([]fs.DirEntry, error) {
+	key := s.key(name)
+	prefix := dirPrefix(key)
+
+	var entries []fs.DirEntry
+	err := s.client.list(context.Background(), prefix, false, func(obj objectInfo) error {
+		if obj.key == prefix {
+			return nil // the directory's own marker
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(&objectFileInfo{
+			name:  strings.TrimSuffix(strings.TrimPrefix(obj.key, prefix), "/"),
+			size:  obj.size,
+			mtime: obj.mtime,
+			dir:   strings.HasSuffix(obj.key, "/"),
+		}))
+		return nil
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	// An empty listing is only valid for a directory that exists
+	if len(entries) == 0 && key != s.prefix {
+		info, err := s.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (s *ObjectStore) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (s *ObjectStore) Mkdir(name string, perm fs.FileMode) error {
+	if _, err := s.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	return s.putMarker(name)
+}
+
+// MkdirAll only needs to create the leaf, since parent prefixes are implicit
+func (s *ObjectStore) MkdirAll(name string, perm fs.FileMode) error {
+	info, err := s.Stat(name)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+		}
+		return nil
+	}
+	return s.putMarker(name)
+}
+
+func (s *ObjectStore) putMarker(name string) error {
+	err := s.client.put(context.Background(), s.key(name)+"/", strings.NewReader(""), 0, false)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Rename copies every object server-side and then deletes the originals
+func (s *ObjectStore) Rename(oldname, newname string) error {
+	if err := s.Copy(oldname, newname); err != nil {
+		return err
+	}
+	return s.RemoveAll(oldname)
+}
+
+// Copy duplicates a file or directory tree with server-side copies
+func (s *ObjectStore) Copy(src, dst string) error {
+	info, err := s.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stops any listing still in flight on early return
+	srcKey, dstKey := s.key(src), s.key(dst)
+	if !info.IsDir() {
+		return s.copyObject(ctx, srcKey, dstKey, info.Size())
+	}
+
+	// Keep the destination directory even if the source is empty
+	if err := s.putMarker(dst); err != nil {
+		return err
+	}
+
+	srcPrefix := dirPrefix(srcKey)
+	err = s.client.list(ctx, srcPrefix, true, func(obj objectInfo) error {
+		if obj.key == srcPrefix {
+			return nil
+		}
+		return s.copyObject(ctx, obj.key, dstKey+"/"+strings.TrimPrefix(obj.key, srcPrefix), obj.size)
+	})
+	if err != nil {
+		return &fs.PathError{Op: "copy", Path: src, Err: err}
+	}
+	return nil
+}
+
+func (s *ObjectStore) copyObject(ctx context.Context, srcKey, dstKey string, size int64) error {
+	if err := s.client.copy(ctx, srcKey, dstKey, size); err != nil {
+		return &fs.PathError{Op: "copy", Path: srcKey, Err: err}
+	}
+	return nil
+}
+
+func (s *ObjectStore) Remove(name string) error {
+	info, err := s.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stops any listing still in flight on early return
+	key := s.key(name)
+	if info.IsDir() {
+		prefix := dirPrefix(key)
+		err := s.client.list(ctx, prefix, false, func(obj objectInfo) error {
+			if obj.key != prefix {
+				return errDirNotEmpty
+			}
+			return nil
+		})
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		key = prefix
+	}
+
+	if err := s.client.remove(ctx, []string{key}); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// RemoveAll deletes the object at name and every object beneath it
+func (s *ObjectStore) RemoveAll(name string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stops any listing still in flight on early return
+	key := s.key(name)
+
+	// Collect first so deletions don't disturb the listing
+	var keys []string
+	if key != s.prefix {
+		keys = append(keys, key)
+	}
+	err := s.client.list(ctx, dirPrefix(key), true, func(obj objectInfo) error {
+		keys = append(keys, obj.key)
+		return nil
+	})
+	if err == nil {
+		err = s.client.remove(ctx, keys)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	return nil
+}
+
+// objectFileInfo describes an object or a key prefix
+type objectFileInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	dir   bool
+}
+
+func (i *objectFileInfo) Name() string { return i.name }
+func (i *objectFileInfo) Size() int64  { return i.size }
+func (i *objectFileInfo) ModTime() time.Time {
+	if i.mtime.IsZero() {
+		return time.Unix(0, 0) // key prefixes have no modification time
+	}
+	return i.mtime
+}
+func (i *objectFileInfo) IsDir() bool { return i.dir }
+func (i *objectFileInfo) Sys() any    { return nil }
+
+func (i *objectFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
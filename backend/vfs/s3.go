@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// maxSingleCopySize is the largest object S3 copies in a single request;
+// bigger objects are copied part by part
+const maxSingleCopySize = 5 * 1024 * 1024 * 1024
+
+// NewS3 creates a backend for an S3-compatible bucket (AWS, MinIO, Wasabi)
+// from a URL of the form
+//
+//	s3://[access:secret@]bucket[/prefix]?endpoint=host:port&region=...&insecure=true&pathStyle=true
+//
+// Without credentials in the URL they are read from AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY (or the MINIO_* equivalents).
+func NewS3(mountPath string, u *url.URL) (*ObjectStore, error) {
+	if u.Host == "" {
+		return nil, errors.New("s3 mount requires a bucket name")
+	}
+
+	query := u.Query()
+	endpoint := query.Get("endpoint")
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+
+	var creds *credentials.Credentials
+	if u.User != nil {
+		secret, _ := u.User.Password()
+		creds = credentials.NewStaticV4(u.User.Username(), secret, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		})
+	}
+
+	lookup := minio.BucketLookupAuto
+	if query.Get("pathStyle") == "true" {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       query.Get("insecure") != "true",
+		Region:       query.Get("region"),
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newObjectStore(&s3Client{client: client, bucket: u.Host, conditionalPut: true}, u.Path, mountPath), nil
+}
+
+// s3Client is the objectClient for S3-compatible APIs
+type s3Client struct {
+	client *minio.Client
+	bucket string
+	// conditionalPut is set when the service honours If-None-Match on uploads
+	conditionalPut bool
+}
+
+func convertS3Err(err error) error {
+	code := minio.ToErrorResponse(err).Code
+	switch code {
+	case "NoSuchKey", "NotFound":
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	case "PreconditionFailed":
+		return fmt.Errorf("%w: %v", fs.ErrExist, err)
+	}
+	return err
+}
+
+func (c *s3Client) stat(ctx context.Context, key string) (objectInfo, error) {
+	obj, err := c.client.StatObject(ctx, c.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return objectInfo{}, convertS3Err(err)
+	}
+	return objectInfo{key: key, size: obj.Size, mtime: obj.LastModified}, nil
+}
+
+func (c *s3Client) get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, err
+	}
+	obj, _, _, err := minio.Core{Client: c.client}.GetObject(ctx, c.bucket, key, opts)
+	if err != nil {
+		return nil, convertS3Err(err)
+	}
+	return obj, nil
+}
+
+func (c *s3Client) put(ctx context.Context, key string, r io.Reader, size int64, exclusive bool) error {
+	opts := minio.PutObjectOptions{}
+	if exclusive && c.conditionalPut {
+		// Fail if another writer created the object in the meantime
+		opts.SetMatchETagExcept("*")
+	}
+	_, err := c.client.PutObject(ctx, c.bucket, key, r, size, opts)
+	return convertS3Err(err)
+}
+
+func (c *s3Client) list(ctx context.Context, prefix string, recursive bool, fn func(objectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // stops the listing if fn ends it early
+
+	for obj := range c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if err := fn(objectInfo{key: obj.Key, size: obj.Size, mtime: obj.LastModified}); err != nil {
+			if err == errStopListing {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes keys in batches with multi-object deletes
+func (c *s3Client) remove(ctx context.Context, keys []string) error {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for _, key := range keys {
+			select {
+			case objects <- minio.ObjectInfo{Key: key}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var errs []error
+	for removeErr := range c.client.RemoveObjects(ctx, c.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if !errors.Is(convertS3Err(removeErr.Err), fs.ErrNotExist) {
+			errs = append(errs, removeErr.Err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *s3Client) copy(ctx context.Context, srcKey, dstKey string, size int64) error {
+	dst := minio.CopyDestOptions{Bucket: c.bucket, Object: dstKey}
+	src := minio.CopySrcOptions{Bucket: c.bucket, Object: srcKey}
+
+	var err error
+	if size > maxSingleCopySize {
+		_, err = c.client.ComposeObject(ctx, dst, src)
+	} else {
+		_, err = c.client.CopyObject(ctx, dst, src)
+	}
+	return convertS3Err(err)
+}
@@ -0,0 +1,130 @@
+// Package vfs abstracts the storage that handlers operate on, so that
+// backends other than the local disk can be plugged in without changing them.
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// File is an open file on a Filesystem
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+	Sync() error
+	Readdirnames(n int) ([]string, error)
+}
+
+// Filesystem is the set of storage operations handlers rely on. Names are the
+// resolved paths returned by utils.ResolveFS for the same backend.
+type Filesystem interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Readlink(name string) (string, error)
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+}
+
+// OS is the local disk. Names are absolute paths that have already been
+// confined to the root directory.
+var OS Filesystem = osFS{}
+
+// IsLocal reports whether fsys is the local disk. Features built on OS-level
+// facilities (reflink copies, hardlinks, shredding, TUS staging) require it.
+func IsLocal(fsys Filesystem) bool {
+	_, ok := fsys.(osFS)
+	return ok
+}
+
+type osFS struct{}
+
+// Open and OpenFile return an untyped nil on error so callers can compare
+// the File against nil safely
+func (osFS) Open(name string) (File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (osFS) Mkdir(name string, perm fs.FileMode) error  { return os.Mkdir(name, perm) }
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+func (osFS) Rename(oldname, newname string) error { return os.Rename(oldname, newname) }
+func (osFS) Remove(name string) error             { return os.Remove(name) }
+func (osFS) RemoveAll(name string) error          { return os.RemoveAll(name) }
+
+// WalkDir walks the tree rooted at root on fsys, calling fn for each entry in
+// lexical order. It behaves like filepath.WalkDir, including fs.SkipDir and
+// fs.SkipAll handling, and does not follow symlinks.
+func WalkDir(fsys Filesystem, root string, fn fs.WalkDirFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fsys Filesystem, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == fs.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		// Report the read error; the callback decides whether to continue
+		if err = fn(path, d, err); err != nil {
+			if err == fs.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if err := walkDir(fsys, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
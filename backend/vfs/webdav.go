@@ -0,0 +1,202 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/studio-b12/gowebdav"
+
+	"nextbrowse-backend/buffers"
+)
+
+// WebDAV proxies another WebDAV server (Nextcloud, ownCloud, box providers)
+type WebDAV struct {
+	client    *gowebdav.Client
+	root      string // remote path the mount maps to
+	mountPath string
+}
+
+// NewWebDAV creates a WebDAV backend from a URL of the form
+//
+//	webdav://[user:password@]host[:port]/remote/path
+//
+// webdavs:// connects over HTTPS.
+func NewWebDAV(mountPath string, u *url.URL) (*WebDAV, error) {
+	if u.Host == "" {
+		return nil, errors.New("webdav mount requires a host")
+	}
+
+	scheme := "http"
+	if u.Scheme == "webdavs" {
+		scheme = "https"
+	}
+	endpoint := &url.URL{Scheme: scheme, Host: u.Host}
+
+	user, password := "", ""
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	return &WebDAV{
+		client:    gowebdav.NewClient(endpoint.String(), user, password),
+		root:      path.Clean("/" + u.Path),
+		mountPath: mountPath,
+	}, nil
+}
+
+// remote maps a user path beneath the mount to a path on the server
+func (w *WebDAV) remote(name string) string {
+	rel := strings.TrimPrefix(filepath.ToSlash(name), w.mountPath)
+	return path.Join(w.root, rel)
+}
+
+// convertErr turns the client's HTTP status errors into fs errors
+func convertErr(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case gowebdav.IsErrNotFound(err):
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	case gowebdav.IsErrCode(err, http.StatusMethodNotAllowed), gowebdav.IsErrCode(err, http.StatusPreconditionFailed):
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrExist}
+	case gowebdav.IsErrCode(err, http.StatusForbidden), gowebdav.IsErrCode(err, http.StatusUnauthorized):
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (w *WebDAV) Open(name string) (File, error) {
+	info, err := w.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &dirFile{fsys: w, name: name, info: info}, nil
+	}
+
+	remote := w.remote(name)
+	return &rangeFile{
+		info: info,
+		open: func(offset, length int64) (io.ReadCloser, error) {
+			body, err := w.client.ReadStreamRange(remote, offset, length)
+			return body, convertErr("read", name, err)
+		},
+	}, nil
+}
+
+// OpenFile opens name for reading, or for writing through a local temporary
+// file that is uploaded when the file is closed
+func (w *WebDAV) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if !isWrite(flag) {
+		return w.Open(name)
+	}
+	return openForWrite(w, name, flag)
+}
+
+func (w *WebDAV) fetch(name string, dst io.Writer) error {
+	body, err := w.client.ReadStream(w.remote(name))
+	if err != nil {
+		return convertErr("read", name, err)
+	}
+	defer body.Close()
+
+	_, err = buffers.Copy(dst, body)
+	return err
+}
+
+// store uploads a file. WebDAV has no portable create-if-absent, so exclusive
+// creation is only checked when the file is opened.
+func (w *WebDAV) store(name string, r io.Reader, size int64, exclusive bool) error {
+	return convertErr("write", name, w.client.WriteStream(w.remote(name), r, 0644))
+}
+
+// Upload streams r to name
+func (w *WebDAV) Upload(name string, r io.Reader, size int64) error {
+	return w.store(name, r, size, false)
+}
+
+func (w *WebDAV) Stat(name string) (fs.FileInfo, error) {
+	info, err := w.client.Stat(w.remote(name))
+	if err != nil {
+		return nil, convertErr("stat", name, err)
+	}
+	return info, nil
+}
+
+// Lstat is Stat, since WebDAV has no symlinks
+func (w *WebDAV) Lstat(name string) (fs.FileInfo, error) {
+	return w.Stat(name)
+}
+
+func (w *WebDAV) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := w.client.ReadDir(w.remote(name))
+	if err != nil {
+		return nil, convertErr("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (w *WebDAV) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (w *WebDAV) Mkdir(name string, perm fs.FileMode) error {
+	if _, err := w.Stat(name); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	return convertErr("mkdir", name, w.client.Mkdir(w.remote(name), perm))
+}
+
+func (w *WebDAV) MkdirAll(name string, perm fs.FileMode) error {
+	return convertErr("mkdir", name, w.client.MkdirAll(w.remote(name), perm))
+}
+
+// Rename uses a server-side MOVE
+func (w *WebDAV) Rename(oldname, newname string) error {
+	return convertErr("rename", oldname, w.client.Rename(w.remote(oldname), w.remote(newname), false))
+}
+
+// Copy uses a server-side COPY
+func (w *WebDAV) Copy(src, dst string) error {
+	return convertErr("copy", src, w.client.Copy(w.remote(src), w.remote(dst), false))
+}
+
+func (w *WebDAV) Remove(name string) error {
+	info, err := w.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		entries, err := w.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+		}
+	}
+	return convertErr("remove", name, w.client.Remove(w.remote(name)))
+}
+
+// RemoveAll relies on DELETE removing collections recursively
+func (w *WebDAV) RemoveAll(name string) error {
+	if _, err := w.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	return convertErr("remove", name, w.client.RemoveAll(w.remote(name)))
+}
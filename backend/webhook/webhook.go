@@ -0,0 +1,323 @@
+// Package webhook posts changes to the tree — uploads, deletes, moves, new
+// directories and shares, finished jobs — to the URLs in WEBHOOKS, so that
+// they can trigger automation elsewhere (a media library rescan, a CI run,
+// a notification). Deliveries are signed, retried with backoff and logged.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"nextbrowse-backend/config"
+	"nextbrowse-backend/events"
+)
+
+// How many deliveries are remembered per webhook
+const maxDeliveries = 100
+
+// Backoff between attempts of a delivery, doubling from the first up to the
+// longest
+const (
+	firstBackoff   = time.Second
+	longestBackoff = time.Minute
+)
+
+// EventPing is the type of the test event sent by Ping
+const EventPing = "ping"
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one event posted, or being posted, to a webhook
+type Delivery struct {
+	ID         string `json:"id"`
+	EventID    int64  `json:"eventId"`
+	Event      string `json:"event"`
+	Path       string `json:"path,omitempty"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	StatusCode int    `json:"statusCode,omitempty"` // of the last attempt
+	Error      string `json:"error,omitempty"`      // of the last attempt
+	CreatedAt  int64  `json:"createdAt"`
+	FinishedAt *int64 `json:"finishedAt,omitempty"`
+}
+
+// Info describes a configured webhook
+type Info struct {
+	Name         string    `json:"name"`
+	URL          string    `json:"url"` // without credentials or query
+	Events       []string  `json:"events,omitempty"`
+	Paths        []string  `json:"paths,omitempty"`
+	Signed       bool      `json:"signed"`
+	LastDelivery *Delivery `json:"lastDelivery,omitempty"`
+}
+
+var (
+	deliveries   = make(map[string][]*Delivery) // oldest first per webhook
+	deliveriesMu sync.Mutex
+
+	client = &http.Client{}
+)
+
+// Start delivers events to every configured webhook until the process exits
+func Start() {
+	for _, hook := range config.Webhooks {
+		if err := Check(hook); err != nil {
+			slog.Warn("Ignoring webhook", "error", err)
+			continue
+		}
+		slog.Info("Delivering events to webhook", "webhook", hook.Name, "url", redact(hook.URL))
+		go run(hook)
+	}
+}
+
+// Check reports whether a webhook's URL is usable
+func Check(hook config.Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return fmt.Errorf("webhook %s: invalid URL: %w", hook.Name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook %s: URL must be http:// or https://", hook.Name)
+	}
+	return nil
+}
+
+// Find returns the configured webhook with the given name
+func Find(name string) (config.Webhook, bool) {
+	for _, hook := range config.Webhooks {
+		if hook.Name == name {
+			return hook, true
+		}
+	}
+	return config.Webhook{}, false
+}
+
+// List describes the configured webhooks
+func List() []Info {
+	deliveriesMu.Lock()
+	defer deliveriesMu.Unlock()
+
+	infos := make([]Info, 0, len(config.Webhooks))
+	for _, hook := range config.Webhooks {
+		info := Info{
+			Name:   hook.Name,
+			URL:    redact(hook.URL),
+			Events: hook.Events,
+			Paths:  hook.Paths,
+			Signed: secret(hook) != "",
+		}
+		if log := deliveries[hook.Name]; len(log) > 0 {
+			last := *log[len(log)-1]
+			info.LastDelivery = &last
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Deliveries returns the remembered deliveries to a webhook, newest first
+func Deliveries(name string) []Delivery {
+	deliveriesMu.Lock()
+	defer deliveriesMu.Unlock()
+
+	log := deliveries[name]
+	result := make([]Delivery, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		result = append(result, *log[i])
+	}
+	return result
+}
+
+// Ping posts a test event to a webhook in the background and returns its
+// delivery
+func Ping(hook config.Webhook) Delivery {
+	e := events.Event{Type: EventPing, Time: time.Now().UnixMilli()}
+	d := newDelivery(hook, e)
+	snapshot := *d
+	go deliver(hook, d, e)
+	return snapshot
+}
+
+// run subscribes a webhook to the events it selects and delivers them one at
+// a time, in order. A webhook too slow to keep up is dropped by the event bus
+// and catches up from the bus's history.
+func run(hook config.Webhook) {
+	filter := events.Filter{Paths: hook.Paths, Types: hook.Events}
+	if slices.Contains(hook.Events, "*") {
+		filter.Types = nil
+	}
+	// Progress is only sent when asked for, being sent every second per job
+	progress := len(hook.Events) > 0
+
+	var last int64
+	handle := func(e events.Event) {
+		if e.Type != events.JobProgress || progress {
+			deliver(hook, newDelivery(hook, e), e)
+		}
+		last = e.ID
+	}
+	for {
+		sub, missed, complete := events.Subscribe(filter, last)
+		if !complete {
+			slog.Warn("Webhook fell behind and missed events", "webhook", hook.Name)
+		}
+		for _, e := range missed {
+			handle(e)
+		}
+		for e := range sub.C {
+			handle(e)
+		}
+	}
+}
+
+// newDelivery logs a pending delivery of e to a webhook
+func newDelivery(hook config.Webhook, e events.Event) *Delivery {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	d := &Delivery{
+		ID:        hex.EncodeToString(id),
+		EventID:   e.ID,
+		Event:     e.Type,
+		Path:      e.Path,
+		Status:    DeliveryPending,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+
+	deliveriesMu.Lock()
+	defer deliveriesMu.Unlock()
+	log := append(deliveries[hook.Name], d)
+	if len(log) > maxDeliveries {
+		log = log[len(log)-maxDeliveries:]
+	}
+	deliveries[hook.Name] = log
+	return d
+}
+
+// deliver posts e to a webhook, retrying WEBHOOK_RETRIES times with growing
+// pauses while it fails with a network error, 408, 429 or a server error
+func deliver(hook config.Webhook, d *Delivery, e events.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		finish(d, DeliveryFailed, 0, err)
+		return
+	}
+
+	backoff := firstBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := post(hook, d.ID, e.Type, body)
+		if err == nil {
+			finish(d, DeliveryDelivered, status, nil)
+			return
+		}
+
+		retry := status == 0 || status == http.StatusRequestTimeout ||
+			status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt > config.WebhookRetries {
+			slog.Warn("Webhook delivery failed", "webhook", hook.Name, "event", e.Type, "attempts", attempt, "error", err)
+			finish(d, DeliveryFailed, status, err)
+			return
+		}
+		update(d, func() {
+			d.Attempts = attempt
+			d.StatusCode = status
+			d.Error = err.Error()
+		})
+		time.Sleep(backoff)
+		backoff = min(2*backoff, longestBackoff)
+	}
+}
+
+// post makes one attempt at a delivery and returns the response status, if
+// there was a response
+func post(hook config.Webhook, deliveryID, eventType string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "NextBrowse-Webhook")
+	req.Header.Set("X-NextBrowse-Event", eventType)
+	req.Header.Set("X-NextBrowse-Delivery", deliveryID)
+	if key := secret(hook); key != "" {
+		req.Header.Set("X-NextBrowse-Signature", "sha256="+Sign(key, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body with key, as sent in the
+// X-NextBrowse-Signature header after "sha256="
+func Sign(key string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// finish records the outcome of a delivery
+func finish(d *Delivery, status string, statusCode int, err error) {
+	update(d, func() {
+		d.Attempts++
+		d.Status = status
+		d.StatusCode = statusCode
+		d.Error = ""
+		if err != nil {
+			d.Error = err.Error()
+		}
+		now := time.Now().UnixMilli()
+		d.FinishedAt = &now
+	})
+}
+
+func update(d *Delivery, change func()) {
+	deliveriesMu.Lock()
+	defer deliveriesMu.Unlock()
+	change()
+}
+
+// secret returns the key a webhook's deliveries are signed with, if any
+func secret(hook config.Webhook) string {
+	if hook.Secret != "" {
+		return hook.Secret
+	}
+	return config.WebhookSecret
+}
+
+// redact strips credentials and the query, which often carries a token,
+// from a webhook URL for display
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -122,6 +122,11 @@ export default function SharePage() {
   };
 
   const handleDownload = (url: string, fileName?: string) => {
+    // Links can't send headers, so a protected share's password goes along
+    // in the URL
+    if (password) {
+      url += `${url.includes("?") ? "&" : "?"}password=${encodeURIComponent(password)}`;
+    }
     const link = document.createElement("a");
     link.href = url;
     if (fileName) {
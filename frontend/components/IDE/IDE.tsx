@@ -32,6 +32,7 @@ interface OpenFile {
   language: string;
   size: number;
   mtime: number;
+  etag?: string;
   cursorPosition?: { line: number; column: number };
 }
 
@@ -151,6 +152,13 @@ export default function IDE({
       try {
         const data = await apiClient.readFile(path);
         if (!data.ok) throw new Error(data.error || "Failed to load file");
+        if (data.encoding !== "text") {
+          throw new Error(
+            data.binary
+              ? "binary files cannot be edited"
+              : `${data.charset} files cannot be edited`
+          );
+        }
 
         const language = getFileLanguage(path.split("/").pop() || "");
         const newFile: OpenFile = {
@@ -160,6 +168,7 @@ export default function IDE({
           language,
           size: data.size,
           mtime: data.mtime,
+          etag: data.etag,
         };
 
         setOpenFiles((prev: Record<string, OpenFile>) => ({
@@ -188,28 +197,25 @@ export default function IDE({
       if (!file) return;
 
       try {
-        // Save via unified API client (handles base URL/proxy)
-        const res = await apiClient.writeFile(path, file.content);
-        if (!(res as any)?.ok) {
-          const msg =
-            (res as any)?.error || (res as any)?.message || "Save failed";
-          throw new Error(msg);
+        // Save via unified API client (handles base URL/proxy); the etag
+        // makes the save fail if the file changed on disk since it was opened
+        const res = await apiClient.writeFile(path, file.content, file.etag);
+        if (!res.ok) {
+          throw new Error(
+            res.status === 412
+              ? "File changed on disk since it was opened; reopen it first"
+              : res.error || "Save failed"
+          );
         }
 
-        // Refresh metadata (size/mtime) by reading file
-        const info = (await apiClient.readFile(path)) as {
-          ok?: boolean;
-          size?: number;
-          mtime?: number;
-        };
-
         setOpenFiles((prev: Record<string, OpenFile>) => ({
           ...prev,
           [path]: {
             ...file,
             originalContent: file.content,
-            size: typeof info?.size === "number" ? info.size : file.size,
-            mtime: typeof info?.mtime === "number" ? info.mtime : Date.now(),
+            size: res.size,
+            mtime: res.mtime,
+            etag: res.etag,
           },
         }));
         if (activeFile === path) {
@@ -51,13 +51,13 @@ export function useFileOperations({
     if (itemsToDownload.length === 1) {
       const itemName = itemsToDownload[0];
       const itemPath = `${currentPath}/${itemName}`.replace(/\/+/g, "/");
+      const item = allItems.find((i) => i.name === itemName);
       const downloadUrl = `/api/fs/download?path=${encodeURIComponent(
         itemPath
-      )}`;
+      )}${item?.type === "dir" ? "&format=zip" : ""}`;
 
       const link = document.createElement("a");
       link.href = downloadUrl;
-      const item = allItems.find((i) => i.name === itemName);
       link.download = item?.type === "dir" ? `${itemName}.zip` : itemName;
       document.body.appendChild(link);
       link.click();
@@ -154,23 +154,11 @@ export function useFileOperations({
   const createFile = useCallback(
     async (fileName: string) => {
       try {
-        // Create an empty file via single-chunk upload (Go backend supports this path)
-        const formData = new FormData();
-        formData.append("path", currentPath);
-        formData.append("fileName", fileName);
-        formData.append(
-          "fileId",
-          `manual_${Date.now()}_${Math.random().toString(36).slice(2)}`
-        );
-        formData.append("chunkIndex", "0");
-        formData.append("totalChunks", "1");
-        // Empty chunk to create 0-byte file
-        const emptyBlob = new Blob([], { type: "application/octet-stream" });
-        formData.append("chunk", emptyBlob, fileName);
-
-        const response = await fetch("/api/fs/upload-chunk", {
+        const response = await fetch("/api/fs/touch", {
           method: "POST",
-          body: formData,
+          headers: { "Content-Type": "application/json" },
+          // Go backend expects parent path + name, like mkdir
+          body: JSON.stringify({ path: currentPath, name: fileName }),
         });
 
         if (!response.ok) {
@@ -240,7 +228,7 @@ export function useFileOperations({
       const itemPath = `${currentPath}/${item.name}`.replace(/\/+/g, "/");
       const downloadUrl = `/api/fs/download?path=${encodeURIComponent(
         itemPath
-      )}`;
+      )}${item.type === "dir" ? "&format=zip" : ""}`;
 
       const link = document.createElement("a");
       link.href = downloadUrl;
@@ -1,6 +1,21 @@
 // API client configuration for Go backend
 // In Docker setup, API calls go through nginx proxy, so we use relative URLs
+import * as api from "./api-generated";
+
 const API_BASE_URL = process.env.NEXT_PUBLIC_GO_API_URL || "";
+api.setBaseUrl(API_BASE_URL);
+
+// There are no accounts, so the browser keeps a random ID that the server
+// uses to tell users apart for frequent and pinned directories
+const USER_ID_KEY = "nextbrowse-user-id";
+if (typeof window !== "undefined") {
+  let userId = window.localStorage.getItem(USER_ID_KEY);
+  if (!userId) {
+    userId = crypto.randomUUID();
+    window.localStorage.setItem(USER_ID_KEY, userId);
+  }
+  api.setDefaultHeaders({ "X-NextBrowse-User": userId });
+}
 
 export const apiClient = {
   // File listing
@@ -13,79 +28,35 @@ export const apiClient = {
       limit?: number;
     }
   ) {
-    const params = new URLSearchParams({ path });
-
-    if (pagination) {
-      if (pagination.page !== undefined)
-        params.set("page", pagination.page.toString());
-      if (pagination.pageSize !== undefined)
-        params.set("pageSize", pagination.pageSize.toString());
-      if (pagination.offset !== undefined)
-        params.set("offset", pagination.offset.toString());
-      if (pagination.limit !== undefined)
-        params.set("limit", pagination.limit.toString());
-    }
-
-    const response = await fetch(`${API_BASE_URL}/api/fs/list?${params}`);
-    return response.json();
+    return api.listDirectory({ path, ...pagination });
   },
 
   // File read
   async readFile(path: string) {
-    const response = await fetch(
-      `${API_BASE_URL}/api/fs/read?path=${encodeURIComponent(path)}`
-    );
-    return response.json();
+    return api.readFile({ path });
   },
 
-  // File write
-  async writeFile(path: string, content: string) {
-    // Emulate write by uploading a single chunk with replace=true
-    const parent = path.substring(0, path.lastIndexOf("/")) || "/";
-    const name = path.split("/").pop() || "file.txt";
-    const form = new FormData();
-    form.append("path", parent);
-    form.append("fileName", name);
-    form.append(
-      "fileId",
-      `api_write_${Date.now()}_${Math.random().toString(36).slice(2)}`
-    );
-    form.append("chunkIndex", "0");
-    form.append("totalChunks", "1");
-    form.append("replace", "true");
-    form.append(
-      "chunk",
-      new Blob([content], { type: "text/plain;charset=utf-8" }),
-      name
-    );
-    const response = await fetch(`${API_BASE_URL}/api/fs/upload-chunk`, {
-      method: "POST",
-      body: form,
-    });
-    return response.json();
+  // File write. Pass the etag returned by readFile so the save fails with
+  // 412 instead of overwriting changes made since the file was read.
+  async writeFile(path: string, content: string, etag?: string) {
+    const headers: Record<string, string> = {
+      "Content-Type": "text/plain;charset=utf-8",
+    };
+    if (etag) headers["If-Match"] = etag;
+    return api.saveFile({ path, body: content }, { headers });
   },
 
-  // File create
+  // File create; refuses to replace an existing file
   async createFile(path: string, content: string = "") {
-    // Create via single-chunk upload; if content empty, upload empty blob
-    const parent = path.substring(0, path.lastIndexOf("/")) || "/";
-    const name = path.split("/").pop() || "untitled";
-    const form = new FormData();
-    form.append("path", parent);
-    form.append("fileName", name);
-    form.append(
-      "fileId",
-      `api_create_${Date.now()}_${Math.random().toString(36).slice(2)}`
+    if (!content) {
+      const parent = path.substring(0, path.lastIndexOf("/")) || "/";
+      const name = path.split("/").pop() || "untitled";
+      return api.createFile({ body: { path: parent, name } });
+    }
+    return api.saveFile(
+      { path, body: content },
+      { headers: { "If-None-Match": "*" } }
     );
-    form.append("chunkIndex", "0");
-    form.append("totalChunks", "1");
-    const blob = new Blob([content], { type: "application/octet-stream" });
-    form.append("chunk", blob, name);
-    const response = await fetch(`${API_BASE_URL}/api/fs/upload-chunk`, {
-      method: "POST",
-      body: form,
-    });
-    return response.json();
   },
 
   // File upload
@@ -106,40 +77,20 @@ export const apiClient = {
 
   // File operations
   async copyFile(source: string, destination: string) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/copy`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ source, destination }),
-    });
-    return response.json();
+    return api.copyFile({ body: { source, destination } });
   },
 
   async moveFile(source: string, destination: string) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/move`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ source, destination }),
-    });
-    return response.json();
+    return api.moveFile({ body: { source, destination } });
   },
 
   async deleteFile(path: string) {
     // Use POST for broad compatibility
-    const response = await fetch(`${API_BASE_URL}/api/fs/delete`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ path }),
-    });
-    return response.json();
+    return api.deleteFilePost({ body: { path } });
   },
 
   async createDirectory(path: string, name: string) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/mkdir`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ path, name }),
-    });
-    return response.json();
+    return api.createDirectory({ body: { path, name } });
   },
 
   // Download - Use nginx direct serving for better performance
@@ -151,39 +102,17 @@ export const apiClient = {
   },
 
   async downloadMultiple(files: string[]) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/download-multiple`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ files }),
-    });
-    return response; // Return response object for streaming
+    // Return response object for streaming
+    return api.downloadMultiple({ body: { files } });
   },
 
   // Share functionality
-  async createShare(shareData: {
-    path: string;
-    password?: string;
-    expiresIn?: number;
-    allowUploads?: boolean;
-    disableViewer?: boolean;
-    quickDownload?: boolean;
-    maxBandwidth?: number;
-    title?: string;
-    description?: string;
-    theme?: string;
-    viewMode?: string;
-  }) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/share/create`, {
-      method: "POST",
-      headers: { "Content-Type": "application/json" },
-      body: JSON.stringify(shareData),
-    });
-    return response.json();
+  async createShare(shareData: api.CreateShareRequest) {
+    return api.createShare({ body: shareData });
   },
 
   async getShare(shareId: string) {
-    const response = await fetch(`${API_BASE_URL}/api/fs/share/${shareId}`);
-    return response.json();
+    return api.getShare({ shareId });
   },
 
   async accessShare(shareId: string, password?: string) {
@@ -202,9 +131,29 @@ export const apiClient = {
     return `${API_BASE_URL}/api/fs/share/${shareId}/download`;
   },
 
+  // Sidebar shortcuts
+  async frequentDirectories(limit?: number) {
+    return api.frequentDirectories({ limit });
+  },
+
+  async listPins() {
+    return api.listPins();
+  },
+
+  async pinDirectory(path: string) {
+    return api.addPin({ body: { path } });
+  },
+
+  async unpinDirectory(path: string) {
+    return api.removePin({ path });
+  },
+
+  async reorderPins(paths: string[]) {
+    return api.setPins({ body: { paths } });
+  },
+
   // Health check
   async healthCheck() {
-    const response = await fetch(`${API_BASE_URL}/health`);
-    return response.json();
+    return api.healthCheck();
   },
 };
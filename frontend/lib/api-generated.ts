@@ -0,0 +1,1810 @@
+// Code generated by `nextbrowse openapi --typescript`. DO NOT EDIT.
+
+let baseUrl = "";
+
+/** Sets the URL the API is served from; requests are relative by default. */
+export function setBaseUrl(url: string) {
+  baseUrl = url.replace(/\/$/, "");
+}
+
+let defaultHeaders: Record<string, string> = {};
+
+/** Sets headers sent with every request, such as X-NextBrowse-User. */
+export function setDefaultHeaders(headers: Record<string, string>) {
+  defaultHeaders = { ...headers };
+}
+
+/** Parsed body of a JSON endpoint; check ok to tell it from a Problem. */
+export type ApiResult<T> = (T & { ok: true }) | Problem;
+
+async function call(
+  method: string,
+  path: string,
+  query: Record<string, unknown>,
+  body: unknown,
+  json: boolean,
+  init?: RequestInit
+): Promise<Response> {
+  const params = new URLSearchParams();
+  for (const [key, value] of Object.entries(query)) {
+    if (value !== undefined && value !== null) params.set(key, String(value));
+  }
+  const search = params.toString();
+  const headers = new Headers(defaultHeaders);
+  new Headers(init?.headers).forEach((value, key) => headers.set(key, value));
+  if (json && body !== undefined) headers.set("Content-Type", "application/json");
+  return fetch(baseUrl + path + (search ? "?" + search : ""), {
+    ...init,
+    method,
+    headers,
+    body: json && body !== undefined ? JSON.stringify(body) : (body as BodyInit | undefined),
+  });
+}
+
+export interface AccessShareRequest {
+  password?: string;
+}
+
+export interface AccessShareResponse {
+  message?: string;
+  ok: boolean;
+  valid: boolean;
+}
+
+export interface Activity {
+  actor?: string;
+  destination?: string;
+  id: number;
+  path: string;
+  time: number;
+  type: string;
+}
+
+export interface BackendCheck {
+  error?: string;
+  latencyMs: number;
+  reachable: boolean;
+  status: string;
+  writable: boolean;
+}
+
+export interface BackupInfo {
+  destination: string;
+  keep: number;
+  lastRun?: BackupRunInfo;
+  name: string;
+  nextRun?: number;
+  schedule: string;
+  source: string;
+}
+
+export interface BackupRunInfo {
+  job: JobInfo;
+  trigger: string;
+}
+
+export interface BenchResult {
+  bytes: number;
+  bytesPerSecond: number;
+  durationMs: number;
+  ok: boolean;
+}
+
+export interface Branding {
+  accentColor?: string;
+  backgroundColor?: string;
+  logoUrl?: string;
+  primaryColor?: string;
+  textColor?: string;
+  theme: string;
+  title?: string;
+}
+
+export interface ChecksumReport {
+  bytes: number;
+  files: number;
+  format: string;
+  manifest?: string;
+}
+
+export interface ChecksumRequest {
+  async: boolean;
+  format: string;
+  path: string;
+}
+
+export interface ChecksumResponse {
+  errors?: JobError[];
+  jobId?: string;
+  message?: string;
+  ok: boolean;
+  report?: ChecksumReport;
+}
+
+export interface CopyMoveRequest {
+  async?: boolean;
+  conflict?: string;
+  destination: string;
+  source: string;
+}
+
+export interface CreateShareRequest {
+  allowUploads?: boolean;
+  description?: string;
+  disableViewer?: boolean;
+  expiresIn?: number;
+  maxBandwidth?: number;
+  password?: string;
+  path: string;
+  quickDownload?: boolean;
+  theme?: string;
+  title?: string;
+  torrent?: boolean;
+  viewMode?: string;
+}
+
+export interface CreateShareResponse {
+  ok: boolean;
+  share: SharePublic;
+  shareId: string;
+  shareUrl: string;
+  torrentJobId?: string;
+}
+
+export interface DeleteRequest {
+  path: string;
+  permanent?: boolean;
+  secure?: boolean;
+}
+
+export interface Delivery {
+  attempts: number;
+  createdAt: number;
+  error?: string;
+  event: string;
+  eventId: number;
+  finishedAt?: number;
+  id: string;
+  path?: string;
+  status: string;
+  statusCode?: number;
+}
+
+export interface DiffEntry {
+  fromMtime?: number;
+  fromSize?: number;
+  path: string;
+  status: string;
+  toMtime?: number;
+  toSize?: number;
+  type: string;
+}
+
+export interface DiffResponse {
+  binary?: boolean;
+  changes?: DiffEntry[];
+  diff?: string;
+  from: string;
+  identical: boolean;
+  ok: boolean;
+  pagination?: Pagination;
+  to: string;
+  truncated?: boolean;
+  type: string;
+}
+
+export interface DownloadMultipleRequest {
+  async?: boolean;
+  files: string[];
+}
+
+export interface FileItem {
+  git?: string;
+  itemCount?: number;
+  itemCountCapped?: boolean;
+  mtime: number;
+  name: string;
+  note?: Note;
+  size?: number;
+  tags?: string[];
+  target?: string;
+  type: string;
+  url?: string;
+}
+
+export interface GalleryItem {
+  height?: number;
+  mtime: number;
+  name: string;
+  path: string;
+  size: number;
+  taken?: number;
+  thumbnail: string;
+  url: string;
+  width?: number;
+}
+
+export interface GalleryResponse {
+  items: GalleryItem[];
+  ok: boolean;
+  pagination: Pagination;
+  path: string;
+}
+
+export interface GetSharesResponse {
+  ok: boolean;
+  pagination?: Pagination;
+  shares: SharePublic[];
+}
+
+export interface GitFile {
+  dir?: boolean;
+  origPath?: string;
+  path: string;
+  staged?: boolean;
+  status: string;
+}
+
+export interface GitResponse {
+  ahead: number;
+  behind: number;
+  branch?: string;
+  commit?: string;
+  dirty: boolean;
+  files: GitFile[];
+  ok: boolean;
+  pagination?: Pagination;
+  root: string;
+  upstream?: string;
+}
+
+export interface GitSummary {
+  branch?: string;
+  commit?: string;
+  dirty: boolean;
+  root: string;
+}
+
+export interface HealthResponse {
+  checkedAt: number;
+  checks: Record<string, BackendCheck>;
+  maintenance?: boolean;
+  status: string;
+}
+
+export interface ISOExtractRequest {
+  destination: string;
+  entry: string;
+  name?: string;
+  path: string;
+}
+
+export interface ISOListing {
+  entry: string;
+  format: string;
+  items: FileItem[];
+  ok: boolean;
+  volume?: string;
+}
+
+export interface Info {
+  description?: string;
+  lastRun?: Run;
+  name: string;
+  nextRun?: number;
+  schedule: string;
+}
+
+export interface JobError {
+  error: string;
+  path: string;
+}
+
+export interface JobInfo {
+  bytesPerSecond?: number;
+  createdAt: number;
+  currentFile?: string;
+  destination?: string;
+  doneBytes: number;
+  doneItems: number;
+  errors?: JobError[];
+  finishedAt?: number;
+  id: string;
+  message?: string;
+  result?: unknown;
+  source?: string;
+  status: string;
+  totalBytes: number;
+  totalItems: number;
+  type: string;
+}
+
+export interface JobStats {
+  byType: Record<string, number>;
+  running: number;
+}
+
+export interface LinkRequest {
+  destination: string;
+  source: string;
+  type?: string;
+}
+
+export interface ListResponse {
+  git?: GitSummary;
+  items: FileItem[];
+  ok: boolean;
+  pagination?: Pagination;
+  path: string;
+}
+
+export interface Maintenance {
+  enabled: boolean;
+  message?: string;
+  since?: number;
+}
+
+export interface MaintenanceRequest {
+  enabled: boolean;
+  message: string;
+}
+
+export interface MkdirRequest {
+  name: string;
+  path: string;
+}
+
+export interface Note {
+  text: string;
+  updatedAt: number;
+}
+
+export interface NoteRequest {
+  path: string;
+  text: string;
+}
+
+export interface NoteResponse {
+  note: Note;
+  ok: boolean;
+  path: string;
+}
+
+export interface OfficeRequest {
+  name?: string;
+  path: string;
+}
+
+export interface OfficeSession {
+  accessToken: string;
+  accessTokenTtl: number;
+  editorUrl: string;
+  fileId: string;
+  ok: boolean;
+  wopiSrc: string;
+}
+
+export interface OperationResponse {
+  errors?: JobError[];
+  jobId?: string;
+  message: string;
+  ok: boolean;
+  trashId?: string;
+}
+
+export interface Pagination {
+  hasMore: boolean;
+  hasNext: boolean;
+  hasPrev: boolean;
+  limit: number;
+  nextOffset?: number;
+  offset: number;
+  page: number;
+  pageSize: number;
+  totalItems: number;
+  totalPages: number;
+}
+
+export interface PasteRequest {
+  content: string;
+  language?: string;
+  name?: string;
+  path: string;
+  share?: PasteShare;
+}
+
+export interface PasteResponse {
+  etag: string;
+  language?: string;
+  ok: boolean;
+  path: string;
+  share?: CreateShareResponse;
+  size: number;
+}
+
+export interface PasteShare {
+  expiresIn?: number;
+  password?: string;
+  title?: string;
+}
+
+export interface PiecesRequest {
+  pieceSize: number;
+  sha256: string[];
+}
+
+export interface PinRequest {
+  path: string;
+}
+
+export interface PinsResponse {
+  ok: boolean;
+  pins: string[];
+}
+
+export interface PreviewCacheRequest {
+  maxSize: number;
+}
+
+export interface PreviewCacheStatus {
+  files: number;
+  maxSize: number;
+  path: string;
+  size: number;
+}
+
+export interface Problem {
+  detail?: string;
+  error: string;
+  ok: false;
+  path: string;
+  requestId?: string;
+  status: number;
+  title: string;
+  type: string;
+  [key: string]: unknown;
+}
+
+export interface QuotaRequest {
+  maxBytes: number;
+  maxFiles: number;
+  path: string;
+}
+
+export interface QuotaStatus {
+  maxBytes?: number;
+  maxFiles?: number;
+  measuredAt: number;
+  path: string;
+  usedBytes: number;
+  usedFiles: number;
+}
+
+export interface ReadFileResponse {
+  binary: boolean;
+  charset?: string;
+  content: string;
+  encoding: string;
+  etag: string;
+  length: number;
+  mtime: number;
+  offset: number;
+  ok: boolean;
+  size: number;
+}
+
+export interface RecentItem {
+  mtime: number;
+  name: string;
+  path: string;
+  size: number;
+  url: string;
+}
+
+export interface RecentResponse {
+  items: RecentItem[];
+  ok: boolean;
+  path: string;
+  scanned: number;
+  truncated: boolean;
+}
+
+export interface Run {
+  finishedAt?: number;
+  id: string;
+  message?: string;
+  startedAt: number;
+  status: string;
+  task: string;
+  trigger: string;
+}
+
+export interface Served {
+  count: number;
+  path: string;
+}
+
+export interface SetPinsRequest {
+  paths: string[];
+}
+
+export interface ShareMeta {
+  description: string;
+  files?: number;
+  image?: string;
+  siteName: string;
+  size?: number;
+  title: string;
+  type: string;
+  url: string;
+}
+
+export interface SharePublic {
+  allowUploads?: boolean;
+  createdAt: number;
+  description?: string;
+  disableViewer?: boolean;
+  expiresAt?: number;
+  hasPassword: boolean;
+  id: string;
+  infoHash?: string;
+  quickDownload?: boolean;
+  title?: string;
+  type: string;
+}
+
+export interface ShareTorrentResponse {
+  jobId: string;
+  message: string;
+  ok: boolean;
+}
+
+export interface SnapshotInfo {
+  id: string;
+  item?: FileItem;
+  kind: string;
+  name: string;
+  time: number;
+}
+
+export interface SnapshotRestoreRequest {
+  async: boolean;
+  conflict: string;
+  destination: string;
+  path: string;
+}
+
+export interface SyncReport {
+  bytesCopied: number;
+  created: string[];
+  deleted: string[];
+  dryRun?: boolean;
+  unchanged: number;
+  updated: string[];
+}
+
+export interface SyncRequest {
+  async: boolean;
+  compare: string;
+  delete: boolean;
+  destination: string;
+  dryRun: boolean;
+  source: string;
+}
+
+export interface SyncResponse {
+  errors?: JobError[];
+  jobId?: string;
+  message?: string;
+  ok: boolean;
+  report?: SyncReport;
+}
+
+export interface TableColumn {
+  name: string;
+  type: string;
+}
+
+export interface TableResponse {
+  columns: TableColumn[];
+  delimiter?: string;
+  format: string;
+  ok: boolean;
+  pagination: Pagination;
+  rows: unknown[][];
+  sheet?: string;
+  sheets?: string[];
+  truncatedColumns?: boolean;
+}
+
+export interface TagCount {
+  count: number;
+  tag: string;
+}
+
+export interface TaggedItem {
+  mtime: number;
+  path: string;
+  size?: number;
+  tags: string[];
+  type: string;
+}
+
+export interface TagsRequest {
+  path: string;
+  tags: string[];
+}
+
+export interface TagsResponse {
+  ok: boolean;
+  path: string;
+  tags: string[];
+}
+
+export interface Theme {
+  accentColor?: string;
+  backgroundColor?: string;
+  logoType?: string;
+  name: string;
+  primaryColor?: string;
+  textColor?: string;
+  title?: string;
+  updatedAt: number;
+}
+
+export interface ThemeRequest {
+  accentColor?: string;
+  backgroundColor?: string;
+  primaryColor?: string;
+  textColor?: string;
+  title?: string;
+}
+
+export interface TouchRequest {
+  name: string;
+  path: string;
+}
+
+export interface TrashItem {
+  deletedAt: number;
+  id: string;
+  path: string;
+  size: number;
+  type: string;
+}
+
+export interface TrashRestoreRequest {
+  destination?: string;
+}
+
+export interface TusUploadInfo {
+  createdAt: string;
+  filename: string;
+  id: string;
+  lastModified: string;
+  offset: number;
+  path: string;
+  size: number;
+  url: string;
+}
+
+export interface UsageSummary {
+  activeUsers: number;
+  bytesDownloaded: number;
+  bytesUploaded: number;
+  clientErrors: number;
+  errorRate: number;
+  filesServed: number;
+  requests: number;
+  serverErrors: number;
+}
+
+export interface VerifyReport {
+  checked: number;
+  intact: boolean;
+  manifest: string;
+  matched: number;
+  mismatched: string[];
+  missing: string[];
+  unlisted: string[];
+}
+
+export interface VerifyRequest {
+  async: boolean;
+  manifest: string;
+  path: string;
+}
+
+export interface VerifyResponse {
+  errors?: JobError[];
+  jobId?: string;
+  message?: string;
+  ok: boolean;
+  report?: VerifyReport;
+}
+
+export interface Visit {
+  count: number;
+  lastVisit: number;
+  path: string;
+}
+
+export interface WebhookInfo {
+  events?: string[];
+  lastDelivery?: Delivery;
+  name: string;
+  paths?: string[];
+  signed: boolean;
+  url: string;
+}
+
+export interface WriteFileResponse {
+  etag: string;
+  message?: string;
+  mtime: number;
+  ok: boolean;
+  size: number;
+}
+
+/** Get maintenance mode */
+export async function getMaintenance(init?: RequestInit): Promise<ApiResult<{
+  maintenance: Maintenance;
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/admin/maintenance`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Turn maintenance mode on or off */
+export async function setMaintenance(params: {
+  body: MaintenanceRequest;
+}, init?: RequestInit): Promise<ApiResult<{
+  maintenance: Maintenance;
+  ok: boolean;
+}>> {
+  const response = await call("PUT", `/api/admin/maintenance`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Purge the preview cache */
+export async function purgePreviewCache(params: {
+  path?: string;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  freed: number;
+  message: string;
+  ok: boolean;
+  removed: number;
+}>> {
+  const response = await call("DELETE", `/api/admin/preview-cache`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Get the preview cache's size */
+export async function getPreviewCache(init?: RequestInit): Promise<ApiResult<{
+  cache: PreviewCacheStatus;
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/admin/preview-cache`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Set the preview cache's maximum size */
+export async function setPreviewCache(params: {
+  body: PreviewCacheRequest;
+}, init?: RequestInit): Promise<ApiResult<{
+  cache: PreviewCacheStatus;
+  ok: boolean;
+}>> {
+  const response = await call("PUT", `/api/admin/preview-cache`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Remove a directory's quota */
+export async function deleteQuota(params: {
+  path: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  message: string;
+  ok: boolean;
+}>> {
+  const response = await call("DELETE", `/api/admin/quotas`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** List directory quotas */
+export async function listQuotas(params: {
+  refresh?: string;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  quotas: QuotaStatus[];
+}>> {
+  const response = await call("GET", `/api/admin/quotas`, {"refresh": params.refresh}, undefined, true, init);
+  return response.json();
+}
+
+/** Set a directory's quota */
+export async function setQuota(params: {
+  body: QuotaRequest;
+}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  quota: QuotaStatus;
+}>> {
+  const response = await call("PUT", `/api/admin/quotas`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Get usage statistics */
+export async function getStats(params: {
+  top?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  jobs: JobStats;
+  month: UsageSummary;
+  ok: boolean;
+  today: UsageSummary;
+  topDownloads: Served[];
+}>> {
+  const response = await call("GET", `/api/admin/stats`, {"top": params.top}, undefined, true, init);
+  return response.json();
+}
+
+/** List scheduled tasks */
+export async function listTasks(init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  tasks: Info[];
+}>> {
+  const response = await call("GET", `/api/admin/tasks`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Run a task now */
+export async function runTask(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  run: Run;
+}>> {
+  const response = await call("POST", `/api/admin/tasks/${encodeURIComponent(params.name)}/run`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List a task's recent runs */
+export async function listTaskRuns(params: {
+  name: string;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  pagination?: Pagination;
+  runs: Run[];
+}>> {
+  const response = await call("GET", `/api/admin/tasks/${encodeURIComponent(params.name)}/runs`, {"offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** List share themes */
+export async function listThemes(init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  themes: Theme[];
+}>> {
+  const response = await call("GET", `/api/admin/themes`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Remove a share theme and its logo */
+export async function deleteTheme(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  message: string;
+  ok: boolean;
+}>> {
+  const response = await call("DELETE", `/api/admin/themes/${encodeURIComponent(params.name)}`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Define a share theme */
+export async function setTheme(params: {
+  name: string;
+  body: ThemeRequest;
+}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  theme: Theme;
+}>> {
+  const response = await call("PUT", `/api/admin/themes/${encodeURIComponent(params.name)}`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Remove a share theme's logo */
+export async function deleteThemeLogo(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  message: string;
+  ok: boolean;
+}>> {
+  const response = await call("DELETE", `/api/admin/themes/${encodeURIComponent(params.name)}/logo`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Upload a share theme's logo */
+export async function setThemeLogo(params: {
+  name: string;
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<{
+  branding: Branding;
+  ok: boolean;
+}>> {
+  const response = await call("PUT", `/api/admin/themes/${encodeURIComponent(params.name)}/logo`, {}, params.body, false, init);
+  return response.json();
+}
+
+/** List webhooks */
+export async function listWebhooks(init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  webhooks: WebhookInfo[];
+}>> {
+  const response = await call("GET", `/api/admin/webhooks`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List a webhook's recent deliveries */
+export async function listWebhookDeliveries(params: {
+  name: string;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+}, init?: RequestInit): Promise<ApiResult<{
+  deliveries: Delivery[];
+  ok: boolean;
+  pagination?: Pagination;
+}>> {
+  const response = await call("GET", `/api/admin/webhooks/${encodeURIComponent(params.name)}/deliveries`, {"offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Send a test event to a webhook */
+export async function pingWebhook(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  delivery: Delivery;
+  ok: boolean;
+}>> {
+  const response = await call("POST", `/api/admin/webhooks/${encodeURIComponent(params.name)}/ping`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List scheduled backups */
+export async function listBackups(init?: RequestInit): Promise<ApiResult<{
+  backups: BackupInfo[];
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/backups`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Run a backup now */
+export async function runBackup(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/backups/${encodeURIComponent(params.name)}/run`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List a backup's runs */
+export async function listBackupRuns(params: {
+  name: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  runs: BackupRunInfo[];
+}>> {
+  const response = await call("GET", `/api/backups/${encodeURIComponent(params.name)}/runs`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Time a download that isn't read from storage */
+export async function benchDownload(params: {
+  size?: number;
+} = {}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/bench/download`, {"size": params.size}, undefined, true, init);
+  return response;
+}
+
+/** Time an upload that isn't stored */
+export async function benchUpload(params: {
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<BenchResult>> {
+  const response = await call("POST", `/api/bench/upload`, {}, params.body, false, init);
+  return response.json();
+}
+
+/** Browse this specification in Swagger UI */
+export async function getAPIDocs(init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/docs`, {}, undefined, true, init);
+  return response;
+}
+
+/** Stream changes and job progress as server-sent events */
+export async function streamEvents(params: {
+  path?: string;
+  type?: string;
+} = {}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/events`, {"path": params.path, "type": params.type}, undefined, true, init);
+  return response;
+}
+
+/** List the changes made on or below a path, newest first */
+export async function getActivity(params: {
+  path?: string;
+  type?: string;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  activity: Activity[];
+  ok: boolean;
+  pagination?: Pagination;
+  path: string;
+}>> {
+  const response = await call("GET", `/api/fs/activity`, {"path": params.path, "type": params.type, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Append to a file */
+export async function appendFile(params: {
+  path: string;
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<WriteFileResponse>> {
+  const response = await call("POST", `/api/fs/append`, {"path": params.path}, params.body, false, init);
+  return response.json();
+}
+
+/** Download a checksum manifest of a directory tree */
+export async function downloadChecksums(params: {
+  path: string;
+  format?: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/checksums`, {"path": params.path, "format": params.format}, undefined, true, init);
+  return response;
+}
+
+/** Write a checksum manifest into a directory */
+export async function createChecksums(params: {
+  body: ChecksumRequest;
+}, init?: RequestInit): Promise<ApiResult<ChecksumResponse>> {
+  const response = await call("POST", `/api/fs/checksums`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Check a directory against its checksum manifest */
+export async function verifyChecksums(params: {
+  body: VerifyRequest;
+}, init?: RequestInit): Promise<ApiResult<VerifyResponse>> {
+  const response = await call("POST", `/api/fs/checksums/verify`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Copy a file or directory */
+export async function copyFile(params: {
+  body: CopyMoveRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/copy`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Delete a file or directory */
+export async function deleteFile(params: {
+  path: string;
+  secure?: boolean;
+  permanent?: boolean;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("DELETE", `/api/fs/delete`, {"path": params.path, "secure": params.secure, "permanent": params.permanent}, undefined, true, init);
+  return response.json();
+}
+
+/** Delete a file or directory */
+export async function deleteFilePost(params: {
+  body: DeleteRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/delete`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Compare two files or two directories */
+export async function diffPaths(params: {
+  from: string;
+  to: string;
+  context?: number;
+  hash?: boolean;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+}, init?: RequestInit): Promise<ApiResult<DiffResponse>> {
+  const response = await call("GET", `/api/fs/diff`, {"from": params.from, "to": params.to, "context": params.context, "hash": params.hash, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Download a file, or a directory as ZIP */
+export async function downloadFile(params: {
+  path: string;
+  format?: string;
+  hidden?: boolean;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/download`, {"path": params.path, "format": params.format, "hidden": params.hidden}, undefined, true, init);
+  return response;
+}
+
+/** Download several files and directories as one ZIP */
+export async function downloadMultiple(params: {
+  hidden?: boolean;
+  body: DownloadMultipleRequest;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("POST", `/api/fs/download-multiple`, {"hidden": params.hidden}, params.body, true, init);
+  return response;
+}
+
+/** List the directories the user lists most often */
+export async function frequentDirectories(params: {
+  limit?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  directories: Visit[];
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/fs/frequent`, {"limit": params.limit}, undefined, true, init);
+  return response.json();
+}
+
+/** List the images of a directory for a photo grid */
+export async function listGallery(params: {
+  path?: string;
+  order?: string;
+  size?: number;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<GalleryResponse>> {
+  const response = await call("GET", `/api/fs/gallery`, {"path": params.path, "order": params.order, "size": params.size, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Get the git status of a working copy */
+export async function gitStatus(params: {
+  path?: string;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<GitResponse>> {
+  const response = await call("GET", `/api/fs/git`, {"path": params.path, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** List a directory inside an ISO image */
+export async function listISO(params: {
+  path: string;
+  entry?: string;
+}, init?: RequestInit): Promise<ApiResult<ISOListing>> {
+  const response = await call("GET", `/api/fs/iso`, {"path": params.path, "entry": params.entry}, undefined, true, init);
+  return response.json();
+}
+
+/** Download a file from inside an ISO image */
+export async function downloadISOEntry(params: {
+  path: string;
+  entry: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/iso/download`, {"path": params.path, "entry": params.entry}, undefined, true, init);
+  return response;
+}
+
+/** Extract a file from an ISO image into a directory */
+export async function extractISOEntry(params: {
+  body: ISOExtractRequest;
+}, init?: RequestInit): Promise<ApiResult<{
+  etag: string;
+  message: string;
+  ok: boolean;
+  path: string;
+  size: number;
+}>> {
+  const response = await call("POST", `/api/fs/iso/extract`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Create a symbolic or hard link */
+export async function createLink(params: {
+  body: LinkRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/link`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** List a directory */
+export async function listDirectory(params: {
+  path?: string;
+  itemCounts?: boolean;
+  tag?: string;
+  notes?: boolean;
+  git?: boolean;
+  fields?: string;
+  hidden?: boolean;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<ListResponse>> {
+  const response = await call("GET", `/api/fs/list`, {"path": params.path, "itemCounts": params.itemCounts, "tag": params.tag, "notes": params.notes, "git": params.git, "fields": params.fields, "hidden": params.hidden, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Create a directory */
+export async function createDirectory(params: {
+  body: MkdirRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/mkdir`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Move or rename a file or directory */
+export async function moveFile(params: {
+  body: CopyMoveRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/move`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Remove the note attached to a file or directory */
+export async function deleteNote(params: {
+  path: string;
+}, init?: RequestInit): Promise<ApiResult<NoteResponse>> {
+  const response = await call("DELETE", `/api/fs/note`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Get the note attached to a file or directory */
+export async function getNote(params: {
+  path: string;
+}, init?: RequestInit): Promise<ApiResult<NoteResponse>> {
+  const response = await call("GET", `/api/fs/note`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Attach a note to a file or directory */
+export async function setNote(params: {
+  body: NoteRequest;
+}, init?: RequestInit): Promise<ApiResult<NoteResponse>> {
+  const response = await call("PUT", `/api/fs/note`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Open a document in the office editor */
+export async function openOffice(params: {
+  body: OfficeRequest;
+}, init?: RequestInit): Promise<ApiResult<OfficeSession>> {
+  const response = await call("POST", `/api/fs/office`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Save pasted text as a file */
+export async function createPaste(params: {
+  body: PasteRequest;
+}, init?: RequestInit): Promise<ApiResult<PasteResponse>> {
+  const response = await call("POST", `/api/fs/paste`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Overwrite part of a file */
+export async function patchFile(params: {
+  path: string;
+  offset: number;
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<WriteFileResponse>> {
+  const response = await call("PATCH", `/api/fs/patch`, {"path": params.path, "offset": params.offset}, params.body, false, init);
+  return response.json();
+}
+
+/** Unpin a directory */
+export async function removePin(params: {
+  path: string;
+}, init?: RequestInit): Promise<ApiResult<PinsResponse>> {
+  const response = await call("DELETE", `/api/fs/pins`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** List the directories the user pinned */
+export async function listPins(init?: RequestInit): Promise<ApiResult<PinsResponse>> {
+  const response = await call("GET", `/api/fs/pins`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Pin a directory */
+export async function addPin(params: {
+  body: PinRequest;
+}, init?: RequestInit): Promise<ApiResult<PinsResponse>> {
+  const response = await call("POST", `/api/fs/pins`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Replace the user's pins */
+export async function setPins(params: {
+  body: SetPinsRequest;
+}, init?: RequestInit): Promise<ApiResult<PinsResponse>> {
+  const response = await call("PUT", `/api/fs/pins`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Read a file */
+export async function readFile(params: {
+  path: string;
+  encoding?: string;
+  range?: string;
+}, init?: RequestInit): Promise<ApiResult<ReadFileResponse>> {
+  const response = await call("GET", `/api/fs/read`, {"path": params.path, "encoding": params.encoding, "range": params.range}, undefined, true, init);
+  return response.json();
+}
+
+/** List recently modified files */
+export async function recentFiles(params: {
+  path?: string;
+  limit?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<RecentResponse>> {
+  const response = await call("GET", `/api/fs/recent`, {"path": params.path, "limit": params.limit}, undefined, true, init);
+  return response.json();
+}
+
+/** Search a subtree for names */
+export async function searchFiles(params: {
+  path?: string;
+  q?: string;
+  type?: string;
+  limit?: number;
+  hidden?: boolean;
+} = {}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/search`, {"path": params.path, "q": params.q, "type": params.type, "limit": params.limit, "hidden": params.hidden}, undefined, true, init);
+  return response;
+}
+
+/** Share a file or directory */
+export async function createShare(params: {
+  body: CreateShareRequest;
+}, init?: RequestInit): Promise<ApiResult<CreateShareResponse>> {
+  const response = await call("POST", `/api/fs/share/create`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Describe a share */
+export async function getShare(params: {
+  shareId: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  branding: Branding;
+  ok: boolean;
+  share: SharePublic;
+}>> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Check a share's password */
+export async function accessShare(params: {
+  shareId: string;
+  body: AccessShareRequest;
+}, init?: RequestInit): Promise<ApiResult<AccessShareResponse>> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/access`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Download a shared file, or a shared directory as ZIP */
+export async function downloadShare(params: {
+  shareId: string;
+  path?: string;
+  password?: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/download`, {"path": params.path, "password": params.password}, undefined, true, init);
+  return response;
+}
+
+/** Describe a share for link previews */
+export async function getShareMeta(params: {
+  shareId: string;
+  format?: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  meta: ShareMeta;
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/meta`, {"format": params.format}, undefined, true, init);
+  return response.json();
+}
+
+/** Serve a file of a shared directory to BitTorrent clients */
+export async function seedShare(params: {
+  shareId: string;
+  path: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/seed/${encodeURIComponent(params.path)}`, {}, undefined, true, init);
+  return response;
+}
+
+/** Download a share's preview image */
+export async function getShareThumbnail(params: {
+  shareId: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/thumbnail`, {}, undefined, true, init);
+  return response;
+}
+
+/** Download the .torrent of a share */
+export async function getShareTorrent(params: {
+  shareId: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/fs/share/${encodeURIComponent(params.shareId)}/torrent`, {}, undefined, true, init);
+  return response;
+}
+
+/** Make a torrent of a share */
+export async function createShareTorrent(params: {
+  shareId: string;
+}, init?: RequestInit): Promise<ApiResult<ShareTorrentResponse>> {
+  const response = await call("POST", `/api/fs/share/${encodeURIComponent(params.shareId)}/torrent`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List shares, newest first */
+export async function getAllShares(params: {
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<GetSharesResponse>> {
+  const response = await call("GET", `/api/fs/shares`, {"offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Make a destination directory match a source */
+export async function syncFiles(params: {
+  body: SyncRequest;
+}, init?: RequestInit): Promise<ApiResult<SyncResponse>> {
+  const response = await call("POST", `/api/fs/sync`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Preview the rows of a CSV, TSV or XLSX file */
+export async function previewTable(params: {
+  path: string;
+  format?: string;
+  delimiter?: string;
+  sheet?: string;
+  header?: boolean;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+}, init?: RequestInit): Promise<ApiResult<TableResponse>> {
+  const response = await call("GET", `/api/fs/table`, {"path": params.path, "format": params.format, "delimiter": params.delimiter, "sheet": params.sheet, "header": params.header, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Remove tags from a file or directory */
+export async function removeTags(params: {
+  path: string;
+  tag?: string;
+}, init?: RequestInit): Promise<ApiResult<TagsResponse>> {
+  const response = await call("DELETE", `/api/fs/tags`, {"path": params.path, "tag": params.tag}, undefined, true, init);
+  return response.json();
+}
+
+/** Get the tags of a file or directory */
+export async function getTags(params: {
+  path: string;
+}, init?: RequestInit): Promise<ApiResult<TagsResponse>> {
+  const response = await call("GET", `/api/fs/tags`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Add tags to a file or directory */
+export async function addTags(params: {
+  body: TagsRequest;
+}, init?: RequestInit): Promise<ApiResult<TagsResponse>> {
+  const response = await call("POST", `/api/fs/tags`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Replace the tags of a file or directory */
+export async function setTags(params: {
+  body: TagsRequest;
+}, init?: RequestInit): Promise<ApiResult<TagsResponse>> {
+  const response = await call("PUT", `/api/fs/tags`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Create an empty file */
+export async function createFile(params: {
+  body: TouchRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/fs/touch`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Upload files into a directory */
+export async function uploadFiles(params: {
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<Record<string, unknown>>> {
+  const response = await call("POST", `/api/fs/upload`, {}, params.body, false, init);
+  return response.json();
+}
+
+/** Replace a file's contents */
+export async function saveFile(params: {
+  path: string;
+  body: BodyInit;
+}, init?: RequestInit): Promise<ApiResult<WriteFileResponse>> {
+  const response = await call("PUT", `/api/fs/write`, {"path": params.path}, params.body, false, init);
+  return response.json();
+}
+
+/** List jobs, newest first */
+export async function listJobs(params: {
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  jobs: JobInfo[];
+  ok: boolean;
+  pagination?: Pagination;
+}>> {
+  const response = await call("GET", `/api/jobs`, {"offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Cancel a job */
+export async function cancelJob(params: {
+  id: string;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("DELETE", `/api/jobs/${encodeURIComponent(params.id)}`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Get a job's progress */
+export async function getJob(params: {
+  id: string;
+}, init?: RequestInit): Promise<ApiResult<{
+  job: JobInfo;
+  ok: boolean;
+}>> {
+  const response = await call("GET", `/api/jobs/${encodeURIComponent(params.id)}`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Download the archive an archive job built */
+export async function downloadArchive(params: {
+  id: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/jobs/${encodeURIComponent(params.id)}/archive`, {}, undefined, true, init);
+  return response;
+}
+
+/** Stream a job's progress as server-sent events */
+export async function streamJobEvents(params: {
+  id: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/jobs/${encodeURIComponent(params.id)}/events`, {}, undefined, true, init);
+  return response;
+}
+
+/** Get this OpenAPI specification */
+export async function getOpenAPISpec(init?: RequestInit): Promise<ApiResult<Record<string, unknown>>> {
+  const response = await call("GET", `/api/openapi.json`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List snapshots */
+export async function listSnapshots(params: {
+  path?: string;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  snapshots: SnapshotInfo[];
+}>> {
+  const response = await call("GET", `/api/snapshots`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Download a file from a snapshot */
+export async function downloadSnapshotFile(params: {
+  id: string;
+  path: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/snapshots/${encodeURIComponent(params.id)}/download`, {"path": params.path}, undefined, true, init);
+  return response;
+}
+
+/** List a directory in a snapshot */
+export async function listSnapshotDirectory(params: {
+  id: string;
+  path?: string;
+}, init?: RequestInit): Promise<ApiResult<ListResponse>> {
+  const response = await call("GET", `/api/snapshots/${encodeURIComponent(params.id)}/list`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Restore a file or directory from a snapshot */
+export async function restoreFromSnapshot(params: {
+  id: string;
+  body: SnapshotRestoreRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/snapshots/${encodeURIComponent(params.id)}/restore`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** List tags with how many paths carry them */
+export async function listAllTags(params: {
+  path?: string;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  ok: boolean;
+  tags: TagCount[];
+}>> {
+  const response = await call("GET", `/api/tags`, {"path": params.path}, undefined, true, init);
+  return response.json();
+}
+
+/** Find the files and directories with a tag */
+export async function findTagged(params: {
+  tag: string;
+  path?: string;
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+}, init?: RequestInit): Promise<ApiResult<{
+  items: TaggedItem[];
+  ok: boolean;
+  pagination?: Pagination;
+  tag: string;
+}>> {
+  const response = await call("GET", `/api/tags/${encodeURIComponent(params.tag)}`, {"path": params.path, "offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Download a share theme's logo */
+export async function getThemeLogo(params: {
+  name: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/themes/${encodeURIComponent(params.name)}/logo`, {}, undefined, true, init);
+  return response;
+}
+
+/** Get a JPEG thumbnail of an image */
+export async function getThumbnail(params: {
+  path: string;
+  size?: number;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/api/thumbnail`, {"path": params.path, "size": params.size}, undefined, true, init);
+  return response;
+}
+
+/** Empty the trash */
+export async function emptyTrash(init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("DELETE", `/api/trash`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** List the trash */
+export async function listTrash(params: {
+  offset?: number;
+  limit?: number;
+  page?: number;
+  pageSize?: number;
+} = {}, init?: RequestInit): Promise<ApiResult<{
+  items: TrashItem[];
+  ok: boolean;
+  size: number;
+}>> {
+  const response = await call("GET", `/api/trash`, {"offset": params.offset, "limit": params.limit, "page": params.page, "pageSize": params.pageSize}, undefined, true, init);
+  return response.json();
+}
+
+/** Remove an entry from the trash for good */
+export async function deleteTrashItem(params: {
+  id: string;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("DELETE", `/api/trash/${encodeURIComponent(params.id)}`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Restore an entry from the trash */
+export async function restoreTrashItem(params: {
+  id: string;
+  body: TrashRestoreRequest;
+}, init?: RequestInit): Promise<ApiResult<OperationResponse>> {
+  const response = await call("POST", `/api/trash/${encodeURIComponent(params.id)}/restore`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Get upload settings for clients */
+export async function getTusConfig(init?: RequestInit): Promise<ApiResult<Record<string, unknown>>> {
+  const response = await call("GET", `/api/tus/config`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Discover the TUS server's capabilities */
+export async function tusOptionsHandler(init?: RequestInit): Promise<Response> {
+  const response = await call("OPTIONS", `/api/tus/files`, {}, undefined, true, init);
+  return response;
+}
+
+/** Create an upload */
+export async function tusPostHandler(init?: RequestInit): Promise<Response> {
+  const response = await call("POST", `/api/tus/files`, {}, undefined, true, init);
+  return response;
+}
+
+/** Cancel an upload */
+export async function tusDeleteHandler(params: {
+  id: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("DELETE", `/api/tus/files/${encodeURIComponent(params.id)}`, {}, undefined, true, init);
+  return response;
+}
+
+/** Get an upload's offset */
+export async function tusHeadHandler(params: {
+  id: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("HEAD", `/api/tus/files/${encodeURIComponent(params.id)}`, {}, undefined, true, init);
+  return response;
+}
+
+/** Upload a chunk */
+export async function tusPatchHandler(params: {
+  id: string;
+  body: BodyInit;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("PATCH", `/api/tus/files/${encodeURIComponent(params.id)}`, {}, params.body, false, init);
+  return response;
+}
+
+/** Set an upload's piecewise checksums */
+export async function tusPiecesHandler(params: {
+  id: string;
+  body: PiecesRequest;
+}, init?: RequestInit): Promise<ApiResult<Record<string, unknown>>> {
+  const response = await call("PUT", `/api/tus/files/${encodeURIComponent(params.id)}/pieces`, {}, params.body, true, init);
+  return response.json();
+}
+
+/** Find an upload to resume */
+export async function tusLookupHandler(params: {
+  fingerprint: string;
+}, init?: RequestInit): Promise<ApiResult<TusUploadInfo>> {
+  const response = await call("GET", `/api/tus/lookup`, {"fingerprint": params.fingerprint}, undefined, true, init);
+  return response.json();
+}
+
+/** Serve a file at its public URL */
+export async function servePublicFile(params: {
+  path: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("GET", `/files/${encodeURIComponent(params.path)}`, {}, undefined, true, init);
+  return response;
+}
+
+/** Get a public file's size, type and ETag */
+export async function servePublicFileHead(params: {
+  path: string;
+}, init?: RequestInit): Promise<Response> {
+  const response = await call("HEAD", `/files/${encodeURIComponent(params.path)}`, {}, undefined, true, init);
+  return response;
+}
+
+/** Check the server and its storage backends */
+export async function healthCheck(init?: RequestInit): Promise<ApiResult<HealthResponse>> {
+  const response = await call("GET", `/health`, {}, undefined, true, init);
+  return response.json();
+}
+
+/** Check that the server is up */
+export async function healthCheckHead(init?: RequestInit): Promise<Response> {
+  const response = await call("HEAD", `/health`, {}, undefined, true, init);
+  return response;
+}